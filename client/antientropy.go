@@ -0,0 +1,234 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+// repairBatchSize is the number of entries in each message that Repair
+// writes
+const repairBatchSize = 100
+
+// DifferenceKind tells how a key differs between two servers
+type DifferenceKind int
+
+const (
+	// OnlyInFirst is a key that only the first server has
+	OnlyInFirst DifferenceKind = iota
+	// OnlyInSecond is a key that only the second server has
+	OnlyInSecond
+	// Changed is a key whose value, expiry or flags differ
+	Changed
+)
+
+// Difference is a key on which two servers differ
+type Difference struct {
+	Key  []byte
+	Kind DifferenceKind
+}
+
+// Diff finds the keys on which the server of the client, the first server,
+// differs from the server at other, the second. The Merkle trees of both
+// servers are compared from the root down, so only the leaves that differ
+// have their keys fetched. The differences are sorted by key.
+func (c *Client) Diff(ctx context.Context, other string) ([]Difference, error) {
+	first, second := c.opts.addr, other
+
+	indices := []uint32{0}
+	for level := uint32(0); len(indices) > 0; level++ {
+		firstTree, err := c.treeOf(ctx, first, level, indices)
+		if err != nil {
+			return nil, err
+		}
+
+		secondTree, err := c.treeOf(ctx, second, level, indices)
+		if err != nil {
+			return nil, err
+		}
+
+		if firstTree.Fanout != secondTree.Fanout || firstTree.Depth != secondTree.Depth {
+			return nil, fmt.Errorf("the Merkle trees of %s and %s have different shapes", first, second)
+		}
+
+		var differing []uint32
+		for i, index := range indices {
+			if !bytes.Equal(firstTree.Hashes[i], secondTree.Hashes[i]) {
+				differing = append(differing, index)
+			}
+		}
+
+		if level == firstTree.Depth {
+			return c.diffLeaves(ctx, first, second, differing)
+		}
+
+		indices = nil
+		for _, index := range differing {
+			for child := range firstTree.Fanout {
+				indices = append(indices, index*firstTree.Fanout+child)
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// diffLeaves merges the sorted keys of leaves of two servers
+func (c *Client) diffLeaves(ctx context.Context, first, second string, leaves []uint32) ([]Difference, error) {
+	if len(leaves) == 0 {
+		return nil, nil
+	}
+
+	firstKeys, err := c.leavesOf(ctx, first, leaves)
+	if err != nil {
+		return nil, err
+	}
+
+	secondKeys, err := c.leavesOf(ctx, second, leaves)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []Difference
+	for len(firstKeys) > 0 || len(secondKeys) > 0 {
+		cmp := 0
+		switch {
+		case len(secondKeys) == 0:
+			cmp = -1
+		case len(firstKeys) == 0:
+			cmp = 1
+		default:
+			cmp = bytes.Compare(firstKeys[0].Key, secondKeys[0].Key)
+		}
+
+		switch {
+		case cmp < 0:
+			diffs = append(diffs, Difference{Key: firstKeys[0].Key, Kind: OnlyInFirst})
+			firstKeys = firstKeys[1:]
+		case cmp > 0:
+			diffs = append(diffs, Difference{Key: secondKeys[0].Key, Kind: OnlyInSecond})
+			secondKeys = secondKeys[1:]
+		default:
+			if !bytes.Equal(firstKeys[0].Digest, secondKeys[0].Digest) {
+				diffs = append(diffs, Difference{Key: firstKeys[0].Key, Kind: Changed})
+			}
+			firstKeys, secondKeys = firstKeys[1:], secondKeys[1:]
+		}
+	}
+
+	return diffs, nil
+}
+
+// Repair makes the server of the client hold the same keys as the server
+// at source: the keys that differ are read from source and written to it,
+// and the keys that source does not have are deleted from it. It returns
+// the number of keys written or deleted.
+func (c *Client) Repair(ctx context.Context, source string) (int, error) {
+	diffs, err := c.Diff(ctx, source)
+	if err != nil {
+		return 0, err
+	}
+
+	var keys [][]byte
+	var entries []*pb.Entry
+	for _, d := range diffs {
+		if d.Kind == OnlyInFirst {
+			entries = append(entries, &pb.Entry{Key: d.Key, Deleted: true})
+		} else {
+			keys = append(keys, d.Key)
+		}
+	}
+
+	if len(keys) > 0 {
+		read, err := c.readFrom(ctx, source, keys)
+		if err != nil {
+			return 0, err
+		}
+		entries = append(entries, read...)
+	}
+
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	written, err := c.writeTo(ctx, c.opts.addr, entries)
+	return int(written), err
+}
+
+func (c *Client) treeOf(ctx context.Context, target string, level uint32, indices []uint32) (*pb.TreeReply, error) {
+	reply, err := execute(ctx, c, target, func(conn *grpc.ClientConn, ctx context.Context) (*pb.TreeReply, error) {
+		return pb.NewAntiEntropyClient(conn).Tree(ctx, &pb.TreeRequest{Level: level, Indices: indices})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(reply.Hashes) != len(indices) {
+		return nil, fmt.Errorf("%s returned %d hashes for %d nodes", target, len(reply.Hashes), len(indices))
+	}
+
+	return reply, nil
+}
+
+func (c *Client) leavesOf(ctx context.Context, target string, indices []uint32) ([]*pb.KeyDigest, error) {
+	return execute(ctx, c, target, func(conn *grpc.ClientConn, ctx context.Context) ([]*pb.KeyDigest, error) {
+		reply, err := pb.NewAntiEntropyClient(conn).Leaves(ctx, &pb.LeavesRequest{Indices: indices})
+		if err != nil {
+			return nil, err
+		}
+
+		return reply.Keys, nil
+	})
+}
+
+func (c *Client) readFrom(ctx context.Context, target string, keys [][]byte) ([]*pb.Entry, error) {
+	return executeStream(ctx, c, target, func(conn *grpc.ClientConn, ctx context.Context) ([]*pb.Entry, error) {
+		stream, err := pb.NewAntiEntropyClient(conn).Read(ctx, &pb.ReadRequest{Keys: keys})
+		if err != nil {
+			return nil, err
+		}
+
+		var entries []*pb.Entry
+		for {
+			reply, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return entries, nil
+			} else if err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, reply.Entries...)
+		}
+	})
+}
+
+func (c *Client) writeTo(ctx context.Context, target string, entries []*pb.Entry) (uint64, error) {
+	return executeStream(ctx, c, target, func(conn *grpc.ClientConn, ctx context.Context) (uint64, error) {
+		stream, err := pb.NewAntiEntropyClient(conn).Write(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		for batch := range slices.Chunk(entries, repairBatchSize) {
+			if err := stream.Send(&pb.WriteRequest{Entries: batch}); err != nil {
+				// the error that ended the stream is returned by
+				// CloseAndRecv
+				break
+			}
+		}
+
+		reply, err := stream.CloseAndRecv()
+		if err != nil {
+			return 0, err
+		}
+
+		return reply.Written, nil
+	})
+}
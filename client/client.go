@@ -0,0 +1,602 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+	pbv2 "github.com/arpitchauhan/simple-database/database/v2"
+	"github.com/arpitchauhan/simple-database/sharding"
+)
+
+// DefaultTimeout is the deadline of requests whose context has none, unless
+// WithTimeout changes it
+const DefaultTimeout = 10 * time.Second
+
+// ErrClosed is returned for requests made with a Client after it was closed
+var ErrClosed = errors.New("client is closed")
+
+// RetryPolicy tells how requests that fail with Unavailable, e.g. because
+// the server is restarting, are retried. Other errors are not retried.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a request is sent at most. Requests are
+	// not retried if it is 1 or less.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, which is
+	// multiplied by Multiplier after every retry, up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+}
+
+// DefaultRetryPolicy is the retry policy unless WithRetryPolicy changes it
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	Multiplier:     2,
+}
+
+// backoff returns the wait before the given retry, starting from 1
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	wait := float64(p.InitialBackoff)
+	for range retry - 1 {
+		wait *= p.Multiplier
+	}
+
+	return min(time.Duration(wait), p.MaxBackoff)
+}
+
+// Option configures a Client
+type Option func(*options) error
+
+type options struct {
+	addr    string
+	creds   credentials.TransportCredentials
+	token   string
+	timeout time.Duration
+	retry   RetryPolicy
+	// keepaliveTime is how long a connection is idle before it is pinged,
+	// and keepaliveTimeout how long the ping may take. Connections are not
+	// pinged if keepaliveTime is 0.
+	keepaliveTime    time.Duration
+	keepaliveTimeout time.Duration
+	sharded          bool
+	// endpoints are the servers that requests fail over between, if
+	// WithEndpoints was given
+	endpoints           []string
+	healthCheckInterval time.Duration
+	// nearCacheSize is the size in bytes of the near cache, which is
+	// disabled if it is 0
+	nearCacheSize int64
+	nearCacheTTL  time.Duration
+}
+
+// WithAddr sets the address of the server, DefaultAddr otherwise
+func WithAddr(addr string) Option {
+	return func(o *options) error {
+		o.addr = addr
+		o.endpoints = nil
+		return nil
+	}
+}
+
+// WithEndpoints sets the addresses of several servers holding the same
+// keys, such as a primary and its replicas or the members of a cluster.
+// An address whose host is a DNS name that resolves to several addresses
+// stands for all of them. The endpoints are health checked: reads go to
+// the healthy ones in turn, writes to those that report themselves as a
+// primary, a leader or a multi-primary node, and a request fails over to
+// the next endpoint when one is unavailable. With sharding, the shard map
+// is fetched from any of them. Diff, Repair, GetShardMap and Rebalance use
+// the first address.
+func WithEndpoints(addrs ...string) Option {
+	return func(o *options) error {
+		if len(addrs) == 0 {
+			return errors.New("no endpoints given")
+		}
+
+		o.addr = addrs[0]
+		o.endpoints = addrs
+		return nil
+	}
+}
+
+// WithHealthCheckInterval sets how often the endpoints of WithEndpoints are
+// checked
+func WithHealthCheckInterval(interval time.Duration) Option {
+	return func(o *options) error {
+		if interval <= 0 {
+			return errors.New("the health check interval must be positive")
+		}
+
+		o.healthCheckInterval = interval
+		return nil
+	}
+}
+
+// WithTLS makes requests use TLS with the given options, rather than
+// plaintext
+func WithTLS(opts TLSOptions) Option {
+	return func(o *options) error {
+		creds, err := tlsCredentials(opts)
+		if err != nil {
+			return err
+		}
+
+		o.creds = creds
+		return nil
+	}
+}
+
+// withCredentials sets the transport credentials as they are
+func withCredentials(creds credentials.TransportCredentials) Option {
+	return func(o *options) error {
+		o.creds = creds
+		return nil
+	}
+}
+
+// WithToken sets the token that requests authenticate with, for servers
+// that require authentication. Without TLS, the token is sent in plaintext.
+func WithToken(token string) Option {
+	return func(o *options) error {
+		o.token = token
+		return nil
+	}
+}
+
+// WithTimeout sets the deadline of requests whose context has none.
+// Requests have no deadline if it is 0.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		o.timeout = timeout
+		return nil
+	}
+}
+
+// WithRetryPolicy sets how requests that fail with Unavailable are retried
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *options) error {
+		o.retry = policy
+		return nil
+	}
+}
+
+// WithKeepalive pings connections that are idle for interval, and closes
+// them if a ping takes longer than timeout, so that broken connections are
+// noticed before requests are sent on them. Servers reject pings more often
+// than every 10 seconds.
+func WithKeepalive(interval, timeout time.Duration) Option {
+	return func(o *options) error {
+		o.keepaliveTime = interval
+		o.keepaliveTimeout = timeout
+		return nil
+	}
+}
+
+// WithSharding makes requests about a key go to the shard that the key
+// belongs to. The shard map is fetched from the server of WithAddr, and
+// fetched again whenever a shard rejects a key because the map changed.
+func WithSharding(enabled bool) Option {
+	return func(o *options) error {
+		o.sharded = enabled
+		return nil
+	}
+}
+
+// Client sends requests to a server, or to the shards of a sharded
+// deployment, over connections that it keeps open until it is closed. It is
+// safe for concurrent use.
+type Client struct {
+	opts options
+
+	mu     sync.Mutex
+	conns  map[string]*grpc.ClientConn
+	closed bool
+
+	// shardMap is the shard map fetched last, which requests are routed
+	// with if sharding is enabled
+	shardMapMu sync.Mutex
+	shardMap   *sharding.Map
+
+	// endpoints is nil unless WithEndpoints was given
+	endpoints *endpoints
+	// nearCache is nil unless WithNearCache was given
+	nearCache *nearCache
+}
+
+// New returns a Client configured with opts. Connections are made when the
+// first request is sent.
+func New(opts ...Option) (*Client, error) {
+	o := options{
+		addr:                DefaultAddr,
+		creds:               insecure.NewCredentials(),
+		timeout:             DefaultTimeout,
+		retry:               DefaultRetryPolicy,
+		healthCheckInterval: DefaultHealthCheckInterval,
+	}
+
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.nearCacheSize > 0 && o.sharded {
+		return nil, errors.New("the near cache can not be combined with sharding")
+	}
+
+	c := &Client{opts: o, conns: make(map[string]*grpc.ClientConn)}
+	if len(o.endpoints) > 0 {
+		c.endpoints = newEndpoints(c, o.endpoints, o.healthCheckInterval)
+	}
+	if o.nearCacheSize > 0 {
+		c.nearCache = newNearCache(c, o.nearCacheSize, o.nearCacheTTL)
+	}
+
+	return c, nil
+}
+
+// Close closes the connections of the client. Requests in progress fail.
+func (c *Client) Close() error {
+	if c.nearCache != nil {
+		c.nearCache.stop()
+	}
+	if c.endpoints != nil {
+		c.endpoints.stop()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+
+	var errs []error
+	for target, conn := range c.conns {
+		errs = append(errs, conn.Close())
+		delete(c.conns, target)
+	}
+
+	return errors.Join(errs...)
+}
+
+// conn returns the connection to the server at target, which is made the
+// first time it is needed
+func (c *Client) conn(target string) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	if conn, ok := c.conns[target]; ok {
+		return conn, nil
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(c.opts.creds),
+		// the trace context of requests is sent along, for the server to
+		// continue their traces
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	}
+
+	// a server that restarts is reconnected to as often as requests are
+	// retried, rather than after the longer backoff of gRPC
+	if c.opts.retry.InitialBackoff > 0 {
+		opts = append(opts, grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  c.opts.retry.InitialBackoff,
+				Multiplier: max(c.opts.retry.Multiplier, 1),
+				Jitter:     0.2,
+				MaxDelay:   max(c.opts.retry.MaxBackoff, c.opts.retry.InitialBackoff),
+			},
+			MinConnectTimeout: 20 * time.Second,
+		}))
+	}
+
+	// an address resolved from a DNS name is verified against the name
+	if authority := c.endpoints.authority(target); authority != "" {
+		opts = append(opts, grpc.WithAuthority(authority))
+	}
+
+	if c.opts.keepaliveTime > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                c.opts.keepaliveTime,
+			Timeout:             c.opts.keepaliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.conns[target] = conn
+
+	return conn, nil
+}
+
+// outgoing returns ctx with the token of the client
+func (c *Client) outgoing(ctx context.Context) context.Context {
+	if c.opts.token == "" {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.opts.token)
+}
+
+// execute executes a request on the server at target, with the timeout of
+// the client if ctx has no deadline, and retries it if the server is
+// unavailable
+func execute[T any](
+	ctx context.Context,
+	c *Client,
+	target string,
+	requestFn func(*grpc.ClientConn, context.Context) (T, error),
+) (T, error) {
+	return executeOnAny(ctx, c, only(target), requestFn)
+}
+
+// executeOnAny is execute for a request that may go to any of the targets:
+// it fails over to the next target when one is unavailable, and is retried
+// once all of them were. The targets are asked for again on every retry.
+func executeOnAny[T any](
+	ctx context.Context,
+	c *Client,
+	targets func(context.Context) ([]string, error),
+	requestFn func(*grpc.ClientConn, context.Context) (T, error),
+) (T, error) {
+	if _, ok := ctx.Deadline(); !ok && c.opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.timeout)
+		defer cancel()
+	}
+
+	ctx = c.outgoing(ctx)
+
+	var result T
+	for attempt := 1; ; attempt++ {
+		addrs, err := targets(ctx)
+		if err != nil {
+			return result, err
+		}
+
+		for _, target := range addrs {
+			var conn *grpc.ClientConn
+			conn, err = c.conn(target)
+			if err != nil {
+				return result, err
+			}
+
+			result, err = requestFn(conn, ctx)
+			if status.Code(err) != codes.Unavailable {
+				return result, err
+			}
+
+			c.endpoints.markDown(target)
+		}
+
+		if attempt >= c.opts.retry.MaxAttempts {
+			return result, err
+		}
+
+		select {
+		case <-time.After(c.opts.retry.backoff(attempt)):
+		case <-ctx.Done():
+			return result, err
+		}
+	}
+}
+
+// only returns the targets of a request that goes to target alone
+func only(target string) func(context.Context) ([]string, error) {
+	return func(context.Context) ([]string, error) {
+		return []string{target}, nil
+	}
+}
+
+// route returns the targets of a request to the server of the client: the
+// endpoints of WithEndpoints in the order to try them, or the address of
+// WithAddr
+func (c *Client) route(write bool) func(context.Context) ([]string, error) {
+	if c.endpoints == nil {
+		return only(c.opts.addr)
+	}
+
+	return func(ctx context.Context) ([]string, error) {
+		return c.endpoints.targets(ctx, write)
+	}
+}
+
+// executeStream is execute for streaming requests, which may take longer
+// than the timeout of the client and are not retried
+func executeStream[T any](
+	ctx context.Context,
+	c *Client,
+	target string,
+	requestFn func(*grpc.ClientConn, context.Context) (T, error),
+) (T, error) {
+	conn, err := c.conn(target)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return requestFn(conn, c.outgoing(ctx))
+}
+
+// executeKeyed executes a request about key, on the shard of the key if
+// sharding is enabled. write tells whether the request changes the key.
+func executeKeyed[T any](
+	ctx context.Context,
+	c *Client,
+	key string,
+	write bool,
+	requestFn func(pb.DatabaseClient, context.Context) (T, error),
+) (T, error) {
+	return executeOnShard(ctx, c, key, write, func(conn *grpc.ClientConn, ctx context.Context) (T, error) {
+		return requestFn(pb.NewDatabaseClient(conn), ctx)
+	})
+}
+
+// executeKeyedV2 is executeKeyed for version 2 of the Database service
+func executeKeyedV2[T any](
+	ctx context.Context,
+	c *Client,
+	key []byte,
+	write bool,
+	requestFn func(pbv2.DatabaseClient, context.Context) (T, error),
+) (T, error) {
+	return executeOnShard(ctx, c, string(key), write, func(conn *grpc.ClientConn, ctx context.Context) (T, error) {
+		return requestFn(pbv2.NewDatabaseClient(conn), ctx)
+	})
+}
+
+// Get gets the latest value set for a key, from the near cache if it has
+// the key
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	get := func(conn *grpc.ClientConn, ctx context.Context) (string, error) {
+		reply, err := pb.NewDatabaseClient(conn).Get(ctx, &pb.GetRequest{Key: key})
+		if err != nil {
+			return "", err
+		}
+
+		return reply.Value, nil
+	}
+
+	if c.nearCache != nil {
+		return c.nearCache.get(ctx, key, func(ctx context.Context, targets func(context.Context) ([]string, error)) (string, error) {
+			return executeOnAny(ctx, c, targets, get)
+		})
+	}
+
+	return executeOnShard(ctx, c, key, false, get)
+}
+
+// Set sets the value for a key
+func (c *Client) Set(ctx context.Context, key string, value string) error {
+	_, err := executeKeyed(ctx, c, key, true, func(client pb.DatabaseClient, ctx context.Context) (*pb.SetReply, error) {
+		return client.Set(ctx, &pb.SetRequest{Key: key, Value: value})
+	})
+
+	if c.nearCache != nil {
+		c.nearCache.changed(key)
+	}
+
+	return err
+}
+
+// GetBytes gets the value for a key, both of which may hold any bytes, from
+// the near cache if it has the key
+func (c *Client) GetBytes(ctx context.Context, key []byte) ([]byte, error) {
+	get := func(conn *grpc.ClientConn, ctx context.Context) ([]byte, error) {
+		reply, err := pbv2.NewDatabaseClient(conn).Get(ctx, &pbv2.GetRequest{Key: key})
+		if err != nil {
+			return nil, err
+		}
+
+		return reply.Value, nil
+	}
+
+	if c.nearCache != nil {
+		value, err := c.nearCache.get(ctx, string(key), func(ctx context.Context, targets func(context.Context) ([]string, error)) (string, error) {
+			value, err := executeOnAny(ctx, c, targets, get)
+			return string(value), err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return []byte(value), nil
+	}
+
+	return executeOnShard(ctx, c, string(key), false, get)
+}
+
+// SetBytes sets the value for a key, both of which may hold any bytes
+func (c *Client) SetBytes(ctx context.Context, key []byte, value []byte) error {
+	_, err := executeKeyedV2(ctx, c, key, true, func(client pbv2.DatabaseClient, ctx context.Context) (*pbv2.SetReply, error) {
+		return client.Set(ctx, &pbv2.SetRequest{Key: key, Value: value})
+	})
+
+	if c.nearCache != nil {
+		c.nearCache.changed(string(key))
+	}
+
+	return err
+}
+
+// Delete deletes a key
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.DeleteBytes(ctx, []byte(key))
+}
+
+// DeleteBytes deletes a key, which may hold any bytes. A delete that is
+// retried after failing with Unavailable succeeds if the key is not found,
+// since the failed attempt may have deleted it and only lost the reply, so
+// a key that was missing all along is not reported then.
+func (c *Client) DeleteBytes(ctx context.Context, key []byte) error {
+	var unavailable, retried bool
+	_, err := executeKeyedV2(ctx, c, key, true, func(client pbv2.DatabaseClient, ctx context.Context) (*pbv2.DeleteReply, error) {
+		retried = unavailable
+		reply, err := client.Delete(ctx, &pbv2.DeleteRequest{Key: key})
+		unavailable = unavailable || status.Code(err) == codes.Unavailable
+		return reply, err
+	})
+	if retried && status.Code(err) == codes.NotFound {
+		err = nil
+	}
+
+	if c.nearCache != nil {
+		c.nearCache.changed(string(key))
+	}
+
+	return err
+}
+
+// Scan returns a page of up to limit keys that start with prefix, from the
+// cursor of the previous page or 0, and the cursor of the next page, which
+// is 0 on the last one. The server picks the limit if it is 0. Cursors are
+// positions in the database file of a server, so keys are scanned on the
+// server of WithAddr, or the first of WithEndpoints, and not across shards.
+func (c *Client) Scan(ctx context.Context, prefix []byte, cursor uint64, limit int) ([][]byte, uint64, error) {
+	reply, err := execute(ctx, c, c.opts.addr, func(conn *grpc.ClientConn, ctx context.Context) (*pbv2.ScanReply, error) {
+		return pbv2.NewDatabaseClient(conn).Scan(ctx, &pbv2.ScanRequest{Prefix: prefix, Cursor: cursor, Limit: uint32(limit)})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return reply.Keys, reply.NextCursor, nil
+}
+
+// Stats gets the statistics of the server, which is any healthy one of the
+// endpoints of WithEndpoints
+func (c *Client) Stats(ctx context.Context) (*pb.StatsReply, error) {
+	return executeOnAny(ctx, c, c.route(false), func(conn *grpc.ClientConn, ctx context.Context) (*pb.StatsReply, error) {
+		return pb.NewDatabaseClient(conn).Stats(ctx, &pb.StatsRequest{})
+	})
+}
+
+// Compact compacts the database file of the server, which is one of the
+// endpoints of WithEndpoints that takes writes
+func (c *Client) Compact(ctx context.Context) (*pb.CompactReply, error) {
+	return executeOnAny(ctx, c, c.route(true), func(conn *grpc.ClientConn, ctx context.Context) (*pb.CompactReply, error) {
+		return pb.NewDatabaseClient(conn).Compact(ctx, &pb.CompactRequest{})
+	})
+}
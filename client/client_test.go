@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pbv2 "github.com/arpitchauhan/simple-database/database/v2"
+)
+
+func Test_Client(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := New(WithAddr(runServer(t, "-data-dir", t.TempDir())), WithKeepalive(10*time.Second, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			key, value := fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)
+			if err := c.Set(ctx, key, value); err != nil {
+				t.Error(err)
+				return
+			}
+
+			got, err := c.Get(ctx, key)
+			if err != nil || got != value {
+				t.Errorf("got = %q, %v, want = %q", got, err, value)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := c.SetBytes(ctx, []byte("binary\x00"), []byte{0xff}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := c.GetBytes(ctx, []byte("binary\x00")); err != nil || string(got) != "\xff" {
+		t.Errorf("got = %q, %v, want = %q", got, err, "\xff")
+	}
+
+	if _, err := c.Get(ctx, "missing"); status.Code(err) != codes.NotFound {
+		t.Errorf("error = %v, want NotFound", err)
+	}
+
+	if err := c.Delete(ctx, "key0"); err != nil {
+		t.Error(err)
+	}
+
+	if err := c.Delete(ctx, "key0"); status.Code(err) != codes.NotFound {
+		t.Errorf("error = %v deleting again, want NotFound", err)
+	}
+
+	// key1 and key10 to key19 in pages of 3
+	var scanned int
+	for cursor := uint64(0); ; {
+		keys, next, err := c.Scan(ctx, []byte("key1"), cursor, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(keys) > 3 {
+			t.Errorf("page of %d keys, want at most 3", len(keys))
+		}
+		scanned += len(keys)
+
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+
+	if scanned != 11 {
+		t.Errorf("scanned %d keys, want = 11", scanned)
+	}
+
+	if _, err := c.Stats(ctx); err != nil {
+		t.Error(err)
+	}
+
+	c.Close()
+
+	if _, err := c.Get(ctx, "key0"); !errors.Is(err, ErrClosed) {
+		t.Errorf("error = %v after close, want ErrClosed", err)
+	}
+}
+
+func Test_Client_retries(t *testing.T) {
+	ctx := context.Background()
+	addr := freeAddr(t)
+
+	policy := RetryPolicy{
+		MaxAttempts:    50,
+		InitialBackoff: 20 * time.Millisecond,
+		MaxBackoff:     100 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	retrying, err := New(WithAddr(addr), WithRetryPolicy(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer retrying.Close()
+
+	once, err := New(WithAddr(addr), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer once.Close()
+
+	if _, err := once.Get(ctx, "key"); status.Code(err) != codes.Unavailable {
+		t.Fatalf("error = %v without retries, want Unavailable", err)
+	}
+
+	// the server starts while the request is being retried
+	done := make(chan error, 1)
+	go func() {
+		_, err := retrying.Get(ctx, "key")
+		done <- err
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	runServer(t, "-addr", addr, "-data-dir", t.TempDir())
+
+	if err := <-done; status.Code(err) != codes.NotFound {
+		t.Errorf("error = %v, want NotFound from the server", err)
+	}
+
+	// a context deadline bounds the retries
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	unreachable, err := New(WithAddr(freeAddr(t)), WithRetryPolicy(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unreachable.Close()
+
+	start := time.Now()
+	if _, err := unreachable.Get(short, "key"); err == nil {
+		t.Error("request to no server succeeded")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request took %v, longer than its deadline", elapsed)
+	}
+}
+
+// lostReplyServer has no keys, and fails the first lost deletes with
+// Unavailable as if it deleted the key but the reply was lost
+type lostReplyServer struct {
+	pbv2.UnimplementedDatabaseServer
+	lost    int32
+	deletes atomic.Int32
+}
+
+func (s *lostReplyServer) Delete(ctx context.Context, in *pbv2.DeleteRequest) (*pbv2.DeleteReply, error) {
+	if s.deletes.Add(1) <= s.lost {
+		return nil, status.Error(codes.Unavailable, "reply lost")
+	}
+
+	return nil, status.Error(codes.NotFound, "Key was not found")
+}
+
+func Test_Client_retries_delete(t *testing.T) {
+	ctx := context.Background()
+
+	for _, lost := range []int32{0, 1} {
+		lis, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s := &lostReplyServer{lost: lost}
+		gs := grpc.NewServer()
+		pbv2.RegisterDatabaseServer(gs, s)
+		go gs.Serve(lis)
+		t.Cleanup(gs.Stop)
+
+		c := newTestClient(t, WithAddr(lis.Addr().String()), WithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+		err = c.Delete(ctx, "key")
+
+		// a missing key is only an error if no attempt could have deleted it
+		if lost == 0 && status.Code(err) != codes.NotFound {
+			t.Errorf("error = %v without a lost reply, want NotFound", err)
+		}
+
+		if lost == 1 && err != nil {
+			t.Errorf("error = %v after a lost reply, want the delete to succeed", err)
+		}
+
+		if deletes := s.deletes.Load(); deletes != lost+1 {
+			t.Errorf("sent %d deletes after %d lost replies, want %d", deletes, lost, lost+1)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+// DefaultHealthCheckInterval is how often the endpoints of WithEndpoints are
+// checked, unless WithHealthCheckInterval changes it
+const DefaultHealthCheckInterval = 2 * time.Second
+
+// writableRoles are the roles of the servers that take writes themselves
+var writableRoles = map[string]bool{
+	"primary":       true,
+	"leader":        true,
+	"multi-primary": true,
+}
+
+// endpoint is a server that requests may be sent to
+type endpoint struct {
+	addr string
+	// healthy is whether the server answered its last health check, and
+	// writable whether it then reported a role that takes writes
+	healthy  bool
+	writable bool
+}
+
+// endpoints are the servers of a client made with WithEndpoints. They are
+// health checked in the background from the first request on, by asking
+// each of them for its statistics, which also tell its role.
+type endpoints struct {
+	c          *Client
+	configured []string
+	interval   time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// ready is closed once every endpoint was checked, and done once the
+	// health checks stopped
+	ready chan struct{}
+	done  chan struct{}
+
+	mu      sync.Mutex
+	started bool
+	all     []*endpoint
+	// authorities are the names that the endpoints resolved from a DNS
+	// name are known by, which TLS verifies the servers against
+	authorities map[string]string
+	// next is where the next request starts in all, so that requests are
+	// spread over the endpoints in turn
+	next int
+}
+
+func newEndpoints(c *Client, configured []string, interval time.Duration) *endpoints {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &endpoints{
+		c:           c,
+		configured:  configured,
+		interval:    interval,
+		ctx:         ctx,
+		cancel:      cancel,
+		ready:       make(chan struct{}),
+		done:        make(chan struct{}),
+		authorities: make(map[string]string),
+	}
+}
+
+// targets returns the addresses of the endpoints to send a request to, in
+// the order to try them: for reads the healthy endpoints, and for writes
+// the healthy endpoints that take writes, then the unhealthy ones in case
+// they came back, then for writes those that do not take writes. The first
+// request waits for the endpoints to be checked.
+func (e *endpoints) targets(ctx context.Context, write bool) ([]string, error) {
+	e.mu.Lock()
+	if !e.started {
+		e.started = true
+		go e.run()
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if e.ctx.Err() != nil {
+		return nil, ErrClosed
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rank := func(ep *endpoint) int {
+		switch {
+		case ep.healthy && (!write || ep.writable):
+			return 0
+		case !ep.healthy:
+			return 1
+		default:
+			return 2
+		}
+	}
+
+	rotated := make([]*endpoint, 0, len(e.all))
+	for i := range e.all {
+		rotated = append(rotated, e.all[(e.next+i)%len(e.all)])
+	}
+	e.next++
+
+	slices.SortStableFunc(rotated, func(a, b *endpoint) int {
+		return cmp.Compare(rank(a), rank(b))
+	})
+
+	addrs := make([]string, len(rotated))
+	for i, ep := range rotated {
+		addrs[i] = ep.addr
+	}
+
+	return addrs, nil
+}
+
+// markDown marks the endpoint at addr as unhealthy until its next health
+// check, after a request to it failed with Unavailable
+func (e *endpoints) markDown(addr string) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, ep := range e.all {
+		if ep.addr == addr {
+			ep.healthy = false
+		}
+	}
+}
+
+// authority returns the name that the endpoint at addr is known by, or ""
+// if it is its address
+func (e *endpoints) authority(addr string) string {
+	if e == nil {
+		return ""
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.authorities[addr]
+}
+
+// stop stops the health checks
+func (e *endpoints) stop() {
+	e.cancel()
+
+	e.mu.Lock()
+	started := e.started
+	e.started = true
+	e.mu.Unlock()
+
+	if started {
+		<-e.done
+	} else {
+		close(e.ready)
+	}
+}
+
+// run checks the endpoints every interval until the client is closed
+func (e *endpoints) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	e.check()
+	close(e.ready)
+
+	for {
+		select {
+		case <-ticker.C:
+			e.check()
+		case <-e.ctx.Done():
+			return
+		}
+	}
+}
+
+// check resolves the endpoints again and checks every one of them
+func (e *endpoints) check() {
+	resolved := e.resolve()
+
+	var wg sync.WaitGroup
+	for _, ep := range resolved {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ep.healthy, ep.writable = e.probe(ep.addr)
+		}()
+	}
+	wg.Wait()
+
+	e.mu.Lock()
+	e.all = resolved
+	e.mu.Unlock()
+}
+
+// resolve returns the configured endpoints, with those whose host is a DNS
+// name that resolves to several addresses replaced by one endpoint for
+// each address
+func (e *endpoints) resolve() []*endpoint {
+	var resolved []*endpoint
+	add := func(addr string) {
+		if !slices.ContainsFunc(resolved, func(ep *endpoint) bool { return ep.addr == addr }) {
+			resolved = append(resolved, &endpoint{addr: addr})
+		}
+	}
+
+	for _, target := range e.configured {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || net.ParseIP(host) != nil {
+			add(target)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(e.ctx, e.interval)
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		cancel()
+
+		if err != nil || len(addrs) <= 1 {
+			add(target)
+			continue
+		}
+
+		e.mu.Lock()
+		for _, a := range addrs {
+			e.authorities[net.JoinHostPort(a, port)] = target
+		}
+		e.mu.Unlock()
+
+		for _, a := range addrs {
+			add(net.JoinHostPort(a, port))
+		}
+	}
+
+	return resolved
+}
+
+// probe returns whether the server at addr is healthy, and whether it
+// takes writes. A server that answers with an error other than Unavailable
+// is up, and is left to reject writes itself.
+func (e *endpoints) probe(addr string) (bool, bool) {
+	conn, err := e.c.conn(addr)
+	if err != nil {
+		return false, false
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, e.interval)
+	defer cancel()
+
+	reply, err := pb.NewDatabaseClient(conn).Stats(e.c.outgoing(ctx), &pb.StatsRequest{})
+	switch status.Code(err) {
+	case codes.OK:
+		return true, writableRoles[reply.Role]
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled:
+		return false, false
+	default:
+		return true, true
+	}
+}
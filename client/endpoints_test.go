@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_Client_failover(t *testing.T) {
+	ctx := context.Background()
+
+	policy := RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: 20 * time.Millisecond,
+		MaxBackoff:     100 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	// workload sets and gets keys until it was told to stop, and returns
+	// the first error
+	workload := func(c *Client, stop <-chan struct{}) error {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+
+			if err := c.Set(ctx, fmt.Sprintf("key%d", i), "value"); err != nil {
+				return fmt.Errorf("set: %w", err)
+			}
+
+			if got, err := c.Get(ctx, "seed"); err != nil || got != "seed" {
+				return fmt.Errorf("get = %q, %w", got, err)
+			}
+		}
+	}
+
+	// run runs the workload while stopping a server in its middle
+	run := func(t *testing.T, c *Client, stopServer func()) {
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() { done <- workload(c, stop) }()
+
+		time.Sleep(200 * time.Millisecond)
+		stopServer()
+		time.Sleep(300 * time.Millisecond)
+		close(stop)
+
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+
+	t.Run("Replica stops", func(t *testing.T) {
+		primaryAddr := runServer(t, "-data-dir", t.TempDir())
+		replicaAddr, stopReplica := startServer(t, "-data-dir", t.TempDir(), "-replica-of", primaryAddr)
+
+		// writes go to the primary whatever the order of the endpoints
+		c, err := New(WithEndpoints(replicaAddr, primaryAddr), WithHealthCheckInterval(50*time.Millisecond), WithRetryPolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		if err := c.Set(ctx, "seed", "seed"); err != nil {
+			t.Fatal(err)
+		}
+
+		replica := newTestClient(t, WithAddr(replicaAddr))
+		eventually(t, "the replica to catch up", func() bool {
+			value, err := replica.Get(ctx, "seed")
+			return err == nil && value == "seed"
+		})
+
+		run(t, c, stopReplica)
+	})
+
+	t.Run("Multi-primary node stops", func(t *testing.T) {
+		addrA, addrB := freeAddr(t), freeAddr(t)
+		_, stopA := startServer(t, "-addr", addrA, "-data-dir", t.TempDir(), "-node-id", "a", "-peers", addrB)
+		runServer(t, "-addr", addrB, "-data-dir", t.TempDir(), "-node-id", "b", "-peers", addrA)
+
+		c, err := New(WithEndpoints(addrA, addrB), WithHealthCheckInterval(50*time.Millisecond), WithRetryPolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		if err := c.Set(ctx, "seed", "seed"); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, addr := range []string{addrA, addrB} {
+			node := newTestClient(t, WithAddr(addr))
+			eventually(t, "the nodes to agree", func() bool {
+				value, err := node.Get(ctx, "seed")
+				return err == nil && value == "seed"
+			})
+		}
+
+		run(t, c, stopA)
+
+		stats, err := c.Stats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stats.Role != "multi-primary" {
+			t.Errorf("role = %q, want = multi-primary", stats.Role)
+		}
+	})
+}
@@ -2,65 +2,222 @@ package client
 
 import (
 	"context"
-	"time"
-
-	"google.golang.org/grpc"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 
 	pb "github.com/arpitchauhan/simple-database/database"
+	"github.com/arpitchauhan/simple-database/sharding"
+)
+
+// DefaultAddr is the address of the server unless SetAddr or WithAddr
+// changes it
+const DefaultAddr = "localhost:50051"
+
+// TLSOptions configure TLS for the connection to the server
+type TLSOptions struct {
+	// CAFile is a PEM bundle of the CAs to verify the server certificate
+	// against. The CAs of the system are used if it is empty.
+	CAFile string
+	// CertFile and KeyFile are a PEM certificate and key to authenticate
+	// with, for servers that require mutual TLS
+	CertFile string
+	KeyFile  string
+}
+
+// The functions of the package send requests with a shared Client, which
+// is configured with SetAddr, SetTLS, SetToken and SetSharding, and made
+// again whenever they change its settings
+var (
+	sharedMu     sync.Mutex
+	shared       *Client
+	addr         = DefaultAddr
+	sharedCreds  = insecure.NewCredentials()
+	token        string
+	shardingMode bool
 )
 
-const addr = "localhost:50051"
+// SetAddr sets the address of the server that requests are sent to. A
+// comma-separated list of addresses makes them the endpoints that requests
+// fail over between, as with WithEndpoints.
+func SetAddr(a string) {
+	configureShared(func() { addr = a })
+}
 
-// Create a new connection, a client that uses that connection, executes
-// the passed-in request, and then returns the result of the execution
-func executeRequest(
-	requestFn func(pb.DatabaseClient, context.Context) (string, error),
-) (string, error) {
-	// Use insecure credentials as this project is not meant for real-world use
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// SetTLS makes requests use TLS with the given options
+func SetTLS(opts TLSOptions) error {
+	creds, err := tlsCredentials(opts)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer conn.Close()
 
-	client := pb.NewDatabaseClient(conn)
+	configureShared(func() { sharedCreds = creds })
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10000*time.Second)
-	defer cancel()
+	return nil
+}
 
-	return requestFn(client, ctx)
+// SetToken sets the token that requests authenticate with, for servers
+// that require authentication. Without TLS, the token is sent in plaintext.
+func SetToken(t string) {
+	configureShared(func() { token = t })
 }
 
-func GetValueForKey(key string) (string, error) {
-	requestFn := func(client pb.DatabaseClient, ctx context.Context) (string, error) {
-		reply, err := client.Get(ctx, &pb.GetRequest{Key: key})
-		if err != nil {
-			return "", err
-		}
+// SetSharding makes requests about a key go to the shard that the key
+// belongs to. The shard map is fetched from the server of SetAddr, and
+// fetched again whenever a shard rejects a key because the map changed.
+func SetSharding(enabled bool) {
+	configureShared(func() { shardingMode = enabled })
+}
+
+// configureShared changes the settings of the shared client, which is
+// closed so that the next request makes it again
+func configureShared(change func()) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
 
-		return reply.Value, nil
+	change()
+
+	if shared != nil {
+		shared.Close()
+		shared = nil
 	}
+}
 
-	value, err := executeRequest(requestFn)
-	if err != nil {
-		return "", err
+// onSharedClient executes a request with the shared client
+func onSharedClient[T any](requestFn func(*Client, context.Context) (T, error)) (T, error) {
+	sharedMu.Lock()
+	if shared == nil {
+		// none of these options can fail
+		target := WithAddr(addr)
+		if strings.Contains(addr, ",") {
+			target = WithEndpoints(strings.Split(addr, ",")...)
+		}
+
+		shared, _ = New(target, withCredentials(sharedCreds), WithToken(token), WithSharding(shardingMode))
 	}
+	c := shared
+	sharedMu.Unlock()
 
-	return value, nil
+	return requestFn(c, context.Background())
 }
 
-func SetValueForKey(key string, value string) error {
-	requestFn := func(client pb.DatabaseClient, ctx context.Context) (string, error) {
-		_, err := client.Set(ctx, &pb.SetRequest{Key: key, Value: value})
+func tlsCredentials(opts TLSOptions) (credentials.TransportCredentials, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", opts.CAFile)
+		}
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
 		if err != nil {
-			return "", err
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
 		}
 
-		return "", nil
+		cfg.Certificates = []tls.Certificate{cert}
 	}
 
-	_, err := executeRequest(requestFn)
+	return credentials.NewTLS(cfg), nil
+}
+
+func GetValueForKey(key string) (string, error) {
+	return onSharedClient(func(c *Client, ctx context.Context) (string, error) {
+		return c.Get(ctx, key)
+	})
+}
+
+func SetValueForKey(key string, value string) error {
+	_, err := onSharedClient(func(c *Client, ctx context.Context) (struct{}, error) {
+		return struct{}{}, c.Set(ctx, key, value)
+	})
+
+	return err
+}
+
+// GetBytes gets the value for a key, both of which may hold any bytes
+func GetBytes(key []byte) ([]byte, error) {
+	return onSharedClient(func(c *Client, ctx context.Context) ([]byte, error) {
+		return c.GetBytes(ctx, key)
+	})
+}
+
+// SetBytes sets the value for a key, both of which may hold any bytes
+func SetBytes(key []byte, value []byte) error {
+	_, err := onSharedClient(func(c *Client, ctx context.Context) (struct{}, error) {
+		return struct{}{}, c.SetBytes(ctx, key, value)
+	})
+
+	return err
+}
+
+// DeleteBytes deletes a key, which may hold any bytes
+func DeleteBytes(key []byte) error {
+	_, err := onSharedClient(func(c *Client, ctx context.Context) (struct{}, error) {
+		return struct{}{}, c.DeleteBytes(ctx, key)
+	})
+
+	return err
+}
+
+// Scan returns a page of the keys that start with prefix, as Client.Scan
+func Scan(prefix []byte, cursor uint64, limit int) ([][]byte, uint64, error) {
+	var next uint64
+	keys, err := onSharedClient(func(c *Client, ctx context.Context) ([][]byte, error) {
+		var keys [][]byte
+		var err error
+		keys, next, err = c.Scan(ctx, prefix, cursor, limit)
+		return keys, err
+	})
+
+	return keys, next, err
+}
+
+func GetStats() (*pb.StatsReply, error) {
+	return onSharedClient((*Client).Stats)
+}
+
+func Compact() (*pb.CompactReply, error) {
+	return onSharedClient((*Client).Compact)
+}
+
+// GetShardMap gets the shard map of the server
+func GetShardMap() (*pb.GetShardMapReply, error) {
+	return onSharedClient((*Client).GetShardMap)
+}
+
+// Rebalance is Client.Rebalance with the shared client
+func Rebalance(shards []sharding.Shard, progress func(format string, args ...any)) error {
+	_, err := onSharedClient(func(c *Client, ctx context.Context) (struct{}, error) {
+		return struct{}{}, c.Rebalance(ctx, shards, progress)
+	})
 
 	return err
 }
+
+// Diff is Client.Diff with the shared client
+func Diff(other string) ([]Difference, error) {
+	return onSharedClient(func(c *Client, ctx context.Context) ([]Difference, error) {
+		return c.Diff(ctx, other)
+	})
+}
+
+// Repair is Client.Repair with the shared client
+func Repair(source string) (int, error) {
+	return onSharedClient(func(c *Client, ctx context.Context) (int, error) {
+		return c.Repair(ctx, source)
+	})
+}
@@ -0,0 +1,482 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+// The near cache keeps the values that Get and GetBytes read in the client,
+// and keeps them coherent with the server by watching the cached keys: the
+// server tells the client when one of them changes, and the client drops
+// its value. A key is watched before its value is read, so that no change
+// after the read goes unnoticed, and the value is read from the server that
+// watches it. Values are cached for at most a TTL all the same, which is
+// the only bound on how stale they get while there is no subscription,
+// e.g. because the server restarts, and for keys that expire on their own.
+
+// nearCacheEntryOverhead is the rough bookkeeping cost of an entry of the
+// near cache, on top of its key and value
+const nearCacheEntryOverhead = 64
+
+// resubscribeDelay is the wait before subscribing to the changes of keys
+// again after the subscription broke
+const resubscribeDelay = time.Second
+
+var errSubscriptionBroken = errors.New("the subscription to the changes of keys broke")
+
+// NearCacheStats are the statistics of the near cache of a client
+type NearCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+	Bytes   int64
+	// Subscribed is whether the server tells the client when cached keys
+	// change. Values are only cached for the TTL otherwise.
+	Subscribed bool
+}
+
+// WithNearCache caches the values that Get and GetBytes read in the client,
+// up to maxBytes of keys and values and for at most ttl each. Cached keys
+// are dropped as soon as the server says that they changed, and the values
+// of keys set with the client are dropped right away. It can not be
+// combined with WithSharding.
+func WithNearCache(maxBytes int64, ttl time.Duration) Option {
+	return func(o *options) error {
+		if maxBytes <= 0 || ttl <= 0 {
+			return errors.New("the size and TTL of the near cache must be positive")
+		}
+
+		o.nearCacheSize = maxBytes
+		o.nearCacheTTL = ttl
+		return nil
+	}
+}
+
+type nearCache struct {
+	c        *Client
+	maxBytes int64
+	ttl      time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// done is closed once the subscription stopped for good
+	done chan struct{}
+
+	mu      sync.Mutex
+	started bool
+	bytes   int64
+	entries map[string]*list.Element
+	// recency holds *nearEntry, most recently used at the front
+	recency *list.List
+	hits    uint64
+	misses  uint64
+
+	// sub is nil while there is no subscription. epoch changes whenever sub
+	// does, so that values read under one are not cached under another.
+	sub   *subscription
+	epoch uint64
+	// reads counts the reads in progress of every key, and stale marks the
+	// keys that changed during them, whose values are then not cached
+	reads map[string]int
+	stale map[string]bool
+	// unwatched are keys that left the cache, which the server stops
+	// watching with the next request
+	unwatched map[string]struct{}
+}
+
+type nearEntry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+// subscription is a Watch stream
+type subscription struct {
+	target string
+	stream pb.Invalidation_WatchClient
+
+	// sendMu orders the requests
+	sendMu sync.Mutex
+	nextID uint64
+
+	mu sync.Mutex
+	// acknowledged are closed once the server acknowledges their request
+	acknowledged map[uint64]chan struct{}
+	// broken is closed once the stream ended
+	broken chan struct{}
+}
+
+func newNearCache(c *Client, maxBytes int64, ttl time.Duration) *nearCache {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &nearCache{
+		c:         c,
+		maxBytes:  maxBytes,
+		ttl:       ttl,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		entries:   make(map[string]*list.Element),
+		recency:   list.New(),
+		reads:     make(map[string]int),
+		stale:     make(map[string]bool),
+		unwatched: make(map[string]struct{}),
+	}
+}
+
+// get returns the value of key from the cache, or reads it with read from
+// one of the targets that it is given and caches it
+func (nc *nearCache) get(
+	ctx context.Context,
+	key string,
+	read func(context.Context, func(context.Context) ([]string, error)) (string, error),
+) (string, error) {
+	value, ok, sub, epoch := nc.lookup(key)
+	if ok {
+		return value, nil
+	}
+
+	targets := nc.c.route(false)
+	if sub != nil {
+		if err := nc.watch(ctx, sub, key); err == nil {
+			targets = only(sub.target)
+		} else if ctx.Err() != nil {
+			nc.endRead(key, epoch, "", false)
+			return "", err
+		}
+		// otherwise the subscription broke, which changed the epoch
+	}
+
+	value, err := read(ctx, targets)
+	nc.endRead(key, epoch, value, err == nil)
+
+	return value, err
+}
+
+// lookup returns the cached value of key if there is one. Otherwise the
+// read of the key starts, in the returned subscription and epoch.
+func (nc *nearCache) lookup(key string) (string, bool, *subscription, uint64) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	if !nc.started {
+		nc.started = true
+		go nc.run()
+	}
+
+	if e, ok := nc.entries[key]; ok {
+		entry := e.Value.(*nearEntry)
+		if time.Now().Before(entry.expires) {
+			nc.hits++
+			nc.recency.MoveToFront(e)
+			return entry.value, true, nil, 0
+		}
+
+		nc.remove(key)
+	}
+
+	nc.misses++
+	nc.reads[key]++
+
+	return "", false, nc.sub, nc.epoch
+}
+
+// endRead ends a read of key, and caches the value read if it succeeded and
+// the key did not change since the read started
+func (nc *nearCache) endRead(key string, epoch uint64, value string, ok bool) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	stale := nc.stale[key]
+
+	nc.reads[key]--
+	if nc.reads[key] == 0 {
+		delete(nc.reads, key)
+		delete(nc.stale, key)
+	}
+
+	if ok && epoch == nc.epoch && !stale {
+		nc.put(key, value)
+	} else if _, cached := nc.entries[key]; !cached {
+		nc.unwatch(key)
+	}
+}
+
+// invalidate drops the value of key. The caller must hold the lock.
+func (nc *nearCache) invalidate(key string) {
+	if _, ok := nc.entries[key]; ok {
+		nc.remove(key)
+	}
+
+	if nc.reads[key] > 0 {
+		nc.stale[key] = true
+	}
+}
+
+// invalidateAll drops every value. The caller must hold the lock.
+func (nc *nearCache) invalidateAll() {
+	clear(nc.entries)
+	nc.recency.Init()
+	nc.bytes = 0
+
+	for key := range nc.reads {
+		nc.stale[key] = true
+	}
+}
+
+// changed drops the value of a key that was set with the client
+func (nc *nearCache) changed(key string) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.invalidate(key)
+}
+
+// put caches the value of key, evicting the least recently used values to
+// make room for it. The caller must hold the lock.
+func (nc *nearCache) put(key, value string) {
+	if _, ok := nc.entries[key]; ok {
+		nc.remove(key)
+	}
+
+	size := int64(len(key)+len(value)) + nearCacheEntryOverhead
+	if size > nc.maxBytes {
+		nc.unwatch(key)
+		return
+	}
+
+	for nc.bytes+size > nc.maxBytes {
+		nc.remove(nc.recency.Back().Value.(*nearEntry).key)
+	}
+
+	nc.entries[key] = nc.recency.PushFront(&nearEntry{key: key, value: value, expires: time.Now().Add(nc.ttl)})
+	nc.bytes += size
+	delete(nc.unwatched, key)
+}
+
+// remove drops the cached value of key, and stops watching it. The caller
+// must hold the lock.
+func (nc *nearCache) remove(key string) {
+	e := nc.entries[key]
+	entry := e.Value.(*nearEntry)
+
+	nc.recency.Remove(e)
+	delete(nc.entries, key)
+	nc.bytes -= int64(len(entry.key)+len(entry.value)) + nearCacheEntryOverhead
+
+	nc.unwatch(key)
+}
+
+// unwatch has the server stop watching key, unless it is being read. The
+// caller must hold the lock.
+func (nc *nearCache) unwatch(key string) {
+	if nc.sub != nil && nc.reads[key] == 0 {
+		nc.unwatched[key] = struct{}{}
+	}
+}
+
+// watch has the server of sub watch key, and waits until it does. The
+// keys that left the cache since the last request stop being watched.
+func (nc *nearCache) watch(ctx context.Context, sub *subscription, key string) error {
+	sub.sendMu.Lock()
+
+	nc.mu.Lock()
+	var remove [][]byte
+	for k := range nc.unwatched {
+		if _, cached := nc.entries[k]; !cached && nc.reads[k] == 0 {
+			remove = append(remove, []byte(k))
+		}
+	}
+	clear(nc.unwatched)
+	nc.mu.Unlock()
+
+	sub.nextID++
+	id := sub.nextID
+	acknowledged := sub.await(id)
+
+	err := sub.stream.Send(&pb.WatchRequest{Id: id, Add: [][]byte{[]byte(key)}, Remove: remove})
+	sub.sendMu.Unlock()
+
+	if err != nil {
+		return errSubscriptionBroken
+	}
+
+	select {
+	case <-acknowledged:
+		return nil
+	case <-sub.broken:
+		return errSubscriptionBroken
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// await returns a channel that is closed once the server acknowledges the
+// request with the given id
+func (sub *subscription) await(id uint64) <-chan struct{} {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	ch := make(chan struct{})
+	sub.acknowledged[id] = ch
+
+	return ch
+}
+
+// acknowledge closes the channels of the requests up to id
+func (sub *subscription) acknowledge(id uint64) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	for i, ch := range sub.acknowledged {
+		if i <= id {
+			close(ch)
+			delete(sub.acknowledged, i)
+		}
+	}
+}
+
+// run subscribes to the changes of cached keys, and subscribes again
+// whenever the subscription breaks, until the client is closed
+func (nc *nearCache) run() {
+	defer close(nc.done)
+
+	for {
+		sub, err := nc.subscribe()
+		if err == nil {
+			nc.setSubscription(sub)
+			nc.follow(sub)
+			nc.setSubscription(nil)
+		}
+
+		select {
+		case <-time.After(resubscribeDelay):
+		case <-nc.ctx.Done():
+			return
+		}
+	}
+}
+
+// subscribe opens a Watch stream to one of the servers of the client, which
+// holds once the server acknowledged a first request
+func (nc *nearCache) subscribe() (*subscription, error) {
+	targets, err := nc.c.route(false)(nc.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := nc.c.conn(targets[0])
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := pb.NewInvalidationClient(conn).Watch(nc.c.outgoing(nc.ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &subscription{
+		target:       targets[0],
+		stream:       stream,
+		nextID:       1,
+		acknowledged: make(map[uint64]chan struct{}),
+		broken:       make(chan struct{}),
+	}
+
+	if err := stream.Send(&pb.WatchRequest{Id: 1}); err != nil {
+		return nil, err
+	}
+
+	for {
+		reply, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+
+		if reply.Acknowledged >= 1 {
+			return sub, nil
+		}
+	}
+}
+
+// setSubscription switches to sub, or to caching for the TTL only if it is
+// nil. Values cached before a subscription are dropped, as their keys are
+// not watched.
+func (nc *nearCache) setSubscription(sub *subscription) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.sub = sub
+	nc.epoch++
+	clear(nc.unwatched)
+
+	if sub != nil {
+		nc.invalidateAll()
+	}
+}
+
+// follow drops the values of the keys that the server of sub says changed,
+// until the stream breaks
+func (nc *nearCache) follow(sub *subscription) {
+	defer close(sub.broken)
+
+	for {
+		reply, err := sub.stream.Recv()
+		if err != nil {
+			return
+		}
+
+		nc.mu.Lock()
+		if reply.All {
+			nc.invalidateAll()
+		}
+		for _, key := range reply.Keys {
+			nc.invalidate(string(key))
+		}
+		nc.mu.Unlock()
+
+		sub.acknowledge(reply.Acknowledged)
+	}
+}
+
+// stop ends the subscription
+func (nc *nearCache) stop() {
+	nc.cancel()
+
+	nc.mu.Lock()
+	started := nc.started
+	nc.started = true
+	nc.mu.Unlock()
+
+	if started {
+		<-nc.done
+	}
+}
+
+func (nc *nearCache) stats() NearCacheStats {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	return NearCacheStats{
+		Hits:       nc.hits,
+		Misses:     nc.misses,
+		Entries:    len(nc.entries),
+		Bytes:      nc.bytes,
+		Subscribed: nc.sub != nil,
+	}
+}
+
+// NearCacheStats returns the statistics of the near cache of the client,
+// which are zero without WithNearCache
+func (c *Client) NearCacheStats() NearCacheStats {
+	if c.nearCache == nil {
+		return NearCacheStats{}
+	}
+
+	return c.nearCache.stats()
+}
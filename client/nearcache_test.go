@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_Client_nearCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	addr, stop := startServer(t, "-data-dir", dir)
+
+	writer, err := New(WithAddr(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	cached, err := New(WithAddr(addr), WithNearCache(1<<20, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cached.Close()
+
+	valueOf := func(c *Client, key string) string {
+		value, err := c.Get(ctx, key)
+		if err != nil {
+			return ""
+		}
+		return value
+	}
+
+	if err := writer.Set(ctx, "config", "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the near cache to subscribe", func() bool {
+		return valueOf(cached, "config") == "v1" && cached.NearCacheStats().Subscribed
+	})
+
+	before := cached.NearCacheStats()
+	for range 100 {
+		if got := valueOf(cached, "config"); got != "v1" {
+			t.Fatalf("got = %q, want = v1", got)
+		}
+	}
+
+	if hits := cached.NearCacheStats().Hits - before.Hits; hits < 99 {
+		t.Errorf("hits = %d, want the value read once and then cached", hits)
+	}
+
+	// a write of another client invalidates the cached value
+	if err := writer.Set(ctx, "config", "v2"); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the cached value to be invalidated", func() bool {
+		return valueOf(cached, "config") == "v2"
+	})
+
+	// a write of the client itself is read right away
+	for _, value := range []string{"1", "2"} {
+		if err := cached.Set(ctx, "own", value); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := valueOf(cached, "own"); got != value {
+			t.Errorf("got = %q, want = %q", got, value)
+		}
+	}
+
+	// values are cached for the TTL only while the server is away
+	stop()
+
+	eventually(t, "the subscription to break", func() bool {
+		return !cached.NearCacheStats().Subscribed
+	})
+
+	if got := valueOf(cached, "config"); got != "v2" {
+		t.Errorf("got = %q while the server is away, want the cached v2", got)
+	}
+
+	runServer(t, "-addr", addr, "-data-dir", dir)
+
+	eventually(t, "the near cache to subscribe again", func() bool {
+		return cached.NearCacheStats().Subscribed
+	})
+
+	if err := writer.Set(ctx, "config", "v3"); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the cached value to be invalidated", func() bool {
+		return valueOf(cached, "config") == "v3"
+	})
+}
+
+func Test_Client_nearCache_bounds(t *testing.T) {
+	ctx := context.Background()
+	addr := runServer(t, "-data-dir", t.TempDir())
+
+	c, err := New(WithAddr(addr), WithNearCache(200, 100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// the cache is emptied when it subscribes
+	eventually(t, "the near cache to subscribe", func() bool {
+		c.Get(ctx, "missing")
+		return c.NearCacheStats().Subscribed
+	})
+
+	for i := range 10 {
+		key := fmt.Sprintf("key%d", i)
+		if err := c.Set(ctx, key, "value"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.Get(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stats := c.NearCacheStats(); stats.Bytes > 200 || stats.Entries == 0 {
+		t.Errorf("stats = %+v, want at most 200 bytes cached", stats)
+	}
+
+	if _, err := c.Get(ctx, "key9"); err != nil {
+		t.Fatal(err)
+	}
+	hit := c.NearCacheStats()
+
+	time.Sleep(150 * time.Millisecond)
+
+	if _, err := c.Get(ctx, "key9"); err != nil {
+		t.Fatal(err)
+	}
+
+	if misses := c.NearCacheStats().Misses - hit.Misses; misses != 1 {
+		t.Errorf("misses = %d after the TTL, want = 1", misses)
+	}
+}
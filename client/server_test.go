@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arpitchauhan/simple-database/internal/server"
+)
+
+// runServer runs a server in-process with args until the test ends, and
+// returns its address
+func runServer(t *testing.T, args ...string) string {
+	t.Helper()
+
+	addr, _ := startServer(t, args...)
+	return addr
+}
+
+// startServer is runServer for servers that a test stops itself, by calling
+// the returned function
+func startServer(t *testing.T, args ...string) (string, func()) {
+	t.Helper()
+
+	cfg, err := server.ParseConfig(append([]string{"-addr", "localhost:0", "-log-level", "error"}, args...), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	addrs := make(chan net.Addr, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx, cfg, func(addr net.Addr) { addrs <- addr })
+	}()
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			if err := <-done; err != nil {
+				t.Errorf("error = %v, did not want error", err)
+			}
+		})
+	}
+	t.Cleanup(stop)
+
+	select {
+	case addr := <-addrs:
+		return addr.String(), stop
+	case err := <-done:
+		t.Fatalf("server failed to start: %v", err)
+		return "", nil
+	}
+}
+
+// newTestClient returns a client that is closed when the test ends
+func newTestClient(t *testing.T, opts ...Option) *Client {
+	t.Helper()
+
+	c, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+// freeAddr returns an address that nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	return lis.Addr().String()
+}
+
+// eventually retries check until it returns true, and fails the test if it
+// does not within a few seconds.
+func eventually(t *testing.T, what string, check func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !check(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+	"github.com/arpitchauhan/simple-database/sharding"
+)
+
+// RebalancePollInterval is how often Rebalance checks whether the shards
+// moved their keys
+var RebalancePollInterval = time.Second
+
+// executeOnShard executes a request on the shard of key, or on the server
+// of the client if sharding is not enabled
+func executeOnShard[T any](
+	ctx context.Context,
+	c *Client,
+	key string,
+	write bool,
+	requestFn func(*grpc.ClientConn, context.Context) (T, error),
+) (T, error) {
+	if !c.opts.sharded {
+		return executeOnAny(ctx, c, c.route(write), requestFn)
+	}
+
+	m, err := c.cachedShardMap(ctx, false)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	result, err := execute(ctx, c, m.Owner(key).Addr, requestFn)
+
+	// the shard map changed since it was fetched
+	if status.Code(err) == codes.Aborted {
+		m, err = c.cachedShardMap(ctx, true)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+
+		return execute(ctx, c, m.Owner(key).Addr, requestFn)
+	}
+
+	return result, err
+}
+
+// cachedShardMap returns the cached shard map, after fetching it if there
+// is none or refresh is set. It is fetched from the server or endpoints of
+// the client, and from the shards of the cached map if those are not up to
+// date.
+func (c *Client) cachedShardMap(ctx context.Context, refresh bool) (*sharding.Map, error) {
+	c.shardMapMu.Lock()
+	defer c.shardMapMu.Unlock()
+
+	if c.shardMap != nil && !refresh {
+		return c.shardMap, nil
+	}
+
+	targets := []string{c.opts.addr}
+	if len(c.opts.endpoints) > 0 {
+		targets = slices.Clone(c.opts.endpoints)
+	}
+	if c.shardMap != nil {
+		for _, s := range c.shardMap.All() {
+			targets = append(targets, s.Addr)
+		}
+	}
+
+	var err error
+	for _, target := range targets {
+		var reply *pb.GetShardMapReply
+		reply, err = c.getShardMapFrom(ctx, target)
+		if err != nil {
+			continue
+		}
+
+		var m *sharding.Map
+		m, err = sharding.FromProto(reply.Map)
+		if err != nil {
+			continue
+		}
+
+		if c.shardMap == nil || m.Version > c.shardMap.Version {
+			c.shardMap = m
+			return c.shardMap, nil
+		}
+	}
+
+	if c.shardMap != nil {
+		return c.shardMap, nil
+	}
+
+	return nil, err
+}
+
+func (c *Client) getShardMapFrom(ctx context.Context, target string) (*pb.GetShardMapReply, error) {
+	return execute(ctx, c, target, func(conn *grpc.ClientConn, ctx context.Context) (*pb.GetShardMapReply, error) {
+		return pb.NewShardingClient(conn).GetShardMap(ctx, &pb.GetShardMapRequest{})
+	})
+}
+
+func (c *Client) setShardMapOn(ctx context.Context, target string, m *sharding.Map) error {
+	_, err := execute(ctx, c, target, func(conn *grpc.ClientConn, ctx context.Context) (*pb.SetShardMapReply, error) {
+		return pb.NewShardingClient(conn).SetShardMap(ctx, &pb.SetShardMapRequest{Map: m.Proto()})
+	})
+
+	return err
+}
+
+// GetShardMap gets the shard map of the server
+func (c *Client) GetShardMap(ctx context.Context) (*pb.GetShardMapReply, error) {
+	return c.getShardMapFrom(ctx, c.opts.addr)
+}
+
+// Rebalance moves the keys of a sharded deployment to a new set of shards,
+// whose map is fetched from the server of the client. It gives every shard a
+// map that lists both the new and the previous shards, waits for the
+// previous shards to move their keys, and then gives every shard the map of
+// the new shards only. Keys stay available while they are moved. A
+// rebalancing that was interrupted is resumed by calling Rebalance again
+// with the same shards. progress is called as the rebalancing goes on.
+func (c *Client) Rebalance(ctx context.Context, shards []sharding.Shard, progress func(format string, args ...any)) error {
+	reply, err := c.GetShardMap(ctx)
+	if err != nil {
+		return err
+	}
+
+	current, err := sharding.FromProto(reply.Map)
+	if err != nil {
+		return err
+	}
+
+	moving := current
+	if !current.Rebalancing() {
+		moving, err = sharding.New(current.Version+1, current.VirtualNodes, shards, current.Shards)
+		if err != nil {
+			return err
+		}
+	} else if !slices.Equal(current.Shards, shards) {
+		return fmt.Errorf("shard map version %d is still being rebalanced to other shards", current.Version)
+	}
+
+	for _, s := range moving.All() {
+		if err := c.setShardMapOn(ctx, s.Addr, moving); err != nil {
+			return fmt.Errorf("shard %s: %w", s.ID, err)
+		}
+	}
+
+	progress("Moving keys with shard map version %d", moving.Version)
+
+	for _, s := range moving.Previous {
+		for {
+			reply, err := c.getShardMapFrom(ctx, s.Addr)
+			if err != nil {
+				return fmt.Errorf("shard %s: %w", s.ID, err)
+			}
+
+			if reply.Map.GetVersion() == moving.Version && !reply.Migrating {
+				progress("Shard %s moved its keys, %d since it started", s.ID, reply.MigratedKeys)
+				break
+			}
+
+			select {
+			case <-time.After(RebalancePollInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	done, err := sharding.New(moving.Version+1, moving.VirtualNodes, shards, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range moving.All() {
+		if err := c.setShardMapOn(ctx, s.Addr, done); err != nil {
+			return fmt.Errorf("shard %s: %w", s.ID, err)
+		}
+	}
+
+	progress("Shard map version %d is in place", done.Version)
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+	"github.com/arpitchauhan/simple-database/sharding"
+)
+
+// shardMapServer serves a shard map, and counts how often it was fetched
+type shardMapServer struct {
+	pb.UnimplementedShardingServer
+	m       *sharding.Map
+	fetched atomic.Int32
+}
+
+func (s *shardMapServer) GetShardMap(ctx context.Context, in *pb.GetShardMapRequest) (*pb.GetShardMapReply, error) {
+	s.fetched.Add(1)
+	return &pb.GetShardMapReply{Map: s.m.Proto()}, nil
+}
+
+func serveShardMap(t *testing.T, m *sharding.Map) (string, *shardMapServer) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &shardMapServer{m: m}
+	gs := grpc.NewServer()
+	pb.RegisterShardingServer(gs, s)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	return lis.Addr().String(), s
+}
+
+func Test_Client_sharded_writeToReplica(t *testing.T) {
+	ctx := context.Background()
+
+	primaryAddr := runServer(t, "-data-dir", t.TempDir())
+	replicaAddr := runServer(t, "-data-dir", t.TempDir(), "-replica-of", primaryAddr)
+
+	// a shard map that sends every key to the replica
+	m, err := sharding.New(1, sharding.DefaultVirtualNodes, []sharding.Shard{{ID: "s1", Addr: replicaAddr}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, maps := serveShardMap(t, m)
+
+	c := newTestClient(t, WithAddr(addr), WithSharding(true))
+
+	if err := c.Set(ctx, "key", "value"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("error = %v, want FailedPrecondition", err)
+	}
+
+	// the rejection is not taken for a stale shard map
+	if fetched := maps.fetched.Load(); fetched != 1 {
+		t.Errorf("shard map fetched %d times, want once", fetched)
+	}
+}
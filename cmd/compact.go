@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"github.com/arpitchauhan/simple-database/client"
+	"github.com/spf13/cobra"
+)
+
+var compact = client.Compact
+
+// compactCmd represents the compact command
+var compactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Reclaim the space taken by overwritten values",
+	Long: "Rewrite the database file with only the latest value of every key. " +
+		"This also re-encrypts every value with the active encryption key.",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := compact()
+
+		if err != nil {
+			return requestFailed(err, requestMessages)
+		}
+
+		output := compactOutput{
+			RecordsBefore: result.RecordsBefore,
+			RecordsAfter:  result.RecordsAfter,
+			BytesBefore:   result.BytesBefore,
+			BytesAfter:    result.BytesAfter,
+		}
+
+		return printResult(cmd, output, func() {
+			cmd.Printf(
+				"Kept %d of %d records, %d bytes down to %d\n",
+				result.RecordsAfter,
+				result.RecordsBefore,
+				result.BytesBefore,
+				result.BytesAfter,
+			)
+		})
+	},
+}
+
+// compactOutput is the result of the compact command with --output json
+type compactOutput struct {
+	RecordsBefore uint64 `json:"records_before"`
+	RecordsAfter  uint64 `json:"records_after"`
+	BytesBefore   int64  `json:"bytes_before"`
+	BytesAfter    int64  `json:"bytes_after"`
+}
+
+func init() {
+	rootCmd.AddCommand(compactCmd)
+}
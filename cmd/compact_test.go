@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+func Test_Compact(t *testing.T) {
+	tests := []struct {
+		name         string
+		flags        []string
+		receivedCode codes.Code
+		receivedMsg  string
+		want         string
+		wantErr      string
+		wantExitCode int
+	}{
+		{
+			name:         "Successful compaction",
+			receivedCode: codes.OK,
+			want:         "Kept 2 of 5 records, 100 bytes down to 40\n",
+		},
+		{
+			name:         "JSON output",
+			flags:        []string{"--output", "json"},
+			receivedCode: codes.OK,
+			want:         `{"records_before":5,"records_after":2,"bytes_before":100,"bytes_after":40}` + "\n",
+		},
+		{
+			name:         "Server not running",
+			receivedCode: codes.Unavailable,
+			wantErr:      "the server is not running",
+			wantExitCode: 24,
+		},
+		{
+			name:         "Shard being rebalanced",
+			receivedCode: codes.FailedPrecondition,
+			receivedMsg:  "Shard is being rebalanced, compact it once that is done",
+			wantErr:      "Shard is being rebalanced, compact it once that is done",
+			wantExitCode: 19,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// override the fn used to compact the database on the server
+			compact = func() (*pb.CompactReply, error) {
+				result := &pb.CompactReply{RecordsBefore: 5, RecordsAfter: 2, BytesBefore: 100, BytesAfter: 40}
+
+				return result, status.Error(tt.receivedCode, tt.receivedMsg)
+			}
+
+			outputFlag = outputText
+			out, err := executeCompactCmd(t, tt.flags)
+
+			if errorMessage(err) != tt.wantErr {
+				t.Errorf("error = %v, want = %v", err, tt.wantErr)
+			}
+
+			if code := exitCode(err); code != tt.wantExitCode {
+				t.Errorf("exit code = %d, want = %d", code, tt.wantExitCode)
+			}
+
+			if out != tt.want {
+				t.Errorf("got = %v, want = %v", out, tt.want)
+				return
+			}
+		})
+	}
+}
+
+func executeCompactCmd(t *testing.T, flags []string) (string, error) {
+	t.Helper()
+
+	b := bytes.NewBufferString("")
+	compactCmd.SetOut(b)
+	os.Args = append([]string{"", "compact"}, flags...)
+	cmdErr := compactCmd.Execute()
+
+	out, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Fatalf("Error reading output of command: %v", err)
+	}
+
+	return string(out), cmdErr
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/arpitchauhan/simple-database/client"
+)
+
+// The server address comes from, in order of precedence, the --addr flag,
+// the SIMPLE_DATABASE_ADDR environment variable, and a profile from the
+// config file. The profile is picked with --profile or
+// SIMPLE_DATABASE_PROFILE, and is "default" otherwise. TLS settings come
+// from the --tls flags, and from the profile otherwise. The token comes
+// from --token, SIMPLE_DATABASE_TOKEN or the profile, in the same way as
+// the address. Requests are routed to the shards of a sharded deployment
+// with --sharded, or if the profile is sharded.
+const (
+	addrEnv        = "SIMPLE_DATABASE_ADDR"
+	tokenEnv       = "SIMPLE_DATABASE_TOKEN"
+	profileEnv     = "SIMPLE_DATABASE_PROFILE"
+	defaultProfile = "default"
+	configFileName = ".simple-database.json"
+)
+
+var (
+	addrFlag    string
+	profileFlag string
+	configFlag  string
+
+	tlsFlag     bool
+	tlsCAFlag   string
+	tlsCertFlag string
+	tlsKeyFlag  string
+
+	tokenFlag string
+
+	shardedFlag bool
+
+	setAddr     = client.SetAddr
+	setTLS      = client.SetTLS
+	setToken    = client.SetToken
+	setSharding = client.SetSharding
+)
+
+// clientConfig is the contents of the config file, for example:
+//
+//	{"profiles": {"default": {"addr": "localhost:50051"}, "prod": {"addr": "db:50051", "tls_ca": "ca.pem"}}}
+type clientConfig struct {
+	Profiles map[string]profile `json:"profiles"`
+}
+
+type profile struct {
+	Addr string `json:"addr"`
+
+	// TLS is used if TLS is true or any of the TLS files is set
+	TLS     bool   `json:"tls"`
+	TLSCA   string `json:"tls_ca"`
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+
+	// Token authenticates with servers that require it
+	Token string `json:"token"`
+
+	// Sharded routes requests to the shards of a sharded deployment, with
+	// the shard map fetched from Addr
+	Sharded bool `json:"sharded"`
+}
+
+func defaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, configFileName)
+}
+
+func readClientConfig(path string) (*clientConfig, error) {
+	config := &clientConfig{}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(contents, config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// loadProfile returns the selected profile. A missing config file is only
+// an error if a profile or config file was asked for explicitly.
+func loadProfile() (profile, error) {
+	name, explicit := profileFlag, profileFlag != ""
+	if !explicit {
+		name, explicit = os.LookupEnv(profileEnv)
+	}
+	if !explicit {
+		name = defaultProfile
+	}
+
+	path := configFlag
+	if path == "" {
+		path = defaultConfigPath()
+	} else {
+		explicit = true
+	}
+
+	config, err := readClientConfig(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return profile{}, nil
+	} else if err != nil {
+		return profile{}, err
+	}
+
+	p, ok := config.Profiles[name]
+	if !ok && explicit {
+		return profile{}, fmt.Errorf("%s: no profile named %q", path, name)
+	}
+
+	return p, nil
+}
+
+func resolveAddr() (string, error) {
+	if addrFlag != "" {
+		return addrFlag, nil
+	}
+
+	if addr, ok := os.LookupEnv(addrEnv); ok {
+		return addr, nil
+	}
+
+	p, err := loadProfile()
+	if err != nil {
+		return "", err
+	}
+
+	if p.Addr != "" {
+		return p.Addr, nil
+	}
+
+	return client.DefaultAddr, nil
+}
+
+// resolveTLS returns the TLS options to connect with, and whether to use
+// TLS at all. Each --tls flag overrides the same setting of the profile.
+func resolveTLS() (client.TLSOptions, bool, error) {
+	p, err := loadProfile()
+	if err != nil {
+		return client.TLSOptions{}, false, err
+	}
+
+	opts := client.TLSOptions{CAFile: p.TLSCA, CertFile: p.TLSCert, KeyFile: p.TLSKey}
+
+	if tlsCAFlag != "" {
+		opts.CAFile = tlsCAFlag
+	}
+	if tlsCertFlag != "" {
+		opts.CertFile = tlsCertFlag
+	}
+	if tlsKeyFlag != "" {
+		opts.KeyFile = tlsKeyFlag
+	}
+
+	enabled := tlsFlag || p.TLS || opts != client.TLSOptions{}
+
+	return opts, enabled, nil
+}
+
+// resolveToken returns the token to authenticate with, or an empty string
+// to send none.
+func resolveToken() (string, error) {
+	if tokenFlag != "" {
+		return tokenFlag, nil
+	}
+
+	if token, ok := os.LookupEnv(tokenEnv); ok {
+		return token, nil
+	}
+
+	p, err := loadProfile()
+	if err != nil {
+		return "", err
+	}
+
+	return p.Token, nil
+}
+
+// resolveSharding returns whether to route requests to the shards of a
+// sharded deployment.
+func resolveSharding() (bool, error) {
+	if shardedFlag {
+		return true, nil
+	}
+
+	p, err := loadProfile()
+	if err != nil {
+		return false, err
+	}
+
+	return p.Sharded, nil
+}
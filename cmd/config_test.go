@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arpitchauhan/simple-database/client"
+)
+
+func Test_resolveAddr(t *testing.T) {
+	const configFile = `{"profiles": {` +
+		`"default": {"addr": "default-host:50051"}, ` +
+		`"prod": {"addr": "prod-host:50051"}}}`
+
+	tests := []struct {
+		name         string
+		addrFlag     string
+		profileFlag  string
+		env          map[string]string
+		noConfigFile bool
+		want         string
+		wantErr      bool
+	}{
+		{
+			name:         "No configuration",
+			noConfigFile: true,
+			want:         "localhost:50051",
+		},
+		{
+			name: "Default profile",
+			want: "default-host:50051",
+		},
+		{
+			name:        "Profile from flag",
+			profileFlag: "prod",
+			want:        "prod-host:50051",
+		},
+		{
+			name: "Profile from environment",
+			env:  map[string]string{"SIMPLE_DATABASE_PROFILE": "prod"},
+			want: "prod-host:50051",
+		},
+		{
+			name:        "Address from environment overrides profile",
+			profileFlag: "prod",
+			env:         map[string]string{"SIMPLE_DATABASE_ADDR": "env-host:50051"},
+			want:        "env-host:50051",
+		},
+		{
+			name:     "Address from flag overrides everything",
+			addrFlag: "flag-host:50051",
+			env:      map[string]string{"SIMPLE_DATABASE_ADDR": "env-host:50051"},
+			want:     "flag-host:50051",
+		},
+		{
+			name:        "Unknown profile",
+			profileFlag: "staging",
+			wantErr:     true,
+		},
+		{
+			name:         "Profile without a config file",
+			profileFlag:  "prod",
+			noConfigFile: true,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			t.Setenv("SIMPLE_DATABASE_ADDR", "")
+			os.Unsetenv("SIMPLE_DATABASE_ADDR")
+			t.Setenv("SIMPLE_DATABASE_PROFILE", "")
+			os.Unsetenv("SIMPLE_DATABASE_PROFILE")
+
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			if !tt.noConfigFile {
+				path := filepath.Join(home, configFileName)
+				if err := os.WriteFile(path, []byte(configFile), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			addrFlag, profileFlag, configFlag = tt.addrFlag, tt.profileFlag, ""
+			t.Cleanup(func() { addrFlag, profileFlag = "", "" })
+
+			got, err := resolveAddr()
+
+			if err != nil {
+				if !tt.wantErr {
+					t.Errorf("error = %v, did not want error", err)
+				}
+				return
+			}
+
+			if tt.wantErr {
+				t.Fatalf("wanted error")
+			}
+
+			if got != tt.want {
+				t.Errorf("got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolveTLS(t *testing.T) {
+	const configFile = `{"profiles": {` +
+		`"default": {"addr": "localhost:50051"}, ` +
+		`"prod": {"addr": "prod-host:50051", "tls_ca": "prod-ca.pem"}}}`
+
+	tests := []struct {
+		name        string
+		profileFlag string
+		tlsFlag     bool
+		caFlag      string
+		certFlag    string
+		keyFlag     string
+		want        client.TLSOptions
+		wantEnabled bool
+	}{
+		{
+			name: "No TLS",
+		},
+		{
+			name:        "TLS with the system CAs",
+			tlsFlag:     true,
+			wantEnabled: true,
+		},
+		{
+			name:        "TLS from profile",
+			profileFlag: "prod",
+			want:        client.TLSOptions{CAFile: "prod-ca.pem"},
+			wantEnabled: true,
+		},
+		{
+			name:        "Flags override profile",
+			profileFlag: "prod",
+			caFlag:      "ca.pem",
+			certFlag:    "client.pem",
+			keyFlag:     "client.key",
+			want:        client.TLSOptions{CAFile: "ca.pem", CertFile: "client.pem", KeyFile: "client.key"},
+			wantEnabled: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			t.Setenv("SIMPLE_DATABASE_PROFILE", "")
+			os.Unsetenv("SIMPLE_DATABASE_PROFILE")
+
+			path := filepath.Join(home, configFileName)
+			if err := os.WriteFile(path, []byte(configFile), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			profileFlag, configFlag = tt.profileFlag, ""
+			tlsFlag, tlsCAFlag, tlsCertFlag, tlsKeyFlag = tt.tlsFlag, tt.caFlag, tt.certFlag, tt.keyFlag
+			t.Cleanup(func() {
+				profileFlag = ""
+				tlsFlag, tlsCAFlag, tlsCertFlag, tlsKeyFlag = false, "", "", ""
+			})
+
+			got, enabled, err := resolveTLS()
+			if err != nil {
+				t.Fatalf("error = %v, did not want error", err)
+			}
+
+			if got != tt.want || enabled != tt.wantEnabled {
+				t.Errorf("got = %+v, %v, want = %+v, %v", got, enabled, tt.want, tt.wantEnabled)
+			}
+		})
+	}
+}
+
+func Test_resolveToken(t *testing.T) {
+	const configFile = `{"profiles": {` +
+		`"default": {"addr": "default-host:50051"}, ` +
+		`"prod": {"addr": "prod-host:50051", "token": "profile-token"}}}`
+
+	tests := []struct {
+		name        string
+		tokenFlag   string
+		profileFlag string
+		env         map[string]string
+		want        string
+	}{
+		{
+			name: "No token",
+			want: "",
+		},
+		{
+			name:        "Token from profile",
+			profileFlag: "prod",
+			want:        "profile-token",
+		},
+		{
+			name:        "Token from environment overrides profile",
+			profileFlag: "prod",
+			env:         map[string]string{"SIMPLE_DATABASE_TOKEN": "env-token"},
+			want:        "env-token",
+		},
+		{
+			name:      "Token from flag overrides everything",
+			tokenFlag: "flag-token",
+			env:       map[string]string{"SIMPLE_DATABASE_TOKEN": "env-token"},
+			want:      "flag-token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			t.Setenv("SIMPLE_DATABASE_TOKEN", "")
+			os.Unsetenv("SIMPLE_DATABASE_TOKEN")
+			t.Setenv("SIMPLE_DATABASE_PROFILE", "")
+			os.Unsetenv("SIMPLE_DATABASE_PROFILE")
+
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			path := filepath.Join(home, configFileName)
+			if err := os.WriteFile(path, []byte(configFile), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			tokenFlag, profileFlag, configFlag = tt.tokenFlag, tt.profileFlag, ""
+			t.Cleanup(func() { tokenFlag, profileFlag = "", "" })
+
+			got, err := resolveToken()
+			if err != nil {
+				t.Fatalf("error = %v, did not want error", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolveSharding(t *testing.T) {
+	const configFile = `{"profiles": {` +
+		`"default": {"addr": "default-host:50051"}, ` +
+		`"prod": {"addr": "prod-host:50051", "sharded": true}}}`
+
+	tests := []struct {
+		name        string
+		shardedFlag bool
+		profileFlag string
+		want        bool
+	}{
+		{
+			name: "Not sharded",
+			want: false,
+		},
+		{
+			name:        "Sharded profile",
+			profileFlag: "prod",
+			want:        true,
+		},
+		{
+			name:        "Sharded from flag",
+			shardedFlag: true,
+			want:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			t.Setenv("SIMPLE_DATABASE_PROFILE", "")
+			os.Unsetenv("SIMPLE_DATABASE_PROFILE")
+
+			path := filepath.Join(home, configFileName)
+			if err := os.WriteFile(path, []byte(configFile), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			shardedFlag, profileFlag, configFlag = tt.shardedFlag, tt.profileFlag, ""
+			t.Cleanup(func() { shardedFlag, profileFlag = false, "" })
+
+			got, err := resolveSharding()
+			if err != nil {
+				t.Fatalf("error = %v, did not want error", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/arpitchauhan/simple-database/client"
+	"github.com/spf13/cobra"
+)
+
+var diff = client.Diff
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff other-addr",
+	Short: "List the keys on which two servers differ",
+	Long: "List the keys on which the server of --addr differs from the server at other-addr. " +
+		"Keys that only --addr has are prefixed with -, keys that only other-addr has with +, " +
+		"and keys whose values differ with !.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		diffs, err := diff(args[0])
+
+		if err != nil {
+			return requestFailed(err, requestMessages)
+		}
+
+		output := diffOutput{Differences: []difference{}}
+		for _, d := range diffs {
+			output.Differences = append(output.Differences, difference{Key: string(d.Key), Kind: diffKinds[d.Kind].name})
+		}
+
+		return printResult(cmd, output, func() {
+			for _, d := range diffs {
+				cmd.Printf("%s %s\n", diffKinds[d.Kind].prefix, printableKey(d.Key))
+			}
+
+			cmd.Printf("%d keys differ\n", len(diffs))
+		})
+	},
+}
+
+// diffKinds are how the diff command prints each kind of difference: with
+// a prefix in text, and by name in JSON
+var diffKinds = map[client.DifferenceKind]struct{ prefix, name string }{
+	client.OnlyInFirst:  {"-", "only_in_addr"},
+	client.OnlyInSecond: {"+", "only_in_other"},
+	client.Changed:      {"!", "changed"},
+}
+
+// diffOutput is the result of the diff command with --output json
+type diffOutput struct {
+	Differences []difference `json:"differences"`
+}
+
+type difference struct {
+	Key  string `json:"key"`
+	Kind string `json:"kind"`
+}
+
+// printableKey returns key as is if it is printable, and quoted otherwise
+func printableKey(key []byte) string {
+	s := string(key)
+	if utf8.ValidString(s) && !strings.ContainsFunc(s, func(r rune) bool { return !unicode.IsPrint(r) }) {
+		return s
+	}
+
+	return strconv.Quote(s)
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/arpitchauhan/simple-database/client"
+)
+
+func Test_Diff(t *testing.T) {
+	tests := []struct {
+		name         string
+		flags        []string
+		diffs        []client.Difference
+		receivedCode codes.Code
+		want         string
+		wantErr      string
+	}{
+		{
+			name: "Differences",
+			diffs: []client.Difference{
+				{Key: []byte("a"), Kind: client.OnlyInFirst},
+				{Key: []byte("b"), Kind: client.Changed},
+				{Key: []byte("c\x00"), Kind: client.OnlyInSecond},
+			},
+			receivedCode: codes.OK,
+			want:         "- a\n! b\n+ \"c\\x00\"\n3 keys differ\n",
+		},
+		{
+			name:  "JSON output",
+			flags: []string{"--output", "json"},
+			diffs: []client.Difference{
+				{Key: []byte("a"), Kind: client.OnlyInFirst},
+				{Key: []byte("b"), Kind: client.Changed},
+				{Key: []byte("c"), Kind: client.OnlyInSecond},
+			},
+			receivedCode: codes.OK,
+			want: `{"differences":[{"key":"a","kind":"only_in_addr"},{"key":"b","kind":"changed"},` +
+				`{"key":"c","kind":"only_in_other"}]}` + "\n",
+		},
+		{
+			name:         "No differences",
+			receivedCode: codes.OK,
+			want:         "0 keys differ\n",
+		},
+		{
+			name:         "Server not running",
+			receivedCode: codes.Unavailable,
+			wantErr:      "the server is not running",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotOther string
+			diff = func(other string) ([]client.Difference, error) {
+				gotOther = other
+				return tt.diffs, status.Error(tt.receivedCode, "")
+			}
+
+			outputFlag = outputText
+			b := bytes.NewBufferString("")
+			diffCmd.SetOut(b)
+			os.Args = append([]string{"", "diff", "db2:50051"}, tt.flags...)
+			if err := diffCmd.Execute(); errorMessage(err) != tt.wantErr {
+				t.Errorf("error = %v, want = %v", err, tt.wantErr)
+			}
+
+			out, err := io.ReadAll(b)
+			if err != nil {
+				t.Fatalf("Error reading output of command: %v", err)
+			}
+
+			if gotOther != "db2:50051" {
+				t.Errorf("diffed with %q, want %q", gotOther, "db2:50051")
+			}
+
+			if string(out) != tt.want {
+				t.Errorf("got = %q, want = %q", out, tt.want)
+			}
+		})
+	}
+}
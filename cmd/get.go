@@ -1,42 +1,93 @@
 package cmd
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
 	"github.com/arpitchauhan/simple-database/client"
 	"github.com/spf13/cobra"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
-var getValueForKey = client.GetValueForKey
+var getValueForKey = client.GetBytes
+
+// encoding of the value printed by the get command, empty for the default
+// "Answer: ..." output
+var getEncoding string
 
 // getCmd represents the get command
 var getCmd = &cobra.Command{
 	Use:   "get",
 	Short: "Get the latest value set for a key",
-	Long: "Get the latest value set for a key",
-	Args: cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	Long:  "Get the latest value set for a key",
+	Args:  cobra.ExactArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		switch getEncoding {
+		case "", "raw", "hex", "base64":
+			return nil
+		default:
+			return &usageError{err: fmt.Errorf("invalid encoding %q, must be raw, hex or base64", getEncoding)}
+		}
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
 		lookupKey := args[0]
-		answer, err := getValueForKey(lookupKey)
+		answer, err := getValueForKey([]byte(lookupKey))
 
 		if err != nil {
-			status, _ := status.FromError(err)
-
-			if status.Code() == codes.Unavailable {
-				cmd.Printf("Error: the server is not running")
-				return
-			} else if status.Code() == codes.NotFound {
-				cmd.Printf("Error: the key was not found")
-				return
+			return requestFailed(err, keyRequestMessages)
+		}
+
+		encoding, value := getEncoding, string(answer)
+		switch encoding {
+		case "hex":
+			value = hex.EncodeToString(answer)
+		case "base64":
+			value = base64.StdEncoding.EncodeToString(answer)
+		}
+
+		switch {
+		case outputFlag == outputJSON:
+			if encoding == "raw" {
+				encoding = ""
+			}
+
+			// JSON strings hold text only, so other values are encoded
+			if encoding == "" && !utf8.Valid(answer) {
+				encoding, value = "base64", base64.StdEncoding.EncodeToString(answer)
 			}
 
-			cobra.CheckErr(err)
+			return printJSON(cmd, getOutput{Key: lookupKey, Value: value, Encoding: encoding})
+		case outputFlag == outputRaw || encoding == "raw":
+			_, err = io.WriteString(cmd.OutOrStdout(), value)
+			return err
+		case encoding != "":
+			cmd.Println(value)
+		default:
+			cmd.Printf("Answer: %s\n", value)
 		}
 
-		cmd.Printf("Answer: %s", answer)
+		return nil
 	},
 }
 
+// getOutput is the result of the get command with --output json
+type getOutput struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// Encoding is hex or base64 if the value is encoded
+	Encoding string `json:"encoding,omitempty"`
+}
+
 func init() {
 	rootCmd.AddCommand(getCmd)
+
+	getCmd.Flags().StringVarP(
+		&getEncoding,
+		"encoding",
+		"e",
+		"",
+		"print only the value, as raw bytes or encoded as hex or base64",
+	)
 }
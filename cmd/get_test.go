@@ -11,41 +11,136 @@ import (
 )
 
 func Test_Get(t *testing.T) {
+	t.Cleanup(func() { getEncoding, outputFlag = "", outputText })
+
 	tests := []struct {
 		name         string
 		key          string
+		flags        []string
+		args         []string // overrides the flags and key as arguments
+		value        []byte
 		receivedCode codes.Code
 		want         string
+		wantErr      string
+		wantExitCode int
 	}{
 		{
 			name:         "Value returned for key",
 			key:          "key",
 			receivedCode: codes.OK,
-			want:         "Answer: value",
+			want:         "Answer: value\n",
 		},
 		{
 			name:         "Server not running",
 			receivedCode: codes.Unavailable,
-			want:         "Error: the server is not running",
+			wantErr:      "the server is not running",
+			wantExitCode: 24,
 		},
 		{
 			name:         "Key not present on server",
 			receivedCode: codes.NotFound,
-			want:         "Error: the key was not found",
+			wantErr:      "the key was not found",
+			wantExitCode: 15,
+		},
+		{
+			name:         "Other error",
+			receivedCode: codes.PermissionDenied,
+			wantErr:      "PermissionDenied",
+			wantExitCode: 17,
+		},
+		{
+			name:         "JSON output",
+			key:          "key",
+			flags:        []string{"--output", "json"},
+			receivedCode: codes.OK,
+			want:         `{"key":"key","value":"value"}` + "\n",
+		},
+		{
+			name:         "JSON output of a binary value",
+			key:          "key",
+			flags:        []string{"-o", "json"},
+			value:        []byte{0x00, 0xff},
+			receivedCode: codes.OK,
+			want:         `{"key":"key","value":"AP8=","encoding":"base64"}` + "\n",
+		},
+		{
+			name:         "JSON output of a hex-encoded value",
+			key:          "key",
+			flags:        []string{"-o", "json", "-e", "hex"},
+			value:        []byte{0x00, 0xff},
+			receivedCode: codes.OK,
+			want:         `{"key":"key","value":"00ff","encoding":"hex"}` + "\n",
+		},
+		{
+			name:         "Raw output",
+			key:          "key",
+			flags:        []string{"--output", "raw"},
+			value:        []byte{0x00, 0xff, '\n'},
+			receivedCode: codes.OK,
+			want:         "\x00\xff\n",
+		},
+		{
+			name:         "Raw value",
+			key:          "key",
+			flags:        []string{"--encoding", "raw"},
+			value:        []byte{0x00, 0xff, '\n'},
+			receivedCode: codes.OK,
+			want:         "\x00\xff\n",
+		},
+		{
+			name:         "Hex-encoded value",
+			key:          "key",
+			flags:        []string{"--encoding", "hex"},
+			value:        []byte{0x00, 0xff},
+			receivedCode: codes.OK,
+			want:         "00ff\n",
+		},
+		{
+			name:         "Base64-encoded value",
+			key:          "key",
+			flags:        []string{"-e", "base64"},
+			value:        []byte{0x00, 0xff},
+			receivedCode: codes.OK,
+			want:         "AP8=\n",
+		},
+		{
+			name:         "Invalid encoding",
+			args:         []string{"key", "--encoding", "octal"},
+			wantErr:      "invalid encoding \"octal\", must be raw, hex or base64",
+			wantExitCode: exitCodeUsage,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var receivedKey string
 
+			value := tt.value
+			if value == nil {
+				value = []byte("value")
+			}
+
 			// override the fn used to get key from server
-			getValueForKey = func(k string) (string, error) {
-				receivedKey = k
+			getValueForKey = func(k []byte) ([]byte, error) {
+				receivedKey = string(k)
+
+				return value, status.Error(tt.receivedCode, "")
+			}
+
+			args := tt.args
+			if args == nil {
+				args = append(tt.flags, tt.key)
+			}
+
+			getEncoding, outputFlag = "", outputText
+			out, err := executeGetCmd(t, args)
 
-				return "value", status.Error(tt.receivedCode, "")
+			if errorMessage(err) != tt.wantErr {
+				t.Errorf("error = %v, want = %v", err, tt.wantErr)
 			}
 
-			out := executeGetCmd(t, []string{tt.key})
+			if code := exitCode(err); code != tt.wantExitCode {
+				t.Errorf("exit code = %d, want = %d", code, tt.wantExitCode)
+			}
 
 			if receivedKey != tt.key {
 				t.Errorf(
@@ -64,21 +159,18 @@ func Test_Get(t *testing.T) {
 	}
 }
 
-func executeGetCmd(t *testing.T, args []string) string {
+func executeGetCmd(t *testing.T, args []string) (string, error) {
 	t.Helper()
 
 	b := bytes.NewBufferString("")
 	getCmd.SetOut(b)
 	os.Args = append([]string{"", "get"}, args...)
-	err := getCmd.Execute()
-	if err != nil {
-		t.Fatalf("Error executing command: %v", err)
-	}
+	cmdErr := getCmd.Execute()
 
 	out, err := ioutil.ReadAll(b)
 	if err != nil {
 		t.Fatalf("Error reading output of command: %v", err)
 	}
 
-	return string(out)
+	return string(out), cmdErr
 }
@@ -0,0 +1,328 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	historyFileName = ".simple-database_history"
+	// maxHistory is the number of lines of history that are kept
+	maxHistory = 1000
+)
+
+// errInterrupted is returned when a line is discarded with Ctrl-C
+var errInterrupted = errors.New("interrupted")
+
+// lineReader reads the lines of the shell
+type lineReader interface {
+	readLine(prompt string) (string, error)
+	addHistory(line string)
+}
+
+// plainReader reads lines from input that is not a terminal, such as a
+// script piped to the shell, without prompts
+type plainReader struct {
+	in *bufio.Reader
+}
+
+func (p *plainReader) readLine(prompt string) (string, error) {
+	line, err := p.in.ReadString('\n')
+	if errors.Is(err, io.EOF) && line != "" {
+		err = nil
+	}
+
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func (p *plainReader) addHistory(line string) {}
+
+// lineEditor reads lines typed at a terminal, which it puts in raw mode
+// while a line is typed. The left and right keys move in the line, up and
+// down browse the history, and Tab completes the word before the cursor.
+type lineEditor struct {
+	in  *bufio.Reader
+	out io.Writer
+	// raw puts the terminal in raw mode and returns the function that
+	// restores it, or is nil if the terminal is already in raw mode
+	raw func() (func() error, error)
+	// complete is given the text before the cursor, and returns where the
+	// word to complete starts in it and the words it may be completed to
+	complete func(before string) (int, []string)
+
+	history []string
+	// historyFile is where the history is saved, if anywhere
+	historyFile string
+}
+
+// newLineReader returns a lineEditor if in is a terminal, and a plainReader
+// otherwise
+func newLineReader(in io.Reader, out io.Writer, complete func(before string) (int, []string)) lineReader {
+	f, ok := in.(*os.File)
+	if !ok || !isTerminal(f) {
+		return &plainReader{in: bufio.NewReader(in)}
+	}
+
+	e := &lineEditor{
+		in:       bufio.NewReader(f),
+		out:      out,
+		raw:      func() (func() error, error) { return makeRaw(f) },
+		complete: complete,
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		e.loadHistory(filepath.Join(home, historyFileName))
+	}
+
+	return e
+}
+
+func ctrl(r rune) rune {
+	return r & 0x1f
+}
+
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if e.raw != nil {
+		restore, err := e.raw()
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+
+	var line []rune
+	pos := 0
+	// browsing is the index in the history of the line shown, or the
+	// length of the history for the line being typed, which typed keeps
+	// while the history is browsed
+	browsing := len(e.history)
+	var typed []rune
+
+	show := func(s string) {
+		line = []rune(s)
+		pos = len(line)
+	}
+
+	for {
+		e.redraw(prompt, line, pos)
+
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		if r == 27 {
+			r = e.readEscape()
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(line), nil
+		case ctrl('C'):
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case ctrl('D'):
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case 127, ctrl('H'):
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case ctrl('A'), keyHome:
+			pos = 0
+		case ctrl('E'), keyEnd:
+			pos = len(line)
+		case ctrl('B'), keyLeft:
+			pos = max(pos-1, 0)
+		case ctrl('F'), keyRight:
+			pos = min(pos+1, len(line))
+		case ctrl('U'):
+			line = line[pos:]
+			pos = 0
+		case ctrl('K'):
+			line = line[:pos]
+		case ctrl('P'), keyUp:
+			if browsing > 0 {
+				if browsing == len(e.history) {
+					typed = line
+				}
+				browsing--
+				show(e.history[browsing])
+			}
+		case ctrl('N'), keyDown:
+			if browsing < len(e.history) {
+				browsing++
+				if browsing == len(e.history) {
+					show(string(typed))
+				} else {
+					show(e.history[browsing])
+				}
+			}
+		case '\t':
+			line, pos = e.completeAt(line, pos)
+		default:
+			if unicode.IsPrint(r) {
+				line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+				pos++
+			}
+		}
+	}
+}
+
+// keys read from escape sequences, which are outside of the runes that
+// can be typed
+const (
+	keyUp rune = unicode.MaxRune + 1 + iota
+	keyDown
+	keyRight
+	keyLeft
+	keyHome
+	keyEnd
+	keyUnknown
+)
+
+// readEscape reads the rest of an escape sequence that a key sent
+func (e *lineEditor) readEscape() rune {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return keyUnknown
+	}
+
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return keyUnknown
+	}
+
+	switch r {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	}
+
+	// sequences such as ESC [ 3 ~ for the delete key end with a ~
+	param := string(r)
+	for r >= '0' && r <= '9' {
+		if r, _, err = e.in.ReadRune(); err != nil {
+			return keyUnknown
+		}
+		param += string(r)
+	}
+
+	switch param {
+	case "1~", "7~":
+		return keyHome
+	case "4~", "8~":
+		return keyEnd
+	case "3~":
+		return ctrl('D')
+	}
+
+	return keyUnknown
+}
+
+// completeAt completes the word before pos in line as far as its words
+// agree, or lists them if they already agree no further
+func (e *lineEditor) completeAt(line []rune, pos int) ([]rune, int) {
+	if e.complete == nil {
+		return line, pos
+	}
+
+	before := string(line[:pos])
+	start, words := e.complete(before)
+	if len(words) == 0 {
+		return line, pos
+	}
+
+	completed := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, completed) {
+			_, size := utf8.DecodeLastRuneInString(completed)
+			completed = completed[:len(completed)-size]
+		}
+	}
+
+	if len(words) == 1 {
+		completed += " "
+	}
+
+	if len(completed) > len(before)-start {
+		head := []rune(before[:start] + completed)
+		return append(head, line[pos:]...), len(head)
+	}
+
+	fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(words, "  "))
+	return line, pos
+}
+
+func (e *lineEditor) redraw(prompt string, line []rune, pos int) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(line))
+
+	if back := len(line) - pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+// addHistory adds a line to the history, and appends it to the history
+// file. The history is best effort, so failing to save it is not an error.
+func (e *lineEditor) addHistory(line string) {
+	if line == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+
+	if e.historyFile == "" {
+		return
+	}
+
+	f, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, line)
+}
+
+// loadHistory reads the history from path, which it then saves lines to,
+// and trims the file to the last maxHistory lines
+func (e *lineEditor) loadHistory(path string) {
+	e.historyFile = path
+
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return
+	}
+
+	e.history = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+		os.WriteFile(path, []byte(strings.Join(e.history, "\n")+"\n"), 0o600)
+	}
+}
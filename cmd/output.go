@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// formats of --output: text for people, json for scripts, and raw for the
+// value of a key as it is stored, with nothing around it
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputRaw  = "raw"
+)
+
+const (
+	// exitCodeBase is added to the gRPC status code of the request that
+	// failed to make the exit code, so that scripts can tell the errors
+	// apart, e.g. 15 for NotFound and 24 for Unavailable. Other errors exit
+	// with 1.
+	exitCodeBase = 10
+	// exitCodeUsage is the exit code of usage errors, such as a missing
+	// argument or an unknown flag
+	exitCodeUsage = 2
+)
+
+var outputFlag string
+
+var (
+	// requestMessages describe the errors of requests for people
+	requestMessages = map[codes.Code]string{
+		codes.Unavailable: "the server is not running",
+	}
+	// keyRequestMessages also describe the errors of requests for a key
+	keyRequestMessages = map[codes.Code]string{
+		codes.Unavailable: "the server is not running",
+		codes.NotFound:    "the key was not found",
+	}
+)
+
+// requestError is the error that a request failed with, described for
+// people. It keeps the status of the request for the exit code.
+type requestError struct {
+	status *status.Status
+	msg    string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func (e *requestError) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// usageError is an error in how a command was called, which its usage is
+// printed along with
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *usageError) Unwrap() error {
+	return e.err
+}
+
+// usageArgs makes the errors of the arguments of cmd and its subcommands
+// usage errors
+func usageArgs(cmd *cobra.Command) {
+	if args := cmd.Args; args != nil {
+		cmd.Args = func(cmd *cobra.Command, a []string) error {
+			if err := args(cmd, a); err != nil {
+				return &usageError{err: err}
+			}
+			return nil
+		}
+	}
+
+	for _, sub := range cmd.Commands() {
+		usageArgs(sub)
+	}
+}
+
+// requestFailed returns err described by messages, or by the message of its
+// status if messages has none for it
+func requestFailed(err error, messages map[codes.Code]string) error {
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	msg, described := messages[s.Code()]
+	if !described {
+		msg = s.Message()
+	}
+	if msg == "" {
+		msg = s.Code().String()
+	}
+
+	return &requestError{status: s, msg: msg}
+}
+
+func checkOutputFormat() error {
+	switch outputFlag {
+	case outputText, outputJSON, outputRaw:
+		return nil
+	default:
+		return fmt.Errorf("invalid output format %q, must be text, json or raw", outputFlag)
+	}
+}
+
+// printResult prints data as JSON with --output json, and calls text to
+// print the result otherwise. Commands whose result is not a value print
+// text with --output raw.
+func printResult(cmd *cobra.Command, data any, text func()) error {
+	if outputFlag == outputJSON {
+		return printJSON(cmd, data)
+	}
+
+	text()
+	return nil
+}
+
+// printJSON prints data as JSON, on one line
+func printJSON(cmd *cobra.Command, data any) error {
+	return json.NewEncoder(cmd.OutOrStdout()).Encode(data)
+}
+
+// exitCode returns the exit code for the error that a command failed with
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var usage *usageError
+	if errors.As(err, &usage) {
+		return exitCodeUsage
+	}
+
+	var withStatus interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &withStatus) {
+		return exitCodeBase + int(withStatus.GRPCStatus().Code())
+	}
+
+	return 1
+}
+
+// printError prints the error that a command failed with, as JSON with
+// --output json
+func printError(w io.Writer, err error) {
+	if outputFlag != outputJSON {
+		fmt.Fprintf(w, "Error: %v\n", err)
+		return
+	}
+
+	out := struct {
+		Error string `json:"error"`
+		Code  string `json:"code,omitempty"`
+	}{Error: err.Error()}
+
+	var withStatus interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &withStatus) {
+		out.Code = withStatus.GRPCStatus().Code().String()
+	}
+
+	json.NewEncoder(w).Encode(out)
+}
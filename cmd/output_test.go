@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_exitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{
+			name: "Success",
+			want: 0,
+		},
+		{
+			name: "Key not found",
+			err:  requestFailed(status.Error(codes.NotFound, ""), keyRequestMessages),
+			want: 15,
+		},
+		{
+			name: "Server not running",
+			err:  requestFailed(status.Error(codes.Unavailable, ""), requestMessages),
+			want: 24,
+		},
+		{
+			name: "Permission denied",
+			err:  requestFailed(status.Error(codes.PermissionDenied, "Not allowed"), requestMessages),
+			want: 17,
+		},
+		{
+			name: "Usage error",
+			err:  &usageError{err: errors.New("accepts 1 arg(s), received 0")},
+			want: 2,
+		},
+		{
+			name: "Other error",
+			err:  errors.New("invalid encoding"),
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("got = %d, want = %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_printError(t *testing.T) {
+	defer func() { outputFlag, traceExporterFlag, getEncoding = outputText, "", "" }()
+
+	tests := []struct {
+		name   string
+		output string
+		err    error
+		want   string
+	}{
+		{
+			name:   "Text",
+			output: outputText,
+			err:    requestFailed(status.Error(codes.NotFound, ""), keyRequestMessages),
+			want:   "Error: the key was not found\n",
+		},
+		{
+			name:   "Message of the server",
+			output: outputRaw,
+			err:    requestFailed(status.Error(codes.PermissionDenied, "Not allowed"), keyRequestMessages),
+			want:   "Error: Not allowed\n",
+		},
+		{
+			name:   "JSON",
+			output: outputJSON,
+			err:    requestFailed(status.Error(codes.Unavailable, ""), requestMessages),
+			want:   `{"error":"the server is not running","code":"Unavailable"}` + "\n",
+		},
+		{
+			name:   "JSON without a status",
+			output: outputJSON,
+			err:    errors.New("invalid encoding"),
+			want:   `{"error":"invalid encoding"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputFlag = tt.output
+
+			var b bytes.Buffer
+			printError(&b, tt.err)
+
+			if b.String() != tt.want {
+				t.Errorf("got = %q, want = %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_Execute(t *testing.T) {
+	defer func() { outputFlag, traceExporterFlag, getEncoding = outputText, "", "" }()
+
+	getValueForKey = func(k []byte) ([]byte, error) {
+		return nil, status.Error(codes.NotFound, "")
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantErr  string
+		// usage is whether the usage is printed after the error
+		usage bool
+	}{
+		{
+			name:     "Request failed",
+			args:     []string{"get", "key"},
+			wantCode: 15,
+			wantErr:  "Error: the key was not found\n",
+		},
+		{
+			name:     "Invalid output format",
+			args:     []string{"--output", "yaml", "get", "key"},
+			wantCode: 2,
+			wantErr:  "Error: invalid output format \"yaml\", must be text, json or raw\n",
+			usage:    true,
+		},
+		{
+			name:     "Invalid trace exporter",
+			args:     []string{"--trace-exporter", "jaeger", "get", "key"},
+			wantCode: 2,
+			wantErr:  "Error: invalid trace exporter \"jaeger\", must be stdout or otlp\n",
+			usage:    true,
+		},
+		{
+			name:     "Invalid encoding",
+			args:     []string{"get", "key", "--encoding", "octal"},
+			wantCode: 2,
+			wantErr:  "Error: invalid encoding \"octal\", must be raw, hex or base64\n",
+			usage:    true,
+		},
+		{
+			name:     "Missing argument",
+			args:     []string{"get"},
+			wantCode: 2,
+			wantErr:  "Error: accepts 1 arg(s), received 0\n",
+			usage:    true,
+		},
+		{
+			name:     "Missing argument with JSON output",
+			args:     []string{"get", "-o", "json"},
+			wantCode: 2,
+			wantErr:  `{"error":"accepts 1 arg(s), received 0"}` + "\n",
+		},
+		{
+			name:     "Unknown flag",
+			args:     []string{"get", "key", "--unknown"},
+			wantCode: 2,
+			wantErr:  "Error: unknown flag: --unknown\n",
+			usage:    true,
+		},
+		{
+			name:     "Unknown command",
+			args:     []string{"fetch", "key"},
+			wantCode: 2,
+			wantErr:  "Error: unknown command \"fetch\" for \"database\"\n",
+			usage:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputFlag, traceExporterFlag, getEncoding = outputText, "", ""
+			rootCmd.SetArgs(tt.args)
+			defer rootCmd.SetArgs(nil)
+
+			var stdout, stderr bytes.Buffer
+			getCmd.SetOut(nil)
+			code := Execute(&stdout, &stderr)
+
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want = %d", code, tt.wantCode)
+			}
+
+			if stdout.Len() != 0 {
+				t.Errorf("stdout = %q, want nothing", stdout.String())
+			}
+
+			printed, found := strings.CutPrefix(stderr.String(), tt.wantErr)
+			if !found {
+				t.Errorf("stderr = %q, want it to start with %q", stderr.String(), tt.wantErr)
+			}
+
+			if usage := strings.HasPrefix(printed, "Usage:"); usage != tt.usage || (!usage && printed != "") {
+				t.Errorf("printed after the error = %q, want the usage printed: %t", printed, tt.usage)
+			}
+		})
+	}
+}
+
+// errorMessage returns the message of err, or "" if it is nil
+func errorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"github.com/arpitchauhan/simple-database/client"
+	"github.com/spf13/cobra"
+)
+
+var repair = client.Repair
+
+// repairCmd represents the repair command
+var repairCmd = &cobra.Command{
+	Use:   "repair source-addr",
+	Short: "Copy the keys that differ from another server",
+	Long: "Make the server of --addr hold the same keys as the server at source-addr. " +
+		"Only the keys that differ are copied, and keys that source-addr does not have are deleted.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repaired, err := repair(args[0])
+
+		if err != nil {
+			return requestFailed(err, requestMessages)
+		}
+
+		return printResult(cmd, repairOutput{Repaired: repaired}, func() {
+			cmd.Printf("Repaired %d keys\n", repaired)
+		})
+	},
+}
+
+// repairOutput is the result of the repair command with --output json
+type repairOutput struct {
+	Repaired int `json:"repaired"`
+}
+
+func init() {
+	rootCmd.AddCommand(repairCmd)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_Repair(t *testing.T) {
+	tests := []struct {
+		name         string
+		receivedCode codes.Code
+		want         string
+		wantErr      string
+	}{
+		{
+			name:         "Successful repair",
+			receivedCode: codes.OK,
+			want:         "Repaired 3 keys\n",
+		},
+		{
+			name:         "Server not running",
+			receivedCode: codes.Unavailable,
+			wantErr:      "the server is not running",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repair = func(source string) (int, error) {
+				return 3, status.Error(tt.receivedCode, "")
+			}
+
+			outputFlag = outputText
+			b := bytes.NewBufferString("")
+			repairCmd.SetOut(b)
+			os.Args = []string{"", "repair", "db2:50051"}
+			if err := repairCmd.Execute(); errorMessage(err) != tt.wantErr {
+				t.Errorf("error = %v, want = %v", err, tt.wantErr)
+			}
+
+			out, err := io.ReadAll(b)
+			if err != nil {
+				t.Fatalf("Error reading output of command: %v", err)
+			}
+
+			if string(out) != tt.want {
+				t.Errorf("got = %q, want = %q", out, tt.want)
+			}
+		})
+	}
+}
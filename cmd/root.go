@@ -1,12 +1,25 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"os"
+	"sync"
 
+	"github.com/arpitchauhan/simple-database/client"
+	"github.com/arpitchauhan/simple-database/internal/tracing"
 	"github.com/spf13/cobra"
 )
 
+var (
+	traceExporterFlag string
+
+	setupTracing = tracing.Setup
+	// shutdownTracing flushes the spans of the command once it is done.
+	shutdownTracing = func(context.Context) error { return nil }
+)
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "database",
@@ -15,19 +28,116 @@ var rootCmd = &cobra.Command{
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+	// errors are printed by Execute, in the format of --output, and so is
+	// the usage, to stderr, for usage errors
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkOutputFormat(); err != nil {
+			return &usageError{err: err}
+		}
+
+		switch traceExporterFlag {
+		case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+		default:
+			return &usageError{err: fmt.Errorf("invalid trace exporter %q, must be stdout or otlp", traceExporterFlag)}
+		}
+
+		shutdown, err := setupTracing(cmd.Context(), traceExporterFlag, "simple-database-cli", cmd.ErrOrStderr())
+		if err != nil {
+			return err
+		}
+
+		shutdownTracing = shutdown
+
+		addr, err := resolveAddr()
+		if err != nil {
+			return err
+		}
+
+		setAddr(addr)
+
+		token, err := resolveToken()
+		if err != nil {
+			return err
+		}
+
+		setToken(token)
+
+		sharded, err := resolveSharding()
+		if err != nil {
+			return err
+		}
+
+		setSharding(sharded)
+
+		tlsOptions, useTLS, err := resolveTLS()
+		if err != nil {
+			return err
+		}
+
+		if useTLS {
+			if err := setTLS(tlsOptions); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
-func Execute(wout io.Writer, werr io.Writer) {
+// It returns the exit code: 0 on success, 10 plus the gRPC status code if a
+// request failed, 2 for usage errors, and 1 for any other error.
+func Execute(wout io.Writer, werr io.Writer) int {
 	rootCmd.SetOut(wout)
 	rootCmd.SetErr(werr)
+	usageArgsOnce.Do(func() { usageArgs(rootCmd) })
+
+	cmd, err := rootCmd.ExecuteC()
+
+	if err := shutdownTracing(context.Background()); err != nil {
+		fmt.Fprintf(werr, "Failed to flush traces: %v\n", err)
+	}
+
+	// commands that were not found, such as misspelled ones, never ran
+	if err != nil && cmd.CalledAs() == "" {
+		err = &usageError{err: err}
+	}
 
-	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		printError(werr, err)
+	}
+
+	// with --output json, stderr only has the JSON of the error
+	var usage *usageError
+	if errors.As(err, &usage) && outputFlag != outputJSON {
+		fmt.Fprintln(werr, cmd.UsageString())
 	}
+
+	return exitCode(err)
 }
 
+// usageArgsOnce makes the errors of arguments usage errors once every
+// command was added
+var usageArgsOnce sync.Once
+
 func init() {
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &usageError{err: err}
+	})
+
+	flags := rootCmd.PersistentFlags()
+	flags.StringVar(&addrFlag, "addr", "", "address of the server, or comma-separated addresses of servers to fail over between (default \""+client.DefaultAddr+"\")")
+	flags.StringVar(&profileFlag, "profile", "", "profile of the config file to use (default \"default\")")
+	flags.StringVar(&configFlag, "config", "", "config file with profiles (default ~/"+configFileName+")")
+	flags.BoolVar(&tlsFlag, "tls", false, "connect with TLS, which the other --tls flags imply")
+	flags.StringVar(&tlsCAFlag, "tls-ca", "", "PEM bundle of the CAs to verify the server with (default the system CAs)")
+	flags.StringVar(&tlsCertFlag, "tls-cert", "", "PEM client certificate, for servers that require mutual TLS")
+	flags.StringVar(&tlsKeyFlag, "tls-key", "", "PEM private key of the client certificate")
+	flags.StringVar(&traceExporterFlag, "trace-exporter", "", "export traces of requests to stdout (written to stderr) or otlp")
+	flags.StringVar(&tokenFlag, "token", "", "token to authenticate with, for servers that require one")
+	flags.StringVarP(&outputFlag, "output", "o", outputText, "format of the output: text, json, or raw for values as they are stored")
+	flags.BoolVar(&shardedFlag, "sharded", false, "route requests to the shards of a sharded deployment that --addr is part of")
 }
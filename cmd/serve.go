@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"github.com/arpitchauhan/simple-database/internal/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	serveConfig  = &server.Config{}
+	serveFlagSet = server.NewFlagSet(serveConfig)
+
+	runServer = server.RunUntilSignalled
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run the database server",
+	Long: "Run the database server until it receives SIGINT or SIGTERM. " +
+		"Settings not given as flags are read from environment variables " +
+		"such as SIMPLE_DATABASE_DATA_DIR, and from the --config file.",
+	Args: cobra.NoArgs,
+	// --addr and --config of this command configure the server, so the
+	// client settings of the root command do not apply
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		setOnCommandLine := make(map[string]bool)
+		cmd.Flags().Visit(func(f *pflag.Flag) { setOnCommandLine[f.Name] = true })
+
+		if err := serveConfig.Load(serveFlagSet, setOnCommandLine); err != nil {
+			return &usageError{err: err}
+		}
+
+		return runServer(serveConfig, nil)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().AddGoFlagSet(serveFlagSet)
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/arpitchauhan/simple-database/internal/server"
+)
+
+func Test_Serve(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantRun bool
+		wantErr bool
+	}{
+		{
+			name:    "Valid flags",
+			args:    []string{"--addr", "localhost:0", "--data-dir", "data", "--durability", "fsync"},
+			wantRun: true,
+		},
+		{
+			name:    "Invalid setting",
+			args:    []string{"--durability", "sometimes"},
+			wantErr: true,
+		},
+		{
+			name:    "Unexpected argument",
+			args:    []string{"now"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran bool
+
+			// override the fn used to run the server
+			runServer = func(cfg *server.Config, ready func(net.Addr)) error {
+				ran = true
+				return nil
+			}
+
+			b := bytes.NewBufferString("")
+			serveCmd.SetOut(b)
+			serveCmd.SetErr(b)
+			os.Args = append([]string{"", "serve"}, tt.args...)
+			err := serveCmd.Execute()
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+			}
+
+			if ran != tt.wantRun {
+				t.Errorf("server ran = %v, want = %v", ran, tt.wantRun)
+			}
+
+			// reset for the next test
+			serveFlagSet.Set("durability", "none")
+		})
+	}
+}
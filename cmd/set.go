@@ -1,40 +1,78 @@
 package cmd
 
 import (
+	"errors"
+	"io"
+	"os"
+
 	"github.com/arpitchauhan/simple-database/client"
 	"github.com/spf13/cobra"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
-var setValueForKey = client.SetValueForKey
+var setValueForKey = client.SetBytes
+
+// file to read the value from for the set command, - for stdin
+var setValueFile string
 
 // setCmd represents the set command
 var setCmd = &cobra.Command{
-	Use:   "set",
+	Use:   "set key [value]",
 	Short: "Add a key-value pair to the database",
-	Long:  "Add a key-value pair to the database",
-	Args: cobra.ExactArgs(2),
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: "Add a key-value pair to the database. " +
+		"The value is either the second argument or the contents of --file.",
+	Args: cobra.RangeArgs(1, 2),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if (len(args) == 2) == (setValueFile != "") {
+			return &usageError{err: errors.New("give the value either as an argument or with --file")}
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
 		key := args[0]
-		value := args[1]
 
-		err := setValueForKey(key, value)
+		var value []byte
+		if len(args) == 2 {
+			value = []byte(args[1])
+		} else {
+			var err error
+			value, err = readValueFile(cmd, setValueFile)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := setValueForKey([]byte(key), value)
 
 		if err != nil {
-			status, _ := status.FromError(err)
-			if status.Code() == codes.Unavailable {
-				cmd.Printf("Error: the server is not running")
-				return
-			}
+			return requestFailed(err, requestMessages)
+		}
 
-			cobra.CheckErr(err)
+		// there is no value to print raw
+		if outputFlag == outputRaw {
+			return nil
 		}
 
-		cmd.Printf("Successful!")
+		return printResult(cmd, setOutput{Key: key}, func() {
+			cmd.Println("Successful!")
+		})
 	},
 }
 
+// setOutput is the result of the set command with --output json
+type setOutput struct {
+	Key string `json:"key"`
+}
+
+func readValueFile(cmd *cobra.Command, path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(cmd.InOrStdin())
+	}
+
+	return os.ReadFile(path)
+}
+
 func init() {
 	rootCmd.AddCommand(setCmd)
+
+	setCmd.Flags().StringVarP(&setValueFile, "file", "f", "", "read the value from a file, - for stdin")
 }
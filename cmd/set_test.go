@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"google.golang.org/grpc/codes"
@@ -11,22 +12,66 @@ import (
 )
 
 func Test_Set(t *testing.T) {
+	t.Cleanup(func() { setValueFile, outputFlag = "", outputText })
+
 	tests := []struct {
 		name         string
 		key          string
 		value        string
+		args         []string // overrides the key and value as arguments
+		stdin        string
 		receivedCode codes.Code
 		want         string
+		wantErr      string
+		wantExitCode int
 	}{
 		{
 			name:         "Successful operation",
 			receivedCode: codes.OK,
-			want:         "Successful!",
+			want:         "Successful!\n",
+		},
+		{
+			name:         "JSON output",
+			key:          "key",
+			value:        "value",
+			args:         []string{"key", "value", "--output", "json"},
+			receivedCode: codes.OK,
+			want:         `{"key":"key"}` + "\n",
+		},
+		{
+			name:         "Raw output",
+			key:          "key",
+			value:        "value",
+			args:         []string{"key", "value", "--output", "raw"},
+			receivedCode: codes.OK,
+			want:         "",
 		},
 		{
 			name:         "Server not running",
 			receivedCode: codes.Unavailable,
-			want:         "Error: the server is not running",
+			wantErr:      "the server is not running",
+			wantExitCode: 24,
+		},
+		{
+			name:         "Value missing",
+			args:         []string{"key"},
+			wantErr:      "give the value either as an argument or with --file",
+			wantExitCode: exitCodeUsage,
+		},
+		{
+			name:         "Value given twice",
+			args:         []string{"key", "value", "--file", "-"},
+			wantErr:      "give the value either as an argument or with --file",
+			wantExitCode: exitCodeUsage,
+		},
+		{
+			name:         "Value read from stdin",
+			key:          "key",
+			value:        "line 1\r\nline 2\x00",
+			args:         []string{"key", "--file", "-"},
+			stdin:        "line 1\r\nline 2\x00",
+			receivedCode: codes.OK,
+			want:         "Successful!\n",
 		},
 	}
 	for _, tt := range tests {
@@ -34,14 +79,29 @@ func Test_Set(t *testing.T) {
 			var receivedKey, receivedValue string
 
 			// override the fn used to get key from server
-			setValueForKey = func(k, v string) error {
-				receivedKey = k
-				receivedValue = v
+			setValueForKey = func(k, v []byte) error {
+				receivedKey = string(k)
+				receivedValue = string(v)
 
 				return status.Error(tt.receivedCode, "")
 			}
 
-			out := executeSetCmd(t, []string{tt.key, tt.value})
+			args := tt.args
+			if args == nil {
+				args = []string{tt.key, tt.value}
+			}
+
+			setValueFile, outputFlag = "", outputText
+			setCmd.SetIn(bytes.NewBufferString(tt.stdin))
+			out, err := executeSetCmd(t, args)
+
+			if errorMessage(err) != tt.wantErr {
+				t.Errorf("error = %v, want = %v", err, tt.wantErr)
+			}
+
+			if code := exitCode(err); code != tt.wantExitCode {
+				t.Errorf("exit code = %d, want = %d", code, tt.wantExitCode)
+			}
 
 			if receivedKey != tt.key || receivedValue != tt.value {
 				t.Errorf(
@@ -62,21 +122,48 @@ func Test_Set(t *testing.T) {
 	}
 }
 
-func executeSetCmd(t *testing.T, args []string) string {
+func executeSetCmd(t *testing.T, args []string) (string, error) {
 	t.Helper()
 
 	b := bytes.NewBufferString("")
 	setCmd.SetOut(b)
 	os.Args = append([]string{"", "set"}, args...)
-	err := setCmd.Execute()
-	if err != nil {
-		t.Fatalf("Error executing command: %v", err)
-	}
+	cmdErr := setCmd.Execute()
 
 	out, err := ioutil.ReadAll(b)
 	if err != nil {
 		t.Fatalf("Error reading output of command: %v", err)
 	}
 
-	return string(out)
+	return string(out), cmdErr
+}
+
+func Test_Set_valueFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "value")
+	value := []byte{0x00, 0xff, ',', '\r', '\n'}
+
+	if err := os.WriteFile(path, value, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var receivedValue []byte
+
+	setValueForKey = func(k, v []byte) error {
+		receivedValue = v
+		return nil
+	}
+
+	setValueFile, outputFlag = "", outputText
+	out, err := executeSetCmd(t, []string{"key", "--file", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(receivedValue, value) {
+		t.Errorf("Server called with wrong value, got: %q, want: %q", receivedValue, value)
+	}
+
+	if out != "Successful!\n" {
+		t.Errorf("got = %v, want = %v", out, "Successful!\n")
+	}
 }
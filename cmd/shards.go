@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/arpitchauhan/simple-database/client"
+	"github.com/arpitchauhan/simple-database/sharding"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	getShardMap = client.GetShardMap
+	rebalance   = client.Rebalance
+)
+
+// shardsCmd represents the shards command
+var shardsCmd = &cobra.Command{
+	Use:   "shards",
+	Short: "Show and change the shards of a sharded deployment",
+	Long: "Show and change the shards of the sharded deployment that --addr is part of. " +
+		"Adding or removing a shard moves keys between the shards, which stay available meanwhile.",
+}
+
+var shardsShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the shard map",
+	Long:  "Show the shard map",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		m, err := currentShardMap()
+		if err != nil {
+			return err
+		}
+
+		// the map is printed in the format of the --shard-map file
+		return printResult(cmd, m, func() {
+			cmd.Printf("Shard map version %d, %d virtual nodes per shard\n", m.Version, m.VirtualNodes)
+			for _, s := range m.Shards {
+				cmd.Printf("%s %s\n", s.ID, s.Addr)
+			}
+
+			if m.Rebalancing() {
+				var previous []string
+				for _, s := range m.Previous {
+					previous = append(previous, s.ID)
+				}
+				cmd.Printf("Rebalancing from: %s\n", strings.Join(previous, ", "))
+			}
+		})
+	},
+}
+
+var shardsAddCmd = &cobra.Command{
+	Use:   "add id addr",
+	Short: "Add a shard, and move its keys to it",
+	Long: "Add a shard, and move the keys that it gets to it. " +
+		"The shard must be running with --shard-id set to its ID.",
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		m, err := currentShardMap()
+		if err != nil {
+			return err
+		}
+
+		if _, found := m.Shard(args[0]); found {
+			return fmt.Errorf("shard %q is already in the shard map", args[0])
+		}
+
+		return rebalanceTo(cmd, append(slices.Clone(m.Shards), sharding.Shard{ID: args[0], Addr: args[1]}))
+	},
+}
+
+var shardsRemoveCmd = &cobra.Command{
+	Use:   "remove id",
+	Short: "Move the keys of a shard to the others, and remove it",
+	Long: "Move the keys of a shard to the other shards, and remove it from the shard map. " +
+		"The shard can be stopped once this is done.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		m, err := currentShardMap()
+		if err != nil {
+			return err
+		}
+
+		shards := slices.DeleteFunc(slices.Clone(m.Shards), func(s sharding.Shard) bool { return s.ID == args[0] })
+		if len(shards) == len(m.Shards) {
+			return fmt.Errorf("shard %q is not in the shard map", args[0])
+		}
+
+		return rebalanceTo(cmd, shards)
+	},
+}
+
+// rebalanceMessages describe the errors of rebalancing, which talks to
+// every shard
+var rebalanceMessages = map[codes.Code]string{
+	codes.Unavailable: "a shard is not running, run the command again once it is",
+}
+
+// currentShardMap gets the shard map
+func currentShardMap() (*sharding.Map, error) {
+	reply, err := getShardMap()
+
+	if err != nil {
+		return nil, requestFailed(err, requestMessages)
+	}
+
+	return sharding.FromProto(reply.Map)
+}
+
+// rebalanceTo moves the keys to shards. Its progress is printed along with
+// the output, or to stderr with --output json so that the output stays
+// valid JSON.
+func rebalanceTo(cmd *cobra.Command, shards []sharding.Shard) error {
+	err := rebalance(shards, func(format string, args ...any) {
+		if outputFlag == outputJSON {
+			cmd.PrintErrf(format+"\n", args...)
+		} else {
+			cmd.Printf(format+"\n", args...)
+		}
+	})
+
+	if err != nil {
+		return requestFailed(err, rebalanceMessages)
+	}
+
+	return printResult(cmd, shardsOutput{Shards: shards}, func() {
+		cmd.Println("Successful!")
+	})
+}
+
+// shardsOutput is the result of adding or removing a shard with --output
+// json
+type shardsOutput struct {
+	Shards []sharding.Shard `json:"shards"`
+}
+
+func init() {
+	rootCmd.AddCommand(shardsCmd)
+
+	shardsCmd.AddCommand(shardsShowCmd, shardsAddCmd, shardsRemoveCmd)
+}
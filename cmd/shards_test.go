@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+	"github.com/arpitchauhan/simple-database/sharding"
+)
+
+func Test_Shards(t *testing.T) {
+	shardMap := &pb.ShardMap{
+		Version:      2,
+		VirtualNodes: 128,
+		Shards:       []*pb.Shard{{Id: "s1", Addr: "db1:50051"}, {Id: "s2", Addr: "db2:50051"}},
+		Previous:     []*pb.Shard{{Id: "s1", Addr: "db1:50051"}},
+	}
+
+	tests := []struct {
+		name          string
+		cmd           *cobra.Command
+		args          []string
+		receivedCode  codes.Code
+		wantRebalance []sharding.Shard
+		want          string
+		wantErr       string
+	}{
+		{
+			name:         "Show",
+			cmd:          shardsShowCmd,
+			args:         []string{"show"},
+			receivedCode: codes.OK,
+			want: "Shard map version 2, 128 virtual nodes per shard\n" +
+				"s1 db1:50051\n" +
+				"s2 db2:50051\n" +
+				"Rebalancing from: s1\n",
+		},
+		{
+			name:         "Show as JSON",
+			cmd:          shardsShowCmd,
+			args:         []string{"show", "--output", "json"},
+			receivedCode: codes.OK,
+			want: `{"version":2,"virtual_nodes":128,"shards":[{"id":"s1","addr":"db1:50051"},{"id":"s2","addr":"db2:50051"}],` +
+				`"previous":[{"id":"s1","addr":"db1:50051"}]}` + "\n",
+		},
+		{
+			name:          "Add",
+			cmd:           shardsAddCmd,
+			args:          []string{"add", "s3", "db3:50051"},
+			receivedCode:  codes.OK,
+			wantRebalance: []sharding.Shard{{ID: "s1", Addr: "db1:50051"}, {ID: "s2", Addr: "db2:50051"}, {ID: "s3", Addr: "db3:50051"}},
+			want:          "Moving keys\nSuccessful!\n",
+		},
+		{
+			name:          "Add as JSON",
+			cmd:           shardsAddCmd,
+			args:          []string{"add", "s3", "db3:50051", "--output", "json"},
+			receivedCode:  codes.OK,
+			wantRebalance: []sharding.Shard{{ID: "s1", Addr: "db1:50051"}, {ID: "s2", Addr: "db2:50051"}, {ID: "s3", Addr: "db3:50051"}},
+			want:          `{"shards":[{"id":"s1","addr":"db1:50051"},{"id":"s2","addr":"db2:50051"},{"id":"s3","addr":"db3:50051"}]}` + "\n",
+		},
+		{
+			name:          "Remove",
+			cmd:           shardsRemoveCmd,
+			args:          []string{"remove", "s1"},
+			receivedCode:  codes.OK,
+			wantRebalance: []sharding.Shard{{ID: "s2", Addr: "db2:50051"}},
+			want:          "Moving keys\nSuccessful!\n",
+		},
+		{
+			name:         "Server not running",
+			cmd:          shardsShowCmd,
+			args:         []string{"show"},
+			receivedCode: codes.Unavailable,
+			wantErr:      "the server is not running",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getShardMap = func() (*pb.GetShardMapReply, error) {
+				if tt.receivedCode != codes.OK {
+					return nil, status.Error(tt.receivedCode, "")
+				}
+				return &pb.GetShardMapReply{Map: shardMap}, nil
+			}
+
+			var rebalancedTo []sharding.Shard
+			rebalance = func(shards []sharding.Shard, progress func(string, ...any)) error {
+				rebalancedTo = shards
+				progress("Moving keys")
+				return nil
+			}
+
+			outputFlag = outputText
+			b := bytes.NewBufferString("")
+			tt.cmd.SetOut(b)
+			tt.cmd.SetErr(io.Discard)
+			os.Args = append([]string{"", "shards"}, tt.args...)
+			if err := tt.cmd.Execute(); errorMessage(err) != tt.wantErr {
+				t.Errorf("error = %v, want = %v", err, tt.wantErr)
+			}
+
+			out, err := io.ReadAll(b)
+			if err != nil {
+				t.Fatalf("Error reading output of command: %v", err)
+			}
+
+			if fmt.Sprint(rebalancedTo) != fmt.Sprint(tt.wantRebalance) {
+				t.Errorf("rebalanced to %v, want %v", rebalancedTo, tt.wantRebalance)
+			}
+
+			if string(out) != tt.want {
+				t.Errorf("got = %q, want = %q", out, tt.want)
+			}
+		})
+	}
+}
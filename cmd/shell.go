@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/arpitchauhan/simple-database/client"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	deleteKey = client.DeleteBytes
+	scanKeys  = client.Scan
+
+	// since measures how long a command of the shell took
+	since = time.Since
+)
+
+const (
+	shellPrompt = "> "
+	// continuationPrompt is the prompt for the next line of a command whose
+	// quote is not closed yet
+	continuationPrompt = "... "
+)
+
+// shellCommands are the commands of the shell, in the order that help
+// lists them
+var shellCommands = []struct {
+	name, usage, help string
+}{
+	{"get", "get key", "print the value of a key"},
+	{"set", "set key value", "set the value of a key"},
+	{"delete", "delete key", "delete a key"},
+	{"scan", "scan [prefix]", "list the keys that start with prefix"},
+	{"help", "help", "list the commands"},
+	{"exit", "exit", "leave the shell, as do quit and Ctrl-D"},
+	{"quit", "quit", ""},
+}
+
+// shellCmd represents the shell command
+var shellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "Run commands against the server from a prompt",
+	Long: "Start a prompt that runs get, set, delete and scan commands over one connection to the server, " +
+		"and prints how long each of them took. Words with spaces are double-quoted, with backslash escapes such as \\n, " +
+		"and a quoted value that is not closed continues on the next line. At a terminal, the up and down keys browse " +
+		"the history, which is kept in ~/" + historyFileName + ", and Tab completes commands and the keys seen so far.",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sh := &shell{cmd: cmd, keys: make(map[string]struct{})}
+		return sh.run(newLineReader(cmd.InOrStdin(), cmd.OutOrStdout(), sh.complete))
+	},
+}
+
+// shell runs the commands read at the prompt
+type shell struct {
+	cmd *cobra.Command
+	// keys are the keys that the session has seen, which Tab completes
+	keys map[string]struct{}
+}
+
+// run runs commands until the input ends or the shell is left
+func (sh *shell) run(lines lineReader) error {
+	for {
+		text, words, err := readCommand(lines)
+		if errors.Is(err, errInterrupted) {
+			continue
+		} else if errors.Is(err, io.ErrUnexpectedEOF) {
+			sh.cmd.PrintErrln("Error: the quote was not closed")
+			return nil
+		} else if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if len(words) == 0 {
+			continue
+		}
+
+		// newlines are only ever quoted, so the command fits on one line of
+		// history with them escaped
+		lines.addHistory(strings.ReplaceAll(text, "\n", `\n`))
+
+		switch words[0] {
+		case "exit", "quit":
+			return nil
+		case "help":
+			sh.help()
+			continue
+		}
+
+		start := time.Now()
+		if sh.execute(words[0], words[1:]) {
+			sh.cmd.Printf("(%v)\n", since(start))
+		}
+	}
+}
+
+// readCommand reads a command, over as many lines as its quotes span, and
+// splits it into words
+func readCommand(lines lineReader) (string, []string, error) {
+	text, err := lines.readLine(shellPrompt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for {
+		words, closed := splitWords(text)
+		if closed {
+			return text, words, nil
+		}
+
+		next, err := lines.readLine(continuationPrompt)
+		if errors.Is(err, io.EOF) {
+			return "", nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return "", nil, err
+		}
+
+		text += "\n" + next
+	}
+}
+
+// execute runs a command that sends requests, and returns whether it did
+func (sh *shell) execute(name string, args []string) bool {
+	switch {
+	case name == "get" && len(args) == 1:
+		value, err := getValueForKey([]byte(args[0]))
+		if sh.failed(args[0], err) {
+			return true
+		}
+
+		sh.keys[args[0]] = struct{}{}
+		sh.cmd.Println(printableValue(value))
+	case name == "set" && len(args) == 2:
+		err := setValueForKey([]byte(args[0]), []byte(args[1]))
+		if sh.failed(args[0], err) {
+			return true
+		}
+
+		sh.keys[args[0]] = struct{}{}
+		sh.cmd.Println("OK")
+	case name == "delete" && len(args) == 1:
+		err := deleteKey([]byte(args[0]))
+		if sh.failed(args[0], err) {
+			return true
+		}
+
+		delete(sh.keys, args[0])
+		sh.cmd.Println("OK")
+	case name == "scan" && len(args) <= 1:
+		var prefix []byte
+		if len(args) == 1 {
+			prefix = []byte(args[0])
+		}
+
+		// cursors are only valid on the server that returned them, which
+		// the shared client keeps sending scans to
+		count := 0
+		for cursor := uint64(0); ; {
+			keys, next, err := scanKeys(prefix, cursor, 0)
+			if sh.failed("", err) {
+				return true
+			}
+
+			for _, key := range keys {
+				sh.keys[string(key)] = struct{}{}
+				sh.cmd.Println(printableKey(key))
+			}
+			count += len(keys)
+
+			if cursor = next; cursor == 0 {
+				break
+			}
+		}
+
+		sh.cmd.Printf("%d keys\n", count)
+	default:
+		for _, c := range shellCommands {
+			if c.name == name {
+				sh.cmd.PrintErrf("Usage: %s\n", c.usage)
+				return false
+			}
+		}
+
+		sh.cmd.PrintErrf("Error: unknown command %q, see help\n", name)
+		return false
+	}
+
+	return true
+}
+
+// failed prints err, if any, and forgets key if it was not found
+func (sh *shell) failed(key string, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if status.Code(err) == codes.NotFound {
+		delete(sh.keys, key)
+	}
+	sh.cmd.PrintErrf("Error: %v\n", requestFailed(err, keyRequestMessages))
+
+	return true
+}
+
+func (sh *shell) help() {
+	for _, c := range shellCommands {
+		if c.help != "" {
+			sh.cmd.Printf("%-16s %s\n", c.usage, c.help)
+		}
+	}
+}
+
+// complete completes the first word to a command, and the second word of a
+// command that takes a key to a key that the session has seen
+func (sh *shell) complete(before string) (int, []string) {
+	start := strings.LastIndexFunc(before, unicode.IsSpace) + 1
+	word, previous := before[start:], strings.Fields(before[:start])
+
+	var matching []string
+	switch {
+	case len(previous) == 0:
+		for _, c := range shellCommands {
+			if strings.HasPrefix(c.name, word) {
+				matching = append(matching, c.name)
+			}
+		}
+	case len(previous) == 1 && slices.Contains([]string{"get", "set", "delete", "scan"}, previous[0]):
+		// keys that need quotes are completed from their opening quote
+		// or from their first character
+		for _, key := range slices.Sorted(maps.Keys(sh.keys)) {
+			if quoted := quoteWord(key); strings.HasPrefix(key, word) || strings.HasPrefix(quoted, word) {
+				matching = append(matching, quoted)
+			}
+		}
+	}
+
+	return start, matching
+}
+
+// splitWords splits a command into words, which are separated by spaces
+// unless they are double-quoted, and in which a backslash escapes the next
+// character. It returns whether every quote was closed.
+func splitWords(text string) ([]string, bool) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted, escaped := false, false, false
+
+	for _, r := range text {
+		switch {
+		case escaped:
+			switch r {
+			case 'n':
+				r = '\n'
+			case 't':
+				r = '\t'
+			}
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped, inWord = true, true
+		case r == '"':
+			quoted, inWord = !quoted, true
+		case !quoted && unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if escaped {
+		word.WriteRune('\\')
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, !quoted
+}
+
+// quoteWord quotes s, if needed, so that splitWords reads it back as one
+// word
+func quoteWord(s string) string {
+	if s != "" && !strings.ContainsFunc(s, func(r rune) bool {
+		return r == '"' || r == '\\' || unicode.IsSpace(r) || !unicode.IsPrint(r)
+	}) {
+		return s
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+	return `"` + replacer.Replace(s) + `"`
+}
+
+// printableValue returns value as is if it is printable text, which may
+// span several lines, and quoted otherwise
+func printableValue(value []byte) string {
+	s := string(value)
+	if utf8.ValidString(s) && !strings.ContainsFunc(s, func(r rune) bool {
+		return !unicode.IsPrint(r) && r != '\n' && r != '\t'
+	}) {
+		return s
+	}
+
+	return strconv.Quote(s)
+}
+
+func init() {
+	rootCmd.AddCommand(shellCmd)
+}
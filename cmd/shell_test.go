@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_Shell(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "Set and get",
+			input: "set key value\nget key\n",
+			want:  "OK\n(1ms)\nvalue\n(1ms)\n",
+		},
+		{
+			name:  "Quoted words",
+			input: "set \"a key\" \"a \\\"quoted\\\" value\"\nget \"a key\"\n",
+			want:  "OK\n(1ms)\na \"quoted\" value\n(1ms)\n",
+		},
+		{
+			name:  "Multi-line value",
+			input: "set key \"line 1\nline 2\"\nget key\n",
+			want:  "OK\n(1ms)\nline 1\nline 2\n(1ms)\n",
+		},
+		{
+			name:  "Quote not closed",
+			input: "set key \"line 1\n",
+			want:  "Error: the quote was not closed\n",
+		},
+		{
+			name:  "Delete",
+			input: "set key value\ndelete key\nget key\ndelete key\n",
+			want:  "OK\n(1ms)\nOK\n(1ms)\nError: the key was not found\n(1ms)\nError: the key was not found\n(1ms)\n",
+		},
+		{
+			name:  "Scan over several pages",
+			input: "set a1 v\nset a2 v\nset a3 v\nset b1 v\nscan a\n",
+			want:  strings.Repeat("OK\n(1ms)\n", 4) + "a1\na2\na3\n3 keys\n(1ms)\n",
+		},
+		{
+			name:  "Wrong arguments",
+			input: "get\nfetch key\n\n",
+			want:  "Usage: get key\nError: unknown command \"fetch\", see help\n",
+		},
+		{
+			name:  "Exit",
+			input: "exit\nget key\n",
+			want:  "",
+		},
+		{
+			name:  "Help",
+			input: "help\n",
+			want: "get key          print the value of a key\n" +
+				"set key value    set the value of a key\n" +
+				"delete key       delete a key\n" +
+				"scan [prefix]    list the keys that start with prefix\n" +
+				"help             list the commands\n" +
+				"exit             leave the shell, as do quit and Ctrl-D\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := map[string][]byte{}
+			getValueForKey = func(k []byte) ([]byte, error) {
+				value, ok := stored[string(k)]
+				if !ok {
+					return nil, status.Error(codes.NotFound, "")
+				}
+				return value, nil
+			}
+			setValueForKey = func(k, v []byte) error {
+				stored[string(k)] = v
+				return nil
+			}
+			deleteKey = func(k []byte) error {
+				if _, ok := stored[string(k)]; !ok {
+					return status.Error(codes.NotFound, "")
+				}
+				delete(stored, string(k))
+				return nil
+			}
+			// pages of one key, whose cursor is the index of the next key
+			scanKeys = func(prefix []byte, cursor uint64, limit int) ([][]byte, uint64, error) {
+				keys := []string{"a1", "a2", "a3", "b1"}
+				if !bytes.HasPrefix([]byte(keys[cursor]), prefix) {
+					return nil, 0, nil
+				}
+				if cursor == uint64(len(keys))-1 {
+					return [][]byte{[]byte(keys[cursor])}, 0, nil
+				}
+				return [][]byte{[]byte(keys[cursor])}, cursor + 1, nil
+			}
+			since = func(time.Time) time.Duration { return time.Millisecond }
+
+			out := executeShellCmd(t, tt.input)
+
+			if out != tt.want {
+				t.Errorf("got = %q, want = %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Shell_serverNotRunning(t *testing.T) {
+	getValueForKey = func(k []byte) ([]byte, error) {
+		return nil, status.Error(codes.Unavailable, "")
+	}
+	since = func(time.Time) time.Duration { return time.Millisecond }
+
+	out := executeShellCmd(t, "get key\n")
+
+	if want := "Error: the server is not running\n(1ms)\n"; out != want {
+		t.Errorf("got = %q, want = %q", out, want)
+	}
+}
+
+func executeShellCmd(t *testing.T, input string) string {
+	t.Helper()
+
+	b := bytes.NewBufferString("")
+	shellCmd.SetOut(b)
+	shellCmd.SetErr(b)
+	shellCmd.SetIn(strings.NewReader(input))
+	os.Args = []string{"", "shell"}
+	err := shellCmd.Execute()
+	if err != nil {
+		t.Fatalf("Error executing command: %v", err)
+	}
+
+	out, err := io.ReadAll(b)
+	if err != nil {
+		t.Fatalf("Error reading output of command: %v", err)
+	}
+
+	return string(out)
+}
+
+func Test_lineEditor(t *testing.T) {
+	sh := &shell{keys: map[string]struct{}{"key1": {}, "key2": {}, "other key": {}}}
+
+	tests := []struct {
+		name    string
+		history []string
+		typed   string
+		want    string
+	}{
+		{
+			name:  "Typed line",
+			typed: "get key\r",
+			want:  "get key",
+		},
+		{
+			name:  "Editing",
+			typed: "gt key\x1b[D\x1b[D\x1b[D\x1b[D\x1b[De\x05\x7f2\r",
+			want:  "get ke2",
+		},
+		{
+			name:    "Previous lines of history",
+			history: []string{"get a", "get b"},
+			typed:   "\x1b[A\x1b[A\x1b[B\x7fc\r",
+			want:    "get c",
+		},
+		{
+			name:    "Back to the typed line",
+			history: []string{"get a"},
+			typed:   "set\x1b[A\x1b[B x\r",
+			want:    "set x",
+		},
+		{
+			name:  "Command completed",
+			typed: "de\tk\r",
+			want:  "delete k",
+		},
+		{
+			name:  "Key completed as far as the keys agree",
+			typed: "get k\t1\r",
+			want:  "get key1",
+		},
+		{
+			name:  "Key quoted",
+			typed: "get o\t\r",
+			want:  "get \"other key\" ",
+		},
+		{
+			name:  "Line discarded",
+			typed: "get key\x03",
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &lineEditor{
+				in:       bufio.NewReader(strings.NewReader(tt.typed)),
+				out:      io.Discard,
+				complete: sh.complete,
+				history:  tt.history,
+			}
+
+			got, err := e.readLine("> ")
+			if err != nil && err != errInterrupted {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("got = %q, want = %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_lineEditor_history(t *testing.T) {
+	path := filepath.Join(t.TempDir(), historyFileName)
+
+	e := &lineEditor{in: bufio.NewReader(strings.NewReader("")), out: io.Discard}
+	e.loadHistory(path)
+	for _, line := range []string{"get a", "get a", "set b \"1\\n2\""} {
+		e.addHistory(line)
+	}
+
+	loaded := &lineEditor{}
+	loaded.loadHistory(path)
+
+	want := []string{"get a", "set b \"1\\n2\""}
+	if strings.Join(loaded.history, "|") != strings.Join(want, "|") {
+		t.Errorf("history = %q, want = %q", loaded.history, want)
+	}
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"github.com/arpitchauhan/simple-database/client"
+	"github.com/spf13/cobra"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+var getStats = client.GetStats
+
+// statsCmd represents the stats command
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show statistics of the server",
+	Long:  "Show statistics of the server",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stats, err := getStats()
+
+		if err != nil {
+			return requestFailed(err, requestMessages)
+		}
+
+		output := statsOutput{
+			CacheHits:        stats.CacheHits,
+			CacheMisses:      stats.CacheMisses,
+			CacheEntries:     stats.CacheEntries,
+			CacheBytes:       stats.CacheBytes,
+			CompressedValues: stats.CompressedValues,
+			CompressionRatio: stats.CompressionRatio,
+			Role:             stats.Role,
+		}
+
+		switch stats.Role {
+		case "leader", "follower", "candidate":
+			output.ClusterLeader = &stats.ClusterLeader
+		case "replica":
+			output.ReplicaConnected = &stats.ReplicaConnected
+			output.ReplicationLagBytes = &stats.ReplicationLagBytes
+			output.ReplicationLagSeconds = &stats.ReplicationLagSeconds
+		case "multi-primary":
+			for _, p := range stats.Peers {
+				output.Peers = append(output.Peers, peerOutput{Addr: p.Addr, Connected: p.Connected, LagBytes: p.LagBytes})
+			}
+		}
+
+		return printResult(cmd, output, func() { printStats(cmd, stats) })
+	},
+}
+
+// statsOutput is the result of the stats command with --output json. The
+// fields of other roles are left out.
+type statsOutput struct {
+	CacheHits             uint64       `json:"cache_hits"`
+	CacheMisses           uint64       `json:"cache_misses"`
+	CacheEntries          uint64       `json:"cache_entries"`
+	CacheBytes            uint64       `json:"cache_bytes"`
+	CompressedValues      uint64       `json:"compressed_values"`
+	CompressionRatio      float64      `json:"compression_ratio"`
+	Role                  string       `json:"role"`
+	ReplicaConnected      *bool        `json:"replica_connected,omitempty"`
+	ReplicationLagBytes   *int64       `json:"replication_lag_bytes,omitempty"`
+	ReplicationLagSeconds *float64     `json:"replication_lag_seconds,omitempty"`
+	ClusterLeader         *string      `json:"cluster_leader,omitempty"`
+	Peers                 []peerOutput `json:"peers,omitempty"`
+}
+
+type peerOutput struct {
+	Addr      string `json:"addr"`
+	Connected bool   `json:"connected"`
+	LagBytes  int64  `json:"lag_bytes"`
+}
+
+func printStats(cmd *cobra.Command, stats *pb.StatsReply) {
+	cmd.Printf("Cache hits: %d\n", stats.CacheHits)
+	cmd.Printf("Cache misses: %d\n", stats.CacheMisses)
+	cmd.Printf("Cache entries: %d\n", stats.CacheEntries)
+	cmd.Printf("Cache size in bytes: %d\n", stats.CacheBytes)
+	cmd.Printf("Compressed values: %d\n", stats.CompressedValues)
+	cmd.Printf("Compression ratio: %.2f\n", stats.CompressionRatio)
+
+	switch stats.Role {
+	case "leader", "follower", "candidate":
+		cmd.Printf("Cluster role: %s\n", stats.Role)
+		cmd.Printf("Cluster leader: %s\n", stats.ClusterLeader)
+	case "replica":
+		cmd.Printf("Connected to primary: %t\n", stats.ReplicaConnected)
+		cmd.Printf("Replication lag: %d bytes, %.1fs\n", stats.ReplicationLagBytes, stats.ReplicationLagSeconds)
+	case "multi-primary":
+		for _, p := range stats.Peers {
+			cmd.Printf("Peer %s: connected: %t, lag: %d bytes\n", p.Addr, p.Connected, p.LagBytes)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+func Test_Stats(t *testing.T) {
+	tests := []struct {
+		name         string
+		flags        []string
+		receivedCode codes.Code
+		role         string
+		want         string
+		wantErr      string
+	}{
+		{
+			name:         "Stats returned",
+			receivedCode: codes.OK,
+			want: "Cache hits: 3\n" +
+				"Cache misses: 1\n" +
+				"Cache entries: 1\n" +
+				"Cache size in bytes: 70\n" +
+				"Compressed values: 2\n" +
+				"Compression ratio: 3.50\n",
+		},
+		{
+			name:         "Stats of a replica",
+			receivedCode: codes.OK,
+			role:         "replica",
+			want: "Cache hits: 3\n" +
+				"Cache misses: 1\n" +
+				"Cache entries: 1\n" +
+				"Cache size in bytes: 70\n" +
+				"Compressed values: 2\n" +
+				"Compression ratio: 3.50\n" +
+				"Connected to primary: true\n" +
+				"Replication lag: 120 bytes, 2.5s\n",
+		},
+		{
+			name:         "Stats of a member of a cluster",
+			receivedCode: codes.OK,
+			role:         "follower",
+			want: "Cache hits: 3\n" +
+				"Cache misses: 1\n" +
+				"Cache entries: 1\n" +
+				"Cache size in bytes: 70\n" +
+				"Compressed values: 2\n" +
+				"Compression ratio: 3.50\n" +
+				"Cluster role: follower\n" +
+				"Cluster leader: localhost:50051\n",
+		},
+		{
+			name:         "Stats of a multi-primary server",
+			receivedCode: codes.OK,
+			role:         "multi-primary",
+			want: "Cache hits: 3\n" +
+				"Cache misses: 1\n" +
+				"Cache entries: 1\n" +
+				"Cache size in bytes: 70\n" +
+				"Compressed values: 2\n" +
+				"Compression ratio: 3.50\n" +
+				"Peer localhost:50052: connected: true, lag: 0 bytes\n" +
+				"Peer localhost:50053: connected: false, lag: 40 bytes\n",
+		},
+		{
+			name:         "JSON output of a replica",
+			flags:        []string{"--output", "json"},
+			receivedCode: codes.OK,
+			role:         "replica",
+			want: `{"cache_hits":3,"cache_misses":1,"cache_entries":1,"cache_bytes":70,"compressed_values":2,` +
+				`"compression_ratio":3.5,"role":"replica","replica_connected":true,"replication_lag_bytes":120,` +
+				`"replication_lag_seconds":2.5}` + "\n",
+		},
+		{
+			name:         "JSON output of a multi-primary server",
+			flags:        []string{"--output", "json"},
+			receivedCode: codes.OK,
+			role:         "multi-primary",
+			want: `{"cache_hits":3,"cache_misses":1,"cache_entries":1,"cache_bytes":70,"compressed_values":2,` +
+				`"compression_ratio":3.5,"role":"multi-primary","peers":[{"addr":"localhost:50052","connected":true,"lag_bytes":0},` +
+				`{"addr":"localhost:50053","connected":false,"lag_bytes":40}]}` + "\n",
+		},
+		{
+			name:         "Server not running",
+			receivedCode: codes.Unavailable,
+			wantErr:      "the server is not running",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// override the fn used to get stats from server
+			getStats = func() (*pb.StatsReply, error) {
+				stats := &pb.StatsReply{
+					CacheHits:        3,
+					CacheMisses:      1,
+					CacheEntries:     1,
+					CacheBytes:       70,
+					CompressedValues: 2,
+					CompressionRatio: 3.5,
+
+					Role:                  tt.role,
+					ReplicaConnected:      true,
+					ReplicationLagBytes:   120,
+					ReplicationLagSeconds: 2.5,
+					ClusterLeader:         "localhost:50051",
+					Peers: []*pb.PeerStats{
+						{Addr: "localhost:50052", Connected: true},
+						{Addr: "localhost:50053", LagBytes: 40},
+					},
+				}
+
+				return stats, status.Error(tt.receivedCode, "")
+			}
+
+			outputFlag = outputText
+			out, err := executeStatsCmd(t, tt.flags)
+
+			if errorMessage(err) != tt.wantErr {
+				t.Errorf("error = %v, want = %v", err, tt.wantErr)
+			}
+
+			if out != tt.want {
+				t.Errorf("got = %v, want = %v", out, tt.want)
+				return
+			}
+		})
+	}
+}
+
+func executeStatsCmd(t *testing.T, flags []string) (string, error) {
+	t.Helper()
+
+	b := bytes.NewBufferString("")
+	statsCmd.SetOut(b)
+	os.Args = append([]string{"", "stats"}, flags...)
+	cmdErr := statsCmd.Execute()
+
+	out, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Fatalf("Error reading output of command: %v", err)
+	}
+
+	return string(out), cmdErr
+}
@@ -0,0 +1,18 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package cmd
+
+import (
+	"errors"
+	"os"
+)
+
+// makeRaw is not supported on this platform, where the shell reads whole
+// lines without editing
+func makeRaw(f *os.File) (func() error, error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+func isTerminal(f *os.File) bool {
+	return false
+}
@@ -0,0 +1,292 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.21.12
+// source: antientropy.proto
+
+package database
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	AntiEntropy_Tree_FullMethodName   = "/server.AntiEntropy/Tree"
+	AntiEntropy_Leaves_FullMethodName = "/server.AntiEntropy/Leaves"
+	AntiEntropy_Read_FullMethodName   = "/server.AntiEntropy/Read"
+	AntiEntropy_Write_FullMethodName  = "/server.AntiEntropy/Write"
+)
+
+// AntiEntropyClient is the client API for AntiEntropy service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AntiEntropyClient interface {
+	// Tree returns the hashes of nodes of the Merkle tree at a level.
+	Tree(ctx context.Context, in *TreeRequest, opts ...grpc.CallOption) (*TreeReply, error)
+	// Leaves returns the keys of leaves of the Merkle tree, with the digests
+	// of their entries.
+	Leaves(ctx context.Context, in *LeavesRequest, opts ...grpc.CallOption) (*LeavesReply, error)
+	// Read streams the entries of keys.
+	Read(ctx context.Context, in *ReadRequest, opts ...grpc.CallOption) (AntiEntropy_ReadClient, error)
+	// Write writes entries, and deletes the keys of those marked deleted.
+	Write(ctx context.Context, opts ...grpc.CallOption) (AntiEntropy_WriteClient, error)
+}
+
+type antiEntropyClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAntiEntropyClient(cc grpc.ClientConnInterface) AntiEntropyClient {
+	return &antiEntropyClient{cc}
+}
+
+func (c *antiEntropyClient) Tree(ctx context.Context, in *TreeRequest, opts ...grpc.CallOption) (*TreeReply, error) {
+	out := new(TreeReply)
+	err := c.cc.Invoke(ctx, AntiEntropy_Tree_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *antiEntropyClient) Leaves(ctx context.Context, in *LeavesRequest, opts ...grpc.CallOption) (*LeavesReply, error) {
+	out := new(LeavesReply)
+	err := c.cc.Invoke(ctx, AntiEntropy_Leaves_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *antiEntropyClient) Read(ctx context.Context, in *ReadRequest, opts ...grpc.CallOption) (AntiEntropy_ReadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AntiEntropy_ServiceDesc.Streams[0], AntiEntropy_Read_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &antiEntropyReadClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AntiEntropy_ReadClient interface {
+	Recv() (*ReadReply, error)
+	grpc.ClientStream
+}
+
+type antiEntropyReadClient struct {
+	grpc.ClientStream
+}
+
+func (x *antiEntropyReadClient) Recv() (*ReadReply, error) {
+	m := new(ReadReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *antiEntropyClient) Write(ctx context.Context, opts ...grpc.CallOption) (AntiEntropy_WriteClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AntiEntropy_ServiceDesc.Streams[1], AntiEntropy_Write_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &antiEntropyWriteClient{stream}
+	return x, nil
+}
+
+type AntiEntropy_WriteClient interface {
+	Send(*WriteRequest) error
+	CloseAndRecv() (*WriteReply, error)
+	grpc.ClientStream
+}
+
+type antiEntropyWriteClient struct {
+	grpc.ClientStream
+}
+
+func (x *antiEntropyWriteClient) Send(m *WriteRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *antiEntropyWriteClient) CloseAndRecv() (*WriteReply, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(WriteReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AntiEntropyServer is the server API for AntiEntropy service.
+// All implementations must embed UnimplementedAntiEntropyServer
+// for forward compatibility
+type AntiEntropyServer interface {
+	// Tree returns the hashes of nodes of the Merkle tree at a level.
+	Tree(context.Context, *TreeRequest) (*TreeReply, error)
+	// Leaves returns the keys of leaves of the Merkle tree, with the digests
+	// of their entries.
+	Leaves(context.Context, *LeavesRequest) (*LeavesReply, error)
+	// Read streams the entries of keys.
+	Read(*ReadRequest, AntiEntropy_ReadServer) error
+	// Write writes entries, and deletes the keys of those marked deleted.
+	Write(AntiEntropy_WriteServer) error
+	mustEmbedUnimplementedAntiEntropyServer()
+}
+
+// UnimplementedAntiEntropyServer must be embedded to have forward compatible implementations.
+type UnimplementedAntiEntropyServer struct {
+}
+
+func (UnimplementedAntiEntropyServer) Tree(context.Context, *TreeRequest) (*TreeReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Tree not implemented")
+}
+func (UnimplementedAntiEntropyServer) Leaves(context.Context, *LeavesRequest) (*LeavesReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Leaves not implemented")
+}
+func (UnimplementedAntiEntropyServer) Read(*ReadRequest, AntiEntropy_ReadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Read not implemented")
+}
+func (UnimplementedAntiEntropyServer) Write(AntiEntropy_WriteServer) error {
+	return status.Errorf(codes.Unimplemented, "method Write not implemented")
+}
+func (UnimplementedAntiEntropyServer) mustEmbedUnimplementedAntiEntropyServer() {}
+
+// UnsafeAntiEntropyServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AntiEntropyServer will
+// result in compilation errors.
+type UnsafeAntiEntropyServer interface {
+	mustEmbedUnimplementedAntiEntropyServer()
+}
+
+func RegisterAntiEntropyServer(s grpc.ServiceRegistrar, srv AntiEntropyServer) {
+	s.RegisterService(&AntiEntropy_ServiceDesc, srv)
+}
+
+func _AntiEntropy_Tree_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TreeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AntiEntropyServer).Tree(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AntiEntropy_Tree_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AntiEntropyServer).Tree(ctx, req.(*TreeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AntiEntropy_Leaves_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeavesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AntiEntropyServer).Leaves(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AntiEntropy_Leaves_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AntiEntropyServer).Leaves(ctx, req.(*LeavesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AntiEntropy_Read_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReadRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AntiEntropyServer).Read(m, &antiEntropyReadServer{stream})
+}
+
+type AntiEntropy_ReadServer interface {
+	Send(*ReadReply) error
+	grpc.ServerStream
+}
+
+type antiEntropyReadServer struct {
+	grpc.ServerStream
+}
+
+func (x *antiEntropyReadServer) Send(m *ReadReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _AntiEntropy_Write_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AntiEntropyServer).Write(&antiEntropyWriteServer{stream})
+}
+
+type AntiEntropy_WriteServer interface {
+	SendAndClose(*WriteReply) error
+	Recv() (*WriteRequest, error)
+	grpc.ServerStream
+}
+
+type antiEntropyWriteServer struct {
+	grpc.ServerStream
+}
+
+func (x *antiEntropyWriteServer) SendAndClose(m *WriteReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *antiEntropyWriteServer) Recv() (*WriteRequest, error) {
+	m := new(WriteRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AntiEntropy_ServiceDesc is the grpc.ServiceDesc for AntiEntropy service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AntiEntropy_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "server.AntiEntropy",
+	HandlerType: (*AntiEntropyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Tree",
+			Handler:    _AntiEntropy_Tree_Handler,
+		},
+		{
+			MethodName: "Leaves",
+			Handler:    _AntiEntropy_Leaves_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Read",
+			Handler:       _AntiEntropy_Read_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Write",
+			Handler:       _AntiEntropy_Write_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "antientropy.proto",
+}
@@ -4,7 +4,7 @@
 // - protoc             v3.21.12
 // source: database.proto
 
-package server
+package database
 
 import (
 	context "context"
@@ -19,8 +19,10 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	Database_Get_FullMethodName = "/server.Database/Get"
-	Database_Set_FullMethodName = "/server.Database/Set"
+	Database_Get_FullMethodName     = "/server.Database/Get"
+	Database_Set_FullMethodName     = "/server.Database/Set"
+	Database_Stats_FullMethodName   = "/server.Database/Stats"
+	Database_Compact_FullMethodName = "/server.Database/Compact"
 )
 
 // DatabaseClient is the client API for Database service.
@@ -29,6 +31,8 @@ const (
 type DatabaseClient interface {
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetReply, error)
 	Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetReply, error)
+	Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsReply, error)
+	Compact(ctx context.Context, in *CompactRequest, opts ...grpc.CallOption) (*CompactReply, error)
 }
 
 type databaseClient struct {
@@ -57,12 +61,32 @@ func (c *databaseClient) Set(ctx context.Context, in *SetRequest, opts ...grpc.C
 	return out, nil
 }
 
+func (c *databaseClient) Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsReply, error) {
+	out := new(StatsReply)
+	err := c.cc.Invoke(ctx, Database_Stats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseClient) Compact(ctx context.Context, in *CompactRequest, opts ...grpc.CallOption) (*CompactReply, error) {
+	out := new(CompactReply)
+	err := c.cc.Invoke(ctx, Database_Compact_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DatabaseServer is the server API for Database service.
 // All implementations must embed UnimplementedDatabaseServer
 // for forward compatibility
 type DatabaseServer interface {
 	Get(context.Context, *GetRequest) (*GetReply, error)
 	Set(context.Context, *SetRequest) (*SetReply, error)
+	Stats(context.Context, *StatsRequest) (*StatsReply, error)
+	Compact(context.Context, *CompactRequest) (*CompactReply, error)
 	mustEmbedUnimplementedDatabaseServer()
 }
 
@@ -76,6 +100,12 @@ func (UnimplementedDatabaseServer) Get(context.Context, *GetRequest) (*GetReply,
 func (UnimplementedDatabaseServer) Set(context.Context, *SetRequest) (*SetReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Set not implemented")
 }
+func (UnimplementedDatabaseServer) Stats(context.Context, *StatsRequest) (*StatsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stats not implemented")
+}
+func (UnimplementedDatabaseServer) Compact(context.Context, *CompactRequest) (*CompactReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Compact not implemented")
+}
 func (UnimplementedDatabaseServer) mustEmbedUnimplementedDatabaseServer() {}
 
 // UnsafeDatabaseServer may be embedded to opt out of forward compatibility for this service.
@@ -125,6 +155,42 @@ func _Database_Set_Handler(srv interface{}, ctx context.Context, dec func(interf
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Database_Stats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseServer).Stats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Database_Stats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseServer).Stats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Database_Compact_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompactRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseServer).Compact(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Database_Compact_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseServer).Compact(ctx, req.(*CompactRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Database_ServiceDesc is the grpc.ServiceDesc for Database service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -140,6 +206,14 @@ var Database_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Set",
 			Handler:    _Database_Set_Handler,
 		},
+		{
+			MethodName: "Stats",
+			Handler:    _Database_Stats_Handler,
+		},
+		{
+			MethodName: "Compact",
+			Handler:    _Database_Compact_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "database.proto",
@@ -0,0 +1,147 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.21.12
+// source: invalidation.proto
+
+package database
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Invalidation_Watch_FullMethodName = "/server.Invalidation/Watch"
+)
+
+// InvalidationClient is the client API for Invalidation service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type InvalidationClient interface {
+	// Watch sends the keys that change among those that the client watches.
+	// A key is watched from the reply that acknowledges the request adding
+	// it until its first change, which is sent once.
+	Watch(ctx context.Context, opts ...grpc.CallOption) (Invalidation_WatchClient, error)
+}
+
+type invalidationClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewInvalidationClient(cc grpc.ClientConnInterface) InvalidationClient {
+	return &invalidationClient{cc}
+}
+
+func (c *invalidationClient) Watch(ctx context.Context, opts ...grpc.CallOption) (Invalidation_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Invalidation_ServiceDesc.Streams[0], Invalidation_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &invalidationWatchClient{stream}
+	return x, nil
+}
+
+type Invalidation_WatchClient interface {
+	Send(*WatchRequest) error
+	Recv() (*WatchReply, error)
+	grpc.ClientStream
+}
+
+type invalidationWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *invalidationWatchClient) Send(m *WatchRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *invalidationWatchClient) Recv() (*WatchReply, error) {
+	m := new(WatchReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// InvalidationServer is the server API for Invalidation service.
+// All implementations must embed UnimplementedInvalidationServer
+// for forward compatibility
+type InvalidationServer interface {
+	// Watch sends the keys that change among those that the client watches.
+	// A key is watched from the reply that acknowledges the request adding
+	// it until its first change, which is sent once.
+	Watch(Invalidation_WatchServer) error
+	mustEmbedUnimplementedInvalidationServer()
+}
+
+// UnimplementedInvalidationServer must be embedded to have forward compatible implementations.
+type UnimplementedInvalidationServer struct {
+}
+
+func (UnimplementedInvalidationServer) Watch(Invalidation_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedInvalidationServer) mustEmbedUnimplementedInvalidationServer() {}
+
+// UnsafeInvalidationServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to InvalidationServer will
+// result in compilation errors.
+type UnsafeInvalidationServer interface {
+	mustEmbedUnimplementedInvalidationServer()
+}
+
+func RegisterInvalidationServer(s grpc.ServiceRegistrar, srv InvalidationServer) {
+	s.RegisterService(&Invalidation_ServiceDesc, srv)
+}
+
+func _Invalidation_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(InvalidationServer).Watch(&invalidationWatchServer{stream})
+}
+
+type Invalidation_WatchServer interface {
+	Send(*WatchReply) error
+	Recv() (*WatchRequest, error)
+	grpc.ServerStream
+}
+
+type invalidationWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *invalidationWatchServer) Send(m *WatchReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *invalidationWatchServer) Recv() (*WatchRequest, error) {
+	m := new(WatchRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Invalidation_ServiceDesc is the grpc.ServiceDesc for Invalidation service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Invalidation_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "server.Invalidation",
+	HandlerType: (*InvalidationServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _Invalidation_Watch_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "invalidation.proto",
+}
@@ -0,0 +1,232 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.21.12
+// source: sharding.proto
+
+package database
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Sharding_GetShardMap_FullMethodName = "/server.Sharding/GetShardMap"
+	Sharding_SetShardMap_FullMethodName = "/server.Sharding/SetShardMap"
+	Sharding_Import_FullMethodName      = "/server.Sharding/Import"
+	Sharding_Fetch_FullMethodName       = "/server.Sharding/Fetch"
+)
+
+// ShardingClient is the client API for Sharding service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ShardingClient interface {
+	GetShardMap(ctx context.Context, in *GetShardMapRequest, opts ...grpc.CallOption) (*GetShardMapReply, error)
+	// SetShardMap replaces the shard map of a shard with one of a later
+	// version. A map with previous shards starts moving the keys that the
+	// shard no longer owns to their new owners.
+	SetShardMap(ctx context.Context, in *SetShardMapRequest, opts ...grpc.CallOption) (*SetShardMapReply, error)
+	// Import writes the entries of keys that the shard has no record of,
+	// which it must own.
+	Import(ctx context.Context, in *ImportRequest, opts ...grpc.CallOption) (*ImportReply, error)
+	// Fetch reads the entry of a key, whether or not the shard owns it.
+	Fetch(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*FetchReply, error)
+}
+
+type shardingClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewShardingClient(cc grpc.ClientConnInterface) ShardingClient {
+	return &shardingClient{cc}
+}
+
+func (c *shardingClient) GetShardMap(ctx context.Context, in *GetShardMapRequest, opts ...grpc.CallOption) (*GetShardMapReply, error) {
+	out := new(GetShardMapReply)
+	err := c.cc.Invoke(ctx, Sharding_GetShardMap_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shardingClient) SetShardMap(ctx context.Context, in *SetShardMapRequest, opts ...grpc.CallOption) (*SetShardMapReply, error) {
+	out := new(SetShardMapReply)
+	err := c.cc.Invoke(ctx, Sharding_SetShardMap_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shardingClient) Import(ctx context.Context, in *ImportRequest, opts ...grpc.CallOption) (*ImportReply, error) {
+	out := new(ImportReply)
+	err := c.cc.Invoke(ctx, Sharding_Import_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shardingClient) Fetch(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*FetchReply, error) {
+	out := new(FetchReply)
+	err := c.cc.Invoke(ctx, Sharding_Fetch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ShardingServer is the server API for Sharding service.
+// All implementations must embed UnimplementedShardingServer
+// for forward compatibility
+type ShardingServer interface {
+	GetShardMap(context.Context, *GetShardMapRequest) (*GetShardMapReply, error)
+	// SetShardMap replaces the shard map of a shard with one of a later
+	// version. A map with previous shards starts moving the keys that the
+	// shard no longer owns to their new owners.
+	SetShardMap(context.Context, *SetShardMapRequest) (*SetShardMapReply, error)
+	// Import writes the entries of keys that the shard has no record of,
+	// which it must own.
+	Import(context.Context, *ImportRequest) (*ImportReply, error)
+	// Fetch reads the entry of a key, whether or not the shard owns it.
+	Fetch(context.Context, *FetchRequest) (*FetchReply, error)
+	mustEmbedUnimplementedShardingServer()
+}
+
+// UnimplementedShardingServer must be embedded to have forward compatible implementations.
+type UnimplementedShardingServer struct {
+}
+
+func (UnimplementedShardingServer) GetShardMap(context.Context, *GetShardMapRequest) (*GetShardMapReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetShardMap not implemented")
+}
+func (UnimplementedShardingServer) SetShardMap(context.Context, *SetShardMapRequest) (*SetShardMapReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetShardMap not implemented")
+}
+func (UnimplementedShardingServer) Import(context.Context, *ImportRequest) (*ImportReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Import not implemented")
+}
+func (UnimplementedShardingServer) Fetch(context.Context, *FetchRequest) (*FetchReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Fetch not implemented")
+}
+func (UnimplementedShardingServer) mustEmbedUnimplementedShardingServer() {}
+
+// UnsafeShardingServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ShardingServer will
+// result in compilation errors.
+type UnsafeShardingServer interface {
+	mustEmbedUnimplementedShardingServer()
+}
+
+func RegisterShardingServer(s grpc.ServiceRegistrar, srv ShardingServer) {
+	s.RegisterService(&Sharding_ServiceDesc, srv)
+}
+
+func _Sharding_GetShardMap_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetShardMapRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardingServer).GetShardMap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sharding_GetShardMap_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardingServer).GetShardMap(ctx, req.(*GetShardMapRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sharding_SetShardMap_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetShardMapRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardingServer).SetShardMap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sharding_SetShardMap_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardingServer).SetShardMap(ctx, req.(*SetShardMapRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sharding_Import_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardingServer).Import(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sharding_Import_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardingServer).Import(ctx, req.(*ImportRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sharding_Fetch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardingServer).Fetch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sharding_Fetch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardingServer).Fetch(ctx, req.(*FetchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Sharding_ServiceDesc is the grpc.ServiceDesc for Sharding service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Sharding_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "server.Sharding",
+	HandlerType: (*ShardingServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetShardMap",
+			Handler:    _Sharding_GetShardMap_Handler,
+		},
+		{
+			MethodName: "SetShardMap",
+			Handler:    _Sharding_SetShardMap_Handler,
+		},
+		{
+			MethodName: "Import",
+			Handler:    _Sharding_Import_Handler,
+		},
+		{
+			MethodName: "Fetch",
+			Handler:    _Sharding_Fetch_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "sharding.proto",
+}
@@ -0,0 +1,358 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+// Anti-entropy finds the keys on which two servers that should hold the
+// same data differ, e.g. because one of them missed writes or was restored
+// from an old backup, and repairs them.
+//
+// Each server summarizes its keys in a Merkle tree. A key goes in the leaf
+// picked by the hash of the key, and a leaf hashes the keys in it with the
+// digests of their entries. Every other node hashes its children, so two
+// servers hold the same keys if their roots are equal, and otherwise the
+// leaves that differ are found by comparing the nodes whose parents differ,
+// from the root down.
+
+const (
+	merkleFanout = 16
+	merkleDepth  = 3
+	merkleLeaves = 4096 // merkleFanout^merkleDepth
+
+	// antiEntropyBatchSize is the number of keys read at a time to build
+	// trees, and the number of entries in each message of Read and Write.
+	antiEntropyBatchSize = 100
+)
+
+// merkleTree is the Merkle tree of the keys of a database at some point.
+type merkleTree struct {
+	// levels holds the hashes of the nodes of each level, from the root
+	// down to the leaves.
+	levels [][][sha256.Size]byte
+	// leaves holds the keys of each leaf, sorted.
+	leaves [][]keyDigest
+	// changed is closed once the database changed after the tree was
+	// built.
+	changed <-chan struct{}
+}
+
+type keyDigest struct {
+	key    string
+	digest [sha256.Size]byte
+}
+
+func merkleLeaf(key string) int {
+	sum := sha256.Sum256([]byte(key))
+	return int(binary.BigEndian.Uint32(sum[:4]) % merkleLeaves)
+}
+
+// entryDigest hashes everything that the database keeps for a key.
+func entryDigest(key string, e entry) [sha256.Size]byte {
+	h := sha256.New()
+
+	var lengths [8]byte
+	binary.BigEndian.PutUint32(lengths[:4], uint32(len(key)))
+	binary.BigEndian.PutUint32(lengths[4:], uint32(len(e.value)))
+	h.Write(lengths[:])
+	h.Write([]byte(key))
+	h.Write([]byte(e.value))
+
+	var header [12]byte
+	binary.BigEndian.PutUint64(header[:8], uint64(e.expiresAt))
+	binary.BigEndian.PutUint32(header[8:], e.flags)
+	h.Write(header[:])
+
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// merkleTree returns the Merkle tree of the database, which is built again
+// only if the database changed since it was last built.
+func (d *database) merkleTree(ctx context.Context) (*merkleTree, ErrorCode) {
+	d.ensureInitialized()
+
+	d.merkleMu.Lock()
+	defer d.merkleMu.Unlock()
+
+	if t := d.merkle; t != nil {
+		select {
+		case <-t.changed:
+		default:
+			return t, OK
+		}
+	}
+
+	t, code := d.buildMerkleTree(ctx)
+	if code != OK {
+		return nil, code
+	}
+
+	d.merkle = t
+	return t, OK
+}
+
+// buildMerkleTree reads every key that exists. Writes made while it runs
+// may or may not be in the tree, which is then built again the next time.
+func (d *database) buildMerkleTree(ctx context.Context) (*merkleTree, ErrorCode) {
+	d.mu.RLock()
+	changed := d.appended
+	d.mu.RUnlock()
+
+	t := &merkleTree{leaves: make([][]keyDigest, merkleLeaves), changed: changed}
+
+	var cursor uint64
+	for {
+		keys, next, code := d.scanKeys(cursor, antiEntropyBatchSize)
+		if code != OK {
+			return nil, code
+		}
+
+		changes, code := d.readEntries(ctx, keys...)
+		if code != OK {
+			return nil, code
+		}
+
+		for _, c := range changes {
+			leaf := merkleLeaf(c.key)
+			t.leaves[leaf] = append(t.leaves[leaf], keyDigest{key: c.key, digest: entryDigest(c.key, c.entry)})
+		}
+
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	leafHashes := make([][sha256.Size]byte, merkleLeaves)
+	for i, leaf := range t.leaves {
+		// a scan that spans a compaction may return a key twice, and the
+		// entry read last is the latest
+		slices.SortStableFunc(leaf, func(a, b keyDigest) int { return strings.Compare(a.key, b.key) })
+
+		unique := leaf[:0]
+		for _, k := range leaf {
+			if n := len(unique); n > 0 && unique[n-1].key == k.key {
+				unique[n-1] = k
+				continue
+			}
+			unique = append(unique, k)
+		}
+		t.leaves[i] = unique
+
+		h := sha256.New()
+		for _, k := range unique {
+			var length [4]byte
+			binary.BigEndian.PutUint32(length[:], uint32(len(k.key)))
+			h.Write(length[:])
+			h.Write([]byte(k.key))
+			h.Write(k.digest[:])
+		}
+		h.Sum(leafHashes[i][:0])
+	}
+
+	t.levels = make([][][sha256.Size]byte, merkleDepth+1)
+	t.levels[merkleDepth] = leafHashes
+
+	for level := merkleDepth - 1; level >= 0; level-- {
+		children := t.levels[level+1]
+		nodes := make([][sha256.Size]byte, len(children)/merkleFanout)
+
+		for i := range nodes {
+			h := sha256.New()
+			for _, child := range children[i*merkleFanout : (i+1)*merkleFanout] {
+				h.Write(child[:])
+			}
+			h.Sum(nodes[i][:0])
+		}
+
+		t.levels[level] = nodes
+	}
+
+	return t, OK
+}
+
+// antiEntropyServer serves the Merkle tree of the database, and reads and
+// writes the entries that differ from another server.
+type antiEntropyServer struct {
+	pb.UnimplementedAntiEntropyServer
+	s *server
+}
+
+func (as *antiEntropyServer) Tree(ctx context.Context, in *pb.TreeRequest) (*pb.TreeReply, error) {
+	if in.Level > merkleDepth {
+		return nil, status.Errorf(codes.InvalidArgument, "Level cannot be deeper than %d", merkleDepth)
+	}
+
+	t, err := as.tree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := t.levels[in.Level]
+	reply := &pb.TreeReply{Fanout: merkleFanout, Depth: merkleDepth}
+
+	for _, i := range in.Indices {
+		if int(i) >= len(nodes) {
+			return nil, status.Errorf(codes.InvalidArgument, "Level %d has %d nodes", in.Level, len(nodes))
+		}
+
+		reply.Hashes = append(reply.Hashes, nodes[i][:])
+	}
+
+	return reply, nil
+}
+
+func (as *antiEntropyServer) Leaves(ctx context.Context, in *pb.LeavesRequest) (*pb.LeavesReply, error) {
+	t, err := as.tree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []keyDigest
+	for _, i := range in.Indices {
+		if i >= merkleLeaves {
+			return nil, status.Errorf(codes.InvalidArgument, "There are %d leaves", merkleLeaves)
+		}
+
+		keys = append(keys, t.leaves[i]...)
+	}
+
+	slices.SortFunc(keys, func(a, b keyDigest) int { return strings.Compare(a.key, b.key) })
+
+	reply := &pb.LeavesReply{}
+	for _, k := range keys {
+		reply.Keys = append(reply.Keys, &pb.KeyDigest{Key: []byte(k.key), Digest: bytes.Clone(k.digest[:])})
+	}
+
+	return reply, nil
+}
+
+func (as *antiEntropyServer) tree(ctx context.Context) (*merkleTree, error) {
+	t, code := as.s.db.merkleTree(ctx)
+
+	if code == DatabaseClosed {
+		return nil, closedErr
+	}
+
+	if code != OK {
+		return nil, internalErr
+	}
+
+	return t, nil
+}
+
+func (as *antiEntropyServer) Read(in *pb.ReadRequest, stream pb.AntiEntropy_ReadServer) error {
+	for batch := range slices.Chunk(in.Keys, antiEntropyBatchSize) {
+		keys := make([]string, len(batch))
+		for i, key := range batch {
+			keys[i] = string(key)
+		}
+
+		changes, code := as.s.db.readEntries(stream.Context(), keys...)
+
+		if code == DatabaseClosed {
+			return closedErr
+		}
+
+		if code != OK {
+			return internalErr
+		}
+
+		found := make(map[string]entry)
+		for _, c := range changes {
+			found[c.key] = c.entry
+		}
+
+		reply := &pb.ReadReply{}
+		for _, key := range keys {
+			e, ok := found[key]
+			reply.Entries = append(reply.Entries, &pb.Entry{
+				Key:       []byte(key),
+				Value:     []byte(e.value),
+				ExpiresAt: e.expiresAt,
+				Flags:     e.flags,
+				Deleted:   !ok,
+			})
+		}
+
+		if err := stream.Send(reply); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (as *antiEntropyServer) Write(stream pb.AntiEntropy_WriteServer) error {
+	var written uint64
+
+	for {
+		in, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&pb.WriteReply{Written: written})
+		} else if err != nil {
+			return err
+		}
+
+		changes := make([]change, len(in.Entries))
+		for i, e := range in.Entries {
+			key := string(e.Key)
+
+			if keyValid, errmsg := as.s.isKeyValid(key); !keyValid {
+				return status.Error(codes.InvalidArgument, errmsg)
+			}
+
+			if as.s.maxValueSize > 0 && len(e.Value) > as.s.maxValueSize {
+				return status.Errorf(codes.InvalidArgument, "Value cannot be larger than %d bytes", as.s.maxValueSize)
+			}
+
+			changes[i] = change{
+				key:     key,
+				entry:   entry{value: string(e.Value), expiresAt: e.ExpiresAt, flags: e.Flags},
+				deleted: e.Deleted,
+			}
+		}
+
+		if len(changes) == 0 {
+			continue
+		}
+
+		code := as.s.db.setEntries(stream.Context(), changes...)
+
+		if code == DatabaseClosed {
+			return closedErr
+		}
+
+		if code == ReadOnly {
+			return readOnlyErr
+		}
+
+		if code == NotLeader {
+			return notLeaderErr
+		}
+
+		if code == WrongShard {
+			return wrongShardErr
+		}
+
+		if code != OK {
+			return internalErr
+		}
+
+		written += uint64(len(changes))
+	}
+}
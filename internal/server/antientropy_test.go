@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/arpitchauhan/simple-database/client"
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+func Test_database_merkleTree(t *testing.T) {
+	ctx := context.Background()
+
+	first := openTestDatabase(t, t.TempDir())
+	second := openTestDatabase(t, t.TempDir())
+
+	for i := range 300 {
+		for _, d := range []*database{first, second} {
+			if code := d.setKey(fmt.Sprintf("key%d", i), "value"); code != OK {
+				t.Fatalf("code = %v, want = OK", code)
+			}
+		}
+	}
+
+	root := func(d *database) [32]byte {
+		t.Helper()
+
+		tree, code := d.merkleTree(ctx)
+		if code != OK {
+			t.Fatalf("code = %v, want = OK", code)
+		}
+
+		return tree.levels[0][0]
+	}
+
+	if root(first) != root(second) {
+		t.Fatal("roots differ for the same keys")
+	}
+
+	if code := second.setKey("key7", "changed"); code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+
+	if root(first) == root(second) {
+		t.Fatal("roots are equal after a key changed")
+	}
+
+	if code := first.setKey("key7", "changed"); code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+
+	if root(first) != root(second) {
+		t.Fatal("roots differ after the same change")
+	}
+
+	if _, code := second.deleteKeys(ctx, "key8"); code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+
+	tree, code := second.merkleTree(ctx)
+	if code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+
+	for _, k := range tree.leaves[merkleLeaf("key8")] {
+		if k.key == "key8" {
+			t.Fatal("deleted key is in the tree")
+		}
+	}
+}
+
+func Test_run_antiEntropy(t *testing.T) {
+	ctx := context.Background()
+
+	firstAddr := runServer(t, "-data-dir", t.TempDir())
+	secondAddr := runServer(t, "-data-dir", t.TempDir())
+	first, second := dialDatabase(t, firstAddr), dialDatabase(t, secondAddr)
+
+	for i := range 200 {
+		key := fmt.Sprintf("key%d", i)
+		for _, db := range []pb.DatabaseClient{first, second} {
+			if _, err := db.Set(ctx, &pb.SetRequest{Key: key, Value: "value"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// the first server missed some writes, and has a key that the second
+	// does not
+	if _, err := second.Set(ctx, &pb.SetRequest{Key: "key3", Value: "changed"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Set(ctx, &pb.SetRequest{Key: "new", Value: "value"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Set(ctx, &pb.SetRequest{Key: "stale", Value: "value"}); err != nil {
+		t.Fatal(err)
+	}
+
+	client.SetAddr(firstAddr)
+	t.Cleanup(func() { client.SetAddr(client.DefaultAddr) })
+
+	diffs, err := client.Diff(secondAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []client.Difference{
+		{Key: []byte("key3"), Kind: client.Changed},
+		{Key: []byte("new"), Kind: client.OnlyInSecond},
+		{Key: []byte("stale"), Kind: client.OnlyInFirst},
+	}
+	if fmt.Sprint(diffs) != fmt.Sprint(want) {
+		t.Fatalf("diffs = %v, want = %v", diffs, want)
+	}
+
+	repaired, err := client.Repair(secondAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if repaired != len(want) {
+		t.Errorf("repaired = %d, want = %d", repaired, len(want))
+	}
+
+	if diffs, err := client.Diff(secondAddr); err != nil || len(diffs) != 0 {
+		t.Errorf("diffs = %v, %v after repair, want none", diffs, err)
+	}
+
+	for key, want := range map[string]string{"key3": "changed", "new": "value"} {
+		reply, err := first.Get(ctx, &pb.GetRequest{Key: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reply.Value != want {
+			t.Errorf("value of %s = %q, want = %q", key, reply.Value, want)
+		}
+	}
+
+	if _, err := first.Get(ctx, &pb.GetRequest{Key: "stale"}); err == nil {
+		t.Error("stale was not deleted")
+	}
+}
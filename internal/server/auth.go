@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+	pbv2 "github.com/arpitchauhan/simple-database/database/v2"
+)
+
+// policy authenticates clients by bearer token, and grants each principal
+// permissions on the keys that start with given prefixes. The policy file
+// holds the SHA-256 of tokens rather than the tokens themselves:
+//
+//	{"principals": {
+//	  "app": {"token_sha256": "...", "grants": [{"prefix": "app/", "permissions": ["read", "write"]}]},
+//	  "admin": {"token_sha256": "...", "grants": [{"prefix": "", "permissions": ["read", "write"]}]}
+//	}}
+type policy struct {
+	// principals are keyed by the SHA-256 of their token.
+	principals map[[sha256.Size]byte]*principal
+}
+
+type principal struct {
+	name   string
+	grants []grant
+}
+
+type grant struct {
+	prefix      string
+	permissions permission
+}
+
+// permission is a set of permissions on keys.
+type permission int
+
+const (
+	permissionRead permission = 1 << iota
+	permissionWrite
+)
+
+var permissionNames = map[string]permission{"read": permissionRead, "write": permissionWrite}
+
+type policyFile struct {
+	Principals map[string]struct {
+		TokenSHA256 string `json:"token_sha256"`
+		Grants      []struct {
+			Prefix      string   `json:"prefix"`
+			Permissions []string `json:"permissions"`
+		} `json:"grants"`
+	} `json:"principals"`
+}
+
+func loadPolicy(path string) (*policy, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file policyFile
+	decoder := json.NewDecoder(strings.NewReader(string(contents)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	p := &policy{principals: make(map[[sha256.Size]byte]*principal)}
+
+	for name, entry := range file.Principals {
+		sum, err := hex.DecodeString(entry.TokenSHA256)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("%s: principal %q: token_sha256 must be a hex-encoded SHA-256", path, name)
+		}
+
+		pr := &principal{name: name}
+
+		for _, g := range entry.Grants {
+			var permissions permission
+			for _, permissionName := range g.Permissions {
+				perm, ok := permissionNames[permissionName]
+				if !ok {
+					return nil, fmt.Errorf("%s: principal %q: unknown permission %q", path, name, permissionName)
+				}
+				permissions |= perm
+			}
+
+			pr.grants = append(pr.grants, grant{prefix: g.Prefix, permissions: permissions})
+		}
+
+		key := [sha256.Size]byte(sum)
+		if _, ok := p.principals[key]; ok {
+			return nil, fmt.Errorf("%s: principal %q has the same token as another", path, name)
+		}
+		p.principals[key] = pr
+	}
+
+	return p, nil
+}
+
+// authenticate returns the principal that a token belongs to.
+func (p *policy) authenticate(token string) (*principal, bool) {
+	pr, ok := p.principals[sha256.Sum256([]byte(token))]
+	return pr, ok
+}
+
+// allowed reports whether the principal has every permission in perm on
+// key, through one or more grants.
+func (pr *principal) allowed(perm permission, key string) bool {
+	var granted permission
+
+	for _, g := range pr.grants {
+		if strings.HasPrefix(key, g.prefix) {
+			granted |= g.permissions
+		}
+	}
+
+	return granted&perm == perm
+}
+
+var unauthenticatedErr = status.Error(codes.Unauthenticated, "Missing or invalid bearer token")
+
+// authenticateBearer returns the principal of an Authorization header
+// value of the form "Bearer <token>".
+func (p *policy) authenticateBearer(authorization string) (*principal, error) {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, unauthenticatedErr
+	}
+
+	pr, ok := p.authenticate(strings.TrimSpace(token))
+	if !ok {
+		return nil, unauthenticatedErr
+	}
+
+	return pr, nil
+}
+
+func (pr *principal) authorize(perm permission, key string) error {
+	if pr.allowed(perm, key) {
+		return nil
+	}
+
+	verb := "read"
+	if perm&permissionWrite != 0 {
+		verb = "write"
+	}
+
+	return status.Errorf(codes.PermissionDenied, "Principal %s may not %s this key", pr.name, verb)
+}
+
+// unaryInterceptor authenticates every gRPC request, and checks that its
+// principal has permission to do what it asks.
+func (p *policy) unaryInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	pr, err := p.authenticateIncoming(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := authorizeRequest(pr, req); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// streamInterceptor authenticates every gRPC stream. Streams may carry any
+// key: replication streams, anti-entropy reads and invalidation streams need
+// read access to every key, and anti-entropy writes need write access.
+func (p *policy) streamInterceptor(
+	srv any,
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	pr, err := p.authenticateIncoming(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	var perm permission
+	switch info.FullMethod {
+	case pb.Replication_Stream_FullMethodName, pb.AntiEntropy_Read_FullMethodName, pb.Invalidation_Watch_FullMethodName:
+		perm = permissionRead
+	case pb.AntiEntropy_Write_FullMethodName:
+		perm = permissionWrite
+	default:
+		return status.Errorf(codes.PermissionDenied, "Principal %s may not make this request", pr.name)
+	}
+
+	if err := pr.authorize(perm, ""); err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
+
+// authenticateIncoming returns the principal of the bearer token in the
+// metadata of an incoming gRPC request.
+func (p *policy) authenticateIncoming(ctx context.Context) (*principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	authorization := md.Get("authorization")
+	if len(authorization) != 1 {
+		return nil, unauthenticatedErr
+	}
+
+	return p.authenticateBearer(authorization[0])
+}
+
+// authorizeRequest checks the permissions that a request needs. Requests
+// that are not known here are denied.
+func authorizeRequest(pr *principal, req any) error {
+	switch r := req.(type) {
+	case *pb.GetRequest:
+		return pr.authorize(permissionRead, r.Key)
+	case *pb.SetRequest:
+		return pr.authorize(permissionWrite, r.Key)
+	case *pbv2.GetRequest:
+		return pr.authorize(permissionRead, string(r.Key))
+	case *pbv2.SetRequest:
+		return pr.authorize(permissionWrite, string(r.Key))
+	case *pbv2.DeleteRequest:
+		return pr.authorize(permissionWrite, string(r.Key))
+	case *pbv2.ScanRequest:
+		// the keys of the prefix are all readable only if the prefix is
+		return pr.authorize(permissionRead, string(r.Prefix))
+	case *pb.StatsRequest:
+		return nil
+	case *pb.CompactRequest:
+		// compaction rewrites every key
+		return pr.authorize(permissionWrite, "")
+	case *pb.GetShardMapRequest:
+		return nil
+	case *pb.SetShardMapRequest, *pb.ImportRequest:
+		// moving keys between shards writes any of them
+		return pr.authorize(permissionWrite, "")
+	case *pb.FetchRequest:
+		return pr.authorize(permissionRead, string(r.Key))
+	case *pb.TreeRequest, *pb.LeavesRequest:
+		// Merkle trees summarize every key
+		return pr.authorize(permissionRead, "")
+	default:
+		return status.Errorf(codes.PermissionDenied, "Principal %s may not make this request", pr.name)
+	}
+}
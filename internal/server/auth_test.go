@@ -0,0 +1,295 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+	pbv2 "github.com/arpitchauhan/simple-database/database/v2"
+)
+
+func tokenSHA256(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeTestPolicy writes a policy where app may read and write keys under
+// app/ and read keys under shared/, and admin may do anything.
+func writeTestPolicy(t *testing.T) string {
+	t.Helper()
+
+	contents := `{"principals": {
+		"app": {"token_sha256": "` + tokenSHA256("app-token") + `", "grants": [
+			{"prefix": "app/", "permissions": ["read", "write"]},
+			{"prefix": "shared/", "permissions": ["read"]}
+		]},
+		"admin": {"token_sha256": "` + tokenSHA256("admin-token") + `", "grants": [
+			{"prefix": "", "permissions": ["read", "write"]}
+		]}
+	}}`
+
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func loadTestPolicy(t *testing.T) *policy {
+	t.Helper()
+
+	p, err := loadPolicy(writeTestPolicy(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func Test_loadPolicy_errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"Not JSON", `principals`},
+		{"Unknown field", `{"principals": {}, "users": {}}`},
+		{"Token not hashed", `{"principals": {"app": {"token_sha256": "app-token"}}}`},
+		{
+			"Unknown permission",
+			`{"principals": {"app": {"token_sha256": "` + tokenSHA256("t") + `", ` +
+				`"grants": [{"prefix": "", "permissions": ["delete"]}]}}}`,
+		},
+		{
+			"Shared token",
+			`{"principals": {"a": {"token_sha256": "` + tokenSHA256("t") + `"}, ` +
+				`"b": {"token_sha256": "` + tokenSHA256("t") + `"}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.json")
+			if err := os.WriteFile(path, []byte(tt.contents), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := loadPolicy(path); err == nil {
+				t.Errorf("wanted error")
+			}
+		})
+	}
+}
+
+func Test_policy_unaryInterceptor(t *testing.T) {
+	p := loadTestPolicy(t)
+
+	tests := []struct {
+		name          string
+		authorization []string
+		req           any
+		want          codes.Code
+	}{
+		{"No token", nil, &pb.GetRequest{Key: "app/1"}, codes.Unauthenticated},
+		{"Unknown token", []string{"Bearer other"}, &pb.GetRequest{Key: "app/1"}, codes.Unauthenticated},
+		{"Not a bearer token", []string{"Basic app-token"}, &pb.GetRequest{Key: "app/1"}, codes.Unauthenticated},
+		{"Read own prefix", []string{"Bearer app-token"}, &pb.GetRequest{Key: "app/1"}, codes.OK},
+		{"Write own prefix", []string{"bearer app-token"}, &pbv2.SetRequest{Key: []byte("app/1")}, codes.OK},
+		{"Read shared prefix", []string{"Bearer app-token"}, &pbv2.GetRequest{Key: []byte("shared/1")}, codes.OK},
+		{"Write read-only prefix", []string{"Bearer app-token"}, &pb.SetRequest{Key: "shared/1"}, codes.PermissionDenied},
+		{"Read other prefix", []string{"Bearer app-token"}, &pb.GetRequest{Key: "other/1"}, codes.PermissionDenied},
+		{"Delete read-only prefix", []string{"Bearer app-token"}, &pbv2.DeleteRequest{Key: []byte("shared/1")}, codes.PermissionDenied},
+		{"Delete own prefix", []string{"Bearer app-token"}, &pbv2.DeleteRequest{Key: []byte("app/1")}, codes.OK},
+		{"Scan shared prefix", []string{"Bearer app-token"}, &pbv2.ScanRequest{Prefix: []byte("shared/")}, codes.OK},
+		{"Scan every key", []string{"Bearer app-token"}, &pbv2.ScanRequest{}, codes.PermissionDenied},
+		{"Stats", []string{"Bearer app-token"}, &pb.StatsRequest{}, codes.OK},
+		{"Compact without access to every key", []string{"Bearer app-token"}, &pb.CompactRequest{}, codes.PermissionDenied},
+		{"Compact", []string{"Bearer admin-token"}, &pb.CompactRequest{}, codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.authorization != nil {
+				ctx = metadata.NewIncomingContext(ctx, metadata.MD{"authorization": tt.authorization})
+			}
+
+			handler := func(ctx context.Context, req any) (any, error) {
+				return "handled", nil
+			}
+
+			_, err := p.unaryInterceptor(ctx, tt.req, &grpc.UnaryServerInfo{}, handler)
+
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("code = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_respServer_auth(t *testing.T) {
+	s, addr := startRESPServer(t)
+	s.policy = loadTestPolicy(t)
+
+	s.db.setKey("shared/1", "shared")
+	s.db.setKey("other/1", "other")
+
+	c := dialRESP(t, addr)
+
+	tests := []struct {
+		name    string
+		command []string
+		want    any
+	}{
+		{"Before authenticating", []string{"GET", "app/1"}, respError("NOAUTH Authentication required.")},
+		{"Wrong token", []string{"AUTH", "other"}, respError("WRONGPASS invalid username-password pair or user is disabled.")},
+		{"Wrong user", []string{"AUTH", "admin", "app-token"}, respError("WRONGPASS invalid username-password pair or user is disabled.")},
+		{"Authenticate", []string{"AUTH", "app", "app-token"}, "OK"},
+		{"Write own prefix", []string{"SET", "app/1", "value"}, "OK"},
+		{"Read shared prefix", []string{"GET", "shared/1"}, "shared"},
+		{
+			"Write read-only prefix",
+			[]string{"MSET", "app/2", "value", "shared/1", "value"},
+			respError("NOPERM User app has no permissions to access one of the keys used as arguments"),
+		},
+		{
+			"Incr needs write",
+			[]string{"INCR", "shared/1"},
+			respError("NOPERM User app has no permissions to access one of the keys used as arguments"),
+		},
+		{"Scan leaves out other keys", []string{"SCAN", "0", "COUNT", "100"}, []any{"0", []any{"shared/1", "app/1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.do(t, tt.command...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %#v, want = %#v", got, tt.want)
+			}
+		})
+	}
+
+	// HELLO authenticates too
+	c2 := dialRESP(t, addr)
+	got := c2.do(t, "HELLO", "2", "AUTH", "default", "admin-token")
+	if _, isError := got.(respError); isError {
+		t.Errorf("got = %#v, want = HELLO reply", got)
+	}
+	if got := c2.do(t, "GET", "other/1"); got != "other" {
+		t.Errorf("got = %#v, want = other", got)
+	}
+}
+
+func Test_httpGateway_auth(t *testing.T) {
+	s, c := startHTTPGateway(t)
+	s.policy = loadTestPolicy(t)
+
+	s.db.setKey("shared/1", "shared")
+	s.db.setKey("other/1", "other")
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		headers    []string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "No token", method: "GET", path: "/v1/keys/app/1",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Write own prefix", method: "PUT", path: "/v1/keys/app/1", body: `{"value": "v"}`,
+			headers:    []string{"Authorization", "Bearer app-token"},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name: "Delete read-only prefix", method: "DELETE", path: "/v1/keys/shared/1",
+			headers:    []string{"Authorization", "Bearer app-token"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "Read other prefix", method: "GET", path: "/v1/keys/other/1",
+			headers:    []string{"Authorization", "Bearer app-token"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "List leaves out other keys", method: "GET", path: "/v1/keys",
+			headers:    []string{"Authorization", "Bearer app-token"},
+			wantStatus: http.StatusOK,
+			wantBody:   `{"keys":["shared/1","app/1"]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.t = t
+
+			resp, body := c.do(tt.method, tt.path, tt.body, tt.headers...)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want = %d, body = %s", resp.StatusCode, tt.wantStatus, body)
+			}
+
+			if tt.wantBody != "" && body != tt.wantBody {
+				t.Errorf("body = %q, want = %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss testServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+func Test_policy_streamInterceptor(t *testing.T) {
+	p := loadTestPolicy(t)
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		want   codes.Code
+	}{
+		{"No token", "", pb.Replication_Stream_FullMethodName, codes.Unauthenticated},
+		{"Without access to every key", "app-token", pb.Replication_Stream_FullMethodName, codes.PermissionDenied},
+		{"Unknown stream", "admin-token", "/server.Replication/Other", codes.PermissionDenied},
+		{"Replicate", "admin-token", pb.Replication_Stream_FullMethodName, codes.OK},
+		{"Anti-entropy read without access to every key", "app-token", pb.AntiEntropy_Read_FullMethodName, codes.PermissionDenied},
+		{"Anti-entropy write without access to every key", "app-token", pb.AntiEntropy_Write_FullMethodName, codes.PermissionDenied},
+		{"Anti-entropy write", "admin-token", pb.AntiEntropy_Write_FullMethodName, codes.OK},
+		{"Watch without access to every key", "app-token", pb.Invalidation_Watch_FullMethodName, codes.PermissionDenied},
+		{"Watch", "admin-token", pb.Invalidation_Watch_FullMethodName, codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.MD{"authorization": {"Bearer " + tt.token}})
+			}
+
+			handler := func(srv any, ss grpc.ServerStream) error {
+				return nil
+			}
+
+			err := p.streamInterceptor(nil, testServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: tt.method}, handler)
+
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("code = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// Rough per-entry bookkeeping cost of the value cache, on top of the key
+// and value themselves.
+const valueCacheEntryOverhead = 64
+
+// valueCache is a least-recently-used cache of values, bounded by the total
+// size of the cached keys and values.
+type valueCache struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	bytes   int64
+	entries map[string]*list.Element
+	// recency holds *cacheEntry, most recently used at the front.
+	recency *list.List
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	key   string
+	value string
+}
+
+func newValueCache(maxBytes int64) *valueCache {
+	return &valueCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		recency:  list.New(),
+	}
+}
+
+func entrySize(key, value string) int64 {
+	return int64(len(key)+len(value)) + valueCacheEntryOverhead
+}
+
+func (c *valueCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return "", false
+	}
+
+	c.hits.Add(1)
+	c.recency.MoveToFront(e)
+
+	return e.Value.(*cacheEntry).value, true
+}
+
+func (c *valueCache) put(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+
+	size := entrySize(key, value)
+	if size > c.maxBytes {
+		return
+	}
+
+	for c.bytes+size > c.maxBytes {
+		c.remove(c.recency.Back().Value.(*cacheEntry).key)
+	}
+
+	c.entries[key] = c.recency.PushFront(&cacheEntry{key: key, value: value})
+	c.bytes += size
+}
+
+func (c *valueCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+}
+
+// clear removes every value.
+func (c *valueCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.recency.Init()
+	c.bytes = 0
+}
+
+func (c *valueCache) remove(key string) {
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	entry := c.recency.Remove(e).(*cacheEntry)
+	delete(c.entries, key)
+	c.bytes -= entrySize(entry.key, entry.value)
+}
+
+type cacheStats struct {
+	hits    uint64
+	misses  uint64
+	entries uint64
+	bytes   uint64
+}
+
+func (c *valueCache) stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return cacheStats{
+		hits:    c.hits.Load(),
+		misses:  c.misses.Load(),
+		entries: uint64(len(c.entries)),
+		bytes:   uint64(c.bytes),
+	}
+}
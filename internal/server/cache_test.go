@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+func Test_valueCache(t *testing.T) {
+	c := newValueCache(2 * entrySize("k1", "v1"))
+
+	c.put("k1", "v1")
+	c.put("k2", "v2")
+	c.get("k1") // k2 is now the least recently used
+	c.put("k3", "v3")
+
+	if _, ok := c.get("k2"); ok {
+		t.Errorf("least recently used entry was not evicted")
+	}
+	if value, ok := c.get("k1"); !ok || value != "v1" {
+		t.Errorf("got = %v, %v, want = v1, true", value, ok)
+	}
+	if value, ok := c.get("k3"); !ok || value != "v3" {
+		t.Errorf("got = %v, %v, want = v3, true", value, ok)
+	}
+
+	c.invalidate("k1")
+	if _, ok := c.get("k1"); ok {
+		t.Errorf("invalidated entry was returned")
+	}
+
+	c.put("big", string(make([]byte, 100)))
+	if _, ok := c.get("big"); ok {
+		t.Errorf("entry larger than the cache was stored")
+	}
+
+	stats := c.stats()
+	want := cacheStats{hits: 3, misses: 3, entries: 1, bytes: uint64(entrySize("k3", "v3"))}
+	if stats != want {
+		t.Errorf("got = %+v, want = %+v", stats, want)
+	}
+}
+
+func Test_server_cache(t *testing.T) {
+	t.Cleanup(deleteDatabase)
+	createDatabase([][]string{{"key", "value"}})
+
+	db := &database{filepath: testDatabasePath, initialized: false, cacheSize: 1 << 20}
+	s := &server{db: db}
+	s.initialize()
+
+	get := func(want string) {
+		t.Helper()
+
+		reply, err := s.Get(context.Background(), &pb.GetRequest{Key: "key"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Value != want {
+			t.Errorf("got = %v, want = %v", reply.Value, want)
+		}
+	}
+
+	get("value")
+	get("value")
+
+	if _, err := s.Set(context.Background(), &pb.SetRequest{Key: "key", Value: "value2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	get("value2")
+
+	stats, err := s.Stats(context.Background(), &pb.StatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.CacheHits != 1 || stats.CacheMisses != 2 || stats.CacheEntries != 1 {
+		t.Errorf(
+			"hits = %d, misses = %d, entries = %d, want 1, 2 and 1",
+			stats.CacheHits,
+			stats.CacheMisses,
+			stats.CacheEntries,
+		)
+	}
+}
@@ -0,0 +1,774 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+	pbv2 "github.com/arpitchauhan/simple-database/database/v2"
+)
+
+// In cluster mode, every write goes through a Raft log that is replicated
+// across the members of the cluster, and is applied to the database of each
+// member once a majority of them have it. The leader of the cluster is the
+// only member that proposes writes; the others forward the gRPC writes they
+// receive to it. Reads are served by every member from its own database,
+// which may lag behind the leader on followers.
+//
+// Raft snapshots are copies of the database file, which let Raft truncate
+// its log and bring members that fell far behind up to date. The index of
+// the last entry applied to the database file is kept next to the log, as
+// Raft applies the whole log again when a member without snapshots restarts.
+
+const (
+	raftDirName = "raft"
+	// raftAppliedFileName is the file of the Raft directory that holds the
+	// index of the last entry applied to the database file.
+	raftAppliedFileName = "applied"
+
+	// raftApplyTimeout is how long a write waits to be enqueued in the
+	// Raft log.
+	raftApplyTimeout = 10 * time.Second
+	// leaderWait is how long a write to a follower waits for a leader to
+	// be elected before failing.
+	leaderWait = 5 * time.Second
+	// forwardedMetadata marks the requests that a follower forwarded to the
+	// leader, which are never forwarded again.
+	forwardedMetadata = "x-simple-database-forwarded"
+)
+
+// raftSnapshotInterval is how often Raft checks whether to take a snapshot.
+// Tests make it shorter.
+var raftSnapshotInterval = 2 * time.Minute
+
+var notLeaderErr = status.Error(codes.Unavailable, "Not the leader of the cluster")
+
+// clusterPeer is a member of a cluster.
+type clusterPeer struct {
+	id       string
+	raftAddr string
+	grpcAddr string
+}
+
+// parseClusterPeers parses a comma-separated list of members, each given as
+// id=raft-address=grpc-address.
+func parseClusterPeers(s string) ([]clusterPeer, error) {
+	var peers []clusterPeer
+	seen := make(map[string]bool)
+
+	for _, spec := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(spec), "=")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid member %q, must be id=raft-address=grpc-address", spec)
+		}
+
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("member %q is listed more than once", parts[0])
+		}
+		seen[parts[0]] = true
+
+		peers = append(peers, clusterPeer{id: parts[0], raftAddr: parts[1], grpcAddr: parts[2]})
+	}
+
+	return peers, nil
+}
+
+// cluster makes a database the state machine of a Raft cluster.
+type cluster struct {
+	d     *database
+	id    string
+	peers []clusterPeer
+
+	raft      *raft.Raft
+	fsm       *clusterFSM
+	transport *raft.NetworkTransport
+	store     *raftboltdb.BoltStore
+
+	// proposing serializes the writes proposed by the leader, so that what
+	// a write reads is not changed by another before it is applied.
+	proposing sync.Mutex
+
+	// conns are the connections to the other members that writes are
+	// forwarded over, by gRPC address.
+	dialOpts []grpc.DialOption
+	connsMu  sync.Mutex
+	conns    map[string]*grpc.ClientConn
+}
+
+// clusterOptions are the settings of a member of a cluster.
+type clusterOptions struct {
+	id    string
+	peers []clusterPeer
+	// dir is where the Raft log and snapshots are kept.
+	dir string
+
+	snapshotThreshold uint64
+	trailingLogs      uint64
+	logLevel          string
+
+	// dialOpts are used to connect to the other members.
+	dialOpts []grpc.DialOption
+	// listenerTLS and dialTLS secure the Raft traffic between the members,
+	// which is plain TCP if they are nil.
+	listenerTLS *tls.Config
+	dialTLS     *tls.Config
+}
+
+// joinCluster starts the member of the cluster in cfg. It connects to the
+// other members with TLS if certs is not nil, over gRPC and Raft alike.
+func joinCluster(d *database, cfg *Config, certs *certReloader) (*cluster, error) {
+	creds, err := peerCredentials(certs != nil, cfg.tlsClientCA, certs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up connections to the cluster: %w", err)
+	}
+
+	var listenerTLS, dialTLS *tls.Config
+	if certs != nil {
+		listenerTLS = certs.config()
+		if dialTLS, err = peerTLSConfig(cfg.tlsClientCA, certs); err != nil {
+			return nil, fmt.Errorf("failed to set up connections to the cluster: %w", err)
+		}
+	}
+
+	c, err := openCluster(d, clusterOptions{
+		id:                cfg.raftID,
+		peers:             cfg.clusterPeers,
+		dir:               filepath.Join(cfg.dataDir, raftDirName),
+		snapshotThreshold: cfg.raftSnapshotThreshold,
+		trailingLogs:      cfg.raftTrailingLogs,
+		logLevel:          cfg.logLevel,
+		dialOpts: []grpc.DialOption{
+			grpc.WithTransportCredentials(creds),
+			grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(cfg.maxKeySize + cfg.maxValueSize + 1024)),
+		},
+		listenerTLS: listenerTLS,
+		dialTLS:     dialTLS,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to join the cluster: %w", err)
+	}
+
+	log.Printf("member %s of a cluster of %d", cfg.raftID, len(cfg.clusterPeers))
+
+	return c, nil
+}
+
+// openCluster starts the Raft member of d, which must be initialized. The
+// cluster is bootstrapped with its members the first time it starts.
+func openCluster(d *database, opts clusterOptions) (*cluster, error) {
+	var self *clusterPeer
+	for i := range opts.peers {
+		if opts.peers[i].id == opts.id {
+			self = &opts.peers[i]
+		}
+	}
+	if self == nil {
+		return nil, fmt.Errorf("member %q is not one of the members of the cluster", opts.id)
+	}
+
+	if err := os.MkdirAll(opts.dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name:   "raft",
+		Level:  raftLogLevel(opts.logLevel),
+		Output: log.Writer(),
+	})
+
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(opts.id)
+	conf.Logger = logger
+	conf.SnapshotInterval = raftSnapshotInterval
+	conf.SnapshotThreshold = opts.snapshotThreshold
+	conf.TrailingLogs = opts.trailingLogs
+
+	store, err := raftboltdb.NewBoltStore(filepath.Join(opts.dir, "raft.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the Raft log: %w", err)
+	}
+
+	snapshots, err := raft.NewFileSnapshotStoreWithLogger(opts.dir, 2, logger)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to open the Raft snapshots: %w", err)
+	}
+
+	transport, err := newRaftTransport(self.raftAddr, opts, logger)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to listen for Raft: %w", err)
+	}
+
+	c := &cluster{
+		d:         d,
+		id:        opts.id,
+		peers:     opts.peers,
+		transport: transport,
+		store:     store,
+		dialOpts:  opts.dialOpts,
+		conns:     make(map[string]*grpc.ClientConn),
+	}
+
+	existing, err := raft.HasExistingState(store, store, snapshots)
+	if err == nil && !existing {
+		configuration := raft.Configuration{}
+		for _, p := range opts.peers {
+			configuration.Servers = append(configuration.Servers, raft.Server{
+				ID:      raft.ServerID(p.id),
+				Address: raft.ServerAddress(p.raftAddr),
+			})
+		}
+
+		err = raft.BootstrapCluster(conf, store, store, snapshots, transport, configuration)
+	}
+	if err != nil {
+		transport.Close()
+		store.Close()
+		return nil, fmt.Errorf("failed to bootstrap the cluster: %w", err)
+	}
+
+	c.fsm, err = openClusterFSM(d, filepath.Join(opts.dir, raftAppliedFileName))
+	if err != nil {
+		transport.Close()
+		store.Close()
+		return nil, fmt.Errorf("failed to open the applied index: %w", err)
+	}
+
+	c.raft, err = raft.NewRaft(conf, c.fsm, store, store, snapshots, transport)
+	if err != nil {
+		c.fsm.close()
+		transport.Close()
+		store.Close()
+		return nil, fmt.Errorf("failed to start Raft: %w", err)
+	}
+
+	return c, nil
+}
+
+// newRaftTransport listens for the Raft traffic of the member at addr, over
+// TLS if opts has a TLS configuration.
+func newRaftTransport(addr string, opts clusterOptions, logger hclog.Logger) (*raft.NetworkTransport, error) {
+	if opts.listenerTLS == nil {
+		return raft.NewTCPTransportWithLogger(addr, nil, 3, 10*time.Second, logger)
+	}
+
+	stream, err := listenTLSStream(addr, opts.listenerTLS, opts.dialTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	return raft.NewNetworkTransportWithLogger(stream, 3, 10*time.Second, logger), nil
+}
+
+// tlsStreamLayer carries the Raft traffic of a member over TLS. Members
+// verify each other as they do over gRPC, so with a client CA, members that
+// connect must also present a certificate signed by it.
+type tlsStreamLayer struct {
+	net.Listener
+	dialTLS *tls.Config
+}
+
+func listenTLSStream(addr string, listenerTLS, dialTLS *tls.Config) (*tlsStreamLayer, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tlsStreamLayer{Listener: tls.NewListener(lis, listenerTLS), dialTLS: dialTLS}, nil
+}
+
+// Dial connects to another member, within timeout including the handshake.
+func (l *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", string(address), l.dialTLS)
+}
+
+func raftLogLevel(level string) hclog.Level {
+	switch level {
+	case logLevelDebug:
+		return hclog.Debug
+	case logLevelInfo:
+		return hclog.Info
+	default:
+		return hclog.Error
+	}
+}
+
+// propose applies the changes returned by compute through the Raft log, if
+// this member is the leader. compute runs with the database locked for
+// reading, after every write before it was applied.
+func (c *cluster) propose(ctx context.Context, compute func() ([]change, ErrorCode)) ErrorCode {
+	_, span := tracer().Start(ctx, "raft.propose")
+	defer span.End()
+
+	c.proposing.Lock()
+	defer c.proposing.Unlock()
+
+	if c.raft.State() != raft.Leader {
+		return NotLeader
+	}
+
+	// a new leader may not have applied every write of the previous one
+	if c.raft.AppliedIndex() < c.raft.LastIndex() {
+		if err := c.raft.Barrier(raftApplyTimeout).Error(); err != nil {
+			log.Printf("Failed to catch up with the Raft log: %v", err)
+			return raftErrorCode(err)
+		}
+	}
+
+	c.d.mu.RLock()
+	if c.d.closed {
+		c.d.mu.RUnlock()
+		return DatabaseClosed
+	}
+	changes, code := compute()
+	c.d.mu.RUnlock()
+
+	if code != OK || len(changes) == 0 {
+		return code
+	}
+
+	command, err := encodeRaftCommand(changes)
+	if err != nil {
+		log.Printf("Failed to encode the changes: %v", err)
+		return InternalError
+	}
+
+	future := c.raft.Apply(command, raftApplyTimeout)
+	if err := future.Error(); err != nil {
+		log.Printf("Failed to apply the changes through Raft: %v", err)
+		return raftErrorCode(err)
+	}
+
+	code, _ = future.Response().(ErrorCode)
+	endSpan(span, code)
+	return code
+}
+
+func raftErrorCode(err error) ErrorCode {
+	if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) ||
+		errors.Is(err, raft.ErrLeadershipTransferInProgress) {
+		return NotLeader
+	}
+
+	if errors.Is(err, raft.ErrRaftShutdown) {
+		return DatabaseClosed
+	}
+
+	return InternalError
+}
+
+// leader returns the member that leads the cluster, if there is one.
+func (c *cluster) leader() (clusterPeer, bool) {
+	_, id := c.raft.LeaderWithID()
+
+	for _, p := range c.peers {
+		if raft.ServerID(p.id) == id {
+			return p, true
+		}
+	}
+
+	return clusterPeer{}, false
+}
+
+// role is the Raft state of the member: leader, follower or candidate.
+func (c *cluster) role() string {
+	return strings.ToLower(c.raft.State().String())
+}
+
+// unaryInterceptor forwards the writes received by a follower to the
+// leader, so that clients may send them to any member.
+func (c *cluster) unaryInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	var reply any
+	switch req.(type) {
+	case *pb.SetRequest:
+		reply = &pb.SetReply{}
+	case *pbv2.SetRequest:
+		reply = &pbv2.SetReply{}
+	case *pbv2.DeleteRequest:
+		reply = &pbv2.DeleteReply{}
+	default:
+		return handler(ctx, req)
+	}
+
+	leader, err := c.waitForLeader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if leader.id == c.id {
+		return handler(ctx, req)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md.Get(forwardedMetadata)) > 0 {
+		return nil, notLeaderErr
+	}
+
+	conn, err := c.conn(leader.grpcAddr)
+	if err != nil {
+		log.Printf("Failed to connect to the leader at %s: %v", leader.grpcAddr, err)
+		return nil, notLeaderErr
+	}
+
+	infof("Forwarding %s to the leader at %s", info.FullMethod, leader.grpcAddr)
+
+	forwardedMD := metadata.MD{forwardedMetadata: {c.id}}
+	if authorization := md.Get("authorization"); len(authorization) > 0 {
+		forwardedMD.Set("authorization", authorization...)
+	}
+
+	err = conn.Invoke(metadata.NewOutgoingContext(ctx, forwardedMD), info.FullMethod, req, reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// waitForLeader returns the leader of the cluster, waiting for one to be
+// elected if there is none.
+func (c *cluster) waitForLeader(ctx context.Context) (clusterPeer, error) {
+	deadline := time.NewTimer(leaderWait)
+	defer deadline.Stop()
+
+	for {
+		if leader, ok := c.leader(); ok {
+			return leader, nil
+		}
+
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline.C:
+			return clusterPeer{}, notLeaderErr
+		case <-ctx.Done():
+			return clusterPeer{}, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+func (c *cluster) conn(addr string) (*grpc.ClientConn, error) {
+	c.connsMu.Lock()
+	defer c.connsMu.Unlock()
+
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+
+	conn, err := grpc.NewClient(addr, c.dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// shutdown stops the member, after handing leadership over to another if
+// it is the leader, so that the cluster does not wait for an election.
+func (c *cluster) shutdown() error {
+	if c.raft.State() == raft.Leader {
+		if err := c.raft.LeadershipTransfer().Error(); err != nil {
+			log.Printf("Failed to transfer leadership: %v", err)
+		}
+	}
+
+	err := c.raft.Shutdown().Error()
+
+	c.connsMu.Lock()
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+	c.connsMu.Unlock()
+
+	if closeErr := c.transport.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := c.store.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := c.fsm.close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// raftChange is a change as it is stored in the Raft log.
+type raftChange struct {
+	Key       string `json:"key"`
+	Value     []byte `json:"value,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	Flags     uint32 `json:"flags,omitempty"`
+	Deleted   bool   `json:"deleted,omitempty"`
+}
+
+func encodeRaftCommand(changes []change) ([]byte, error) {
+	command := make([]raftChange, len(changes))
+	for i, c := range changes {
+		command[i] = raftChange{
+			Key:       c.key,
+			Value:     []byte(c.entry.value),
+			ExpiresAt: c.entry.expiresAt,
+			Flags:     c.entry.flags,
+			Deleted:   c.deleted,
+		}
+	}
+
+	return json.Marshal(command)
+}
+
+func decodeRaftCommand(data []byte) ([]change, error) {
+	var command []raftChange
+	if err := json.Unmarshal(data, &command); err != nil {
+		return nil, err
+	}
+
+	changes := make([]change, len(command))
+	for i, c := range command {
+		changes[i] = change{
+			key:     c.Key,
+			entry:   entry{value: string(c.Value), expiresAt: c.ExpiresAt, flags: c.Flags},
+			deleted: c.Deleted,
+		}
+	}
+
+	return changes, nil
+}
+
+// clusterFSM applies the Raft log to a database.
+type clusterFSM struct {
+	d *database
+
+	// appliedFile holds applied, the index of the last entry in the
+	// database file. Raft only calls the FSM from one goroutine, so
+	// neither needs a lock.
+	appliedFile *os.File
+	applied     uint64
+}
+
+// openClusterFSM opens the FSM of d, which must be initialized, with the
+// index of the last applied entry kept at path. The index is ignored if
+// the database file is empty, such as after it was deleted.
+func openClusterFSM(d *database, path string) (*clusterFSM, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	fsm := &clusterFSM{d: d, appliedFile: f}
+
+	var b [8]byte
+	if _, err := f.ReadAt(b[:], 0); err != nil && !errors.Is(err, io.EOF) {
+		f.Close()
+		return nil, err
+	}
+
+	info, err := os.Stat(d.filepath)
+	if err == nil && info.Size() > 0 {
+		fsm.applied = binary.LittleEndian.Uint64(b[:])
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		f.Close()
+		return nil, err
+	}
+
+	return fsm, nil
+}
+
+// setApplied records that the database file has the entries up to index.
+// It is called once the entry is written, so a crash in between only makes
+// the restarted member append that entry again.
+func (f *clusterFSM) setApplied(index uint64) error {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], index)
+
+	if _, err := f.appliedFile.WriteAt(b[:], 0); err != nil {
+		return err
+	}
+
+	f.applied = index
+	return nil
+}
+
+func (f *clusterFSM) close() error {
+	return f.appliedFile.Close()
+}
+
+// Apply appends the changes of a log entry to the database, and returns
+// the ErrorCode of the write. Entries that the database file already has,
+// which Raft applies again after a restart, are skipped.
+func (f *clusterFSM) Apply(l *raft.Log) any {
+	if l.Index <= f.applied {
+		return OK
+	}
+
+	changes, err := decodeRaftCommand(l.Data)
+	if err != nil {
+		log.Printf("Failed to decode Raft log entry %d: %v", l.Index, err)
+		return InternalError
+	}
+
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+
+	if f.d.closed {
+		return DatabaseClosed
+	}
+
+	code := f.d.apply(context.Background(), changes)
+	if code == OK {
+		if err := f.setApplied(l.Index); err != nil {
+			log.Printf("Failed to record the applied Raft log entry %d: %v", l.Index, err)
+		}
+	}
+
+	return code
+}
+
+// Snapshot captures the database file as it is. The file is only appended
+// to, or replaced as a whole, so the part of it that exists now stays as it
+// is for as long as it is open.
+func (f *clusterFSM) Snapshot() (raft.FSMSnapshot, error) {
+	f.d.mu.RLock()
+	defer f.d.mu.RUnlock()
+
+	if f.d.closed {
+		return nil, errors.New("database is closed")
+	}
+
+	file, code := f.d.openForReading()
+	if code != OK {
+		return nil, fmt.Errorf("failed to open the database file: error code %d", code)
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &clusterSnapshot{file: file, size: size}, nil
+}
+
+// Restore replaces the database file with a snapshot. The entries that
+// follow the snapshot are applied to it, even those that the replaced file
+// had, so the applied index starts over.
+func (f *clusterFSM) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+
+	if err := f.setApplied(0); err != nil {
+		return fmt.Errorf("failed to reset the applied index: %w", err)
+	}
+
+	if code := f.d.restoreFile(snapshot); code != OK {
+		return fmt.Errorf("failed to restore the snapshot: error code %d", code)
+	}
+
+	return nil
+}
+
+type clusterSnapshot struct {
+	file *os.File
+	size int64
+}
+
+func (s *clusterSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := io.Copy(sink, io.NewSectionReader(s.file, 0, s.size)); err != nil {
+		sink.Cancel()
+		return err
+	}
+
+	return sink.Close()
+}
+
+func (s *clusterSnapshot) Release() {
+	s.file.Close()
+}
+
+// restoreFile replaces the database file with the contents of r.
+func (d *database) restoreFile(r io.Reader) ErrorCode {
+	d.ensureInitialized()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return DatabaseClosed
+	}
+
+	tmpPath := d.filepath + compactionSuffix
+
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		log.Printf("Failed to create the restored database file: %v", err)
+		return InternalError
+	}
+	defer os.Remove(tmpPath)
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		log.Printf("Failed to write the restored database file: %v", err)
+		return InternalError
+	}
+
+	if err := d.sync(tmp); err != nil {
+		log.Printf("Failed to sync the restored database file: %v", err)
+		return InternalError
+	}
+
+	// replicas start over from the restored file, as after a compaction
+	if d.epoch != "" {
+		if code := d.writeEpoch(newEpoch()); code != OK {
+			return code
+		}
+	}
+
+	if err := os.Rename(tmpPath, d.filepath); err != nil {
+		log.Printf("Failed to replace the database file: %v", err)
+		return InternalError
+	}
+
+	d.compactions.Add(1)
+	d.notifyAppended()
+
+	if d.cache != nil {
+		d.cache.clear()
+	}
+	d.watchers.invalidateAll()
+
+	if code := d.rebuildIndex(); code != OK {
+		return code
+	}
+
+	if d.storage != nil {
+		return d.countStorage()
+	}
+
+	return OK
+}
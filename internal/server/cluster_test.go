@@ -0,0 +1,357 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+// testCluster runs the members of a cluster in-process, each with its own
+// data directory, so that they can be killed and restarted.
+type testCluster struct {
+	t     *testing.T
+	peers []clusterPeer
+	dirs  []string
+	args  []string
+	// clientTLS is what the test connects to members with, if they serve
+	// TLS
+	clientTLS *tls.Config
+
+	members []*testClusterMember
+}
+
+type testClusterMember struct {
+	cancel context.CancelFunc
+	done   chan error
+	client pb.DatabaseClient
+}
+
+func startTestCluster(t *testing.T, size int, args ...string) *testCluster {
+	return startTLSTestCluster(t, size, nil, args...)
+}
+
+func startTLSTestCluster(t *testing.T, size int, clientTLS *tls.Config, args ...string) *testCluster {
+	tc := &testCluster{t: t, args: args, clientTLS: clientTLS, members: make([]*testClusterMember, size)}
+
+	for i := 0; i < size; i++ {
+		tc.peers = append(tc.peers, clusterPeer{id: fmt.Sprintf("n%d", i), raftAddr: freeAddr(t), grpcAddr: freeAddr(t)})
+		tc.dirs = append(tc.dirs, t.TempDir())
+	}
+
+	for i := range tc.members {
+		tc.start(i)
+	}
+	t.Cleanup(func() {
+		for i := range tc.members {
+			tc.kill(i)
+		}
+	})
+
+	return tc
+}
+
+func (tc *testCluster) start(i int) {
+	tc.t.Helper()
+
+	var peers []string
+	for _, p := range tc.peers {
+		peers = append(peers, p.id+"="+p.raftAddr+"="+p.grpcAddr)
+	}
+
+	args := []string{
+		"-addr", tc.peers[i].grpcAddr,
+		"-data-dir", tc.dirs[i],
+		"-log-level", "error",
+		"-raft-id", tc.peers[i].id,
+		"-raft-peers", strings.Join(peers, ","),
+	}
+
+	cfg, err := ParseConfig(append(args, tc.args...), io.Discard)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &testClusterMember{cancel: cancel, done: make(chan error, 1)}
+
+	ready := make(chan struct{})
+	go func() {
+		m.done <- Run(ctx, cfg, func(net.Addr) { close(ready) })
+	}()
+
+	select {
+	case <-ready:
+	case err := <-m.done:
+		tc.t.Fatalf("member %d failed to start: %v", i, err)
+	}
+
+	if tc.clientTLS != nil {
+		conn, err := grpc.NewClient(tc.peers[i].grpcAddr, grpc.WithTransportCredentials(credentials.NewTLS(tc.clientTLS)))
+		if err != nil {
+			tc.t.Fatal(err)
+		}
+		tc.t.Cleanup(func() { conn.Close() })
+		m.client = pb.NewDatabaseClient(conn)
+	} else {
+		m.client = dialDatabase(tc.t, tc.peers[i].grpcAddr)
+	}
+	tc.members[i] = m
+}
+
+// kill stops a member, if it is running.
+func (tc *testCluster) kill(i int) {
+	tc.t.Helper()
+
+	m := tc.members[i]
+	if m == nil {
+		return
+	}
+
+	m.cancel()
+	if err := <-m.done; err != nil {
+		tc.t.Errorf("member %d: error = %v, did not want error", i, err)
+	}
+	tc.members[i] = nil
+}
+
+// leader waits for the running members to agree on a leader, and returns
+// it.
+func (tc *testCluster) leader() int {
+	tc.t.Helper()
+
+	leader := -1
+	eventually(tc.t, "a leader", func() bool {
+		leader = -1
+		for i, m := range tc.members {
+			if m == nil {
+				continue
+			}
+
+			stats, err := m.client.Stats(context.Background(), &pb.StatsRequest{})
+			if err != nil || stats.ClusterLeader == "" {
+				return false
+			}
+
+			if stats.Role == "leader" {
+				leader = i
+			}
+		}
+		return leader >= 0
+	})
+
+	return leader
+}
+
+// follower returns a running member other than the leader.
+func (tc *testCluster) follower(leader int) int {
+	for i, m := range tc.members {
+		if m != nil && i != leader {
+			return i
+		}
+	}
+
+	tc.t.Fatal("no follower is running")
+	return -1
+}
+
+func (tc *testCluster) set(i int, key, value string) {
+	tc.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := tc.members[i].client.Set(ctx, &pb.SetRequest{Key: key, Value: value}); err != nil {
+		tc.t.Fatalf("set %s on member %d: %v", key, i, err)
+	}
+}
+
+// waitForValue waits until every running member has the value of key.
+func (tc *testCluster) waitForValue(key, want string) {
+	tc.t.Helper()
+
+	for i, m := range tc.members {
+		if m == nil {
+			continue
+		}
+
+		eventually(tc.t, fmt.Sprintf("%s on member %d", key, i), func() bool {
+			reply, err := m.client.Get(context.Background(), &pb.GetRequest{Key: key})
+			return err == nil && reply.Value == want
+		})
+	}
+}
+
+func Test_cluster(t *testing.T) {
+	tc := startTestCluster(t, 3)
+
+	leader := tc.leader()
+
+	// followers forward writes to the leader
+	tc.set(tc.follower(leader), "key", "1")
+	tc.waitForValue("key", "1")
+
+	tc.kill(leader)
+
+	newLeader := tc.leader()
+	if newLeader == leader {
+		t.Fatalf("leader did not change after it was killed")
+	}
+
+	tc.set(tc.follower(newLeader), "key", "2")
+	tc.set(newLeader, "other", "3")
+
+	// the killed member catches up once it is back
+	tc.start(leader)
+	tc.waitForValue("key", "2")
+	tc.waitForValue("other", "3")
+}
+
+func Test_cluster_snapshots(t *testing.T) {
+	interval := raftSnapshotInterval
+	raftSnapshotInterval = 50 * time.Millisecond
+	t.Cleanup(func() { raftSnapshotInterval = interval })
+
+	tc := startTestCluster(t, 3, "-raft-snapshot-threshold", "4", "-raft-trailing-logs", "1")
+
+	leader := tc.leader()
+	lagging := tc.follower(leader)
+
+	// a member that lost its data must be brought back with a snapshot, as
+	// the log it misses is truncated
+	tc.kill(lagging)
+	if err := os.RemoveAll(tc.dirs[lagging]); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		tc.set(leader, fmt.Sprintf("key%d", i), fmt.Sprint(i))
+	}
+
+	eventually(t, "a snapshot", func() bool {
+		snapshots, _ := os.ReadDir(filepath.Join(tc.dirs[leader], raftDirName, "snapshots"))
+		return len(snapshots) > 0
+	})
+
+	tc.start(lagging)
+
+	for i := 0; i < 20; i++ {
+		tc.waitForValue(fmt.Sprintf("key%d", i), fmt.Sprint(i))
+	}
+}
+
+func Test_cluster_restart(t *testing.T) {
+	tc := startTestCluster(t, 1)
+
+	leader := tc.leader()
+	for i := 0; i < 5; i++ {
+		tc.set(leader, fmt.Sprintf("key%d", i), fmt.Sprint(i))
+	}
+
+	records := func() int {
+		f, err := os.Open(filepath.Join(tc.dirs[0], databaseFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		all, err := newCSVReader(f).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(all)
+	}
+
+	// without a snapshot, Raft applies the whole log again on restart,
+	// which must not append the entries that the file already has
+	tc.kill(0)
+	before := records()
+
+	tc.start(0)
+	tc.set(tc.leader(), "after", "restart")
+
+	if got := records(); got != before+1 {
+		t.Errorf("database file has %d records after the restart and a write, want %d", got, before+1)
+	}
+
+	for i := 0; i < 5; i++ {
+		tc.waitForValue(fmt.Sprintf("key%d", i), fmt.Sprint(i))
+	}
+}
+
+func Test_cluster_mutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	memberCert, memberKey := ca.issue(t, "member", 2)
+	writeFiles(t, dir, map[string][]byte{"ca.pem": ca.pem, "member.pem": memberCert, "member.key": memberKey})
+
+	cert, err := tls.X509KeyPair(memberCert, memberKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tc := startTLSTestCluster(t, 3, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{cert}},
+		"-tls-cert", filepath.Join(dir, "member.pem"),
+		"-tls-key", filepath.Join(dir, "member.key"),
+		"-tls-client-ca", filepath.Join(dir, "ca.pem"),
+	)
+
+	leader := tc.leader()
+	tc.set(tc.follower(leader), "key", "1")
+	tc.waitForValue("key", "1")
+
+	// the Raft port serves TLS, and refuses connections without a
+	// certificate of the CA
+	conn, err := tls.Dial("tcp", tc.peers[leader].raftAddr, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("error = %v connecting to Raft with a certificate, did not want error", err)
+	}
+	conn.Close()
+
+	conn, err = tls.Dial("tcp", tc.peers[leader].raftAddr, &tls.Config{RootCAs: roots})
+	if err == nil {
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+	}
+
+	// the server refuses the handshake rather than waiting for a command
+	if err == nil || os.IsTimeout(err) {
+		t.Errorf("error = %v connecting to Raft without a certificate, want the handshake refused", err)
+	}
+}
+
+func Test_parseClusterPeers(t *testing.T) {
+	peers, err := parseClusterPeers("n1=localhost:7001=localhost:50051, n2=localhost:7002=localhost:50052")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []clusterPeer{
+		{id: "n1", raftAddr: "localhost:7001", grpcAddr: "localhost:50051"},
+		{id: "n2", raftAddr: "localhost:7002", grpcAddr: "localhost:50052"},
+	}
+	if fmt.Sprint(peers) != fmt.Sprint(want) {
+		t.Errorf("got = %v, want = %v", peers, want)
+	}
+
+	for _, s := range []string{"n1=localhost:7001", "n1=localhost:7001=localhost:50051,n1=localhost:7002=localhost:50052"} {
+		if _, err := parseClusterPeers(s); err == nil {
+			t.Errorf("%q: wanted error", s)
+		}
+	}
+}
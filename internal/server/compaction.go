@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+)
+
+const compactionSuffix = ".compact"
+
+type compactionResult struct {
+	recordsBefore uint64
+	recordsAfter  uint64
+	bytesBefore   int64
+	bytesAfter    int64
+}
+
+// compact rewrites the database file with only the latest record of every
+// key that still exists, which reclaims the space taken by overwritten,
+// deleted and expired records. In multi-primary mode, the latest records of
+// deleted and expired keys are kept for the tombstone retention. The records
+// are re-encoded with the current settings, so compaction also compresses
+// and encrypts records written before those were enabled, and re-encrypts
+// records with the active key after a key rotation.
+//
+// The database is locked for the whole compaction.
+func (d *database) compact() (compactionResult, ErrorCode) {
+	d.ensureInitialized()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var result compactionResult
+
+	if d.closed {
+		return result, DatabaseClosed
+	}
+
+	if d.readOnly {
+		return result, ReadOnly
+	}
+
+	if d.shard != nil && d.shard.rebalancing() {
+		return result, Rebalancing
+	}
+
+	f, code := d.openForReading()
+	if code != OK {
+		return result, code
+	}
+	defer f.Close()
+
+	tmpPath := d.filepath + compactionSuffix
+
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		log.Printf("Failed to create the compacted database file: %v", err)
+		return result, InternalError
+	}
+	defer os.Remove(tmpPath)
+	defer tmp.Close()
+
+	csvReader := newCSVReader(f)
+	csvWriter := csv.NewWriter(tmp)
+	now := time.Now()
+
+	var keys int64
+
+	for {
+		pos := csvReader.InputOffset()
+		record, err := csvReader.Read()
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Printf("Error while reading file: %v", err)
+			return result, InternalError
+		}
+
+		result.recordsBefore++
+
+		key, value, header, code := decodeRecord(record, d.keys, d.maxValueSize)
+		if code != OK {
+			return result, code
+		}
+
+		_, latestPosition, code := d.getKeyPosition(key)
+		if code != OK {
+			return result, code
+		}
+
+		e := newEntry(value, header)
+		gone := header.deleted || e.expired(now)
+
+		if latestPosition != pos || gone && !d.retainsTombstone(header, now) {
+			continue
+		}
+
+		if !gone {
+			keys++
+		}
+
+		c := change{key: key, entry: e, deleted: header.deleted, stamp: stamp{time: header.timestamp, origin: header.origin}}
+		fields, code := d.encodeRecord(c)
+		if code != OK {
+			return result, code
+		}
+
+		if err := csvWriter.Write(fields); err != nil {
+			log.Printf("Error while writing to file: %v", err)
+			return result, InternalError
+		}
+
+		result.recordsAfter++
+	}
+
+	result.bytesBefore = csvReader.InputOffset()
+
+	csvWriter.Flush()
+
+	if err := csvWriter.Error(); err != nil {
+		log.Printf("Error after flushing: %v", err)
+		return result, InternalError
+	}
+
+	if err := d.sync(tmp); err != nil {
+		log.Printf("Failed to sync the compacted database file: %v", err)
+		return result, InternalError
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		log.Printf("Failed to stat the compacted database file: %v", err)
+		return result, InternalError
+	}
+	result.bytesAfter = info.Size()
+
+	// Replicas must start over from the new file. The epoch changes first,
+	// so that a crash in between only makes them start over needlessly.
+	if d.epoch != "" {
+		if code := d.writeEpoch(newEpoch()); code != OK {
+			return result, code
+		}
+	}
+
+	if err := os.Rename(tmpPath, d.filepath); err != nil {
+		log.Printf("Failed to replace the database file: %v", err)
+		return result, InternalError
+	}
+
+	d.compactions.Add(1)
+	d.notifyAppended()
+
+	if code := d.rebuildIndex(); code != OK {
+		return result, code
+	}
+
+	// only the latest record of every key is left, along with the retained
+	// tombstones
+	if d.storage != nil {
+		d.storage.keys.Store(keys)
+		d.storage.staleBytes.Store(0)
+	}
+
+	return result, OK
+}
+
+// rebuildIndex indexes the database file from scratch, after it was
+// replaced. The caller must hold the write lock.
+func (d *database) rebuildIndex() ErrorCode {
+	if code := d.index.close(0, 0); code != OK {
+		return code
+	}
+
+	if err := os.Remove(d.filepath + diskIndexSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to remove the index file: %v", err)
+		return InternalError
+	}
+
+	return d.initializeKeyPositions()
+}
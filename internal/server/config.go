@@ -0,0 +1,479 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/arpitchauhan/simple-database/internal/tracing"
+)
+
+// Every setting of the server is a command-line flag. A setting can also be
+// given in the JSON config file, keyed by the flag name, or in an
+// environment variable named after the flag, e.g. SIMPLE_DATABASE_DATA_DIR
+// for -data-dir. Flags take precedence over environment variables, which
+// take precedence over the config file.
+const envPrefix = "SIMPLE_DATABASE_"
+
+const (
+	durabilityNone  = "none"
+	durabilityFsync = "fsync"
+
+	logLevelDebug = "debug"
+	logLevelInfo  = "info"
+	logLevelError = "error"
+
+	databaseFileName = "database.csv"
+)
+
+// Config holds the settings of the server.
+type Config struct {
+	configFile string
+
+	addr    string
+	dataDir string
+	// respAddr, httpAddr and memcachedAddr are the addresses of the Redis
+	// protocol, HTTP and memcached listeners, which are disabled if they
+	// are empty.
+	respAddr      string
+	httpAddr      string
+	memcachedAddr string
+	// durability is durabilityNone to leave writes in the OS page cache, or
+	// durabilityFsync to flush every write to disk before acknowledging it.
+	durability string
+	logLevel   string
+	// shutdownTimeout is how long a shutdown waits for requests in flight.
+	shutdownTimeout time.Duration
+
+	maxKeySize   int
+	maxValueSize int
+
+	indexMode            string
+	indexMemoryLimit     int64
+	cacheSize            int64
+	compressionThreshold int
+	encryptionKeyFile    string
+
+	// tlsCert and tlsKey enable TLS on every listener. With tlsClientCA,
+	// clients must also present a certificate signed by one of its CAs.
+	tlsCert     string
+	tlsKey      string
+	tlsClientCA string
+
+	// traceExporter is where spans are exported: tracing.ExporterStdout,
+	// tracing.ExporterOTLP, or nowhere if empty.
+	traceExporter string
+
+	// metricsAddr is where Prometheus metrics are served, at /metrics.
+	metricsAddr string
+
+	// authPolicyFile enables authentication with the tokens and permissions
+	// of the policy in this file.
+	authPolicyFile string
+
+	// replicaOf is the address of the primary that the server follows as a
+	// read-only replica, or empty if the server is a primary. The replica
+	// authenticates with replicationToken, and verifies the certificate of
+	// the primary against replicationTLSCA if it is set.
+	replicaOf        string
+	replicationToken string
+	replicationTLSCA string
+
+	// raftID makes the server the member of a Raft cluster with this ID.
+	// raftPeers lists every member, as parsed into clusterPeers.
+	raftID                string
+	raftPeers             string
+	clusterPeers          []clusterPeer
+	raftSnapshotThreshold uint64
+	raftTrailingLogs      uint64
+
+	// shardID makes the server the shard with this ID of a sharded
+	// deployment, with the shard map in shardMap until it is given a later
+	// one. It authenticates to the other shards with shardToken.
+	shardID    string
+	shardMap   string
+	shardToken string
+
+	// nodeID enables multi-primary mode, in which the server accepts writes
+	// and merges the writes of peers, as parsed from the comma-separated
+	// gRPC addresses of peers into multiPrimaryPeers. They authenticate
+	// with replicationToken and replicationTLSCA, as replicas do.
+	nodeID             string
+	peers              string
+	multiPrimaryPeers  []string
+	tombstoneRetention time.Duration
+}
+
+func (c *Config) databasePath() string {
+	return filepath.Join(c.dataDir, databaseFileName)
+}
+
+// NewFlagSet returns the flags of the server, which fill in c when they are
+// parsed. Load then completes c.
+func NewFlagSet(c *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+
+	fs.StringVar(&c.configFile, "config", "", "JSON file with settings, keyed by flag name")
+
+	fs.StringVar(&c.addr, "addr", "localhost:50051", "address to listen on")
+	fs.StringVar(&c.dataDir, "data-dir", ".", "directory of the database files")
+	fs.StringVar(
+		&c.respAddr,
+		"resp-addr",
+		"",
+		"address to serve the Redis protocol on, e.g. localhost:6379; disabled if empty",
+	)
+	fs.StringVar(
+		&c.httpAddr,
+		"http-addr",
+		"",
+		"address to serve the HTTP/JSON API on, e.g. localhost:8080; disabled if empty",
+	)
+	fs.StringVar(
+		&c.memcachedAddr,
+		"memcached-addr",
+		"",
+		"address to serve the memcached text protocol on, e.g. localhost:11211; disabled if empty",
+	)
+	fs.StringVar(
+		&c.durability,
+		"durability",
+		durabilityNone,
+		"none to acknowledge writes once they reach the OS, fsync to wait until they are on disk",
+	)
+	fs.StringVar(&c.logLevel, "log-level", logLevelInfo, "debug, info or error")
+	fs.DurationVar(
+		&c.shutdownTimeout,
+		"shutdown-timeout",
+		10*time.Second,
+		"how long to wait for requests in flight when shutting down",
+	)
+
+	fs.IntVar(&c.maxKeySize, "max-key-size", 64<<10, "largest key accepted, in bytes")
+	fs.IntVar(&c.maxValueSize, "max-value-size", 4<<20, "largest value accepted, in bytes")
+
+	fs.StringVar(&c.indexMode, "index", indexModeMemory, "how to index keys: memory or disk")
+	fs.Int64Var(
+		&c.indexMemoryLimit,
+		"index-memory-limit",
+		64<<20,
+		"memory in bytes that the disk index may use for caching",
+	)
+	fs.Int64Var(&c.cacheSize, "cache-size", 32<<20, "size in bytes of the cache of hot values, 0 to disable")
+	fs.IntVar(
+		&c.compressionThreshold,
+		"compression-threshold",
+		0,
+		"size in bytes from which values are compressed, 0 to disable",
+	)
+	fs.StringVar(
+		&c.encryptionKeyFile,
+		"encryption-key-file",
+		"",
+		"file with the keys to encrypt records with; defaults to the "+encryptionKeysEnv+" environment variable",
+	)
+
+	fs.StringVar(&c.tlsCert, "tls-cert", "", "PEM certificate to serve TLS with; TLS is disabled if empty")
+	fs.StringVar(&c.tlsKey, "tls-key", "", "PEM private key of the TLS certificate")
+	fs.StringVar(
+		&c.tlsClientCA,
+		"tls-client-ca",
+		"",
+		"PEM bundle of the CAs that client certificates must be signed by, to require mutual TLS",
+	)
+
+	fs.StringVar(
+		&c.traceExporter,
+		"trace-exporter",
+		tracing.ExporterNone,
+		"export OpenTelemetry traces to stdout, or otlp to the collector of OTEL_EXPORTER_OTLP_ENDPOINT; disabled if empty",
+	)
+
+	fs.StringVar(
+		&c.metricsAddr,
+		"metrics-addr",
+		"",
+		"address to serve Prometheus metrics on, at /metrics; disabled if empty",
+	)
+
+	fs.StringVar(
+		&c.authPolicyFile,
+		"auth-policy-file",
+		"",
+		"JSON file with the tokens of principals and their permissions on key prefixes; "+
+			"anyone may read and write every key if empty",
+	)
+
+	fs.StringVar(
+		&c.replicaOf,
+		"replica-of",
+		"",
+		"gRPC address of a primary to follow as a read-only replica; the server is a primary if empty",
+	)
+	fs.StringVar(
+		&c.replicationToken,
+		"replication-token",
+		"",
+		"bearer token that the replica authenticates to its primary with, which needs read access to every key",
+	)
+	fs.StringVar(
+		&c.replicationTLSCA,
+		"replication-tls-ca",
+		"",
+		"PEM bundle of the CAs to verify the certificate of the primary with; connects without TLS if empty",
+	)
+
+	fs.StringVar(&c.raftID, "raft-id", "", "ID of the server in its Raft cluster; cluster mode is disabled if empty")
+	fs.StringVar(
+		&c.raftPeers,
+		"raft-peers",
+		"",
+		"every member of the Raft cluster, including this one, as comma-separated id=raft-address=grpc-address",
+	)
+	fs.Uint64Var(
+		&c.raftSnapshotThreshold,
+		"raft-snapshot-threshold",
+		8192,
+		"Raft log entries after which a snapshot is taken",
+	)
+	fs.Uint64Var(&c.raftTrailingLogs, "raft-trailing-logs", 10240, "Raft log entries kept after a snapshot")
+
+	fs.StringVar(&c.shardID, "shard-id", "", "ID of the server in its sharded deployment; sharding is disabled if empty")
+	fs.StringVar(
+		&c.shardMap,
+		"shard-map",
+		"",
+		"JSON file with the shard map to start with, unless the shard was given a later one",
+	)
+	fs.StringVar(
+		&c.shardToken,
+		"shard-token",
+		"",
+		"bearer token that the shard authenticates to the others with, which needs read and write access to every key",
+	)
+
+	fs.StringVar(&c.nodeID, "node-id", "", "ID of the server in multi-primary mode; multi-primary mode is disabled if empty")
+	fs.StringVar(&c.peers, "peers", "", "comma-separated gRPC addresses of the other primaries in multi-primary mode")
+	fs.DurationVar(
+		&c.tombstoneRetention,
+		"tombstone-retention",
+		7*24*time.Hour,
+		"how long compaction keeps the records of deleted keys in multi-primary mode; "+
+			"peers that stay away for longer may bring deleted keys back",
+	)
+
+	return fs
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// ParseConfig builds the configuration from the command-line arguments,
+// environment variables and config file.
+func ParseConfig(args []string, output io.Writer) (*Config, error) {
+	c := &Config{}
+	fs := NewFlagSet(c)
+	fs.SetOutput(output)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	setOnCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { setOnCommandLine[f.Name] = true })
+
+	return c, c.Load(fs, setOnCommandLine)
+}
+
+// Load fills in the settings of fs that were not set on the command line
+// from the environment and the config file, and validates the result.
+func (c *Config) Load(fs *flag.FlagSet, setOnCommandLine map[string]bool) error {
+	if !setOnCommandLine["config"] {
+		if path, ok := os.LookupEnv(envName("config")); ok {
+			c.configFile = path
+		}
+	}
+
+	if c.configFile != "" {
+		settings, err := readConfigFile(c.configFile)
+		if err != nil {
+			return err
+		}
+
+		for name, value := range settings {
+			if name == "config" || fs.Lookup(name) == nil {
+				return fmt.Errorf("%s: unknown setting %q", c.configFile, name)
+			}
+
+			if setOnCommandLine[name] {
+				continue
+			}
+
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("%s: setting %q: %w", c.configFile, name, err)
+			}
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if err != nil || !ok || setOnCommandLine[f.Name] || f.Name == "config" {
+			return
+		}
+
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: %w", envName(f.Name), setErr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.validate()
+}
+
+// readConfigFile reads a JSON object of settings. Values may be strings,
+// numbers or booleans.
+func readConfigFile(path string) (map[string]string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+
+	var raw map[string]any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	settings := make(map[string]string)
+	for name, value := range raw {
+		switch v := value.(type) {
+		case string:
+			settings[name] = v
+		case json.Number, bool:
+			settings[name] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("%s: setting %q must be a string, number or boolean", path, name)
+		}
+	}
+
+	return settings, nil
+}
+
+func (c *Config) validate() error {
+	switch c.durability {
+	case durabilityNone, durabilityFsync:
+	default:
+		return fmt.Errorf("invalid durability %q, must be none or fsync", c.durability)
+	}
+
+	switch c.logLevel {
+	case logLevelDebug, logLevelInfo, logLevelError:
+	default:
+		return fmt.Errorf("invalid log level %q, must be debug, info or error", c.logLevel)
+	}
+
+	switch c.indexMode {
+	case indexModeMemory, indexModeDisk:
+	default:
+		return fmt.Errorf("invalid index %q, must be memory or disk", c.indexMode)
+	}
+
+	if c.maxKeySize <= 0 || c.maxValueSize <= 0 {
+		return fmt.Errorf("size limits must be positive")
+	}
+
+	switch c.traceExporter {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		return fmt.Errorf("invalid trace exporter %q, must be stdout or otlp", c.traceExporter)
+	}
+
+	if (c.tlsCert == "") != (c.tlsKey == "") {
+		return fmt.Errorf("tls-cert and tls-key must be given together")
+	}
+
+	if c.tlsClientCA != "" && c.tlsCert == "" {
+		return fmt.Errorf("tls-client-ca requires tls-cert and tls-key")
+	}
+
+	if c.authPolicyFile != "" && c.memcachedAddr != "" {
+		return fmt.Errorf("memcached-addr cannot be used with auth-policy-file, the memcached protocol has no authentication")
+	}
+
+	if c.replicaOf == "" && c.nodeID == "" && (c.replicationToken != "" || c.replicationTLSCA != "") {
+		return fmt.Errorf("replication-token and replication-tls-ca require replica-of or node-id")
+	}
+
+	if (c.raftID == "") != (c.raftPeers == "") {
+		return fmt.Errorf("raft-id and raft-peers must be given together")
+	}
+
+	if c.raftID != "" {
+		if c.replicaOf != "" {
+			return fmt.Errorf("replica-of cannot be used with raft-id, members of a cluster replicate through Raft")
+		}
+
+		// Raft carries no tokens, so members are only authenticated by
+		// their client certificates
+		if c.authPolicyFile != "" && c.tlsClientCA == "" {
+			return fmt.Errorf("raft-id with auth-policy-file requires tls-client-ca, members of a cluster authenticate each other with certificates")
+		}
+
+		peers, err := parseClusterPeers(c.raftPeers)
+		if err != nil {
+			return fmt.Errorf("invalid raft-peers: %w", err)
+		}
+		c.clusterPeers = peers
+	}
+
+	if c.shardID == "" && (c.shardMap != "" || c.shardToken != "") {
+		return fmt.Errorf("shard-map and shard-token require shard-id")
+	}
+
+	if c.shardID != "" && (c.replicaOf != "" || c.raftID != "") {
+		return fmt.Errorf("shard-id cannot be used with replica-of or raft-id")
+	}
+
+	if (c.nodeID == "") != (c.peers == "") {
+		return fmt.Errorf("node-id and peers must be given together")
+	}
+
+	if c.nodeID != "" {
+		if !nodeIDPattern.MatchString(c.nodeID) {
+			return fmt.Errorf("invalid node-id %q, must only hold letters, digits, - and _", c.nodeID)
+		}
+
+		if c.replicaOf != "" || c.raftID != "" || c.shardID != "" {
+			return fmt.Errorf("node-id cannot be used with replica-of, raft-id or shard-id")
+		}
+
+		for _, addr := range strings.Split(c.peers, ",") {
+			if addr = strings.TrimSpace(addr); addr == "" {
+				return fmt.Errorf("invalid peers: empty address")
+			}
+			c.multiPrimaryPeers = append(c.multiPrimaryPeers, addr)
+		}
+
+		if c.tombstoneRetention <= 0 {
+			return fmt.Errorf("tombstone-retention must be positive")
+		}
+	}
+
+	return nil
+}
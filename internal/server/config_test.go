@@ -0,0 +1,161 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_parseConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		configFile string
+		want       func(c *Config) bool
+		wantErr    bool
+	}{
+		{
+			name: "Defaults",
+			want: func(c *Config) bool {
+				return c.addr == "localhost:50051" &&
+					c.databasePath() == "database.csv" &&
+					c.durability == durabilityNone &&
+					c.indexMode == indexModeMemory
+			},
+		},
+		{
+			name: "Flags",
+			args: []string{"-addr", ":6000", "-data-dir", "/var/lib/db", "-durability", "fsync"},
+			want: func(c *Config) bool {
+				return c.addr == ":6000" &&
+					c.databasePath() == "/var/lib/db/database.csv" &&
+					c.durability == durabilityFsync
+			},
+		},
+		{
+			name:       "Config file",
+			configFile: `{"addr": ":7000", "cache-size": 67108864, "log-level": "debug"}`,
+			want: func(c *Config) bool {
+				return c.addr == ":7000" && c.cacheSize == 64<<20 && c.logLevel == logLevelDebug
+			},
+		},
+		{
+			name:       "Environment overrides config file",
+			configFile: `{"addr": ":7000", "data-dir": "/from/file"}`,
+			env:        map[string]string{"SIMPLE_DATABASE_ADDR": ":8000"},
+			want: func(c *Config) bool {
+				return c.addr == ":8000" && c.dataDir == "/from/file"
+			},
+		},
+		{
+			name:       "Flags override environment and config file",
+			args:       []string{"-addr", ":9000"},
+			configFile: `{"addr": ":7000"}`,
+			env:        map[string]string{"SIMPLE_DATABASE_ADDR": ":8000"},
+			want: func(c *Config) bool {
+				return c.addr == ":9000"
+			},
+		},
+		{
+			name:       "Unknown setting in config file",
+			configFile: `{"adress": ":7000"}`,
+			wantErr:    true,
+		},
+		{
+			name:    "Invalid value in environment",
+			env:     map[string]string{"SIMPLE_DATABASE_MAX_KEY_SIZE": "big"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid durability",
+			args:    []string{"-durability", "sometimes"},
+			wantErr: true,
+		},
+		{
+			name:    "memcached with an authorization policy",
+			args:    []string{"-memcached-addr", ":11211", "-auth-policy-file", "policy.json"},
+			wantErr: true,
+		},
+		{
+			name:    "Cluster with an authorization policy but no client CA",
+			args:    []string{"-raft-id", "n1", "-raft-peers", "n1=:7001=:50051", "-auth-policy-file", "policy.json"},
+			wantErr: true,
+		},
+		{
+			name: "Cluster with an authorization policy and a client CA",
+			args: []string{
+				"-raft-id", "n1", "-raft-peers", "n1=:7001=:50051", "-auth-policy-file", "policy.json",
+				"-tls-cert", "server.pem", "-tls-key", "server.key", "-tls-client-ca", "ca.pem",
+			},
+			want: func(c *Config) bool {
+				return c.raftID == "n1" && c.tlsClientCA == "ca.pem"
+			},
+		},
+		{
+			name: "Multi-primary mode",
+			args: []string{"-node-id", "site-a", "-peers", "db2:50051, db3:50051"},
+			want: func(c *Config) bool {
+				return c.nodeID == "site-a" &&
+					slices.Equal(c.multiPrimaryPeers, []string{"db2:50051", "db3:50051"}) &&
+					c.tombstoneRetention == 7*24*time.Hour
+			},
+		},
+		{
+			name:    "Multi-primary mode without peers",
+			args:    []string{"-node-id", "site-a"},
+			wantErr: true,
+		},
+		{
+			name:    "Multi-primary replica",
+			args:    []string{"-node-id", "site-a", "-peers", "db2:50051", "-replica-of", "db3:50051"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid node ID",
+			args:    []string{"-node-id", "site;a", "-peers", "db2:50051"},
+			wantErr: true,
+		},
+		{
+			name:    "Unexpected argument",
+			args:    []string{"serve"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			args := tt.args
+			if tt.configFile != "" {
+				path := filepath.Join(t.TempDir(), "config.json")
+				if err := os.WriteFile(path, []byte(tt.configFile), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				args = append([]string{"-config", path}, args...)
+			}
+
+			c, err := ParseConfig(args, io.Discard)
+
+			if err != nil {
+				if !tt.wantErr {
+					t.Errorf("error = %v, did not want error", err)
+				}
+				return
+			}
+
+			if tt.wantErr {
+				t.Fatalf("wanted error")
+			}
+
+			if !tt.want(c) {
+				t.Errorf("unexpected config: %+v", c)
+			}
+		})
+	}
+}
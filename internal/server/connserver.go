@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// connServer serves a protocol over TCP with a goroutine per connection. It
+// implements frontEnd for the protocols that have no server library.
+type connServer struct {
+	lis net.Listener
+	// handle serves a connection until it is closed, or until a read fails,
+	// which is how gracefulStop tells it to return.
+	handle func(conn net.Conn)
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	stopping bool
+	wg       sync.WaitGroup
+}
+
+var errLineTooLong = errors.New("line too long")
+
+func newConnServer(lis net.Listener, handle func(conn net.Conn)) connServer {
+	return connServer{lis: lis, handle: handle, conns: make(map[net.Conn]struct{})}
+}
+
+func (cs *connServer) serve() error {
+	for {
+		conn, err := cs.lis.Accept()
+		if err != nil {
+			cs.mu.Lock()
+			stopping := cs.stopping
+			cs.mu.Unlock()
+
+			if stopping {
+				return nil
+			}
+			return err
+		}
+
+		cs.mu.Lock()
+		if cs.stopping {
+			cs.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		cs.conns[conn] = struct{}{}
+		cs.wg.Add(1)
+		cs.mu.Unlock()
+
+		go func() {
+			defer func() {
+				conn.Close()
+
+				cs.mu.Lock()
+				delete(cs.conns, conn)
+				cs.mu.Unlock()
+
+				cs.wg.Done()
+			}()
+
+			cs.handle(conn)
+		}()
+	}
+}
+
+// gracefulStop stops accepting connections and closes the open ones once
+// the requests they are running are done.
+func (cs *connServer) gracefulStop() {
+	cs.mu.Lock()
+	cs.stopping = true
+	cs.lis.Close()
+	for conn := range cs.conns {
+		// wakes up the connections waiting for a request; the others stop
+		// after replying to the one they are running
+		conn.SetReadDeadline(time.Now())
+	}
+	cs.mu.Unlock()
+
+	cs.wg.Wait()
+}
+
+// stop closes every connection right away.
+func (cs *connServer) stop() {
+	cs.mu.Lock()
+	cs.stopping = true
+	cs.lis.Close()
+	for conn := range cs.conns {
+		conn.Close()
+	}
+	cs.mu.Unlock()
+}
+
+func (cs *connServer) connectedClients() int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return len(cs.conns)
+}
+
+// readLine reads a line terminated by CRLF or LF, without its terminator.
+// It fails with errLineTooLong if the line is longer than maxSize.
+func readLine(r *bufio.Reader, maxSize int) (string, error) {
+	var line []byte
+
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+
+		if len(line) > maxSize {
+			return "", errLineTooLong
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		line = line[:len(line)-1]
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+
+		return string(line), nil
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// encryptionKeysEnv holds encryption keys when no key file is given, in the
+// same format as the key file.
+const encryptionKeysEnv = "SIMPLE_DATABASE_ENCRYPTION_KEYS"
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// keyring holds the AES-256 keys that records may be encrypted with. New
+// records are encrypted with the active key; the other keys only remain to
+// read older records until a compaction has re-encrypted them.
+type keyring struct {
+	aeads    map[string]cipher.AEAD
+	activeID string
+}
+
+// loadKeyring reads the keys from the key file at path or, if path is
+// empty, from the environment. It returns nil if no keys are configured.
+func loadKeyring(path string) (*keyring, error) {
+	if path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return parseKeyring(string(contents))
+	}
+
+	if keys := os.Getenv(encryptionKeysEnv); keys != "" {
+		return parseKeyring(keys)
+	}
+
+	return nil, nil
+}
+
+// parseKeyring parses keys written as id=base64-encoded-key, separated by
+// newlines or commas. The last key is the active one. Blank lines and lines
+// starting with # are ignored.
+func parseKeyring(s string) (*keyring, error) {
+	k := &keyring{aeads: make(map[string]cipher.AEAD)}
+
+	entries := strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ',' })
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		id, encodedKey, ok := strings.Cut(entry, "=")
+		if !ok || !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("malformed key entry for key %q", id)
+		}
+
+		if _, ok := k.aeads[id]; ok {
+			return nil, fmt.Errorf("duplicate key %q", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %q: must be 32 bytes, is %d", id, len(key))
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		k.aeads[id] = aead
+		k.activeID = id
+	}
+
+	if k.activeID == "" {
+		return nil, errors.New("no keys found")
+	}
+
+	return k, nil
+}
+
+// seal encrypts plaintext with the key with the given id. The output starts
+// with the random nonce that was used.
+func (k *keyring) seal(id string, plaintext, additionalData []byte) ([]byte, error) {
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open authenticates and decrypts the output of seal.
+func (k *keyring) open(id string, sealed, additionalData []byte) ([]byte, error) {
+	if k == nil {
+		return nil, errors.New("record is encrypted but no keys are configured")
+	}
+
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+var (
+	testKey1 = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	testKey2 = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+)
+
+func Test_parseKeyring(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		wantActiveID string
+		wantErr      bool
+	}{
+		{
+			name:         "One key",
+			input:        "key1=" + testKey1,
+			wantActiveID: "key1",
+		},
+		{
+			name:         "Last key is active",
+			input:        "# rotated on 2026-10-01\nkey1=" + testKey1 + "\n\nkey2=" + testKey2 + "\n",
+			wantActiveID: "key2",
+		},
+		{
+			name:         "Comma-separated keys",
+			input:        "key1=" + testKey1 + ",key2=" + testKey2,
+			wantActiveID: "key2",
+		},
+		{
+			name:    "No keys",
+			input:   "# nothing here\n",
+			wantErr: true,
+		},
+		{
+			name:    "Key of the wrong size",
+			input:   "key1=" + base64.StdEncoding.EncodeToString([]byte("short")),
+			wantErr: true,
+		},
+		{
+			name:    "Invalid key id",
+			input:   "key;1=" + testKey1,
+			wantErr: true,
+		},
+		{
+			name:    "Duplicate key id",
+			input:   "key1=" + testKey1 + "\nkey1=" + testKey2,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := parseKeyring(tt.input)
+
+			if err != nil {
+				if !tt.wantErr {
+					t.Errorf("error = %v, did not want error", err)
+				}
+				return
+			}
+
+			if tt.wantErr {
+				t.Fatalf("wanted error")
+			}
+
+			if k.activeID != tt.wantActiveID {
+				t.Errorf("active key = %v, want = %v", k.activeID, tt.wantActiveID)
+			}
+		})
+	}
+}
+
+func Test_server_encryption(t *testing.T) {
+	t.Cleanup(deleteDatabase)
+	createDatabase([][]string{{"plain", "value"}})
+
+	s := getEncryptedServer(t, "key1="+testKey1)
+	set(t, s, "secret", "value")
+
+	dbContents, err := os.ReadFile(testDatabasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Count(string(dbContents), "secret") != 0 {
+		t.Errorf("key was stored in plaintext: %s", dbContents)
+	}
+
+	// a fresh server reads both the plaintext and the encrypted record
+	s = getEncryptedServer(t, "key1="+testKey1)
+	assertValue(t, s, "plain", "value")
+	assertValue(t, s, "secret", "value")
+
+	// tampering with the value fails authentication
+	lines := strings.Split(string(dbContents), "\n")
+	fields := strings.Split(lines[1], ",")
+	sealedValue, _ := base64.StdEncoding.DecodeString(fields[1])
+	sealedValue[len(sealedValue)-1] ^= 1
+	fields[1] = base64.StdEncoding.EncodeToString(sealedValue)
+	lines[1] = strings.Join(fields, ",")
+
+	if err := os.WriteFile(testDatabasePath, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Get(context.Background(), &pb.GetRequest{Key: "secret"})
+	if err == nil {
+		t.Errorf("tampered record was decrypted")
+	}
+}
+
+func Test_server_keyRotation(t *testing.T) {
+	t.Cleanup(deleteDatabase)
+	createDatabase([][]string{{"plain", "value"}})
+
+	s := getEncryptedServer(t, "key1="+testKey1)
+	set(t, s, "key", "old")
+	set(t, s, "key", "new")
+
+	// rotate to key2, keeping key1 to read the existing records
+	s = getEncryptedServer(t, "key1="+testKey1+"\nkey2="+testKey2)
+	set(t, s, "other", "value")
+	assertValue(t, s, "key", "new")
+
+	reply, err := s.Compact(context.Background(), &pb.CompactRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.RecordsBefore != 4 || reply.RecordsAfter != 3 {
+		t.Errorf("records = %d and %d, want = 4 and 3", reply.RecordsBefore, reply.RecordsAfter)
+	}
+
+	if reply.BytesAfter >= reply.BytesBefore {
+		t.Errorf("bytes = %d and %d, want fewer after compaction", reply.BytesBefore, reply.BytesAfter)
+	}
+
+	assertValue(t, s, "key", "new")
+
+	// key1 is no longer needed once everything is re-encrypted
+	s = getEncryptedServer(t, "key2="+testKey2)
+	assertValue(t, s, "plain", "value")
+	assertValue(t, s, "key", "new")
+	assertValue(t, s, "other", "value")
+}
+
+func getEncryptedServer(t *testing.T, keys string) *server {
+	t.Helper()
+
+	k, err := parseKeyring(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &database{filepath: testDatabasePath, initialized: false, keys: k}
+	s := &server{db: db}
+	if code := s.initialize(); code != OK {
+		t.Fatalf("initializing failed with code %d", code)
+	}
+
+	return s
+}
+
+func set(t *testing.T, s *server, key, value string) {
+	t.Helper()
+
+	if _, err := s.Set(context.Background(), &pb.SetRequest{Key: key, Value: value}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertValue(t *testing.T, s *server, key, want string) {
+	t.Helper()
+
+	reply, err := s.Get(context.Background(), &pb.GetRequest{Key: key})
+	if err != nil {
+		t.Fatalf("key %v: %v", key, err)
+	}
+
+	if reply.Value != want {
+		t.Errorf("key %v: got = %v, want = %v", key, reply.Value, want)
+	}
+}
@@ -0,0 +1,855 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type ErrorCode uint32
+
+const (
+	OK            ErrorCode = 0
+	KeyNotFound   ErrorCode = 1
+	InternalError ErrorCode = 2
+	// DatabaseClosed is returned for requests made after the database was
+	// closed.
+	DatabaseClosed ErrorCode = 3
+	// ConditionFailed is returned by updates whose condition on the current
+	// entry of a key does not hold.
+	ConditionFailed ErrorCode = 4
+	// ReadOnly is returned for writes to a replica.
+	ReadOnly ErrorCode = 5
+	// NotLeader is returned for writes to a member of a cluster that is
+	// not its leader.
+	NotLeader ErrorCode = 6
+	// WrongShard is returned for requests to a shard for keys that the
+	// shard map places on another.
+	WrongShard ErrorCode = 7
+	// Rebalancing is returned for compactions of a shard while keys are
+	// moved between shards, which would drop the records of deleted keys
+	// that keep moved entries from bringing them back.
+	Rebalancing ErrorCode = 8
+)
+
+type database struct {
+	filepath    string
+	initialized bool
+	closed      bool
+	index       keyIndex
+	mu          sync.RWMutex
+	// syncWrites makes every write wait until it is flushed to disk.
+	syncWrites bool
+
+	// indexMode selects how key positions are kept: indexModeMemory (the
+	// default) or indexModeDisk.
+	indexMode string
+	// indexMemoryLimit caps the memory used by the disk index, in bytes.
+	indexMemoryLimit int64
+
+	// cacheSize is the size in bytes of the cache of recently read values.
+	// The cache is disabled if it is 0.
+	cacheSize int64
+	cache     *valueCache
+
+	// watchers are the keys that clients cache and watch for changes.
+	watchers *keyWatchers
+
+	// compressionThreshold is the size in bytes from which values are
+	// compressed. Compression is disabled if it is 0.
+	compressionThreshold int
+	compression          compressionStats
+	// maxValueSize caps how large a compressed value may inflate to, so
+	// that a corrupt record cannot take all the memory. There is no cap if
+	// it is 0.
+	maxValueSize int
+
+	// keys encrypt new records with their active key and decrypt existing
+	// ones. Records are stored in plaintext if it is nil.
+	keys *keyring
+
+	// compactions counts the compactions since the server started.
+	compactions atomic.Uint64
+
+	// storage counts keys and stale bytes, if it is not nil.
+	storage *storageStats
+	// observeFsync is called with the duration of every fsync, if it is
+	// not nil.
+	observeFsync func(time.Duration)
+
+	// readOnly rejects writes with ReadOnly; replicas only change through
+	// appendReplicated.
+	readOnly bool
+	// epoch identifies the contents of the database file for replication.
+	// It changes whenever the file is rewritten rather than appended to,
+	// and is empty until the file is first replicated.
+	epoch string
+	// appended is closed and replaced whenever the database file changes.
+	appended chan struct{}
+
+	// cluster replicates writes through Raft, if the database is a member
+	// of a cluster.
+	cluster *cluster
+	// shard restricts the database to the keys that the shard map places
+	// on it, if it is a shard.
+	shard *shard
+
+	// merkle is the Merkle tree of the keys built last, for anti-entropy.
+	merkleMu sync.Mutex
+	merkle   *merkleTree
+
+	// clock stamps every write with a timestamp and nodeID in multi-primary
+	// mode, which resolves concurrent writes to a key on different nodes.
+	// It is nil otherwise.
+	clock  *hlc
+	nodeID string
+	// tombstoneRetention is how long compaction keeps the records of
+	// deleted and expired keys in multi-primary mode, so that the older
+	// writes of peers that have not seen them yet do not bring the keys
+	// back.
+	tombstoneRetention time.Duration
+}
+
+// Scan cursors keep the position of a record in their low bits and the
+// number of compactions so far in the others.
+const (
+	scanPositionBits   = 40
+	scanPositionMask   = 1<<scanPositionBits - 1
+	scanGenerationMask = 1<<(64-scanPositionBits) - 1
+)
+
+// compressionStats counts the values compressed since the server started.
+type compressionStats struct {
+	values atomic.Uint64
+	// bytesIn and bytesOut are the sizes of the values before and after
+	// compression.
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+}
+
+func (d *database) openForReading() (*os.File, ErrorCode) {
+	f, err := os.OpenFile(d.filepath, os.O_RDONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		log.Printf("Failed to open the database file: %v", err)
+		return nil, InternalError
+	}
+	return f, OK
+}
+
+func (d *database) openForWriting() (*os.File, ErrorCode) {
+	f, err := os.OpenFile(d.filepath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		log.Printf("Failed to open the database file: %v", err)
+		return nil, InternalError
+	}
+	return f, OK
+}
+
+func (d *database) ensureInitialized() {
+	if !d.initialized {
+		log.Fatal("Database should have been initialized by now")
+	}
+}
+
+func (d *database) initialize() ErrorCode {
+	if d.initialized {
+		log.Fatal("Database was already initialized")
+	}
+
+	if code := d.loadEpoch(); code != OK {
+		return code
+	}
+	d.appended = make(chan struct{})
+
+	code := d.initializeKeyPositions()
+
+	if code != OK {
+		return code
+	}
+
+	if d.cacheSize > 0 {
+		d.cache = newValueCache(d.cacheSize)
+	}
+
+	if d.storage != nil {
+		if code := d.countStorage(); code != OK {
+			return code
+		}
+	}
+
+	d.initialized = true
+
+	return OK
+}
+
+func (d *database) initializeKeyPositions() ErrorCode {
+	index, code := d.newIndex()
+	if code != OK {
+		return code
+	}
+	d.index = index
+
+	indexedUpTo, timestamp, code := d.index.open()
+	if code != OK {
+		return code
+	}
+
+	// the clock must not stamp new writes earlier than the ones it
+	// already stamped, even if the wall clock went back
+	if d.clock != nil {
+		d.clock.observe(timestamp)
+	}
+
+	f, code := d.openForReading()
+	if code != OK {
+		return code
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(indexedUpTo, io.SeekStart); err != nil {
+		log.Printf("Failed to seek to end of indexed data: %v", err)
+		return InternalError
+	}
+
+	csvReader := newCSVReader(f)
+
+	for {
+		pos := indexedUpTo + csvReader.InputOffset()
+		record, err := csvReader.Read()
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Printf("Error while reading file: %v", err)
+			return InternalError
+		}
+
+		key, header, code := decodeRecordKey(record, d.keys)
+		if code != OK {
+			return code
+		}
+
+		if d.clock != nil {
+			d.clock.observe(header.timestamp)
+		}
+
+		if code := d.updateKeyPosition(key, pos); code != OK {
+			return code
+		}
+	}
+
+	return OK
+}
+
+func (d *database) newIndex() (keyIndex, ErrorCode) {
+	switch d.indexMode {
+	case "", indexModeMemory:
+		return newMemoryIndex(), OK
+	case indexModeDisk:
+		return newDiskIndex(d.filepath, d.indexMemoryLimit, d.keyAt), OK
+	default:
+		log.Printf("Unknown index mode: %q", d.indexMode)
+		return nil, InternalError
+	}
+}
+
+func (d *database) getKeyPosition(key string) (bool, int64, ErrorCode) {
+	return d.index.get(key)
+}
+
+func (d *database) updateKeyPosition(key string, pos int64) ErrorCode {
+	return d.index.put(key, pos)
+}
+
+// readRecordAt reads the record that starts at the given position of the
+// database file.
+func (d *database) readRecordAt(ctx context.Context, pos int64) ([]string, ErrorCode) {
+	_, span := tracer().Start(ctx, "open")
+	f, code := d.openForReading()
+	endSpan(span, code)
+	if code != OK {
+		return nil, code
+	}
+	defer f.Close()
+
+	_, span = tracer().Start(ctx, "seek")
+	_, err := f.Seek(pos, io.SeekStart)
+	span.End()
+	if err != nil {
+		log.Printf("Failed to seek position of key: %v", err)
+		return nil, InternalError
+	}
+
+	_, span = tracer().Start(ctx, "read")
+	defer span.End()
+
+	csvReader := newCSVReader(f)
+	record, err := csvReader.Read()
+	if err != nil {
+		log.Printf("Error while reading: %v", err)
+		endSpan(span, InternalError)
+		return nil, InternalError
+	}
+
+	return record, OK
+}
+
+// keyAt returns the key of the record that starts at the given position.
+func (d *database) keyAt(pos int64) (string, ErrorCode) {
+	record, code := d.readRecordAt(context.Background(), pos)
+	if code != OK {
+		return "", code
+	}
+
+	key, _, code := decodeRecordKey(record, d.keys)
+	return key, code
+}
+
+// entry is what the database keeps for a key.
+type entry struct {
+	value string
+	// expiresAt is the time from which the entry is gone, in Unix
+	// milliseconds. The entry never expires if it is 0.
+	expiresAt int64
+	// flags are opaque to the database; memcached clients keep the type of
+	// their values in them.
+	flags uint32
+}
+
+func newEntry(value string, h recordHeader) entry {
+	return entry{value: value, expiresAt: h.expiresAt, flags: h.flags}
+}
+
+func (e entry) expired(now time.Time) bool {
+	return e.expiresAt != 0 && now.UnixMilli() >= e.expiresAt
+}
+
+// change is a write to a key: either a new entry or, if deleted is true,
+// the deletion of the key. In multi-primary mode, it is stamped with when
+// and where it was made.
+type change struct {
+	key     string
+	entry   entry
+	deleted bool
+	stamp   stamp
+}
+
+func (d *database) getKey(key string) (string, ErrorCode) {
+	e, code := d.getEntry(context.Background(), key)
+	return e.value, code
+}
+
+func (d *database) getEntry(ctx context.Context, key string) (entry, ErrorCode) {
+	d.ensureInitialized()
+
+	if d.shard != nil {
+		if code := d.shard.pull(ctx, key); code != OK {
+			return entry{}, code
+		}
+	}
+
+	ctx, span := tracer().Start(ctx, "database.get")
+	defer span.End()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return entry{}, DatabaseClosed
+	}
+
+	if d.cache != nil {
+		if value, ok := d.cache.get(key); ok {
+			span.SetAttributes(attribute.Bool("cache_hit", true))
+			return entry{value: value}, OK
+		}
+	}
+
+	e, code := d.readEntry(ctx, key)
+	endSpan(span, code)
+	return e, code
+}
+
+// readEntry reads the entry of a key from the database file. Deleted and
+// expired keys are not found. The caller must hold the lock.
+func (d *database) readEntry(ctx context.Context, key string) (entry, ErrorCode) {
+	e, code := d.readStoredEntry(ctx, key)
+	if code != OK {
+		return entry{}, code
+	}
+
+	// The cache only keeps values, so entries that expire or have flags are
+	// left out of it.
+	if d.cache != nil && e == (entry{value: e.value}) {
+		d.cache.put(key, e.value)
+	}
+
+	return e, OK
+}
+
+// readStoredEntry is readEntry without the cache, for reads that would
+// only fill it with keys that are not read again.
+func (d *database) readStoredEntry(ctx context.Context, key string) (entry, ErrorCode) {
+	keyFound, keyPosition, code := d.getKeyPosition(key)
+	if code != OK {
+		return entry{}, code
+	}
+
+	if !keyFound {
+		return entry{}, KeyNotFound
+	}
+
+	record, code := d.readRecordAt(ctx, keyPosition)
+	if code != OK {
+		return entry{}, code
+	}
+
+	readKey, value, header, code := decodeRecord(record, d.keys, d.maxValueSize)
+	if code != OK {
+		return entry{}, code
+	}
+
+	if readKey != key {
+		log.Printf("Key at stored position is not correct")
+		return entry{}, InternalError
+	}
+
+	e := newEntry(value, header)
+
+	if header.deleted || e.expired(time.Now()) {
+		return entry{}, KeyNotFound
+	}
+
+	return e, OK
+}
+
+func (d *database) setKey(key string, value string) ErrorCode {
+	return d.setEntries(context.Background(), change{key: key, entry: entry{value: value}})
+}
+
+// setEntries writes several changes at once.
+func (d *database) setEntries(ctx context.Context, changes ...change) ErrorCode {
+	return d.write(ctx, func() ([]change, ErrorCode) {
+		return changes, OK
+	})
+}
+
+// deleteKeys deletes keys, and returns how many of them existed.
+func (d *database) deleteKeys(ctx context.Context, keys ...string) (int, ErrorCode) {
+	if d.shard != nil {
+		if code := d.shard.pull(ctx, keys...); code != OK {
+			return 0, code
+		}
+	}
+
+	var changes []change
+
+	code := d.write(ctx, func() ([]change, ErrorCode) {
+		changes = nil
+		deleted := make(map[string]bool)
+
+		for _, key := range keys {
+			if deleted[key] {
+				continue
+			}
+
+			_, code := d.readEntry(ctx, key)
+			if code == KeyNotFound {
+				continue
+			} else if code != OK {
+				return nil, code
+			}
+
+			deleted[key] = true
+			changes = append(changes, change{key: key, deleted: true})
+		}
+
+		return changes, OK
+	})
+	if code != OK {
+		return 0, code
+	}
+
+	return len(changes), OK
+}
+
+// updateKey atomically replaces the entry of a key with the one returned
+// by update, which is given the current entry and whether it was found.
+// If update returns a code other than OK, nothing is written and updateKey
+// returns that code.
+func (d *database) updateKey(ctx context.Context, key string, update func(current entry, found bool) (entry, ErrorCode)) ErrorCode {
+	if d.shard != nil {
+		if code := d.shard.pull(ctx, key); code != OK {
+			return code
+		}
+	}
+
+	return d.write(ctx, func() ([]change, ErrorCode) {
+		current, code := d.readEntry(ctx, key)
+		if code != OK && code != KeyNotFound {
+			return nil, code
+		}
+
+		e, code := update(current, code == OK)
+		if code != OK {
+			return nil, code
+		}
+
+		return []change{{key: key, entry: e}}, OK
+	})
+}
+
+// deleteKeyIf deletes a key if condition, which is given its current entry,
+// returns OK. It returns KeyNotFound if the key does not exist, and the code
+// returned by condition otherwise.
+func (d *database) deleteKeyIf(ctx context.Context, key string, condition func(current entry) ErrorCode) ErrorCode {
+	if d.shard != nil {
+		if code := d.shard.pull(ctx, key); code != OK {
+			return code
+		}
+	}
+
+	return d.write(ctx, func() ([]change, ErrorCode) {
+		current, code := d.readEntry(ctx, key)
+		if code != OK {
+			return nil, code
+		}
+
+		if code := condition(current); code != OK {
+			return nil, code
+		}
+
+		return []change{{key: key, deleted: true}}, OK
+	})
+}
+
+// write applies the changes returned by compute, unless it returns a code
+// other than OK, which write then returns. compute may read the database,
+// and nothing else is written between its reads and the changes. In a
+// cluster, the changes go through the Raft log. A shard only writes the
+// keys that it owns.
+func (d *database) write(ctx context.Context, compute func() ([]change, ErrorCode)) ErrorCode {
+	d.ensureInitialized()
+
+	if d.cluster != nil {
+		return d.cluster.propose(ctx, compute)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return DatabaseClosed
+	}
+
+	if d.readOnly {
+		return ReadOnly
+	}
+
+	changes, code := compute()
+	if code != OK || len(changes) == 0 {
+		return code
+	}
+
+	if d.shard != nil {
+		for _, c := range changes {
+			if !d.shard.owns(c.key) {
+				return WrongShard
+			}
+		}
+	}
+
+	if d.clock != nil {
+		changes = d.stamp(changes)
+	}
+
+	return d.apply(ctx, changes)
+}
+
+// apply appends a record for each change to the database file, and then
+// points the index at them. The caller must hold the write lock.
+func (d *database) apply(ctx context.Context, changes []change) ErrorCode {
+	ctx, span := tracer().Start(ctx, "database.apply", trace.WithAttributes(attribute.Int("changes", len(changes))))
+	defer span.End()
+
+	code := d.appendChanges(ctx, changes)
+	d.notifyAppended()
+	for _, c := range changes {
+		d.watchers.invalidate(c.key)
+	}
+	endSpan(span, code)
+	return code
+}
+
+func (d *database) appendChanges(ctx context.Context, changes []change) ErrorCode {
+	_, span := tracer().Start(ctx, "open")
+	f, code := d.openForWriting()
+	endSpan(span, code)
+	if code != OK {
+		return code
+	}
+	defer f.Close()
+
+	_, span = tracer().Start(ctx, "seek")
+	currentPosition, err := f.Seek(0, io.SeekEnd)
+	span.End()
+	if err != nil {
+		log.Printf("Error while getting current position in file: %v", err)
+		return InternalError
+	}
+
+	var b bytes.Buffer
+	csvWriter := csv.NewWriter(&b)
+	positions := make([]int64, len(changes))
+
+	_, span = tracer().Start(ctx, "encode")
+	for i, c := range changes {
+		if d.cache != nil {
+			d.cache.invalidate(c.key)
+		}
+
+		fields, code := d.encodeRecord(c)
+		if code != OK {
+			endSpan(span, code)
+			return code
+		}
+
+		positions[i] = currentPosition + int64(b.Len())
+
+		if err := csvWriter.Write(fields); err != nil {
+			log.Printf("Error while writing to file: %v", err)
+			endSpan(span, InternalError)
+			return InternalError
+		}
+
+		csvWriter.Flush()
+
+		if err := csvWriter.Error(); err != nil {
+			log.Printf("Error after flushing: %v", err)
+			endSpan(span, InternalError)
+			return InternalError
+		}
+	}
+	span.End()
+
+	_, span = tracer().Start(ctx, "write", trace.WithAttributes(attribute.Int("bytes", b.Len())))
+	_, err = f.Write(b.Bytes())
+	span.End()
+	if err != nil {
+		log.Printf("Error while writing to file: %v", err)
+		return InternalError
+	}
+
+	if d.syncWrites {
+		_, span = tracer().Start(ctx, "fsync")
+		err := d.sync(f)
+		span.End()
+		if err != nil {
+			log.Printf("Failed to sync the database file: %v", err)
+			return InternalError
+		}
+	}
+
+	end := currentPosition + int64(b.Len())
+
+	_, span = tracer().Start(ctx, "index")
+	defer span.End()
+
+	for i, c := range changes {
+		if d.storage != nil {
+			next := end
+			if i+1 < len(changes) {
+				next = positions[i+1]
+			}
+
+			if code := d.trackChange(c, next-positions[i]); code != OK {
+				return code
+			}
+		}
+
+		if code := d.updateKeyPosition(c.key, positions[i]); code != OK {
+			return code
+		}
+	}
+
+	return OK
+}
+
+// scanKeys returns the keys that exist among up to count records of the
+// database file, starting from cursor, and the cursor to continue from,
+// which is 0 once the whole file was scanned. A scan starts from cursor 0.
+//
+// A cursor is the position of a record in the file, tagged with the number
+// of compactions so far. Compaction moves records, so a scan that spans one
+// starts over; keys may then be returned more than once.
+func (d *database) scanKeys(cursor uint64, count int) ([]string, uint64, ErrorCode) {
+	d.ensureInitialized()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return nil, 0, DatabaseClosed
+	}
+
+	generation := d.compactions.Load() & scanGenerationMask
+	pos := int64(cursor & scanPositionMask)
+
+	if cursor>>scanPositionBits != generation {
+		pos = 0
+	}
+
+	f, code := d.openForReading()
+	if code != OK {
+		return nil, 0, code
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(pos, io.SeekStart); err != nil {
+		log.Printf("Failed to seek to the scan cursor: %v", err)
+		return nil, 0, InternalError
+	}
+
+	csvReader := newCSVReader(f)
+	now := time.Now()
+
+	var keys []string
+
+	for i := 0; i < count; i++ {
+		recordPosition := pos + csvReader.InputOffset()
+		record, err := csvReader.Read()
+
+		if err == io.EOF {
+			return keys, 0, OK
+		} else if err != nil {
+			log.Printf("Error while reading file: %v", err)
+			return nil, 0, InternalError
+		}
+
+		key, header, code := decodeRecordKey(record, d.keys)
+		if code != OK {
+			return nil, 0, code
+		}
+
+		_, latestPosition, code := d.getKeyPosition(key)
+		if code != OK {
+			return nil, 0, code
+		}
+
+		expired := entry{expiresAt: header.expiresAt}.expired(now)
+
+		if latestPosition == recordPosition && !header.deleted && !expired {
+			keys = append(keys, key)
+		}
+	}
+
+	next := pos + csvReader.InputOffset()
+
+	return keys, generation<<scanPositionBits | uint64(next), OK
+}
+
+// close flushes the database file to disk and saves the index, so that the
+// next start does not need to rebuild it. It waits for the requests in
+// progress; later requests fail with DatabaseClosed.
+func (d *database) close() ErrorCode {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.initialized || d.closed {
+		return OK
+	}
+
+	d.closed = true
+
+	f, code := d.openForWriting()
+	if code != OK {
+		return code
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		log.Printf("Error while getting the size of the database file: %v", err)
+		return InternalError
+	}
+
+	if err := d.sync(f); err != nil {
+		log.Printf("Failed to sync the database file: %v", err)
+		return InternalError
+	}
+
+	var timestamp uint64
+	if d.clock != nil {
+		timestamp = d.clock.latest()
+	}
+
+	return d.index.close(size, timestamp)
+}
+
+// encodeRecord returns the CSV fields of a new record for a change,
+// compressed and encrypted as configured.
+func (d *database) encodeRecord(c change) ([]string, ErrorCode) {
+	key, e := c.key, c.entry
+
+	storedValue, header, code := d.compressValue(e.value)
+	if code != OK {
+		return nil, code
+	}
+
+	header.expiresAt = e.expiresAt
+	header.flags = e.flags
+	header.timestamp = c.stamp.time
+	header.origin = c.stamp.origin
+	header.deleted = c.deleted
+
+	if d.keys != nil {
+		header.keyID = d.keys.activeID
+	}
+
+	if header.isEmpty() && !(isPlainText(key) && isPlainText(e.value)) {
+		header.binary = true
+	}
+
+	return encodeRecord(key, storedValue, header, d.keys)
+}
+
+// compressValue compresses values of at least compressionThreshold bytes,
+// unless that does not make them smaller. It returns the value to store and
+// the header that describes it.
+func (d *database) compressValue(value string) (string, recordHeader, ErrorCode) {
+	if d.compressionThreshold <= 0 || len(value) < d.compressionThreshold {
+		return value, recordHeader{}, OK
+	}
+
+	compressed, code := compress(value)
+	if code != OK {
+		return "", recordHeader{}, code
+	}
+
+	if len(compressed) >= len(value) {
+		return value, recordHeader{}, OK
+	}
+
+	d.compression.values.Add(1)
+	d.compression.bytesIn.Add(uint64(len(value)))
+	d.compression.bytesOut.Add(uint64(len(compressed)))
+
+	return compressed, recordHeader{codec: codecFlate}, OK
+}
+
+func (d *database) cacheStats() cacheStats {
+	if d.cache == nil {
+		return cacheStats{}
+	}
+
+	return d.cache.stats()
+}
@@ -0,0 +1,359 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// httpGateway serves the database as JSON over HTTP:
+//
+//	GET    /v1/keys/{key}  the value of a key
+//	PUT    /v1/keys/{key}  sets the value of a key
+//	DELETE /v1/keys/{key}  deletes a key
+//	GET    /v1/keys        lists keys, a page at a time
+//
+// Values are sent as {"value": "..."}, or as {"value_base64": "..."} if they
+// are not valid UTF-8. Every value has an ETag, which PUT and DELETE accept
+// in If-Match to only change the value that the client last read.
+type httpGateway struct {
+	s *server
+}
+
+const (
+	// httpMaxBodySize is the largest body accepted if the server has no
+	// limit on the size of values.
+	httpMaxBodySize = 64 << 20
+)
+
+type valueJSON struct {
+	Key         string  `json:"key,omitempty"`
+	Value       *string `json:"value,omitempty"`
+	ValueBase64 []byte  `json:"value_base64,omitempty"`
+}
+
+type keysJSON struct {
+	Keys []string `json:"keys"`
+	// NextCursor is the cursor of the next page, or empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+func newHTTPHandler(s *server) http.Handler {
+	g := &httpGateway{s: s}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/keys", g.list)
+	mux.HandleFunc("GET /v1/keys/{key...}", g.get)
+	mux.HandleFunc("PUT /v1/keys/{key...}", g.put)
+	mux.HandleFunc("DELETE /v1/keys/{key...}", g.delete)
+
+	return mux
+}
+
+type httpFrontEnd struct {
+	srv *http.Server
+	lis net.Listener
+}
+
+func newHTTPFrontEnd(s *server, lis net.Listener) *httpFrontEnd {
+	return &httpFrontEnd{srv: &http.Server{Handler: newHTTPHandler(s)}, lis: lis}
+}
+
+func (fe *httpFrontEnd) serve() error {
+	if err := fe.srv.Serve(fe.lis); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+func (fe *httpFrontEnd) gracefulStop() {
+	fe.srv.Shutdown(context.Background())
+}
+
+func (fe *httpFrontEnd) stop() {
+	fe.srv.Close()
+	// in case it was never served
+	fe.lis.Close()
+}
+
+// etag returns the entity tag of a value, which changes whenever the value
+// does.
+func etag(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// matchesETag reports whether a value matches an If-Match or If-None-Match
+// header, which is * or a list of entity tags.
+func matchesETag(header, value string) bool {
+	tag := etag(value)
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authenticate returns the principal of the bearer token of a request, if
+// the server has an authorization policy, or replies with an error.
+func (g *httpGateway) authenticate(w http.ResponseWriter, r *http.Request) (*principal, bool) {
+	if g.s.policy == nil {
+		return nil, true
+	}
+
+	pr, err := g.s.policy.authenticateBearer(r.Header.Get("Authorization"))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeHTTPError(w, err)
+		return nil, false
+	}
+
+	return pr, true
+}
+
+// authorize checks that the request may do what perm allows on key, or
+// replies with an error.
+func (g *httpGateway) authorize(w http.ResponseWriter, r *http.Request, perm permission, key string) bool {
+	pr, ok := g.authenticate(w, r)
+	if !ok {
+		return false
+	}
+
+	if pr != nil {
+		if err := pr.authorize(perm, key); err != nil {
+			writeHTTPError(w, err)
+			return false
+		}
+	}
+
+	return true
+}
+
+func (g *httpGateway) get(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	if !g.authorize(w, r, permissionRead, key) {
+		return
+	}
+
+	value, err := g.s.get(r.Context(), key)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(value))
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && matchesETag(ifNoneMatch, value) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	reply := valueJSON{Key: key}
+	if utf8.ValidString(value) {
+		reply.Value = &value
+	} else {
+		reply.ValueBase64 = []byte(value)
+	}
+
+	writeJSON(w, http.StatusOK, reply)
+}
+
+// put sets the value of a key. With If-Match, only a key whose value has
+// one of the given entity tags is changed; with If-None-Match: *, only a
+// key that does not exist yet is set.
+func (g *httpGateway) put(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	if !g.authorize(w, r, permissionWrite, key) {
+		return
+	}
+
+	maxBodySize := int64(httpMaxBodySize)
+	if g.s.maxValueSize > 0 {
+		// base64 takes 4 bytes for every 3, plus room for the rest of the body
+		maxBodySize = int64(g.s.maxValueSize)*4/3 + 1024
+	}
+
+	var body valueJSON
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeHTTPError(w, status.Error(codes.InvalidArgument, "Request body is too large"))
+		} else {
+			writeHTTPError(w, status.Errorf(codes.InvalidArgument, "Request body is not valid: %v", err))
+		}
+		return
+	}
+
+	var value string
+	switch {
+	case body.Value != nil && body.ValueBase64 == nil:
+		value = *body.Value
+	case body.Value == nil && body.ValueBase64 != nil:
+		value = string(body.ValueBase64)
+	default:
+		writeHTTPError(w, status.Error(codes.InvalidArgument, "Request body needs either value or value_base64"))
+		return
+	}
+
+	var condition func(current entry, found bool) bool
+
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+
+	switch {
+	case ifMatch != "":
+		condition = func(current entry, found bool) bool {
+			return found && matchesETag(ifMatch, current.value)
+		}
+	case ifNoneMatch == "*":
+		condition = func(current entry, found bool) bool {
+			return !found
+		}
+	case ifNoneMatch != "":
+		writeHTTPError(w, status.Error(codes.InvalidArgument, "If-None-Match only supports *"))
+		return
+	}
+
+	if err := g.s.setIf(r.Context(), key, value, condition); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(value))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// delete deletes a key, only if its value has one of the entity tags given
+// in If-Match, if any.
+func (g *httpGateway) delete(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	if !g.authorize(w, r, permissionWrite, key) {
+		return
+	}
+
+	var condition func(current entry) bool
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		condition = func(current entry) bool {
+			return matchesETag(ifMatch, current.value)
+		}
+	}
+
+	if err := g.s.deleteIf(r.Context(), key, condition); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// list returns up to limit keys that start with prefix, from the cursor of
+// the previous page. Only the last page has fewer keys than the limit.
+// Keys that the client may not read are left out.
+func (g *httpGateway) list(w http.ResponseWriter, r *http.Request) {
+	pr, ok := g.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+
+	limit := defaultScanLimit
+	if s := query.Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxScanLimit {
+			writeHTTPError(w, status.Errorf(codes.InvalidArgument, "Limit must be between 1 and %d", maxScanLimit))
+			return
+		}
+	}
+
+	var cursor uint64
+	if s := query.Get("cursor"); s != "" {
+		var err error
+		cursor, err = strconv.ParseUint(s, 10, 64)
+		if err != nil || cursor == 0 {
+			writeHTTPError(w, status.Error(codes.InvalidArgument, "Cursor is not valid"))
+			return
+		}
+	}
+
+	infof("List: received prefix: %v", prefix)
+
+	keys, cursor, err := g.s.scan(prefix, cursor, limit, func(key string) bool {
+		return pr == nil || pr.allowed(permissionRead, key)
+	})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	reply := keysJSON{Keys: append([]string{}, keys...)}
+
+	if cursor != 0 {
+		reply.NextCursor = strconv.FormatUint(cursor, 10)
+	}
+
+	writeJSON(w, http.StatusOK, reply)
+}
+
+// httpStatus returns the HTTP status code for a gRPC status code.
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.Aborted:
+		return http.StatusMisdirectedRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	writeJSON(w, httpStatus(st.Code()), errorJSON{Error: st.Message(), Code: st.Code().String()})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type httpTestClient struct {
+	t       *testing.T
+	baseURL string
+}
+
+// do sends a request and returns the response, with its body read.
+func (c httpTestClient) do(method, path, body string, headers ...string) (*http.Response, string) {
+	c.t.Helper()
+
+	req, err := http.NewRequest(method, c.baseURL+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return resp, strings.TrimSpace(string(b))
+}
+
+func startHTTPGateway(t *testing.T) (*server, httpTestClient) {
+	t.Helper()
+	t.Cleanup(deleteDatabase)
+
+	s := getServer()
+	ts := httptest.NewServer(newHTTPHandler(s))
+	t.Cleanup(ts.Close)
+
+	return s, httpTestClient{t: t, baseURL: ts.URL}
+}
+
+func Test_httpGateway(t *testing.T) {
+	_, c := startHTTPGateway(t)
+
+	valueETag := etag("value")
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		headers    []string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Get missing key",
+			method:     "GET",
+			path:       "/v1/keys/key",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"error":"Key was not found","code":"NotFound"}`,
+		},
+		{
+			name:       "Put",
+			method:     "PUT",
+			path:       "/v1/keys/key",
+			body:       `{"value":"value"}`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Get",
+			method:     "GET",
+			path:       "/v1/keys/key",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"key":"key","value":"value"}`,
+		},
+		{
+			name:       "Get unchanged value",
+			method:     "GET",
+			path:       "/v1/keys/key",
+			headers:    []string{"If-None-Match", valueETag},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "Put if absent",
+			method:     "PUT",
+			path:       "/v1/keys/key",
+			body:       `{"value":"other"}`,
+			headers:    []string{"If-None-Match", "*"},
+			wantStatus: http.StatusPreconditionFailed,
+			wantBody:   `{"error":"Condition on the current value does not hold","code":"FailedPrecondition"}`,
+		},
+		{
+			name:       "Put with stale ETag",
+			method:     "PUT",
+			path:       "/v1/keys/key",
+			body:       `{"value":"other"}`,
+			headers:    []string{"If-Match", etag("stale")},
+			wantStatus: http.StatusPreconditionFailed,
+			wantBody:   `{"error":"Condition on the current value does not hold","code":"FailedPrecondition"}`,
+		},
+		{
+			name:       "Put with current ETag",
+			method:     "PUT",
+			path:       "/v1/keys/key",
+			body:       `{"value":"value2"}`,
+			headers:    []string{"If-Match", valueETag},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Delete with stale ETag",
+			method:     "DELETE",
+			path:       "/v1/keys/key",
+			headers:    []string{"If-Match", valueETag},
+			wantStatus: http.StatusPreconditionFailed,
+			wantBody:   `{"error":"Condition on the current value does not hold","code":"FailedPrecondition"}`,
+		},
+		{
+			name:       "Delete",
+			method:     "DELETE",
+			path:       "/v1/keys/key",
+			headers:    []string{"If-Match", etag("value2")},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Delete missing key",
+			method:     "DELETE",
+			path:       "/v1/keys/key",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"error":"Key was not found","code":"NotFound"}`,
+		},
+		{
+			name:       "Put binary value",
+			method:     "PUT",
+			path:       "/v1/keys/dir/binary",
+			body:       `{"value_base64":"/wA="}`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Get binary value",
+			method:     "GET",
+			path:       "/v1/keys/dir/binary",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"key":"dir/binary","value_base64":"/wA="}`,
+		},
+		{
+			name:       "Empty key",
+			method:     "GET",
+			path:       "/v1/keys/",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"error":"Key cannot be empty","code":"InvalidArgument"}`,
+		},
+		{
+			name:       "Body without value",
+			method:     "PUT",
+			path:       "/v1/keys/key",
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"error":"Request body needs either value or value_base64","code":"InvalidArgument"}`,
+		},
+		{
+			name:       "Malformed body",
+			method:     "PUT",
+			path:       "/v1/keys/key",
+			body:       `value`,
+			wantStatus: http.StatusBadRequest,
+			wantBody: `{"error":"Request body is not valid: invalid character 'v' looking for beginning of value",` +
+				`"code":"InvalidArgument"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := c.do(tt.method, tt.path, tt.body, tt.headers...)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %v, want = %v", resp.StatusCode, tt.wantStatus)
+			}
+
+			if body != tt.wantBody {
+				t.Errorf("body = %v, want = %v", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_httpGateway_etag(t *testing.T) {
+	_, c := startHTTPGateway(t)
+
+	put, _ := c.do("PUT", "/v1/keys/key", `{"value":"value"}`)
+	get, _ := c.do("GET", "/v1/keys/key", "")
+
+	if put.Header.Get("ETag") == "" || put.Header.Get("ETag") != get.Header.Get("ETag") {
+		t.Errorf("ETag = %q after PUT and %q after GET, want the same", put.Header.Get("ETag"), get.Header.Get("ETag"))
+	}
+}
+
+func Test_httpGateway_replica(t *testing.T) {
+	s, c := startHTTPGateway(t)
+	s.db.setKey("key", "value")
+	s.db.readOnly = true
+
+	for _, method := range []string{"PUT", "DELETE"} {
+		resp, body := c.do(method, "/v1/keys/key", `{"value":"new value"}`)
+
+		if resp.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("%s status = %v, want = %v", method, resp.StatusCode, http.StatusPreconditionFailed)
+		}
+
+		if want := `{"error":"Server is a read-only replica, write to its primary","code":"FailedPrecondition"}`; body != want {
+			t.Errorf("%s body = %v, want = %v", method, body, want)
+		}
+	}
+
+	if resp, body := c.do("GET", "/v1/keys/key", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET status = %v, body = %v, want = %v", resp.StatusCode, body, http.StatusOK)
+	}
+}
+
+func Test_httpGateway_list(t *testing.T) {
+	s, c := startHTTPGateway(t)
+
+	var want []string
+	for i := 0; i < 12; i++ {
+		key := fmt.Sprintf("user/%d", i)
+		s.db.setKey(key, "value")
+		want = append(want, key)
+	}
+	s.db.setKey("other", "value")
+	s.db.deleteKeys(context.Background(), "user/5")
+	want = append(want[:5], want[6:]...)
+
+	var got []string
+	path := "/v1/keys?prefix=user/&limit=5"
+	pages := 0
+
+	for {
+		resp, body := c.do("GET", path, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %v, body = %v", resp.StatusCode, body)
+		}
+
+		var page keysJSON
+		if err := json.Unmarshal([]byte(body), &page); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(page.Keys) > 5 {
+			t.Errorf("page has %d keys, want at most 5", len(page.Keys))
+		}
+
+		got = append(got, page.Keys...)
+		pages++
+
+		if page.NextCursor == "" {
+			break
+		}
+		path = "/v1/keys?prefix=user/&limit=5&cursor=" + page.NextCursor
+	}
+
+	sort.Strings(got)
+	sort.Strings(want)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got = %v, want = %v", got, want)
+	}
+
+	if pages != 3 {
+		t.Errorf("got %d pages, want = 3", pages)
+	}
+
+	if resp, _ := c.do("GET", "/v1/keys?limit=0", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %v for a limit of 0, want = %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,474 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+const (
+	indexModeMemory = "memory"
+	indexModeDisk   = "disk"
+
+	diskIndexSuffix = ".idx"
+)
+
+// keyIndex maps every key in the database file to the position of its
+// latest record.
+type keyIndex interface {
+	// open prepares the index for use and returns the position in the
+	// database file up to which the index is already populated, and the
+	// latest timestamp of the records up to there.
+	open() (int64, uint64, ErrorCode)
+	get(key string) (bool, int64, ErrorCode)
+	put(key string, pos int64) ErrorCode
+	// close persists the index, recording that it covers the database file
+	// up to indexedUpTo, whose records are stamped no later than timestamp.
+	close(indexedUpTo int64, timestamp uint64) ErrorCode
+}
+
+// memoryIndex keeps every key in a map. It is the fastest index, but its
+// memory use grows with the number and length of the keys.
+type memoryIndex struct {
+	keyPositions map[string]int64
+}
+
+func newMemoryIndex() *memoryIndex {
+	return &memoryIndex{keyPositions: make(map[string]int64)}
+}
+
+func (m *memoryIndex) open() (int64, uint64, ErrorCode) {
+	return 0, 0, OK
+}
+
+func (m *memoryIndex) get(key string) (bool, int64, ErrorCode) {
+	keyPosition, keyFound := m.keyPositions[key]
+
+	return keyFound, keyPosition, OK
+}
+
+func (m *memoryIndex) put(key string, pos int64) ErrorCode {
+	m.keyPositions[key] = pos
+	return OK
+}
+
+func (m *memoryIndex) close(indexedUpTo int64, timestamp uint64) ErrorCode {
+	return OK
+}
+
+// The disk index is an open-addressing hash table stored in its own file.
+// Keys are not stored: each slot holds a 64-bit fingerprint of the key and
+// the position of its record, and candidates are confirmed by reading the
+// key back from the database file. Only a bounded cache of fingerprints is
+// kept in memory.
+//
+// File layout (all integers little-endian):
+//
+//	header: magic [8]byte, capacity uint64, count uint64, indexedUpTo int64,
+//	        timestamp uint64
+//	slots:  capacity * (fingerprint uint64, position int64)
+//
+// A fingerprint of 0 marks an empty slot. timestamp is the latest timestamp
+// of the records up to indexedUpTo, which a multi-primary server restores its
+// clock from without reading those records again. Files of another format,
+// such as those written before the timestamp was added, are rebuilt.
+const (
+	diskIndexMagic           = "SDBIDX02"
+	diskIndexHeaderSize      = 40
+	diskIndexSlotSize        = 16
+	diskIndexInitialCapacity = 1 << 10
+	// The table is doubled once it is more than three quarters full.
+	diskIndexMaxLoadNum = 3
+	diskIndexMaxLoadDen = 4
+	// Rough cost of one fingerprint cache entry, including map overhead.
+	diskIndexCacheEntrySize = 48
+)
+
+type diskIndex struct {
+	path        string
+	dataPath    string
+	memoryLimit int64
+	// keyAt reads the key stored at a position of the database file.
+	keyAt func(pos int64) (string, ErrorCode)
+
+	mu       sync.Mutex
+	f        *os.File
+	capacity uint64
+	count    uint64
+	// timestamp is the latest timestamp of the records that the index
+	// covered when it was opened, which close keeps if it is given none
+	// later, such as by a server that is not multi-primary.
+	timestamp uint64
+	// cache holds recently used fingerprints and their positions.
+	cache map[uint64]int64
+}
+
+func newDiskIndex(
+	dataPath string,
+	memoryLimit int64,
+	keyAt func(pos int64) (string, ErrorCode),
+) *diskIndex {
+	return &diskIndex{
+		path:        dataPath + diskIndexSuffix,
+		dataPath:    dataPath,
+		memoryLimit: memoryLimit,
+		keyAt:       keyAt,
+		cache:       make(map[uint64]int64),
+	}
+}
+
+func fingerprint(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	fp := h.Sum64()
+
+	if fp == 0 {
+		// 0 is reserved for empty slots
+		fp = 1
+	}
+
+	return fp
+}
+
+func (x *diskIndex) open() (int64, uint64, ErrorCode) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	f, err := os.OpenFile(x.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		log.Printf("Failed to open the index file: %v", err)
+		return 0, 0, InternalError
+	}
+	x.f = f
+
+	indexedUpTo, err := x.readHeader()
+	if err == nil {
+		return indexedUpTo, x.timestamp, OK
+	}
+
+	if !errors.Is(err, io.EOF) {
+		log.Printf("Rebuilding index file: %v", err)
+	}
+
+	if code := x.reset(diskIndexInitialCapacity); code != OK {
+		return 0, 0, code
+	}
+
+	return 0, 0, OK
+}
+
+func (x *diskIndex) readHeader() (int64, error) {
+	var header [diskIndexHeaderSize]byte
+	if _, err := x.f.ReadAt(header[:], 0); err != nil {
+		return 0, err
+	}
+
+	if string(header[:8]) != diskIndexMagic {
+		return 0, errors.New("unknown index file format")
+	}
+
+	x.capacity = binary.LittleEndian.Uint64(header[8:])
+	x.count = binary.LittleEndian.Uint64(header[16:])
+	indexedUpTo := int64(binary.LittleEndian.Uint64(header[24:]))
+	x.timestamp = binary.LittleEndian.Uint64(header[32:])
+
+	if x.capacity == 0 || x.capacity&(x.capacity-1) != 0 {
+		return 0, errors.New("invalid index capacity")
+	}
+
+	info, err := x.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if info.Size() != diskIndexHeaderSize+int64(x.capacity)*diskIndexSlotSize {
+		return 0, errors.New("index file has the wrong size")
+	}
+
+	data, err := os.Stat(x.dataPath)
+	if err != nil || data.Size() < indexedUpTo {
+		return 0, errors.New("index is ahead of the database file")
+	}
+
+	return indexedUpTo, nil
+}
+
+func (x *diskIndex) writeHeader(f *os.File, capacity, count uint64, indexedUpTo int64, timestamp uint64) error {
+	var header [diskIndexHeaderSize]byte
+	copy(header[:8], diskIndexMagic)
+	binary.LittleEndian.PutUint64(header[8:], capacity)
+	binary.LittleEndian.PutUint64(header[16:], count)
+	binary.LittleEndian.PutUint64(header[24:], uint64(indexedUpTo))
+	binary.LittleEndian.PutUint64(header[32:], timestamp)
+
+	_, err := f.WriteAt(header[:], 0)
+	return err
+}
+
+// reset empties the index file and sizes it for the given capacity.
+func (x *diskIndex) reset(capacity uint64) ErrorCode {
+	if err := x.f.Truncate(0); err != nil {
+		log.Printf("Failed to truncate the index file: %v", err)
+		return InternalError
+	}
+
+	if err := x.f.Truncate(diskIndexHeaderSize + int64(capacity)*diskIndexSlotSize); err != nil {
+		log.Printf("Failed to size the index file: %v", err)
+		return InternalError
+	}
+
+	if err := x.writeHeader(x.f, capacity, 0, 0, 0); err != nil {
+		log.Printf("Failed to write the index header: %v", err)
+		return InternalError
+	}
+
+	x.capacity = capacity
+	x.count = 0
+	x.cache = make(map[uint64]int64)
+
+	return OK
+}
+
+func slotOffset(slot uint64) int64 {
+	return diskIndexHeaderSize + int64(slot)*diskIndexSlotSize
+}
+
+func (x *diskIndex) readSlot(slot uint64) (uint64, int64, ErrorCode) {
+	var buf [diskIndexSlotSize]byte
+	if _, err := x.f.ReadAt(buf[:], slotOffset(slot)); err != nil {
+		log.Printf("Failed to read from the index file: %v", err)
+		return 0, 0, InternalError
+	}
+
+	fp := binary.LittleEndian.Uint64(buf[:8])
+	pos := int64(binary.LittleEndian.Uint64(buf[8:]))
+
+	return fp, pos, OK
+}
+
+func writeSlot(f *os.File, slot uint64, fp uint64, pos int64) ErrorCode {
+	var buf [diskIndexSlotSize]byte
+	binary.LittleEndian.PutUint64(buf[:8], fp)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(pos))
+
+	if _, err := f.WriteAt(buf[:], slotOffset(slot)); err != nil {
+		log.Printf("Failed to write to the index file: %v", err)
+		return InternalError
+	}
+
+	return OK
+}
+
+// findSlot probes the table for key. It returns the slot holding the key if
+// it is present, or else the empty slot where it would be inserted.
+func (x *diskIndex) findSlot(key string, fp uint64) (slot uint64, found bool, pos int64, code ErrorCode) {
+	mask := x.capacity - 1
+
+	for slot = fp & mask; ; slot = (slot + 1) & mask {
+		slotFp, slotPos, code := x.readSlot(slot)
+		if code != OK {
+			return 0, false, 0, code
+		}
+
+		if slotFp == 0 {
+			return slot, false, 0, OK
+		}
+
+		if slotFp != fp {
+			continue
+		}
+
+		storedKey, code := x.keyAt(slotPos)
+		if code != OK {
+			return 0, false, 0, code
+		}
+
+		if storedKey == key {
+			return slot, true, slotPos, OK
+		}
+	}
+}
+
+func (x *diskIndex) get(key string) (bool, int64, ErrorCode) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	fp := fingerprint(key)
+
+	if pos, ok := x.cache[fp]; ok {
+		storedKey, code := x.keyAt(pos)
+		if code != OK {
+			return false, 0, code
+		}
+
+		if storedKey == key {
+			return true, pos, OK
+		}
+	}
+
+	_, found, pos, code := x.findSlot(key, fp)
+	if code != OK || !found {
+		return false, 0, code
+	}
+
+	x.cachePosition(fp, pos)
+
+	return true, pos, OK
+}
+
+func (x *diskIndex) put(key string, pos int64) ErrorCode {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if (x.count+1)*diskIndexMaxLoadDen > x.capacity*diskIndexMaxLoadNum {
+		if code := x.grow(); code != OK {
+			return code
+		}
+	}
+
+	fp := fingerprint(key)
+
+	slot, found, _, code := x.findSlot(key, fp)
+	if code != OK {
+		return code
+	}
+
+	if code := writeSlot(x.f, slot, fp, pos); code != OK {
+		return code
+	}
+
+	if !found {
+		x.count++
+	}
+
+	x.cachePosition(fp, pos)
+
+	return OK
+}
+
+// grow doubles the capacity of the table. Slots are rehashed by their
+// fingerprint, so the database file does not need to be read.
+func (x *diskIndex) grow() ErrorCode {
+	capacity := x.capacity * 2
+	tmpPath := x.path + ".tmp"
+
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		log.Printf("Failed to create the index file: %v", err)
+		return InternalError
+	}
+
+	if err := tmp.Truncate(diskIndexHeaderSize + int64(capacity)*diskIndexSlotSize); err != nil {
+		log.Printf("Failed to size the index file: %v", err)
+		tmp.Close()
+		return InternalError
+	}
+
+	mask := capacity - 1
+
+	for slot := uint64(0); slot < x.capacity; slot++ {
+		fp, pos, code := x.readSlot(slot)
+		if code != OK {
+			tmp.Close()
+			return code
+		}
+
+		if fp == 0 {
+			continue
+		}
+
+		newSlot := fp & mask
+		for {
+			var buf [8]byte
+			if _, err := tmp.ReadAt(buf[:], slotOffset(newSlot)); err != nil {
+				log.Printf("Failed to read from the index file: %v", err)
+				tmp.Close()
+				return InternalError
+			}
+
+			if binary.LittleEndian.Uint64(buf[:]) == 0 {
+				break
+			}
+
+			newSlot = (newSlot + 1) & mask
+		}
+
+		if code := writeSlot(tmp, newSlot, fp, pos); code != OK {
+			tmp.Close()
+			return code
+		}
+	}
+
+	// The new table is not tied to any position in the database file until
+	// it is closed, so a crash before then makes the next start rebuild it.
+	if err := x.writeHeader(tmp, capacity, x.count, 0, 0); err != nil {
+		log.Printf("Failed to write the index header: %v", err)
+		tmp.Close()
+		return InternalError
+	}
+
+	if err := os.Rename(tmpPath, x.path); err != nil {
+		log.Printf("Failed to replace the index file: %v", err)
+		tmp.Close()
+		return InternalError
+	}
+
+	x.f.Close()
+	x.f = tmp
+	x.capacity = capacity
+
+	return OK
+}
+
+// cachePosition remembers the position for a fingerprint, evicting other
+// entries if the cache would exceed the memory limit.
+func (x *diskIndex) cachePosition(fp uint64, pos int64) {
+	maxEntries := int(x.memoryLimit / diskIndexCacheEntrySize)
+
+	if _, ok := x.cache[fp]; !ok && len(x.cache) >= maxEntries {
+		// Map iteration order is random, which makes this a cheap random
+		// eviction.
+		for evicted := range x.cache {
+			delete(x.cache, evicted)
+			if len(x.cache) < maxEntries {
+				break
+			}
+		}
+	}
+
+	if maxEntries > 0 {
+		x.cache[fp] = pos
+	}
+}
+
+func (x *diskIndex) close(indexedUpTo int64, timestamp uint64) ErrorCode {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.f == nil {
+		return OK
+	}
+
+	if err := x.writeHeader(x.f, x.capacity, x.count, indexedUpTo, max(x.timestamp, timestamp)); err != nil {
+		log.Printf("Failed to write the index header: %v", err)
+		return InternalError
+	}
+
+	if err := x.f.Sync(); err != nil {
+		log.Printf("Failed to sync the index file: %v", err)
+		return InternalError
+	}
+
+	if err := x.f.Close(); err != nil {
+		log.Printf("Failed to close the index file: %v", err)
+		return InternalError
+	}
+
+	x.f = nil
+
+	return OK
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+func Test_diskIndex(t *testing.T) {
+	tests := []struct {
+		name        string
+		memoryLimit int64
+	}{
+		{
+			name:        "No memory for caching",
+			memoryLimit: 0,
+		},
+		{
+			name:        "Cache smaller than the number of keys",
+			memoryLimit: 100 * diskIndexCacheEntrySize,
+		},
+		{
+			name:        "Cache larger than the number of keys",
+			memoryLimit: 64 << 20,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(deleteDatabase)
+
+			// enough keys to make the table grow a few times
+			keysCount := 4 * diskIndexInitialCapacity
+
+			var keyValuePairs [][]string
+			for i := 0; i < keysCount; i++ {
+				keyValuePairs = append(keyValuePairs, []string{fmt.Sprintf("key%d", i), "old"})
+			}
+			createDatabase(keyValuePairs)
+
+			s := getServerWithIndex(indexModeDisk, tt.memoryLimit)
+
+			for i := 0; i < keysCount; i += 2 {
+				setRequest := &pb.SetRequest{Key: fmt.Sprintf("key%d", i), Value: "new"}
+				if _, err := s.Set(context.Background(), setRequest); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			assertValues(t, s, keysCount)
+
+			if found, _, _ := s.db.getKeyPosition("nonexistent_key"); found {
+				t.Errorf("nonexistent key was found")
+			}
+
+			// Reopen the index from its file, with records appended after it
+			// was closed.
+			closeIndex(t, s)
+			setRequest := &pb.SetRequest{Key: "key1", Value: "newer"}
+			if _, err := getServer().Set(context.Background(), setRequest); err != nil {
+				t.Fatal(err)
+			}
+
+			s = getServerWithIndex(indexModeDisk, tt.memoryLimit)
+
+			reply, err := s.Get(context.Background(), &pb.GetRequest{Key: "key1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply.Value != "newer" {
+				t.Errorf("got = %v, want = %v", reply.Value, "newer")
+			}
+		})
+	}
+}
+
+func Test_diskIndex_corruptFile(t *testing.T) {
+	t.Cleanup(deleteDatabase)
+	createDatabase([][]string{{"key", "value"}})
+
+	if err := os.WriteFile(testDatabasePath+diskIndexSuffix, []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := getServerWithIndex(indexModeDisk, 0)
+
+	reply, err := s.Get(context.Background(), &pb.GetRequest{Key: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Value != "value" {
+		t.Errorf("got = %v, want = %v", reply.Value, "value")
+	}
+}
+
+func BenchmarkGetIndex(b *testing.B) {
+	log.SetOutput(io.Discard) // skip logging
+
+	rowsCount := 100000
+
+	var keyValuePairs [][]string
+	for i := 0; i < rowsCount; i++ {
+		keyValuePairs = append(keyValuePairs, []string{randStringBytes(10), randStringBytes(15)})
+	}
+
+	benchmarks := []struct {
+		name        string
+		mode        string
+		memoryLimit int64
+	}{
+		{name: "memory", mode: indexModeMemory},
+		{name: "disk_uncached", mode: indexModeDisk, memoryLimit: 0},
+		{name: "disk_cached", mode: indexModeDisk, memoryLimit: 64 << 20},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.Cleanup(deleteDatabase)
+			createDatabase(keyValuePairs)
+			s := getServerWithIndex(bm.mode, bm.memoryLimit)
+
+			b.ResetTimer()
+
+			for n := 0; n < b.N; n++ {
+				getRequest := &pb.GetRequest{Key: keyValuePairs[n%rowsCount][0]}
+				if _, err := s.Get(context.Background(), getRequest); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func getServerWithIndex(mode string, memoryLimit int64) *server {
+	db := &database{
+		filepath:         testDatabasePath,
+		initialized:      false,
+		indexMode:        mode,
+		indexMemoryLimit: memoryLimit,
+	}
+	s := &server{
+		UnimplementedDatabaseServer: pb.UnimplementedDatabaseServer{},
+		db:                          db,
+	}
+	s.initialize()
+	return s
+}
+
+func assertValues(t *testing.T, s *server, keysCount int) {
+	t.Helper()
+
+	for i := 0; i < keysCount; i++ {
+		want := "old"
+		if i%2 == 0 {
+			want = "new"
+		}
+
+		reply, err := s.Get(context.Background(), &pb.GetRequest{Key: fmt.Sprintf("key%d", i)})
+		if err != nil {
+			t.Fatalf("key%d: %v", i, err)
+		}
+		if reply.Value != want {
+			t.Fatalf("key%d: got = %v, want = %v", i, reply.Value, want)
+		}
+	}
+}
+
+func closeIndex(t *testing.T, s *server) {
+	t.Helper()
+
+	info, err := os.Stat(testDatabasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := s.db.index.close(info.Size(), 0); code != OK {
+		t.Fatalf("closing index failed with code %d", code)
+	}
+}
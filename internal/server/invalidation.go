@@ -0,0 +1,228 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+// Clients that cache values watch the keys that they cached, and are told
+// when those change. Keys are invalidated wherever the value cache of the
+// server invalidates them, once their change can be read: when the database
+// applies changes, and when a replica applies the records of its primary.
+// When a replica or a cluster member starts over from a snapshot, every key
+// is invalidated at once. A key is watched until its first change, after
+// which a client that caches it again watches it again.
+
+// keyWatchers are the keys that the Watch streams of clients watch.
+type keyWatchers struct {
+	mu sync.Mutex
+	// byKey holds the watchers of every watched key, and all every watcher.
+	byKey map[string]map[*watcher]struct{}
+	all   map[*watcher]struct{}
+}
+
+// watcher is a Watch stream, and the replies waiting to be sent on it.
+type watcher struct {
+	// keys are the keys that it watches. They are guarded by the lock of
+	// the keyWatchers.
+	keys map[string]struct{}
+
+	mu sync.Mutex
+	// changed are the keys that changed since the last reply, everything
+	// whether every key did, and acknowledged the id of the last request.
+	changed      [][]byte
+	everything   bool
+	acknowledged uint64
+	// ready has a value when there is a reply to send.
+	ready chan struct{}
+}
+
+func newKeyWatchers() *keyWatchers {
+	return &keyWatchers{byKey: make(map[string]map[*watcher]struct{}), all: make(map[*watcher]struct{})}
+}
+
+func (kw *keyWatchers) register() *watcher {
+	w := &watcher{keys: make(map[string]struct{}), ready: make(chan struct{}, 1)}
+
+	kw.mu.Lock()
+	defer kw.mu.Unlock()
+
+	kw.all[w] = struct{}{}
+
+	return w
+}
+
+func (kw *keyWatchers) unregister(w *watcher) {
+	kw.mu.Lock()
+	defer kw.mu.Unlock()
+
+	for key := range w.keys {
+		kw.unwatch(w, key)
+	}
+	delete(kw.all, w)
+}
+
+// update removes and adds keys of w, and then acknowledges the request.
+func (kw *keyWatchers) update(w *watcher, in *pb.WatchRequest) {
+	kw.mu.Lock()
+	for _, key := range in.Remove {
+		kw.unwatch(w, string(key))
+	}
+	for _, key := range in.Add {
+		if kw.byKey[string(key)] == nil {
+			kw.byKey[string(key)] = make(map[*watcher]struct{})
+		}
+		kw.byKey[string(key)][w] = struct{}{}
+		w.keys[string(key)] = struct{}{}
+	}
+	kw.mu.Unlock()
+
+	w.mu.Lock()
+	w.acknowledged = in.Id
+	w.mu.Unlock()
+
+	w.signal()
+}
+
+// unwatch stops w from watching key. The caller must hold the lock.
+func (kw *keyWatchers) unwatch(w *watcher, key string) {
+	delete(w.keys, key)
+
+	if ws := kw.byKey[key]; ws != nil {
+		delete(ws, w)
+		if len(ws) == 0 {
+			delete(kw.byKey, key)
+		}
+	}
+}
+
+// invalidate tells the watchers of key that it changed.
+func (kw *keyWatchers) invalidate(key string) {
+	if kw == nil {
+		return
+	}
+
+	kw.mu.Lock()
+	defer kw.mu.Unlock()
+
+	ws, ok := kw.byKey[key]
+	if !ok {
+		return
+	}
+	delete(kw.byKey, key)
+
+	for w := range ws {
+		delete(w.keys, key)
+
+		w.mu.Lock()
+		w.changed = append(w.changed, []byte(key))
+		w.mu.Unlock()
+
+		w.signal()
+	}
+}
+
+// invalidateAll tells every watcher that any key may have changed.
+func (kw *keyWatchers) invalidateAll() {
+	if kw == nil {
+		return
+	}
+
+	kw.mu.Lock()
+	defer kw.mu.Unlock()
+
+	clear(kw.byKey)
+
+	for w := range kw.all {
+		clear(w.keys)
+
+		w.mu.Lock()
+		w.changed = nil
+		w.everything = true
+		w.mu.Unlock()
+
+		w.signal()
+	}
+}
+
+func (w *watcher) signal() {
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// reply takes the reply waiting to be sent.
+func (w *watcher) reply() *pb.WatchReply {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	reply := &pb.WatchReply{Keys: w.changed, All: w.everything, Acknowledged: w.acknowledged}
+	w.changed, w.everything = nil, false
+
+	return reply
+}
+
+// invalidationServer streams the changes of watched keys to clients.
+type invalidationServer struct {
+	pb.UnimplementedInvalidationServer
+	db *database
+
+	// stopping is closed when the server shuts down, which ends the
+	// streams; they would otherwise never end on their own.
+	stopping chan struct{}
+	stopOnce sync.Once
+}
+
+func newInvalidationServer(d *database) *invalidationServer {
+	return &invalidationServer{db: d, stopping: make(chan struct{})}
+}
+
+func (is *invalidationServer) shutdown() {
+	is.stopOnce.Do(func() { close(is.stopping) })
+}
+
+func (is *invalidationServer) Watch(stream pb.Invalidation_WatchServer) error {
+	if is.db.watchers == nil {
+		return internalErr
+	}
+
+	w := is.db.watchers.register()
+	defer is.db.watchers.unregister(w)
+
+	received := make(chan error, 1)
+	go func() {
+		for {
+			in, err := stream.Recv()
+			if err != nil {
+				received <- err
+				return
+			}
+
+			is.db.watchers.update(w, in)
+		}
+	}()
+
+	for {
+		select {
+		case <-w.ready:
+			if err := stream.Send(w.reply()); err != nil {
+				return err
+			}
+		case err := <-received:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case <-is.stopping:
+			return closedErr
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+func Test_keyWatchers(t *testing.T) {
+	kw := newKeyWatchers()
+	w := kw.register()
+	other := kw.register()
+
+	kw.update(w, &pb.WatchRequest{Id: 1, Add: [][]byte{[]byte("a"), []byte("b")}})
+	kw.update(other, &pb.WatchRequest{Id: 1, Add: [][]byte{[]byte("a")}})
+
+	// a key that is removed and added again stays watched
+	kw.update(w, &pb.WatchRequest{Id: 2, Add: [][]byte{[]byte("b")}, Remove: [][]byte{[]byte("b")}})
+
+	kw.invalidate("a")
+	kw.invalidate("b")
+	kw.invalidate("c")
+	// keys are only invalidated once
+	kw.invalidate("a")
+
+	reply := w.reply()
+	if got := keysOf(reply); !slices.Equal(got, []string{"a", "b"}) || reply.Acknowledged != 2 {
+		t.Errorf("reply = %q, acknowledged %d, want = [a b], acknowledged 2", got, reply.Acknowledged)
+	}
+
+	if got := keysOf(other.reply()); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("keys of other = %q, want = [a]", got)
+	}
+
+	kw.update(w, &pb.WatchRequest{Id: 3, Add: [][]byte{[]byte("a")}})
+	kw.invalidateAll()
+
+	if reply := w.reply(); !reply.All || len(reply.Keys) != 0 {
+		t.Errorf("reply = %+v, want every key invalidated", reply)
+	}
+
+	kw.unregister(w)
+	kw.unregister(other)
+
+	if len(kw.byKey) != 0 || len(kw.all) != 0 {
+		t.Errorf("%d keys and %d watchers left, want none", len(kw.byKey), len(kw.all))
+	}
+}
+
+func keysOf(reply *pb.WatchReply) []string {
+	var keys []string
+	for _, key := range reply.Keys {
+		keys = append(keys, string(key))
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,19 @@
+package server
+
+import "log"
+
+// logLevel is one of logLevelDebug, logLevelInfo or logLevelError. Errors
+// are always logged with log.Printf; debugf and infof log the rest.
+var logLevel = logLevelInfo
+
+func debugf(format string, v ...any) {
+	if logLevel == logLevelDebug {
+		log.Printf(format, v...)
+	}
+}
+
+func infof(format string, v ...any) {
+	if logLevel == logLevelDebug || logLevel == logLevelInfo {
+		log.Printf(format, v...)
+	}
+}
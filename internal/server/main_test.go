@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"context"
@@ -296,6 +296,8 @@ func createDatabase(keyValuePairs [][]string) error {
 
 func deleteDatabase() {
 	os.Remove(testDatabasePath)
+	os.Remove(testDatabasePath + diskIndexSuffix)
+	os.Remove(testDatabasePath + epochSuffix)
 }
 
 func randStringBytes(n int) string {
@@ -307,3 +309,53 @@ func randStringBytes(n int) string {
 	}
 	return string(b)
 }
+
+func Test_server_limits(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		value      string
+		wantErrMsg string
+	}{
+		{
+			name:  "Within limits",
+			key:   "key",
+			value: "value",
+		},
+		{
+			name:       "Key too large",
+			key:        "key-too-large",
+			value:      "value",
+			wantErrMsg: "Key cannot be larger than 5 bytes",
+		},
+		{
+			name:       "Value too large",
+			key:        "key",
+			value:      "value-too-large",
+			wantErrMsg: "Value cannot be larger than 10 bytes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(deleteDatabase)
+			s := getServer()
+			s.maxKeySize = 5
+			s.maxValueSize = 10
+
+			_, err := s.Set(context.Background(), &pb.SetRequest{Key: tt.key, Value: tt.value})
+
+			if tt.wantErrMsg == "" {
+				if err != nil {
+					t.Errorf("error = %v, did not want error", err)
+				}
+				return
+			}
+
+			st, _ := status.FromError(err)
+
+			if st.Code() != codes.InvalidArgument || st.Message() != tt.wantErrMsg {
+				t.Errorf("error = %v, want = %v", err, tt.wantErrMsg)
+			}
+		})
+	}
+}
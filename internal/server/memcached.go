@@ -0,0 +1,391 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// memcachedServer serves the memcached text protocol on top of the same
+// database as the gRPC server, so that memcached clients can use it.
+type memcachedServer struct {
+	connServer
+	s *server
+}
+
+// memcachedConn is a client connection.
+type memcachedConn struct {
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+const (
+	memcachedVersion = "1.6.0"
+
+	memcachedMaxKeySize  = 250
+	memcachedMaxLineSize = 2048
+	// Expiry times of up to 30 days are relative to now; longer ones are
+	// Unix times.
+	memcachedMaxRelativeExptime = 60 * 60 * 24 * 30
+)
+
+func newMemcachedServer(s *server, lis net.Listener) *memcachedServer {
+	ms := &memcachedServer{s: s}
+	ms.connServer = newConnServer(lis, ms.serveConn)
+	return ms
+}
+
+func (ms *memcachedServer) serveConn(conn net.Conn) {
+	c := &memcachedConn{r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+
+	for {
+		line, err := readLine(c.r, memcachedMaxLineSize)
+		if err == errLineTooLong {
+			c.w.WriteString("CLIENT_ERROR line too long\r\n")
+			c.w.Flush()
+			return
+		} else if err != nil {
+			return
+		}
+
+		quit := ms.execute(c, strings.Fields(line))
+
+		// replies to pipelined commands are sent together
+		if c.r.Buffered() == 0 || quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+
+		if quit {
+			return
+		}
+	}
+}
+
+// execute runs a command and writes its reply. It returns true if the
+// connection is to be closed, because the client asked for it or because
+// the rest of the stream cannot be trusted.
+func (ms *memcachedServer) execute(c *memcachedConn, args []string) bool {
+	if len(args) == 0 {
+		c.w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	debugf("memcached: received command %v", args[0])
+
+	switch args[0] {
+	case "get", "gets":
+		ms.get(c, args)
+	case "set", "add", "replace", "cas":
+		return ms.store(c, args)
+	case "delete":
+		ms.delete(c, args)
+	case "incr", "decr":
+		ms.incrDecr(c, args)
+	case "version":
+		c.w.WriteString("VERSION " + memcachedVersion + "\r\n")
+	case "quit":
+		return true
+	default:
+		c.w.WriteString("ERROR\r\n")
+	}
+
+	return false
+}
+
+func (c *memcachedConn) reply(noreply bool, s string) {
+	if !noreply {
+		c.w.WriteString(s + "\r\n")
+	}
+}
+
+// writeCode replies with the error for a code that commands do not handle
+// themselves.
+func (c *memcachedConn) writeCode(code ErrorCode) {
+	if code == DatabaseClosed {
+		c.w.WriteString("SERVER_ERROR server is shutting down\r\n")
+	} else if code == ReadOnly {
+		c.w.WriteString("SERVER_ERROR server is a read-only replica\r\n")
+	} else if code == NotLeader {
+		c.w.WriteString("SERVER_ERROR not the leader of the cluster\r\n")
+	} else if code == WrongShard {
+		c.w.WriteString("SERVER_ERROR key belongs to another shard\r\n")
+	} else {
+		c.w.WriteString("SERVER_ERROR internal error\r\n")
+	}
+}
+
+// validKey checks a key against the limits of memcached and of the server,
+// and replies with an error if it is not valid.
+func (ms *memcachedServer) validKey(c *memcachedConn, key string) bool {
+	if len(key) > memcachedMaxKeySize {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+
+	if keyValid, errmsg := ms.s.isKeyValid(key); !keyValid {
+		c.w.WriteString("CLIENT_ERROR " + errmsg + "\r\n")
+		return false
+	}
+
+	return true
+}
+
+// casToken returns the cas unique of an entry, which changes whenever the
+// entry does.
+func casToken(e entry) uint64 {
+	h := fnv.New64a()
+
+	var metadata [12]byte
+	binary.LittleEndian.PutUint32(metadata[:4], e.flags)
+	binary.LittleEndian.PutUint64(metadata[4:], uint64(e.expiresAt))
+
+	h.Write(metadata[:])
+	io.WriteString(h, e.value)
+
+	return h.Sum64()
+}
+
+// expiresAt returns the expiry time in Unix milliseconds of an exptime,
+// which is 0 for no expiry, a number of seconds from now, or a Unix time.
+// Negative exptimes have already expired.
+func expiresAt(exptime int64) int64 {
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime < 0:
+		return 1
+	case exptime > memcachedMaxRelativeExptime:
+		return exptime * 1000
+	default:
+		return time.Now().Add(time.Duration(exptime) * time.Second).UnixMilli()
+	}
+}
+
+// get implements get and gets <key>*.
+func (ms *memcachedServer) get(c *memcachedConn, args []string) {
+	if len(args) < 2 {
+		c.w.WriteString("ERROR\r\n")
+		return
+	}
+
+	for _, key := range args[1:] {
+		if !ms.validKey(c, key) {
+			return
+		}
+	}
+
+	for _, key := range args[1:] {
+		e, code := ms.s.db.getEntry(context.Background(), key)
+
+		if code == KeyNotFound {
+			continue
+		} else if code != OK {
+			c.writeCode(code)
+			return
+		}
+
+		c.w.WriteString("VALUE " + key + " " + strconv.FormatUint(uint64(e.flags), 10) + " " + strconv.Itoa(len(e.value)))
+		if args[0] == "gets" {
+			c.w.WriteString(" " + strconv.FormatUint(casToken(e), 10))
+		}
+		c.w.WriteString("\r\n" + e.value + "\r\n")
+	}
+
+	c.w.WriteString("END\r\n")
+}
+
+// store implements the storage commands:
+//
+//	set|add|replace <key> <flags> <exptime> <bytes> [noreply]
+//	cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+//
+// followed by a line with the data.
+func (ms *memcachedServer) store(c *memcachedConn, args []string) bool {
+	command := args[0]
+
+	n := 5
+	if command == "cas" {
+		n = 6
+	}
+
+	if len(args) != n && len(args) != n+1 {
+		c.w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	noreply := len(args) == n+1 && args[n] == "noreply"
+
+	key := args[1]
+	flags, flagsErr := strconv.ParseUint(args[2], 10, 32)
+	exptime, exptimeErr := strconv.ParseInt(args[3], 10, 64)
+	size, sizeErr := strconv.Atoi(args[4])
+
+	var cas uint64
+	var casErr error
+	if command == "cas" {
+		cas, casErr = strconv.ParseUint(args[5], 10, 64)
+	}
+
+	if flagsErr != nil || exptimeErr != nil || sizeErr != nil || casErr != nil || size < 0 ||
+		(len(args) == n+1 && !noreply) {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+
+	if ms.s.maxValueSize > 0 && size > ms.s.maxValueSize {
+		// skip the data to stay in sync with the client
+		if _, err := c.r.Discard(size + 2); err != nil {
+			return true
+		}
+		c.w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return false
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return true
+	}
+
+	if string(data[size:]) != "\r\n" {
+		c.w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return true
+	}
+
+	if !ms.validKey(c, key) {
+		return false
+	}
+
+	e := entry{value: string(data[:size]), expiresAt: expiresAt(exptime), flags: uint32(flags)}
+
+	var code ErrorCode
+
+	switch command {
+	case "set":
+		code = ms.s.db.setEntries(context.Background(), change{key: key, entry: e})
+	case "add", "replace":
+		code = ms.s.db.updateKey(context.Background(), key, func(_ entry, found bool) (entry, ErrorCode) {
+			if found != (command == "replace") {
+				return entry{}, ConditionFailed
+			}
+			return e, OK
+		})
+	case "cas":
+		code = ms.s.db.updateKey(context.Background(), key, func(current entry, found bool) (entry, ErrorCode) {
+			if !found {
+				return entry{}, KeyNotFound
+			}
+			if casToken(current) != cas {
+				return entry{}, ConditionFailed
+			}
+			return e, OK
+		})
+	}
+
+	switch {
+	case code == OK:
+		c.reply(noreply, "STORED")
+	case code == ConditionFailed && command == "cas":
+		c.reply(noreply, "EXISTS")
+	case code == ConditionFailed:
+		c.reply(noreply, "NOT_STORED")
+	case code == KeyNotFound:
+		c.reply(noreply, "NOT_FOUND")
+	default:
+		c.writeCode(code)
+	}
+
+	return false
+}
+
+// delete implements delete <key> [noreply].
+func (ms *memcachedServer) delete(c *memcachedConn, args []string) {
+	if len(args) != 2 && !(len(args) == 3 && args[2] == "noreply") {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+
+	noreply := len(args) == 3
+
+	if !ms.validKey(c, args[1]) {
+		return
+	}
+
+	deleted, code := ms.s.db.deleteKeys(context.Background(), args[1])
+	if code != OK {
+		c.writeCode(code)
+		return
+	}
+
+	if deleted == 1 {
+		c.reply(noreply, "DELETED")
+	} else {
+		c.reply(noreply, "NOT_FOUND")
+	}
+}
+
+// incrDecr implements incr|decr <key> <delta> [noreply]. Values are 64-bit
+// unsigned integers: incr wraps around, and decr stops at 0.
+func (ms *memcachedServer) incrDecr(c *memcachedConn, args []string) {
+	if len(args) != 3 && !(len(args) == 4 && args[3] == "noreply") {
+		c.w.WriteString("ERROR\r\n")
+		return
+	}
+
+	noreply := len(args) == 4
+	key := args[1]
+
+	delta, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		c.w.WriteString("CLIENT_ERROR invalid numeric delta argument\r\n")
+		return
+	}
+
+	if !ms.validKey(c, key) {
+		return
+	}
+
+	var n uint64
+
+	code := ms.s.db.updateKey(context.Background(), key, func(current entry, found bool) (entry, ErrorCode) {
+		if !found {
+			return entry{}, KeyNotFound
+		}
+
+		var err error
+		n, err = strconv.ParseUint(current.value, 10, 64)
+		if err != nil {
+			return entry{}, ConditionFailed
+		}
+
+		switch {
+		case args[0] == "incr":
+			n += delta
+		case delta > n:
+			n = 0
+		default:
+			n -= delta
+		}
+
+		current.value = strconv.FormatUint(n, 10)
+		return current, OK
+	})
+
+	switch code {
+	case OK:
+		c.reply(noreply, strconv.FormatUint(n, 10))
+	case KeyNotFound:
+		c.reply(noreply, "NOT_FOUND")
+	case ConditionFailed:
+		c.w.WriteString("CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+	default:
+		c.writeCode(code)
+	}
+}
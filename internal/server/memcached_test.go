@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memcachedClient is a minimal memcached text protocol client.
+type memcachedClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startMemcachedServer(t *testing.T) (*server, *memcachedClient) {
+	t.Helper()
+	t.Cleanup(deleteDatabase)
+
+	s := getServer()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms := newMemcachedServer(s, lis)
+	go ms.serve()
+	t.Cleanup(ms.gracefulStop)
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return s, &memcachedClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends a request and reads the lines of its reply, up to the given
+// number of lines.
+func (c *memcachedClient) do(request string, lines int) string {
+	c.t.Helper()
+
+	if _, err := io.WriteString(c.conn, request); err != nil {
+		c.t.Fatal(err)
+	}
+
+	var reply strings.Builder
+	for i := 0; i < lines; i++ {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		reply.WriteString(line)
+	}
+
+	return reply.String()
+}
+
+func Test_memcachedServer(t *testing.T) {
+	_, c := startMemcachedServer(t)
+
+	tests := []struct {
+		name    string
+		request string
+		lines   int
+		want    string
+	}{
+		{"Get missing key", "get key\r\n", 1, "END\r\n"},
+		{"Set", "set key 42 0 5\r\nvalue\r\n", 1, "STORED\r\n"},
+		{"Get", "get key\r\n", 3, "VALUE key 42 5\r\nvalue\r\nEND\r\n"},
+		{"Add existing key", "add key 0 0 5\r\nother\r\n", 1, "NOT_STORED\r\n"},
+		{"Add", "add new 0 0 3\r\nnew\r\n", 1, "STORED\r\n"},
+		{"Replace missing key", "replace missing 0 0 1\r\nx\r\n", 1, "NOT_STORED\r\n"},
+		{"Replace", "replace new 0 0 4\r\nnew2\r\n", 1, "STORED\r\n"},
+		{"Get several keys", "get key missing new\r\n", 5, "VALUE key 42 5\r\nvalue\r\nVALUE new 0 4\r\nnew2\r\nEND\r\n"},
+		{"Cas with stale token", "cas key 0 0 5 1\r\nother\r\n", 1, "EXISTS\r\n"},
+		{"Cas missing key", "cas missing 0 0 1 1\r\nx\r\n", 1, "NOT_FOUND\r\n"},
+		{"Incr", "set counter 7 0 2\r\n10\r\nincr counter 5\r\n", 2, "STORED\r\n15\r\n"},
+		{"Decr stops at 0", "decr counter 20\r\n", 1, "0\r\n"},
+		{"Incr keeps flags", "get counter\r\n", 3, "VALUE counter 7 1\r\n0\r\nEND\r\n"},
+		{"Incr wraps around", "set counter 0 0 20\r\n18446744073709551615\r\nincr counter 2\r\n", 2, "STORED\r\n1\r\n"},
+		{"Incr non-numeric value", "incr key 1\r\n", 1, "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+		{"Incr missing key", "incr missing 1\r\n", 1, "NOT_FOUND\r\n"},
+		{"Delete", "delete key\r\n", 1, "DELETED\r\n"},
+		{"Delete missing key", "delete key\r\n", 1, "NOT_FOUND\r\n"},
+		{"Noreply", "set quiet 0 0 1 noreply\r\nq\r\nget quiet\r\n", 3, "VALUE quiet 0 1\r\nq\r\nEND\r\n"},
+		{"Negative exptime", "set gone 0 -1 1\r\nx\r\nget gone\r\n", 2, "STORED\r\nEND\r\n"},
+		{"Bad command line", "set key flags 0 1\r\n", 1, "CLIENT_ERROR bad command line format\r\n"},
+		{"Key too long", "get " + strings.Repeat("k", 251) + "\r\n", 1, "CLIENT_ERROR bad command line format\r\n"},
+		{"Unknown command", "flush_all\r\n", 1, "ERROR\r\n"},
+		{"Version", "version\r\n", 1, "VERSION " + memcachedVersion + "\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.t = t
+
+			if got := c.do(tt.request, tt.lines); got != tt.want {
+				t.Errorf("got = %q, want = %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_memcachedServer_cas(t *testing.T) {
+	_, c := startMemcachedServer(t)
+
+	c.do("set key 1 0 5\r\nvalue\r\n", 1)
+
+	reply := c.do("gets key\r\n", 3)
+	fields := strings.Fields(strings.SplitN(reply, "\r\n", 2)[0])
+	if len(fields) != 5 {
+		t.Fatalf("got = %q, want = VALUE line with a cas unique", reply)
+	}
+	cas := fields[4]
+
+	if got := c.do("cas key 2 0 6 "+cas+"\r\nvalue2\r\n", 1); got != "STORED\r\n" {
+		t.Errorf("got = %q, want = STORED", got)
+	}
+
+	// the token changed with the value
+	if got := c.do("cas key 3 0 6 "+cas+"\r\nvalue3\r\n", 1); got != "EXISTS\r\n" {
+		t.Errorf("got = %q, want = EXISTS", got)
+	}
+
+	if got := c.do("get key\r\n", 3); got != "VALUE key 2 6\r\nvalue2\r\nEND\r\n" {
+		t.Errorf("got = %q, want = the value set by cas", got)
+	}
+}
+
+func Test_memcachedServer_exptime(t *testing.T) {
+	s, c := startMemcachedServer(t)
+
+	c.do("set short 0 1 5\r\nvalue\r\n", 1)
+	c.do("set absolute 0 4102444800 5\r\nvalue\r\n", 1)
+
+	e, code := s.db.getEntry(context.Background(), "absolute")
+	if code != OK || e.expiresAt != 4102444800000 {
+		t.Errorf("got = %+v and code %v, want an expiry time of 4102444800000", e, code)
+	}
+
+	if got := c.do("get short\r\n", 3); got != "VALUE short 0 5\r\nvalue\r\nEND\r\n" {
+		t.Errorf("got = %q before expiry", got)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if got := c.do("get short\r\n", 1); got != "END\r\n" {
+		t.Errorf("got = %q after expiry, want = END", got)
+	}
+}
+
+func Test_memcachedServer_limits(t *testing.T) {
+	s, c := startMemcachedServer(t)
+	s.maxValueSize = 4
+
+	// the data of a value that is too large is skipped
+	got := c.do("set key 0 0 5\r\nvalue\r\nget key\r\n", 2)
+	if got != "SERVER_ERROR object too large for cache\r\nEND\r\n" {
+		t.Errorf("got = %q, want = too large error", got)
+	}
+
+	// the connection is closed when the data does not match its length
+	if got := c.do("set key 0 0 2\r\nvalue\r\n", 1); got != "CLIENT_ERROR bad data chunk\r\n" {
+		t.Errorf("got = %q, want = bad data chunk", got)
+	}
+
+	if _, err := c.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("error = %v, want = %v", err, io.EOF)
+	}
+}
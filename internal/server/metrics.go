@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const metricsNamespace = "simple_database"
+
+// metrics are the Prometheus metrics of the gRPC requests and of the
+// storage of a database.
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+}
+
+// newMetrics registers the metrics of d, and makes d keep the storage
+// stats and fsync latencies that they report. It must be called before d
+// is initialized.
+func newMetrics(d *database) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "grpc_requests_total",
+				Help:      "gRPC requests handled, by method and status code.",
+			},
+			[]string{"method", "code"},
+		),
+		requestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: metricsNamespace,
+				Name:      "grpc_request_duration_seconds",
+				Help:      "Time taken to handle gRPC requests, by method.",
+				Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+			},
+			[]string{"method"},
+		),
+	}
+
+	fsyncDuration := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "fsync_duration_seconds",
+		Help:      "Time taken to flush the database file to disk.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	})
+
+	d.storage = &storageStats{}
+	d.observeFsync = func(duration time.Duration) {
+		fsyncDuration.Observe(duration.Seconds())
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		fsyncDuration,
+		prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: metricsNamespace,
+				Name:      "keys",
+				Help:      "Keys in the database, including expired keys not yet compacted away.",
+			},
+			func() float64 { return float64(d.storage.keys.Load()) },
+		),
+		prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: metricsNamespace,
+				Name:      "file_size_bytes",
+				Help:      "Size of the database file.",
+			},
+			func() float64 {
+				info, err := os.Stat(d.filepath)
+				if err != nil {
+					return 0
+				}
+				return float64(info.Size())
+			},
+		),
+		prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: metricsNamespace,
+				Name:      "stale_bytes",
+				Help:      "Bytes of overwritten records and tombstones, which compaction reclaims.",
+			},
+			func() float64 { return float64(d.storage.staleBytes.Load()) },
+		),
+		prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "compactions_total",
+				Help:      "Compactions since the server started.",
+			},
+			func() float64 { return float64(d.compactions.Load()) },
+		),
+	)
+
+	return m
+}
+
+// registerReplica registers the metrics of the replication of a replica.
+func (m *metrics) registerReplica(r *replica) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: metricsNamespace,
+				Name:      "replica_connected",
+				Help:      "1 if the replica is connected to its primary, 0 otherwise.",
+			},
+			func() float64 {
+				if connected, _, _ := r.lag(); connected {
+					return 1
+				}
+				return 0
+			},
+		),
+		prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: metricsNamespace,
+				Name:      "replication_lag_bytes",
+				Help:      "Bytes of the database file of the primary that the replica does not have yet.",
+			},
+			func() float64 {
+				_, lagBytes, _ := r.lag()
+				return float64(lagBytes)
+			},
+		),
+		prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: metricsNamespace,
+				Name:      "replication_lag_seconds",
+				Help:      "Time since the replica was last caught up with its primary, 0 while it is.",
+			},
+			func() float64 {
+				_, _, lag := r.lag()
+				return lag.Seconds()
+			},
+		),
+	)
+}
+
+// unaryInterceptor counts gRPC requests and times them.
+func (m *metrics) unaryInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	start := time.Now()
+
+	reply, err := handler(ctx, req)
+
+	m.requestDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+	m.requests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+
+	return reply, err
+}
+
+func newMetricsFrontEnd(m *metrics, lis net.Listener) *httpFrontEnd {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+
+	return &httpFrontEnd{srv: &http.Server{Handler: mux}, lis: lis}
+}
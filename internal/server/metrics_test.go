@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+func getServerWithMetrics() *server {
+	db := &database{filepath: testDatabasePath, initialized: false}
+	s := &server{db: db, metrics: newMetrics(db)}
+	s.initialize()
+	return s
+}
+
+func Test_storageStats(t *testing.T) {
+	t.Cleanup(deleteDatabase)
+
+	s := getServerWithMetrics()
+
+	s.db.setKey("a", "1")
+	s.db.setKey("b", "2")
+	s.db.setKey("c", "3")
+	s.db.setKey("a", "11")
+	s.db.setEntries(context.Background(), change{key: "b", entry: entry{value: "22"}}, change{key: "b", entry: entry{value: "222"}})
+	s.db.deleteKeys(context.Background(), "c")
+	s.db.deleteKeys(context.Background(), "c")
+
+	keys, staleBytes := s.db.storage.keys.Load(), s.db.storage.staleBytes.Load()
+	if keys != 2 {
+		t.Errorf("keys = %d, want = 2", keys)
+	}
+
+	// the database starts counting from the file when it is reopened
+	s.db.close()
+	reopened := getServerWithMetrics()
+
+	if got := reopened.db.storage.keys.Load(); got != keys {
+		t.Errorf("keys = %d after reopening, want = %d", got, keys)
+	}
+	if got := reopened.db.storage.staleBytes.Load(); got != staleBytes {
+		t.Errorf("stale bytes = %d after reopening, want = %d", got, staleBytes)
+	}
+
+	// stale bytes are what compaction reclaims
+	result, code := reopened.db.compact()
+	if code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+
+	if reclaimed := result.bytesBefore - result.bytesAfter; staleBytes != reclaimed {
+		t.Errorf("stale bytes = %d, want = %d reclaimed by compaction", staleBytes, reclaimed)
+	}
+
+	if got := reopened.db.storage.staleBytes.Load(); got != 0 {
+		t.Errorf("stale bytes = %d after compaction, want = 0", got)
+	}
+}
+
+func Test_metrics_unaryInterceptor(t *testing.T) {
+	t.Cleanup(deleteDatabase)
+
+	s := getServerWithMetrics()
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/server.Database/Get"}
+
+	for _, err := range []error{nil, status.Error(codes.NotFound, "not found"), status.Error(codes.NotFound, "not found")} {
+		s.metrics.unaryInterceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+			return nil, err
+		})
+	}
+
+	tests := []struct {
+		code codes.Code
+		want float64
+	}{
+		{codes.OK, 1},
+		{codes.NotFound, 2},
+		{codes.Internal, 0},
+	}
+	for _, tt := range tests {
+		got := testutil.ToFloat64(s.metrics.requests.WithLabelValues(info.FullMethod, tt.code.String()))
+		if got != tt.want {
+			t.Errorf("requests with code %v = %v, want = %v", tt.code, got, tt.want)
+		}
+	}
+
+	if got := testutil.CollectAndCount(s.metrics.requestDuration); got != 1 {
+		t.Errorf("request duration series = %d, want = 1", got)
+	}
+}
+
+func Test_run_metrics(t *testing.T) {
+	metricsAddr := freeAddr(t)
+
+	cfg, err := ParseConfig(
+		[]string{
+			"-addr", "localhost:0",
+			"-metrics-addr", metricsAddr,
+			"-data-dir", t.TempDir(),
+			"-durability", "fsync",
+			"-log-level", "error",
+		},
+		io.Discard,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	addrs := make(chan net.Addr, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, cfg, func(addr net.Addr) { addrs <- addr })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	conn, err := grpc.Dial((<-addrs).String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	requestCtx, requestCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer requestCancel()
+
+	client := pb.NewDatabaseClient(conn)
+	client.Set(requestCtx, &pb.SetRequest{Key: "key", Value: "value"})
+	client.Get(requestCtx, &pb.GetRequest{Key: "missing"})
+
+	resp, body := httpTestClient{t: t, baseURL: "http://" + metricsAddr}.do("GET", "/metrics", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want = %d", resp.StatusCode, http.StatusOK)
+	}
+
+	for _, want := range []string{
+		`simple_database_grpc_requests_total{code="OK",method="/server.Database/Set"} 1`,
+		`simple_database_grpc_requests_total{code="NotFound",method="/server.Database/Get"} 1`,
+		`simple_database_grpc_request_duration_seconds_count{method="/server.Database/Set"} 1`,
+		`simple_database_keys 1`,
+		`simple_database_stale_bytes 0`,
+		`simple_database_compactions_total 0`,
+		`simple_database_fsync_duration_seconds_count 1`,
+		`simple_database_file_size_bytes 10`,
+	} {
+		if !strings.Contains(body+"\n", want+"\n") {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
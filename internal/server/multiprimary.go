@@ -0,0 +1,459 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+// In multi-primary mode, every node accepts writes, and follows the
+// database files of its peers as a replica follows its primary. Rather than
+// appending the records of a peer as they are, a node merges them: every
+// record is stamped with a hybrid logical clock timestamp and the id of the
+// node it was written on, and a record only replaces the one a node has of
+// its key if its stamp is later. Nodes thus end up with the same latest
+// record of every key whatever order they see the writes in, and concurrent
+// writes to a key resolve to the same one everywhere.
+//
+// Deletions are tombstones, which are merged like any other record.
+// Compaction keeps them, and the records of expired keys, for the tombstone
+// retention, so that a peer that has not seen them yet does not bring the
+// keys back with an older write. A peer that stays away for longer than
+// that may do so.
+const (
+	peerPositionsSuffix = ".peers"
+
+	// hlcLogicalBits is the number of low bits of timestamps that hold the
+	// logical counter; the others hold the wall clock in milliseconds.
+	hlcLogicalBits = 16
+)
+
+var nodeIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// hlc is a hybrid logical clock. Its timestamps follow the wall clock, but
+// are later than every timestamp it made or observed before, so that a
+// write is stamped later than the writes that its node had seen, even if
+// the clocks of the nodes differ.
+type hlc struct {
+	mu   sync.Mutex
+	last uint64
+}
+
+// now returns a new timestamp.
+func (c *hlc) now() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	physical := uint64(time.Now().UnixMilli()) << hlcLogicalBits
+	if physical > c.last {
+		c.last = physical
+	} else {
+		c.last++
+	}
+
+	return c.last
+}
+
+// observe makes the clock later than a timestamp of another node.
+func (c *hlc) observe(timestamp uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.last = max(c.last, timestamp)
+}
+
+// latest returns the latest timestamp that the clock stamped or observed.
+func (c *hlc) latest() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.last
+}
+
+// timestampTime returns the wall clock time of a timestamp.
+func timestampTime(timestamp uint64) time.Time {
+	return time.UnixMilli(int64(timestamp >> hlcLogicalBits))
+}
+
+// stamp is when and where a change was made. Records written before
+// multi-primary mode was enabled have the zero stamp.
+type stamp struct {
+	time   uint64
+	origin string
+}
+
+// after reports whether s wins over other: the later timestamp wins, and
+// the larger origin breaks ties.
+func (s stamp) after(other stamp) bool {
+	if s.time != other.time {
+		return s.time > other.time
+	}
+
+	return s.origin > other.origin
+}
+
+// stamp returns the changes of a local write stamped with a new timestamp.
+func (d *database) stamp(changes []change) []change {
+	stamped := make([]change, len(changes))
+	for i, c := range changes {
+		c.stamp = stamp{time: d.clock.now(), origin: d.nodeID}
+		stamped[i] = c
+	}
+
+	return stamped
+}
+
+// retainsTombstone reports whether compaction keeps the record of a deleted
+// or expired key with the given header.
+func (d *database) retainsTombstone(h recordHeader, now time.Time) bool {
+	if d.clock == nil {
+		return false
+	}
+
+	return now.Sub(timestampTime(h.timestamp)) < d.tombstoneRetention
+}
+
+// storedStamp returns the stamp of the latest record of a key, including
+// tombstones, and whether there is one. The caller must hold the lock.
+func (d *database) storedStamp(ctx context.Context, key string) (stamp, bool, ErrorCode) {
+	found, pos, code := d.getKeyPosition(key)
+	if code != OK || !found {
+		return stamp{}, false, code
+	}
+
+	record, code := d.readRecordAt(ctx, pos)
+	if code != OK {
+		return stamp{}, false, code
+	}
+
+	_, header, code := decodeRecordKey(record, d.keys)
+	if code != OK {
+		return stamp{}, false, code
+	}
+
+	return stamp{time: header.timestamp, origin: header.origin}, true, OK
+}
+
+// mergeChanges writes the changes made on peers that win over the records
+// that the database has of their keys, and returns how many it wrote.
+func (d *database) mergeChanges(ctx context.Context, changes []change) (int, ErrorCode) {
+	d.ensureInitialized()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return 0, DatabaseClosed
+	}
+
+	// a key may change several times among the changes
+	latest := make(map[string]stamp)
+	var merged []change
+
+	for _, c := range changes {
+		d.clock.observe(c.stamp.time)
+
+		current, found := latest[c.key]
+		if !found {
+			var code ErrorCode
+			current, found, code = d.storedStamp(ctx, c.key)
+			if code != OK {
+				return 0, code
+			}
+		}
+
+		if found && !c.stamp.after(current) {
+			continue
+		}
+
+		latest[c.key] = c.stamp
+		merged = append(merged, c)
+	}
+
+	if len(merged) == 0 {
+		return 0, OK
+	}
+
+	return len(merged), d.apply(ctx, merged)
+}
+
+// decodeChanges decodes the records of a chunk of the database file of a
+// peer.
+func (d *database) decodeChanges(data []byte) ([]change, ErrorCode) {
+	csvReader := newCSVReader(bytes.NewReader(data))
+
+	var changes []change
+	for {
+		record, err := csvReader.Read()
+
+		if err == io.EOF {
+			return changes, OK
+		} else if err != nil {
+			log.Printf("Error while reading the records of a peer: %v", err)
+			return nil, InternalError
+		}
+
+		key, value, header, code := decodeRecord(record, d.keys, d.maxValueSize)
+		if code != OK {
+			return nil, code
+		}
+
+		changes = append(changes, change{
+			key:     key,
+			entry:   newEntry(value, header),
+			deleted: header.deleted,
+			stamp:   stamp{time: header.timestamp, origin: header.origin},
+		})
+	}
+}
+
+// peerPosition is how much of the database file of a peer was merged.
+type peerPosition struct {
+	Epoch  string `json:"epoch"`
+	Offset int64  `json:"offset"`
+}
+
+// multiPrimary merges the writes of the peers of the node into its
+// database.
+type multiPrimary struct {
+	db    *database
+	peers []*peer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// token authenticates the node to its peers, if it is not empty.
+	token string
+
+	// positions are kept in a file next to the database file, so that a
+	// node that restarts resumes where it stopped.
+	mu        sync.Mutex
+	positions map[string]peerPosition
+}
+
+// peer is another node whose database file is merged.
+type peer struct {
+	mp   *multiPrimary
+	addr string
+	conn *grpc.ClientConn
+
+	mu        sync.Mutex
+	connected bool
+	// applied is how much of the file of the peer was merged, and peerSize
+	// how much the peer had when it last said so.
+	applied  int64
+	peerSize int64
+}
+
+// newMultiPrimary returns the merging of the peers in cfg into d. It
+// presents the certificate of certs to the peers if the connections use
+// TLS.
+func newMultiPrimary(d *database, cfg *Config, certs *certReloader) (*multiPrimary, error) {
+	creds, err := peerCredentials(cfg.replicationTLSCA != "", cfg.replicationTLSCA, certs)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mp := &multiPrimary{db: d, token: cfg.replicationToken, ctx: ctx, cancel: cancel, positions: make(map[string]peerPosition)}
+
+	if err := mp.loadPositions(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	for _, addr := range cfg.multiPrimaryPeers {
+		conn, err := grpc.NewClient(
+			addr,
+			grpc.WithTransportCredentials(creds),
+			// a chunk ends with a whole record, which encoding may make up
+			// to twice as large as its key and value
+			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(replicationChunkSize+2*(cfg.maxKeySize+cfg.maxValueSize)+1024)),
+		)
+		if err != nil {
+			mp.stop()
+			return nil, err
+		}
+
+		mp.peers = append(mp.peers, &peer{mp: mp, addr: addr, conn: conn})
+	}
+
+	return mp, nil
+}
+
+func (mp *multiPrimary) loadPositions() error {
+	contents, err := os.ReadFile(mp.db.filepath + peerPositionsSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read the positions of the peers: %w", err)
+	}
+
+	if err := json.Unmarshal(contents, &mp.positions); err != nil {
+		return fmt.Errorf("failed to parse the positions of the peers: %w", err)
+	}
+
+	return nil
+}
+
+func (mp *multiPrimary) position(addr string) peerPosition {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.positions[addr]
+}
+
+// setPosition records how much of the file of a peer was merged.
+func (mp *multiPrimary) setPosition(addr string, position peerPosition) ErrorCode {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.positions[addr] = position
+
+	contents, err := json.Marshal(mp.positions)
+	if err != nil {
+		log.Printf("Failed to encode the positions of the peers: %v", err)
+		return InternalError
+	}
+
+	path := mp.db.filepath + peerPositionsSuffix
+	tmpPath := path + compactionSuffix
+
+	if err := os.WriteFile(tmpPath, contents, 0o644); err != nil {
+		log.Printf("Failed to write the positions of the peers: %v", err)
+		return InternalError
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		log.Printf("Failed to replace the positions of the peers: %v", err)
+		return InternalError
+	}
+
+	return OK
+}
+
+// serve merges the writes of every peer until the node stops.
+func (mp *multiPrimary) serve() error {
+	var wg sync.WaitGroup
+	for _, p := range mp.peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.serve()
+		}()
+	}
+
+	wg.Wait()
+	return nil
+}
+
+func (mp *multiPrimary) gracefulStop() {
+	mp.stop()
+}
+
+// stop disconnects from the peers. Records being merged when it is called
+// are either merged in full or not at all.
+func (mp *multiPrimary) stop() {
+	mp.cancel()
+	for _, p := range mp.peers {
+		p.conn.Close()
+	}
+}
+
+// serve follows the peer, reconnecting whenever the stream breaks, until
+// the node stops.
+func (p *peer) serve() {
+	for {
+		err := p.follow()
+
+		p.mu.Lock()
+		p.connected = false
+		p.mu.Unlock()
+
+		if p.mp.ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("Merging the writes of peer %s failed, retrying in %v: %v", p.addr, replicaRetryDelay, err)
+
+		select {
+		case <-time.After(replicaRetryDelay):
+		case <-p.mp.ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *peer) follow() error {
+	position := p.mp.position(p.addr)
+
+	ctx := p.mp.ctx
+	if p.mp.token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+p.mp.token)
+	}
+
+	in := &pb.StreamRequest{Epoch: position.Epoch, Offset: position.Offset}
+	stream, err := pb.NewReplicationClient(p.conn).Stream(ctx, in)
+	if err != nil {
+		return err
+	}
+
+	for {
+		reply, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		if reply.Reset_ {
+			infof("Multi-primary: merging the database file of peer %s from the start", p.addr)
+		}
+
+		changes, code := p.mp.db.decodeChanges(reply.Data)
+		if code != OK {
+			return fmt.Errorf("failed to decode the records of the peer: error code %d", code)
+		}
+
+		merged, code := p.mp.db.mergeChanges(p.mp.ctx, changes)
+		if code != OK {
+			return fmt.Errorf("failed to merge the records of the peer: error code %d", code)
+		}
+
+		if merged > 0 {
+			debugf("Multi-primary: merged %d of %d records of peer %s", merged, len(changes), p.addr)
+		}
+
+		applied := reply.Offset + int64(len(reply.Data))
+		if code := p.mp.setPosition(p.addr, peerPosition{Epoch: reply.Epoch, Offset: applied}); code != OK {
+			return fmt.Errorf("failed to save the position of the peer: error code %d", code)
+		}
+
+		p.mu.Lock()
+		p.connected = true
+		p.applied = applied
+		p.peerSize = reply.PrimarySize
+		p.mu.Unlock()
+	}
+}
+
+// lag returns whether the node is connected to the peer, and how many bytes
+// of the file of the peer it has yet to merge.
+func (p *peer) lag() (bool, int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.connected, max(p.peerSize-p.applied, 0)
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+func openMultiPrimaryDatabase(t *testing.T, nodeID string) *database {
+	t.Helper()
+
+	d := &database{
+		filepath:           filepath.Join(t.TempDir(), databaseFileName),
+		clock:              &hlc{},
+		nodeID:             nodeID,
+		tombstoneRetention: time.Hour,
+	}
+	if code := d.initialize(); code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+	t.Cleanup(func() { d.close() })
+
+	return d
+}
+
+func Test_hlc(t *testing.T) {
+	c := &hlc{}
+
+	first := c.now()
+	if second := c.now(); second <= first {
+		t.Errorf("second timestamp %d is not after %d", second, first)
+	}
+
+	// a peer whose clock is ahead
+	ahead := uint64(time.Now().Add(time.Hour).UnixMilli()) << hlcLogicalBits
+	c.observe(ahead)
+
+	if next := c.now(); next <= ahead {
+		t.Errorf("timestamp %d is not after the observed %d", next, ahead)
+	}
+
+	if got := timestampTime(first); time.Since(got) > time.Minute {
+		t.Errorf("time of timestamp = %v, want about now", got)
+	}
+}
+
+func Test_database_reopen_clock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), databaseFileName)
+	open := func() *database {
+		d := &database{filepath: path, clock: &hlc{}, nodeID: "a", indexMode: indexModeDisk}
+		if code := d.initialize(); code != OK {
+			t.Fatalf("code = %v, want = OK", code)
+		}
+		return d
+	}
+
+	// a write stamped while the clock was ahead of the wall clock
+	d := open()
+	ahead := uint64(time.Now().Add(time.Hour).UnixMilli()) << hlcLogicalBits
+	d.clock.observe(ahead)
+	if code := d.setKey("key", "value"); code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+	if code := d.close(); code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+
+	// the disk index covers the write, which is not read again
+	d = open()
+	t.Cleanup(func() { d.close() })
+
+	if next := d.clock.now(); next <= ahead {
+		t.Errorf("timestamp %d after reopening is not after the stamped %d", next, ahead)
+	}
+}
+
+func Test_database_mergeChanges(t *testing.T) {
+	ctx := context.Background()
+	d := openMultiPrimaryDatabase(t, "b")
+
+	if code := d.setKey("key", "local"); code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+
+	local, _, code := d.storedStamp(ctx, "key")
+	if code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+
+	if local.origin != "b" || local.time == 0 {
+		t.Fatalf("stamp of local write = %+v, want one of node b", local)
+	}
+
+	tests := []struct {
+		name       string
+		change     change
+		wantMerged int
+		wantValue  string
+		wantCode   ErrorCode
+	}{
+		{
+			name:       "Earlier write",
+			change:     change{key: "key", entry: entry{value: "earlier"}, stamp: stamp{time: local.time - 1, origin: "a"}},
+			wantMerged: 0,
+			wantValue:  "local",
+		},
+		{
+			name:       "Concurrent write of a smaller origin",
+			change:     change{key: "key", entry: entry{value: "concurrent"}, stamp: stamp{time: local.time, origin: "a"}},
+			wantMerged: 0,
+			wantValue:  "local",
+		},
+		{
+			name:       "Concurrent write of a larger origin",
+			change:     change{key: "key", entry: entry{value: "concurrent"}, stamp: stamp{time: local.time, origin: "c"}},
+			wantMerged: 1,
+			wantValue:  "concurrent",
+		},
+		{
+			name:       "Later deletion",
+			change:     change{key: "key", deleted: true, stamp: stamp{time: local.time + 1, origin: "a"}},
+			wantMerged: 1,
+			wantCode:   KeyNotFound,
+		},
+		{
+			name:       "Write older than the deletion",
+			change:     change{key: "key", entry: entry{value: "resurrected"}, stamp: stamp{time: local.time - 1, origin: "c"}},
+			wantMerged: 0,
+			wantCode:   KeyNotFound,
+		},
+		{
+			name:       "Same deletion again",
+			change:     change{key: "key", deleted: true, stamp: stamp{time: local.time + 1, origin: "a"}},
+			wantMerged: 0,
+			wantCode:   KeyNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, code := d.mergeChanges(ctx, []change{tt.change})
+			if code != OK {
+				t.Fatalf("code = %v, want = OK", code)
+			}
+
+			if merged != tt.wantMerged {
+				t.Errorf("merged = %d, want = %d", merged, tt.wantMerged)
+			}
+
+			value, code := d.getKey("key")
+			if code != tt.wantCode || value != tt.wantValue {
+				t.Errorf("got = %q, %v, want = %q, %v", value, code, tt.wantValue, tt.wantCode)
+			}
+		})
+	}
+
+	// later local writes win over everything merged so far
+	if code := d.setKey("key", "again"); code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+
+	if again, _, _ := d.storedStamp(ctx, "key"); !again.after(stamp{time: local.time + 1, origin: "a"}) {
+		t.Errorf("stamp of local write %+v is not after the merged deletion", again)
+	}
+}
+
+func Test_database_compact_tombstones(t *testing.T) {
+	ctx := context.Background()
+	d := openMultiPrimaryDatabase(t, "a")
+	d.storage = &storageStats{}
+
+	old := uint64(time.Now().Add(-2*time.Hour).UnixMilli()) << hlcLogicalBits
+	recent := uint64(time.Now().Add(-time.Minute).UnixMilli()) << hlcLogicalBits
+
+	changes := []change{
+		{key: "live", entry: entry{value: "value"}, stamp: stamp{time: old, origin: "b"}},
+		{key: "old", deleted: true, stamp: stamp{time: old, origin: "b"}},
+		{key: "expired", entry: entry{value: "value", expiresAt: 1}, stamp: stamp{time: old, origin: "b"}},
+		{key: "recently-expired", entry: entry{value: "value", expiresAt: 1}, stamp: stamp{time: recent, origin: "b"}},
+	}
+	if _, code := d.mergeChanges(ctx, changes); code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+
+	if _, code := d.deleteKeys(ctx, "live"); code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+
+	result, code := d.compact()
+	if code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+
+	// only the recent tombstones of live and recently-expired are left,
+	// which are not keys
+	if result.recordsAfter != 2 {
+		t.Errorf("records after compaction = %d, want = 2", result.recordsAfter)
+	}
+
+	if keys := d.storage.keys.Load(); keys != 0 {
+		t.Errorf("keys after compaction = %d, want = 0", keys)
+	}
+
+	s, found, code := d.storedStamp(ctx, "live")
+	if code != OK || !found || s.origin != "a" {
+		t.Errorf("stamp of live = %+v, %t, %v, want the tombstone of node a", s, found, code)
+	}
+
+	// the tombstone still keeps the write it replaced from coming back
+	resurrect := []change{{key: "live", entry: entry{value: "value"}, stamp: stamp{time: old, origin: "b"}}}
+	if merged, code := d.mergeChanges(ctx, resurrect); code != OK || merged != 0 {
+		t.Errorf("merged = %d, %v, want = 0, OK", merged, code)
+	}
+}
+
+func Test_run_multiPrimary(t *testing.T) {
+	ctx := context.Background()
+
+	addrA, addrB := freeAddr(t), freeAddr(t)
+	a := dialDatabase(t, runServer(t, "-addr", addrA, "-data-dir", t.TempDir(), "-node-id", "a", "-peers", addrB))
+	b := dialDatabase(t, runServer(t, "-addr", addrB, "-data-dir", t.TempDir(), "-node-id", "b", "-peers", addrA))
+
+	valueOf := func(db pb.DatabaseClient, key string) string {
+		reply, err := db.Get(ctx, &pb.GetRequest{Key: key})
+		if err != nil {
+			return ""
+		}
+		return reply.Value
+	}
+
+	if _, err := a.Set(ctx, &pb.SetRequest{Key: "from-a", Value: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Set(ctx, &pb.SetRequest{Key: "from-b", Value: "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "writes to reach the other node", func() bool {
+		return valueOf(b, "from-a") == "1" && valueOf(a, "from-b") == "2"
+	})
+
+	// both nodes take a write to the same key, and agree on one of them
+	if _, err := a.Set(ctx, &pb.SetRequest{Key: "both", Value: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Set(ctx, &pb.SetRequest{Key: "both", Value: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the nodes to agree", func() bool {
+		value := valueOf(a, "both")
+		return value != "" && value == valueOf(b, "both")
+	})
+
+	stats, err := a.Stats(ctx, &pb.StatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Role != "multi-primary" || len(stats.Peers) != 1 || stats.Peers[0].Addr != addrB {
+		t.Errorf("stats = %+v, want a multi-primary with peer %s", stats, addrB)
+	}
+}
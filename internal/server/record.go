@@ -0,0 +1,327 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Records are stored as rows of the CSV database file. A plain record has
+// two fields, the key and the value. A record that needs to say more about
+// how it is stored has a third field, its header, and then keeps its key
+// and value base64-encoded so that they can hold any bytes.
+//
+// The header is a list of semicolon-separated name=value attributes:
+//
+//	c  the codec the value was compressed with, e.g. c=flate
+//	k  the id of the key the record was encrypted with
+//	x  the time the record expires, in Unix milliseconds
+//	f  opaque flags that clients store along with the value
+//	t  the hybrid logical clock timestamp of the write, in multi-primary
+//	   mode
+//	o  the id of the node that the write was made on, in multi-primary mode
+//	d  d=1 if the record is a tombstone, which marks its key as deleted
+//	b  b=1 if the record has a header only because its key or value is not
+//	   plain text, which CSV cannot hold as is
+//
+// The key and value of an encrypted record are each sealed with AES-GCM
+// under their own random nonce. The header is authenticated along with
+// both, and the key along with the value, so that records cannot be
+// tampered with or have their values swapped.
+type recordHeader struct {
+	codec     string
+	keyID     string
+	expiresAt int64
+	flags     uint32
+	timestamp uint64
+	origin    string
+	deleted   bool
+	binary    bool
+}
+
+const (
+	codecNone  = ""
+	codecFlate = "flate"
+)
+
+func newCSVReader(r io.Reader) *csv.Reader {
+	csvReader := csv.NewReader(r)
+	// plain records and records with a header can be mixed in one file
+	csvReader.FieldsPerRecord = -1
+	return csvReader
+}
+
+func (h recordHeader) isEmpty() bool {
+	return h == recordHeader{}
+}
+
+func (h recordHeader) String() string {
+	var attributes []string
+
+	if h.codec != codecNone {
+		attributes = append(attributes, "c="+h.codec)
+	}
+
+	if h.keyID != "" {
+		attributes = append(attributes, "k="+h.keyID)
+	}
+
+	if h.expiresAt != 0 {
+		attributes = append(attributes, "x="+strconv.FormatInt(h.expiresAt, 10))
+	}
+
+	if h.flags != 0 {
+		attributes = append(attributes, "f="+strconv.FormatUint(uint64(h.flags), 10))
+	}
+
+	if h.timestamp != 0 {
+		attributes = append(attributes, "t="+strconv.FormatUint(h.timestamp, 10))
+	}
+
+	if h.origin != "" {
+		attributes = append(attributes, "o="+h.origin)
+	}
+
+	if h.deleted {
+		attributes = append(attributes, "d=1")
+	}
+
+	if h.binary {
+		attributes = append(attributes, "b=1")
+	}
+
+	return strings.Join(attributes, ";")
+}
+
+func parseRecordHeader(s string) (recordHeader, error) {
+	var h recordHeader
+
+	for _, attribute := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(attribute, "=")
+		if !ok {
+			return h, fmt.Errorf("malformed attribute %q", attribute)
+		}
+
+		switch name {
+		case "c":
+			if value != codecFlate {
+				return h, fmt.Errorf("unknown codec %q", value)
+			}
+			h.codec = value
+		case "k":
+			if !keyIDPattern.MatchString(value) {
+				return h, fmt.Errorf("malformed key id %q", value)
+			}
+			h.keyID = value
+		case "x":
+			expiresAt, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || expiresAt <= 0 {
+				return h, fmt.Errorf("malformed expiry time %q", value)
+			}
+			h.expiresAt = expiresAt
+		case "f":
+			flags, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || flags == 0 {
+				return h, fmt.Errorf("malformed flags %q", value)
+			}
+			h.flags = uint32(flags)
+		case "t":
+			timestamp, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || timestamp == 0 {
+				return h, fmt.Errorf("malformed timestamp %q", value)
+			}
+			h.timestamp = timestamp
+		case "o":
+			if !nodeIDPattern.MatchString(value) {
+				return h, fmt.Errorf("malformed origin %q", value)
+			}
+			h.origin = value
+		case "d":
+			if value != "1" {
+				return h, fmt.Errorf("malformed deletion flag %q", value)
+			}
+			h.deleted = true
+		case "b":
+			if value != "1" {
+				return h, fmt.Errorf("malformed binary flag %q", value)
+			}
+			h.binary = true
+		default:
+			return h, fmt.Errorf("unknown attribute %q", name)
+		}
+	}
+
+	return h, nil
+}
+
+// encodeRecord returns the CSV fields for a key and a value that is stored
+// as described by the header. Records with a key id in their header are
+// encrypted with that key from keys.
+func encodeRecord(key, value string, h recordHeader, keys *keyring) ([]string, ErrorCode) {
+	if h.isEmpty() {
+		return []string{key, value}, OK
+	}
+
+	storedKey, storedValue := []byte(key), []byte(value)
+
+	if h.keyID != "" {
+		var err error
+
+		storedKey, err = keys.seal(h.keyID, storedKey, keyAdditionalData(h))
+		if err != nil {
+			log.Printf("Failed to encrypt key: %v", err)
+			return nil, InternalError
+		}
+
+		storedValue, err = keys.seal(h.keyID, storedValue, valueAdditionalData(h, key))
+		if err != nil {
+			log.Printf("Failed to encrypt value: %v", err)
+			return nil, InternalError
+		}
+	}
+
+	return []string{
+		base64.StdEncoding.EncodeToString(storedKey),
+		base64.StdEncoding.EncodeToString(storedValue),
+		h.String(),
+	}, OK
+}
+
+// isPlainText reports whether s survives a round trip through a CSV field.
+// The CSV reader turns \r\n into \n, so carriage returns are not allowed.
+func isPlainText(s string) bool {
+	return utf8.ValidString(s) && !strings.ContainsRune(s, '\r')
+}
+
+func keyAdditionalData(h recordHeader) []byte {
+	return []byte(h.String())
+}
+
+func valueAdditionalData(h recordHeader, key string) []byte {
+	return []byte(h.String() + "\x00" + key)
+}
+
+// decodeRecordKey returns the key of a record, and its header, without
+// decoding its value.
+func decodeRecordKey(fields []string, keys *keyring) (string, recordHeader, ErrorCode) {
+	switch len(fields) {
+	case 2:
+		return fields[0], recordHeader{}, OK
+	case 3:
+		h, err := parseRecordHeader(fields[2])
+		if err != nil {
+			log.Printf("Failed to parse header of record: %v", err)
+			return "", h, InternalError
+		}
+
+		key, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			log.Printf("Failed to decode key of record: %v", err)
+			return "", h, InternalError
+		}
+
+		if h.keyID != "" {
+			key, err = keys.open(h.keyID, key, keyAdditionalData(h))
+			if err != nil {
+				log.Printf("Failed to decrypt key of record: %v", err)
+				return "", h, InternalError
+			}
+		}
+
+		return string(key), h, OK
+	default:
+		log.Printf("Record has %d fields", len(fields))
+		return "", recordHeader{}, InternalError
+	}
+}
+
+// decodeRecord returns the key, value and header of a record. Compressed
+// values that inflate to more than maxValueSize bytes are an error, unless
+// it is 0.
+func decodeRecord(fields []string, keys *keyring, maxValueSize int) (string, string, recordHeader, ErrorCode) {
+	key, h, code := decodeRecordKey(fields, keys)
+	if code != OK {
+		return "", "", h, code
+	}
+
+	if len(fields) == 2 {
+		return key, fields[1], h, OK
+	}
+
+	value, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		log.Printf("Failed to decode value of record: %v", err)
+		return "", "", h, InternalError
+	}
+
+	if h.keyID != "" {
+		value, err = keys.open(h.keyID, value, valueAdditionalData(h, key))
+		if err != nil {
+			log.Printf("Failed to decrypt value of record: %v", err)
+			return "", "", h, InternalError
+		}
+	}
+
+	if h.codec == codecFlate {
+		decompressed, code := decompress(value, maxValueSize)
+		if code != OK {
+			return "", "", h, code
+		}
+		return key, decompressed, h, OK
+	}
+
+	return key, string(value), h, OK
+}
+
+func compress(value string) (string, ErrorCode) {
+	var b bytes.Buffer
+
+	w, err := flate.NewWriter(&b, flate.DefaultCompression)
+	if err != nil {
+		log.Printf("Failed to create compressor: %v", err)
+		return "", InternalError
+	}
+
+	if _, err := io.WriteString(w, value); err != nil {
+		log.Printf("Failed to compress value: %v", err)
+		return "", InternalError
+	}
+
+	if err := w.Close(); err != nil {
+		log.Printf("Failed to compress value: %v", err)
+		return "", InternalError
+	}
+
+	return b.String(), OK
+}
+
+func decompress(value []byte, maxSize int) (string, ErrorCode) {
+	r := flate.NewReader(bytes.NewReader(value))
+	defer r.Close()
+
+	var limited io.Reader = r
+	if maxSize > 0 {
+		// one byte more than the limit tells a value over it apart
+		limited = io.LimitReader(r, int64(maxSize)+1)
+	}
+
+	decompressed, err := io.ReadAll(limited)
+	if err != nil {
+		log.Printf("Failed to decompress value: %v", err)
+		return "", InternalError
+	}
+
+	if maxSize > 0 && len(decompressed) > maxSize {
+		log.Printf("Decompressed value is larger than %d bytes", maxSize)
+		return "", InternalError
+	}
+
+	return string(decompressed), OK
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+func Test_encodeRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		value  string
+		header recordHeader
+		want   []string
+	}{
+		{
+			name:  "Plain record",
+			key:   "key",
+			value: "value",
+			want:  []string{"key", "value"},
+		},
+		{
+			name:   "Compressed record",
+			key:    "key",
+			value:  "compressed",
+			header: recordHeader{codec: codecFlate},
+			want:   []string{"a2V5", "Y29tcHJlc3NlZA==", "c=flate"},
+		},
+		{
+			name:   "Tombstone of an expiring record",
+			key:    "key",
+			header: recordHeader{expiresAt: 1700000000000, deleted: true},
+			want:   []string{"a2V5", "", "x=1700000000000;d=1"},
+		},
+		{
+			name:   "Tombstone of a multi-primary write",
+			key:    "key",
+			header: recordHeader{timestamp: 111411200000000000, origin: "site-a", deleted: true},
+			want:   []string{"a2V5", "", "t=111411200000000000;o=site-a;d=1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, code := encodeRecord(tt.key, tt.value, tt.header, nil)
+			if code != OK {
+				t.Fatalf("code = %v, want = %v", code, OK)
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_decodeRecord(t *testing.T) {
+	large := strings.Repeat("value", 200)
+	compressed, code := compress(large)
+	if code != OK {
+		t.Fatalf("code = %v, want = %v", code, OK)
+	}
+	compressedFields := []string{"a2V5", base64.StdEncoding.EncodeToString([]byte(compressed)), "c=flate"}
+
+	tests := []struct {
+		name         string
+		fields       []string
+		maxValueSize int
+		wantKey      string
+		wantValue    string
+		wantHeader   recordHeader
+		wantCode     ErrorCode
+	}{
+		{
+			name:      "Plain record",
+			fields:    []string{"key", "value"},
+			wantKey:   "key",
+			wantValue: "value",
+		},
+		{
+			name:       "Expiring record",
+			fields:     []string{"a2V5", "dmFsdWU=", "x=1700000000000"},
+			wantKey:    "key",
+			wantValue:  "value",
+			wantHeader: recordHeader{expiresAt: 1700000000000},
+		},
+		{
+			name:       "Record with flags",
+			fields:     []string{"a2V5", "dmFsdWU=", "f=42"},
+			wantKey:    "key",
+			wantValue:  "value",
+			wantHeader: recordHeader{flags: 42},
+		},
+		{
+			name:       "Multi-primary record",
+			fields:     []string{"a2V5", "dmFsdWU=", "t=111411200000000000;o=site-a"},
+			wantKey:    "key",
+			wantValue:  "value",
+			wantHeader: recordHeader{timestamp: 111411200000000000, origin: "site-a"},
+		},
+		{
+			name:     "Malformed origin",
+			fields:   []string{"a2V5", "dmFsdWU=", "t=1;o=site a"},
+			wantCode: InternalError,
+		},
+		{
+			name:     "Malformed expiry time",
+			fields:   []string{"a2V5", "dmFsdWU=", "x=soon"},
+			wantCode: InternalError,
+		},
+		{
+			name:     "Unknown attribute in header",
+			fields:   []string{"a2V5", "dmFsdWU=", "z=1"},
+			wantCode: InternalError,
+		},
+		{
+			name:     "Unknown codec",
+			fields:   []string{"a2V5", "dmFsdWU=", "c=zstd"},
+			wantCode: InternalError,
+		},
+		{
+			name:     "Encrypted record without keys",
+			fields:   []string{"a2V5", "dmFsdWU=", "k=key1"},
+			wantCode: InternalError,
+		},
+		{
+			name:         "Compressed record",
+			fields:       compressedFields,
+			maxValueSize: len(large),
+			wantKey:      "key",
+			wantValue:    large,
+			wantHeader:   recordHeader{codec: codecFlate},
+		},
+		{
+			name:         "Compressed record larger than the maximum value size",
+			fields:       compressedFields,
+			maxValueSize: len(large) - 1,
+			wantCode:     InternalError,
+		},
+		{
+			name:     "Too many fields",
+			fields:   []string{"key", "value", "c=flate", "extra"},
+			wantCode: InternalError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, value, header, code := decodeRecord(tt.fields, nil, tt.maxValueSize)
+
+			if code != tt.wantCode {
+				t.Fatalf("code = %v, want = %v", code, tt.wantCode)
+			}
+
+			if key != tt.wantKey || value != tt.wantValue {
+				t.Errorf("got = %v and %v, want = %v and %v", key, value, tt.wantKey, tt.wantValue)
+			}
+
+			if code == OK && header != tt.wantHeader {
+				t.Errorf("header = %+v, want = %+v", header, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func Test_server_compression(t *testing.T) {
+	t.Cleanup(deleteDatabase)
+	createDatabase([][]string{{"plain", "value"}})
+
+	db := &database{filepath: testDatabasePath, initialized: false, compressionThreshold: 100}
+	s := &server{db: db}
+	s.initialize()
+
+	large := strings.Repeat(`{"field": "value"}`, 100)
+
+	for _, kv := range [][]string{{"small", "value"}, {"large", large}} {
+		if _, err := s.Set(context.Background(), &pb.SetRequest{Key: kv[0], Value: kv[1]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a fresh server reads the mixed file from scratch
+	s = getServer()
+
+	for _, kv := range [][]string{{"plain", "value"}, {"small", "value"}, {"large", large}} {
+		reply, err := s.Get(context.Background(), &pb.GetRequest{Key: kv[0]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Value != kv[1] {
+			t.Errorf("key %v: got = %v, want = %v", kv[0], reply.Value, kv[1])
+		}
+	}
+
+	stats, err := (&server{db: db}).Stats(context.Background(), &pb.StatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.CompressedValues != 1 || stats.CompressionBytesIn != uint64(len(large)) {
+		t.Errorf(
+			"compressed values = %d, bytes in = %d, want 1 and %d",
+			stats.CompressedValues,
+			stats.CompressionBytesIn,
+			len(large),
+		)
+	}
+
+	if stats.CompressionRatio <= 1 {
+		t.Errorf("compression ratio = %v, want > 1", stats.CompressionRatio)
+	}
+}
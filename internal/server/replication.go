@@ -0,0 +1,529 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+// Replication copies the database file of a primary to its replicas. The
+// file is only ever appended to, so a replica that has a prefix of it can
+// catch up by appending what follows. The file is rewritten by compaction,
+// which gives it a new epoch; a replica whose epoch is not the one of the
+// primary starts over from a snapshot of the whole file.
+const (
+	epochSuffix = ".epoch"
+
+	// replicationChunkSize is the size from which the records sent to a
+	// replica are split over several replies.
+	replicationChunkSize = 1 << 20
+	// replicationHeartbeat is how often the primary tells idle replicas the
+	// size of its file, which they measure their lag against.
+	replicationHeartbeat = time.Second
+	// replicaRetryDelay is how long a replica waits before reconnecting to
+	// its primary.
+	replicaRetryDelay = time.Second
+)
+
+func newEpoch() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// loadEpoch reads the epoch of the database file, if it has one.
+func (d *database) loadEpoch() ErrorCode {
+	contents, err := os.ReadFile(d.filepath + epochSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return OK
+	} else if err != nil {
+		log.Printf("Failed to read the epoch file: %v", err)
+		return InternalError
+	}
+
+	d.epoch = strings.TrimSpace(string(contents))
+	return OK
+}
+
+// writeEpoch replaces the epoch of the database file.
+func (d *database) writeEpoch(epoch string) ErrorCode {
+	tmpPath := d.filepath + epochSuffix + compactionSuffix
+
+	if err := os.WriteFile(tmpPath, []byte(epoch+"\n"), 0o644); err != nil {
+		log.Printf("Failed to write the epoch file: %v", err)
+		return InternalError
+	}
+
+	if err := os.Rename(tmpPath, d.filepath+epochSuffix); err != nil {
+		log.Printf("Failed to replace the epoch file: %v", err)
+		return InternalError
+	}
+
+	d.epoch = epoch
+	return OK
+}
+
+// ensureEpoch gives the database file an epoch if it has none yet, which
+// happens when it is first replicated.
+func (d *database) ensureEpoch() ErrorCode {
+	d.ensureInitialized()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return DatabaseClosed
+	}
+
+	if d.epoch != "" {
+		return OK
+	}
+
+	return d.writeEpoch(newEpoch())
+}
+
+// notifyAppended wakes up the replication streams waiting for the database
+// file to change. The caller must hold the write lock.
+func (d *database) notifyAppended() {
+	close(d.appended)
+	d.appended = make(chan struct{})
+}
+
+// replicationChunk is a part of the database file to send to a replica.
+type replicationChunk struct {
+	epoch string
+	// reset is true if the replica must discard its file, because it is
+	// not a prefix of this one.
+	reset  bool
+	offset int64
+	data   []byte
+	// size is the size of the file when the chunk was read.
+	size int64
+	// appended is closed once the file changes after the chunk was read.
+	appended <-chan struct{}
+}
+
+// readReplicationChunk reads the whole records of the database file from
+// offset, up to about maxSize bytes, for a replica that has the file of the
+// given epoch up to offset.
+func (d *database) readReplicationChunk(epoch string, offset int64, maxSize int64) (replicationChunk, ErrorCode) {
+	d.ensureInitialized()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return replicationChunk{}, DatabaseClosed
+	}
+
+	f, code := d.openForReading()
+	if code != OK {
+		return replicationChunk{}, code
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		log.Printf("Error while getting the size of the database file: %v", err)
+		return replicationChunk{}, InternalError
+	}
+
+	c := replicationChunk{epoch: d.epoch, offset: offset, size: size, appended: d.appended}
+
+	if epoch != d.epoch || offset > size {
+		c.reset = true
+		c.offset = 0
+	}
+
+	csvReader := newCSVReader(io.NewSectionReader(f, c.offset, size-c.offset))
+	for csvReader.InputOffset() < maxSize {
+		if _, err := csvReader.Read(); err == io.EOF {
+			break
+		} else if err != nil {
+			log.Printf("Error while reading file: %v", err)
+			return replicationChunk{}, InternalError
+		}
+	}
+
+	c.data = make([]byte, csvReader.InputOffset())
+	if _, err := f.ReadAt(c.data, c.offset); err != nil {
+		log.Printf("Error while reading file: %v", err)
+		return replicationChunk{}, InternalError
+	}
+
+	return c, OK
+}
+
+// replicationPosition returns the epoch and the size of the database file,
+// from where a replica resumes.
+func (d *database) replicationPosition() (string, int64, ErrorCode) {
+	d.ensureInitialized()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return "", 0, DatabaseClosed
+	}
+
+	info, err := os.Stat(d.filepath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return d.epoch, 0, OK
+	} else if err != nil {
+		log.Printf("Failed to stat the database file: %v", err)
+		return "", 0, InternalError
+	}
+
+	return d.epoch, info.Size(), OK
+}
+
+// appendReplicated appends records streamed from the primary at offset of
+// the database file, which is first emptied if reset is true, and indexes
+// them.
+func (d *database) appendReplicated(epoch string, reset bool, offset int64, data []byte) ErrorCode {
+	d.ensureInitialized()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return DatabaseClosed
+	}
+
+	if reset {
+		if code := d.resetReplica(epoch); code != OK {
+			return code
+		}
+	} else if epoch != d.epoch {
+		log.Printf("Replicated records of epoch %s do not belong to the file of epoch %s", epoch, d.epoch)
+		return InternalError
+	}
+
+	if len(data) == 0 {
+		return OK
+	}
+
+	f, code := d.openForWriting()
+	if code != OK {
+		return code
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		log.Printf("Error while getting current position in file: %v", err)
+		return InternalError
+	}
+
+	if size != offset {
+		log.Printf("Replicated records at offset %d do not follow the end of the file at %d", offset, size)
+		return InternalError
+	}
+
+	if _, err := f.Write(data); err != nil {
+		log.Printf("Error while writing to file: %v", err)
+		return InternalError
+	}
+
+	if d.syncWrites {
+		if err := d.sync(f); err != nil {
+			log.Printf("Failed to sync the database file: %v", err)
+			return InternalError
+		}
+	}
+
+	defer d.notifyAppended()
+
+	csvReader := newCSVReader(bytes.NewReader(data))
+
+	for {
+		pos := csvReader.InputOffset()
+		record, err := csvReader.Read()
+
+		if err == io.EOF {
+			return OK
+		} else if err != nil {
+			log.Printf("Error while reading replicated records: %v", err)
+			return InternalError
+		}
+
+		key, header, code := decodeRecordKey(record, d.keys)
+		if code != OK {
+			return code
+		}
+
+		if d.cache != nil {
+			d.cache.invalidate(key)
+		}
+
+		if d.storage != nil {
+			c := change{key: key, deleted: header.deleted}
+			if code := d.trackChange(c, csvReader.InputOffset()-pos); code != OK {
+				return code
+			}
+		}
+
+		if code := d.updateKeyPosition(key, offset+pos); code != OK {
+			return code
+		}
+
+		d.watchers.invalidate(key)
+	}
+}
+
+// resetReplica empties the database file, before a snapshot of the file of
+// the primary with the given epoch is appended to it. The caller must hold
+// the write lock.
+func (d *database) resetReplica(epoch string) ErrorCode {
+	log.Printf("Replicating the database file of epoch %s from the start", epoch)
+
+	if err := os.Truncate(d.filepath, 0); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to empty the database file: %v", err)
+		return InternalError
+	}
+
+	if code := d.writeEpoch(epoch); code != OK {
+		return code
+	}
+
+	// scans that span the reset start over, as they do after a compaction
+	d.compactions.Add(1)
+	d.notifyAppended()
+
+	if d.cache != nil {
+		d.cache.clear()
+	}
+	d.watchers.invalidateAll()
+
+	if d.storage != nil {
+		d.storage.keys.Store(0)
+		d.storage.staleBytes.Store(0)
+	}
+
+	return d.rebuildIndex()
+}
+
+// replicationServer streams the database file to replicas.
+type replicationServer struct {
+	pb.UnimplementedReplicationServer
+	db *database
+
+	// stopping is closed when the server shuts down, which ends the
+	// streams; they would otherwise never end on their own.
+	stopping chan struct{}
+	stopOnce sync.Once
+}
+
+func newReplicationServer(d *database) *replicationServer {
+	return &replicationServer{db: d, stopping: make(chan struct{})}
+}
+
+func (rs *replicationServer) shutdown() {
+	rs.stopOnce.Do(func() { close(rs.stopping) })
+}
+
+func (rs *replicationServer) Stream(in *pb.StreamRequest, stream pb.Replication_StreamServer) error {
+	infof("Replication: replica connected at epoch %q, offset %d", in.Epoch, in.Offset)
+
+	if code := rs.db.ensureEpoch(); code == DatabaseClosed {
+		return closedErr
+	} else if code != OK {
+		return internalErr
+	}
+
+	epoch, offset := in.Epoch, in.Offset
+
+	for {
+		c, code := rs.db.readReplicationChunk(epoch, offset, replicationChunkSize)
+
+		if code == DatabaseClosed {
+			return closedErr
+		}
+
+		if code != OK {
+			return internalErr
+		}
+
+		reply := &pb.StreamReply{
+			Epoch:       c.epoch,
+			Reset_:      c.reset,
+			Offset:      c.offset,
+			Data:        c.data,
+			PrimarySize: c.size,
+		}
+		if err := stream.Send(reply); err != nil {
+			return err
+		}
+
+		epoch, offset = c.epoch, c.offset+int64(len(c.data))
+
+		if offset < c.size {
+			continue
+		}
+
+		select {
+		case <-c.appended:
+		case <-time.After(replicationHeartbeat):
+		case <-rs.stopping:
+			return closedErr
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+// replica follows a primary: it streams the database file of the primary
+// into its own, which it serves reads from.
+type replica struct {
+	db    *database
+	conn  *grpc.ClientConn
+	token string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	connected bool
+	// applied is how much of the file of the primary the replica has, and
+	// primarySize how much the primary had when it last said so.
+	applied     int64
+	primarySize int64
+	caughtUpAt  time.Time
+}
+
+// newReplicaOf returns a replica of the primary in cfg. It presents the
+// certificate of certs to the primary if the connection uses TLS.
+func newReplicaOf(d *database, cfg *Config, certs *certReloader) (*replica, error) {
+	creds, err := peerCredentials(cfg.replicationTLSCA != "", cfg.replicationTLSCA, certs)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReplica(
+		d,
+		cfg.replicaOf,
+		cfg.replicationToken,
+		grpc.WithTransportCredentials(creds),
+		// a chunk ends with a whole record, which encoding may make up to
+		// twice as large as its key and value
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(replicationChunkSize+2*(cfg.maxKeySize+cfg.maxValueSize)+1024)),
+	)
+}
+
+// newReplica returns a replica of the primary at addr, which it connects to
+// with opts, authenticating with token if it is not empty.
+func newReplica(d *database, addr string, token string, opts ...grpc.DialOption) (*replica, error) {
+	conn, err := grpc.NewClient(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &replica{db: d, conn: conn, token: token, ctx: ctx, cancel: cancel, caughtUpAt: time.Now()}, nil
+}
+
+// serve follows the primary, reconnecting whenever the stream breaks, until
+// the replica is stopped.
+func (r *replica) serve() error {
+	for {
+		err := r.follow()
+
+		r.mu.Lock()
+		r.connected = false
+		r.mu.Unlock()
+
+		if r.ctx.Err() != nil {
+			return nil
+		}
+
+		log.Printf("Replication from the primary failed, retrying in %v: %v", replicaRetryDelay, err)
+
+		select {
+		case <-time.After(replicaRetryDelay):
+		case <-r.ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *replica) follow() error {
+	epoch, offset, code := r.db.replicationPosition()
+	if code != OK {
+		return fmt.Errorf("failed to read the replication position: error code %d", code)
+	}
+
+	ctx := r.ctx
+	if r.token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+r.token)
+	}
+
+	stream, err := pb.NewReplicationClient(r.conn).Stream(ctx, &pb.StreamRequest{Epoch: epoch, Offset: offset})
+	if err != nil {
+		return err
+	}
+
+	for {
+		reply, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		code := r.db.appendReplicated(reply.Epoch, reply.Reset_, reply.Offset, reply.Data)
+		if code != OK {
+			return fmt.Errorf("failed to append the replicated records: error code %d", code)
+		}
+
+		r.mu.Lock()
+		r.connected = true
+		r.applied = reply.Offset + int64(len(reply.Data))
+		r.primarySize = reply.PrimarySize
+		if r.applied >= r.primarySize {
+			r.caughtUpAt = time.Now()
+		}
+		r.mu.Unlock()
+	}
+}
+
+// lag returns whether the replica is connected to its primary, and how far
+// behind it is: in bytes of the file of the primary, and in time since it
+// was last caught up, which is 0 while it is connected and caught up.
+func (r *replica) lag() (bool, int64, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lagBytes := max(r.primarySize-r.applied, 0)
+
+	if r.connected && lagBytes == 0 {
+		return true, 0, 0
+	}
+
+	return r.connected, lagBytes, time.Since(r.caughtUpAt)
+}
+
+func (r *replica) gracefulStop() {
+	r.stop()
+}
+
+// stop disconnects from the primary. Records being appended when it is
+// called are either appended in full or not at all.
+func (r *replica) stop() {
+	r.cancel()
+	r.conn.Close()
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+func openTestDatabase(t *testing.T, dir string) *database {
+	t.Helper()
+
+	d := &database{filepath: filepath.Join(dir, databaseFileName)}
+	if code := d.initialize(); code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+	t.Cleanup(func() { d.close() })
+
+	return d
+}
+
+// replicateAll appends what the replica is missing of the file of the
+// primary, in chunks of about maxSize bytes, and returns whether it had to
+// start over.
+func replicateAll(t *testing.T, primary, replica *database, maxSize int64) bool {
+	t.Helper()
+
+	reset := false
+
+	for {
+		epoch, offset, code := replica.replicationPosition()
+		if code != OK {
+			t.Fatalf("code = %v, want = OK", code)
+		}
+
+		c, code := primary.readReplicationChunk(epoch, offset, maxSize)
+		if code != OK {
+			t.Fatalf("code = %v, want = OK", code)
+		}
+
+		reset = reset || c.reset
+
+		if code := replica.appendReplicated(c.epoch, c.reset, c.offset, c.data); code != OK {
+			t.Fatalf("code = %v, want = OK", code)
+		}
+
+		if c.offset+int64(len(c.data)) == c.size {
+			return reset
+		}
+	}
+}
+
+func Test_database_replication(t *testing.T) {
+	primary := openTestDatabase(t, t.TempDir())
+	replica := openTestDatabase(t, t.TempDir())
+	replica.readOnly = true
+
+	if code := primary.ensureEpoch(); code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+
+	primary.setKey("a", "1")
+	primary.setKey("b", "2")
+	primary.setKey("a", "11")
+
+	if !replicateAll(t, primary, replica, 1) {
+		t.Errorf("replica without a file did not start over")
+	}
+
+	primary.setKey("c", "3")
+	primary.deleteKeys(context.Background(), "b")
+
+	if replicateAll(t, primary, replica, 1) {
+		t.Errorf("replica started over, want it to resume")
+	}
+
+	assertSameFile := func() {
+		t.Helper()
+
+		want, _ := os.ReadFile(primary.filepath)
+		got, _ := os.ReadFile(replica.filepath)
+		if !bytes.Equal(got, want) {
+			t.Errorf("replica file = %q, want = %q", got, want)
+		}
+
+		for key, want := range map[string]string{"a": "11", "c": "3"} {
+			if got, code := replica.getKey(key); code != OK || got != want {
+				t.Errorf("replica %s = %q (code %v), want = %q", key, got, code, want)
+			}
+		}
+
+		if _, code := replica.getKey("b"); code != KeyNotFound {
+			t.Errorf("replica b code = %v, want = KeyNotFound", code)
+		}
+	}
+	assertSameFile()
+
+	if code := replica.setKey("a", "x"); code != ReadOnly {
+		t.Errorf("write to the replica code = %v, want = ReadOnly", code)
+	}
+
+	// compaction rewrites the file, so the replica starts over
+	if _, code := primary.compact(); code != OK {
+		t.Fatalf("code = %v, want = OK", code)
+	}
+
+	if !replicateAll(t, primary, replica, 1<<20) {
+		t.Errorf("replica did not start over after compaction")
+	}
+	assertSameFile()
+
+	// records must follow the end of the file of the replica
+	primary.setKey("d", "4")
+	c, _ := primary.readReplicationChunk(replica.epoch, 0, 1<<20)
+	if code := replica.appendReplicated(c.epoch, false, 1, c.data); code != InternalError {
+		t.Errorf("misplaced records code = %v, want = InternalError", code)
+	}
+}
+
+// runServer runs a server with args until the test ends, and returns its
+// gRPC address.
+func runServer(t *testing.T, args ...string) string {
+	t.Helper()
+
+	addr, _ := startServer(t, args...)
+	return addr
+}
+
+// startServer is runServer for servers that a test stops itself, by calling
+// the returned function
+func startServer(t *testing.T, args ...string) (string, func()) {
+	t.Helper()
+
+	cfg, err := ParseConfig(append([]string{"-addr", "localhost:0", "-log-level", "error"}, args...), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	addrs := make(chan net.Addr, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, cfg, func(addr net.Addr) { addrs <- addr })
+	}()
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			if err := <-done; err != nil {
+				t.Errorf("error = %v, did not want error", err)
+			}
+		})
+	}
+	t.Cleanup(stop)
+
+	select {
+	case addr := <-addrs:
+		return addr.String(), stop
+	case err := <-done:
+		t.Fatalf("server failed to start: %v", err)
+		return "", nil
+	}
+}
+
+func dialDatabase(t *testing.T, addr string) pb.DatabaseClient {
+	t.Helper()
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewDatabaseClient(conn)
+}
+
+// eventually retries check until it returns true, and fails the test if it
+// does not within a few seconds.
+func eventually(t *testing.T, what string, check func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !check(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_run_replication(t *testing.T) {
+	ctx := context.Background()
+
+	primaryAddr := runServer(t, "-data-dir", t.TempDir())
+	primary := dialDatabase(t, primaryAddr)
+
+	if _, err := primary.Set(ctx, &pb.SetRequest{Key: "before", Value: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	replicas := []pb.DatabaseClient{
+		dialDatabase(t, runServer(t, "-data-dir", t.TempDir(), "-replica-of", primaryAddr)),
+		dialDatabase(t, runServer(t, "-data-dir", t.TempDir(), "-replica-of", primaryAddr)),
+	}
+
+	if _, err := primary.Set(ctx, &pb.SetRequest{Key: "after", Value: "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	replicated := func(replica pb.DatabaseClient, key, want string) func() bool {
+		return func() bool {
+			reply, err := replica.Get(ctx, &pb.GetRequest{Key: key})
+			return err == nil && reply.Value == want
+		}
+	}
+
+	for _, replica := range replicas {
+		eventually(t, "the snapshot", replicated(replica, "before", "1"))
+		eventually(t, "the appended records", replicated(replica, "after", "2"))
+
+		_, err := replica.Set(ctx, &pb.SetRequest{Key: "after", Value: "3"})
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("write to a replica error = %v, want = FailedPrecondition", err)
+		}
+
+		eventually(t, "the replica to catch up", func() bool {
+			stats, err := replica.Stats(ctx, &pb.StatsRequest{})
+			return err == nil && stats.Role == "replica" && stats.ReplicaConnected &&
+				stats.ReplicationLagBytes == 0 && stats.ReplicationLagSeconds == 0
+		})
+	}
+
+	stats, err := primary.Stats(ctx, &pb.StatsRequest{})
+	if err != nil || stats.Role != "primary" {
+		t.Errorf("primary stats = %v, %v, want role primary", stats, err)
+	}
+
+	// replicas start over from the compacted file
+	if _, err := primary.Set(ctx, &pb.SetRequest{Key: "before", Value: "11"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := primary.Compact(ctx, &pb.CompactRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := primary.Set(ctx, &pb.SetRequest{Key: "compacted", Value: "3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, replica := range replicas {
+		eventually(t, "records after the compaction", replicated(replica, "compacted", "3"))
+		eventually(t, "records before the compaction", replicated(replica, "before", "11"))
+	}
+}
+
+func Test_run_replication_restart(t *testing.T) {
+	ctx := context.Background()
+
+	primaryAddr := runServer(t, "-data-dir", t.TempDir())
+	primary := dialDatabase(t, primaryAddr)
+
+	replicaDir := t.TempDir()
+
+	// the first replica is stopped once it caught up
+	t.Run("First run", func(t *testing.T) {
+		replica := dialDatabase(t, runServer(t, "-data-dir", replicaDir, "-replica-of", primaryAddr))
+
+		if _, err := primary.Set(ctx, &pb.SetRequest{Key: "key", Value: "1"}); err != nil {
+			t.Fatal(err)
+		}
+
+		eventually(t, "the record", func() bool {
+			reply, err := replica.Get(ctx, &pb.GetRequest{Key: "key"})
+			return err == nil && reply.Value == "1"
+		})
+	})
+
+	if _, err := primary.Set(ctx, &pb.SetRequest{Key: "key", Value: "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	epoch, err := os.ReadFile(filepath.Join(replicaDir, databaseFileName+epochSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replica := dialDatabase(t, runServer(t, "-data-dir", replicaDir, "-replica-of", primaryAddr))
+
+	eventually(t, "the record written while the replica was down", func() bool {
+		reply, err := replica.Get(ctx, &pb.GetRequest{Key: "key"})
+		return err == nil && reply.Value == "2"
+	})
+
+	if got, _ := os.ReadFile(filepath.Join(replicaDir, databaseFileName+epochSuffix)); !bytes.Equal(got, epoch) {
+		t.Errorf("epoch = %q after restarting, want = %q", got, epoch)
+	}
+}
@@ -0,0 +1,953 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// respServer serves the Redis serialization protocol (RESP) on top of the
+// same database as the gRPC server, so that redis-cli and Redis client
+// libraries can use it. Connections start with version 2 of the protocol
+// and can switch to version 3 with HELLO.
+type respServer struct {
+	connServer
+	s         *server
+	startedAt time.Time
+
+	lastConnID atomic.Int64
+	commands   atomic.Uint64
+}
+
+// respConn is the state of a client connection.
+type respConn struct {
+	id   int64
+	name string
+	r    *bufio.Reader
+	w    respWriter
+	// principal is who the client authenticated as, if the server has an
+	// authorization policy.
+	principal *principal
+}
+
+const (
+	// respVersion is the version of Redis reported to clients, which some
+	// of them look at to tell which commands they can use.
+	respVersion = "7.0.0"
+
+	// Largest inline command, and largest number of arguments of a command.
+	respMaxInlineSize = 64 << 10
+	respMaxArgs       = 1 << 20
+	// respMaxBulkSize is the largest argument accepted if the server has no
+	// size limits, as in Redis.
+	respMaxBulkSize = 512 << 20
+
+	respDefaultScanCount = 10
+)
+
+// respProtocolError is a malformed request, after which the connection is
+// closed since the rest of the stream cannot be trusted.
+type respProtocolError string
+
+func (e respProtocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+func newRESPServer(s *server, lis net.Listener) *respServer {
+	rs := &respServer{s: s, startedAt: time.Now()}
+	rs.connServer = newConnServer(lis, rs.serveConn)
+	return rs
+}
+
+func (rs *respServer) serveConn(conn net.Conn) {
+	c := &respConn{
+		id: rs.lastConnID.Add(1),
+		r:  bufio.NewReader(conn),
+		w:  respWriter{w: bufio.NewWriter(conn), version: 2},
+	}
+
+	for {
+		args, err := readCommand(c.r, rs.maxBulkSize())
+		if err != nil {
+			var protocolErr respProtocolError
+			if errors.As(err, &protocolErr) {
+				c.w.error("ERR " + protocolErr.Error())
+				c.w.w.Flush()
+			}
+			return
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		rs.commands.Add(1)
+		quit := rs.execute(c, args)
+
+		// replies to pipelined commands are sent together
+		if c.r.Buffered() == 0 || quit {
+			if err := c.w.w.Flush(); err != nil {
+				return
+			}
+		}
+
+		if quit {
+			return
+		}
+	}
+}
+
+func (rs *respServer) maxBulkSize() int {
+	if rs.s.maxKeySize <= 0 || rs.s.maxValueSize <= 0 {
+		return respMaxBulkSize
+	}
+
+	return max(rs.s.maxKeySize, rs.s.maxValueSize)
+}
+
+// readCommand reads a command and its arguments. Clients send commands as
+// arrays of bulk strings, but commands can also be typed inline, as a line
+// of space-separated arguments.
+func readCommand(r *bufio.Reader, maxBulkSize int) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > respMaxArgs {
+		return nil, respProtocolError("invalid multibulk length")
+	}
+
+	args := make([]string, 0, max(n, 0))
+
+	for i := 0; i < n; i++ {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(line, "$") {
+			return nil, respProtocolError(fmt.Sprintf("expected '$', got '%.1s'", line))
+		}
+
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkSize {
+			return nil, respProtocolError("invalid bulk length")
+		}
+
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+
+		if string(arg[size:]) != "\r\n" {
+			return nil, respProtocolError("bulk string is not terminated by CRLF")
+		}
+
+		args = append(args, string(arg[:size]))
+	}
+
+	return args, nil
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := readLine(r, respMaxInlineSize)
+	if err == errLineTooLong {
+		return "", respProtocolError("too big inline request")
+	}
+
+	return line, err
+}
+
+// respWriter writes replies in the version of the protocol that the
+// connection uses.
+type respWriter struct {
+	w       *bufio.Writer
+	version int
+}
+
+func (w respWriter) simple(s string) {
+	w.w.WriteString("+" + s + "\r\n")
+}
+
+func (w respWriter) error(s string) {
+	w.w.WriteString("-" + s + "\r\n")
+}
+
+func (w respWriter) integer(n int64) {
+	w.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w respWriter) bulk(s string) {
+	w.w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n")
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+func (w respWriter) null() {
+	if w.version == 3 {
+		w.w.WriteString("_\r\n")
+	} else {
+		w.w.WriteString("$-1\r\n")
+	}
+}
+
+func (w respWriter) array(n int) {
+	w.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// mapOf starts a map of n pairs, which version 2 of the protocol sends as an
+// array of keys and values.
+func (w respWriter) mapOf(n int) {
+	if w.version == 3 {
+		w.w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+	} else {
+		w.array(2 * n)
+	}
+}
+
+type respCommand struct {
+	// arity is the number of arguments, counting the command name. A
+	// negative arity -n means at least n arguments.
+	arity int
+	run   func(rs *respServer, c *respConn, args []string)
+
+	// access is what the command does to its keys, which are the
+	// arguments from firstKey to lastKey, every keyStep arguments. A
+	// negative lastKey counts from the end.
+	access            permission
+	firstKey, lastKey int
+	keyStep           int
+	// public commands can be run before authenticating.
+	public bool
+}
+
+var respCommands = map[string]respCommand{
+	"get": {
+		arity: 2, run: (*respServer).get,
+		access: permissionRead, firstKey: 1, lastKey: 1, keyStep: 1,
+	},
+	"set": {
+		arity: -3, run: (*respServer).set,
+		access: permissionWrite, firstKey: 1, lastKey: 1, keyStep: 1,
+	},
+	"del": {
+		arity: -2, run: (*respServer).del,
+		access: permissionWrite, firstKey: 1, lastKey: -1, keyStep: 1,
+	},
+	"exists": {
+		arity: -2, run: (*respServer).exists,
+		access: permissionRead, firstKey: 1, lastKey: -1, keyStep: 1,
+	},
+	"mget": {
+		arity: -2, run: (*respServer).mget,
+		access: permissionRead, firstKey: 1, lastKey: -1, keyStep: 1,
+	},
+	"mset": {
+		arity: -3, run: (*respServer).mset,
+		access: permissionWrite, firstKey: 1, lastKey: -1, keyStep: 2,
+	},
+	"incr": {
+		arity: 2, run: (*respServer).incr,
+		access: permissionRead | permissionWrite, firstKey: 1, lastKey: 1, keyStep: 1,
+	},
+	// scan only returns the keys that the client may read
+	"scan": {arity: -2, run: (*respServer).scan},
+	"ping": {arity: -1, run: (*respServer).ping},
+	"info": {arity: -1, run: (*respServer).info},
+
+	// commands that clients send when they connect
+	"auth":    {arity: -2, run: (*respServer).auth, public: true},
+	"hello":   {arity: -1, run: (*respServer).hello, public: true},
+	"client":  {arity: -2, run: (*respServer).client},
+	"select":  {arity: 2, run: (*respServer).selectDB},
+	"command": {arity: -1, run: (*respServer).command},
+}
+
+// keys returns the keys among the arguments of the command.
+func (cmd respCommand) keys(args []string) []string {
+	if cmd.keyStep == 0 {
+		return nil
+	}
+
+	last := cmd.lastKey
+	if last < 0 {
+		last += len(args)
+	}
+
+	var keys []string
+	for i := cmd.firstKey; i <= last && i < len(args); i += cmd.keyStep {
+		keys = append(keys, args[i])
+	}
+
+	return keys
+}
+
+// execute runs a command and writes its reply. It returns true if the
+// client asked to close the connection.
+func (rs *respServer) execute(c *respConn, args []string) bool {
+	name := strings.ToLower(args[0])
+	debugf("RESP: received command %v", name)
+
+	if name == "quit" {
+		c.w.simple("OK")
+		return true
+	}
+
+	cmd, ok := respCommands[name]
+	if !ok {
+		c.w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return false
+	}
+
+	if (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity {
+		c.w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return false
+	}
+
+	if rs.s.policy != nil && !cmd.public {
+		if c.principal == nil {
+			c.w.error("NOAUTH Authentication required.")
+			return false
+		}
+
+		for _, key := range cmd.keys(args) {
+			if !c.principal.allowed(cmd.access, key) {
+				c.w.error(fmt.Sprintf("NOPERM User %s has no permissions to access one of the keys used as arguments", c.principal.name))
+				return false
+			}
+		}
+	}
+
+	cmd.run(rs, c, args)
+	return false
+}
+
+// writeCode replies with the error for a code other than OK and
+// KeyNotFound, which commands handle themselves.
+func (c *respConn) writeCode(code ErrorCode) {
+	if code == DatabaseClosed {
+		c.w.error("ERR server is shutting down")
+	} else if code == ReadOnly {
+		c.w.error("READONLY You can't write against a read only replica.")
+	} else if code == NotLeader {
+		c.w.error("ERR not the leader of the cluster, write to the leader")
+	} else if code == WrongShard {
+		c.w.error("ERR key belongs to another shard")
+	} else {
+		c.w.error("ERR internal error")
+	}
+}
+
+// validKeys checks keys against the limits of the server, and replies with
+// an error if one of them is not valid.
+func (rs *respServer) validKeys(c *respConn, keys ...string) bool {
+	for _, key := range keys {
+		if keyValid, errmsg := rs.s.isKeyValid(key); !keyValid {
+			c.w.error("ERR " + errmsg)
+			return false
+		}
+	}
+
+	return true
+}
+
+func (rs *respServer) validValue(c *respConn, value string) bool {
+	if rs.s.maxValueSize > 0 && len(value) > rs.s.maxValueSize {
+		c.w.error(fmt.Sprintf("ERR Value cannot be larger than %d bytes", rs.s.maxValueSize))
+		return false
+	}
+
+	return true
+}
+
+func (rs *respServer) get(c *respConn, args []string) {
+	if !rs.validKeys(c, args[1]) {
+		return
+	}
+
+	value, code := rs.s.db.getKey(args[1])
+
+	switch code {
+	case OK:
+		c.w.bulk(value)
+	case KeyNotFound:
+		c.w.null()
+	default:
+		c.writeCode(code)
+	}
+}
+
+// set implements SET key value [NX | XX] [EX seconds | PX milliseconds].
+func (rs *respServer) set(c *respConn, args []string) {
+	key, value := args[1], args[2]
+
+	if !rs.validKeys(c, key) || !rs.validValue(c, value) {
+		return
+	}
+
+	var onlyIfAbsent, onlyIfPresent bool
+	e := entry{value: value}
+
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "NX" && !onlyIfPresent:
+			onlyIfAbsent = true
+		case option == "XX" && !onlyIfAbsent:
+			onlyIfPresent = true
+		case (option == "EX" || option == "PX") && e.expiresAt == 0 && i+1 < len(args):
+			i++
+
+			unit := time.Second
+			if option == "PX" {
+				unit = time.Millisecond
+			}
+
+			expiresAt, ok := expiryTime(args[i], unit)
+			if !ok {
+				c.w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			e.expiresAt = expiresAt
+		default:
+			c.w.error("ERR syntax error")
+			return
+		}
+	}
+
+	var code ErrorCode
+
+	if onlyIfAbsent || onlyIfPresent {
+		code = rs.s.db.updateKey(context.Background(), key, func(_ entry, found bool) (entry, ErrorCode) {
+			if found == onlyIfAbsent {
+				return entry{}, ConditionFailed
+			}
+			return e, OK
+		})
+	} else {
+		code = rs.s.db.setEntries(context.Background(), change{key: key, entry: e})
+	}
+
+	switch code {
+	case OK:
+		c.w.simple("OK")
+	case ConditionFailed:
+		c.w.null()
+	default:
+		c.writeCode(code)
+	}
+}
+
+// expiryTime returns the time in Unix milliseconds that is ttl units from
+// now.
+func expiryTime(ttl string, unit time.Duration) (int64, bool) {
+	n, err := strconv.ParseInt(ttl, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+
+	now := time.Now().UnixMilli()
+	perUnit := int64(unit / time.Millisecond)
+
+	if n > (math.MaxInt64-now)/perUnit {
+		return 0, false
+	}
+
+	return now + n*perUnit, true
+}
+
+func (rs *respServer) del(c *respConn, args []string) {
+	if !rs.validKeys(c, args[1:]...) {
+		return
+	}
+
+	deleted, code := rs.s.db.deleteKeys(context.Background(), args[1:]...)
+	if code != OK {
+		c.writeCode(code)
+		return
+	}
+
+	c.w.integer(int64(deleted))
+}
+
+func (rs *respServer) exists(c *respConn, args []string) {
+	if !rs.validKeys(c, args[1:]...) {
+		return
+	}
+
+	var found int64
+
+	for _, key := range args[1:] {
+		_, code := rs.s.db.getKey(key)
+
+		if code == OK {
+			found++
+		} else if code != KeyNotFound {
+			c.writeCode(code)
+			return
+		}
+	}
+
+	c.w.integer(found)
+}
+
+func (rs *respServer) mget(c *respConn, args []string) {
+	keys := args[1:]
+
+	if !rs.validKeys(c, keys...) {
+		return
+	}
+
+	values := make([]*string, len(keys))
+
+	for i, key := range keys {
+		value, code := rs.s.db.getKey(key)
+
+		if code == OK {
+			values[i] = &value
+		} else if code != KeyNotFound {
+			c.writeCode(code)
+			return
+		}
+	}
+
+	c.w.array(len(values))
+	for _, value := range values {
+		if value == nil {
+			c.w.null()
+		} else {
+			c.w.bulk(*value)
+		}
+	}
+}
+
+func (rs *respServer) mset(c *respConn, args []string) {
+	if len(args)%2 == 0 {
+		c.w.error("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+
+	var changes []change
+
+	for i := 1; i < len(args); i += 2 {
+		if !rs.validKeys(c, args[i]) || !rs.validValue(c, args[i+1]) {
+			return
+		}
+
+		changes = append(changes, change{key: args[i], entry: entry{value: args[i+1]}})
+	}
+
+	if code := rs.s.db.setEntries(context.Background(), changes...); code != OK {
+		c.writeCode(code)
+		return
+	}
+
+	c.w.simple("OK")
+}
+
+// incr increments the integer value of a key, which is 0 if it does not
+// exist. The key keeps its expiry time.
+func (rs *respServer) incr(c *respConn, args []string) {
+	key := args[1]
+
+	if !rs.validKeys(c, key) {
+		return
+	}
+
+	var n int64
+	var errmsg string
+
+	code := rs.s.db.updateKey(context.Background(), key, func(current entry, found bool) (entry, ErrorCode) {
+		n = 0
+
+		if found {
+			var err error
+			n, err = strconv.ParseInt(current.value, 10, 64)
+			if err != nil {
+				errmsg = "ERR value is not an integer or out of range"
+				return entry{}, ConditionFailed
+			}
+		}
+
+		if n == math.MaxInt64 {
+			errmsg = "ERR increment or decrement would overflow"
+			return entry{}, ConditionFailed
+		}
+
+		n++
+
+		return entry{value: strconv.FormatInt(n, 10), expiresAt: current.expiresAt}, OK
+	})
+
+	switch code {
+	case OK:
+		c.w.integer(n)
+	case ConditionFailed:
+		c.w.error(errmsg)
+	default:
+		c.writeCode(code)
+	}
+}
+
+// scan implements SCAN cursor [MATCH pattern] [COUNT count]. COUNT is the
+// number of records to look at, so a call may return fewer keys, or none,
+// before the scan is over.
+func (rs *respServer) scan(c *respConn, args []string) {
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		c.w.error("ERR invalid cursor")
+		return
+	}
+
+	pattern := "*"
+	count := respDefaultScanCount
+
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			c.w.error("ERR syntax error")
+			return
+		}
+
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil {
+				c.w.error("ERR value is not an integer or out of range")
+				return
+			}
+			if count < 1 {
+				c.w.error("ERR syntax error")
+				return
+			}
+		default:
+			c.w.error("ERR syntax error")
+			return
+		}
+	}
+
+	keys, next, code := rs.s.db.scanKeys(cursor, count)
+	if code != OK {
+		c.writeCode(code)
+		return
+	}
+
+	var matching []string
+	for _, key := range keys {
+		if c.principal != nil && !c.principal.allowed(permissionRead, key) {
+			continue
+		}
+		if globMatch(pattern, key) {
+			matching = append(matching, key)
+		}
+	}
+
+	c.w.array(2)
+	c.w.bulk(strconv.FormatUint(next, 10))
+	c.w.array(len(matching))
+	for _, key := range matching {
+		c.w.bulk(key)
+	}
+}
+
+// globMatch reports whether s matches a glob-style pattern, as in Redis:
+// * matches any sequence of bytes, ? any single byte, [abc], [^abc] and
+// [a-z] a set of bytes, and \ escapes the next byte.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+
+			var matched bool
+			pattern, matched = matchByteSet(pattern[1:], s[0])
+			if !matched {
+				return false
+			}
+			s = s[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+
+	return len(s) == 0
+}
+
+// matchByteSet matches b against the set of bytes at the start of pattern,
+// after its opening bracket, and returns the rest of the pattern.
+func matchByteSet(pattern string, b byte) (string, bool) {
+	negated := strings.HasPrefix(pattern, "^")
+	if negated {
+		pattern = pattern[1:]
+	}
+
+	matched := false
+
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			matched = matched || pattern[1] == b
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			low, high := min(pattern[0], pattern[2]), max(pattern[0], pattern[2])
+			matched = matched || (low <= b && b <= high)
+			pattern = pattern[3:]
+		default:
+			matched = matched || pattern[0] == b
+			pattern = pattern[1:]
+		}
+	}
+
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+
+	return pattern, matched != negated
+}
+
+func (rs *respServer) ping(c *respConn, args []string) {
+	switch len(args) {
+	case 1:
+		c.w.simple("PONG")
+	case 2:
+		c.w.bulk(args[1])
+	default:
+		c.w.error("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+// info replies with the sections of information asked for, or the default
+// ones: server, clients and stats.
+func (rs *respServer) info(c *respConn, args []string) {
+	sections := map[string]bool{}
+	for _, section := range args[1:] {
+		sections[strings.ToLower(section)] = true
+	}
+
+	all := len(sections) == 0 || sections["all"] || sections["default"] || sections["everything"]
+
+	var b strings.Builder
+
+	if all || sections["server"] {
+		port := 0
+		if addr, ok := rs.lis.Addr().(*net.TCPAddr); ok {
+			port = addr.Port
+		}
+
+		fmt.Fprintf(&b, "# Server\r\n")
+		fmt.Fprintf(&b, "redis_version:%s\r\n", respVersion)
+		fmt.Fprintf(&b, "redis_mode:standalone\r\n")
+		fmt.Fprintf(&b, "process_id:%d\r\n", os.Getpid())
+		fmt.Fprintf(&b, "tcp_port:%d\r\n", port)
+		fmt.Fprintf(&b, "uptime_in_seconds:%d\r\n", int64(time.Since(rs.startedAt).Seconds()))
+		fmt.Fprintf(&b, "\r\n")
+	}
+
+	if all || sections["clients"] {
+		fmt.Fprintf(&b, "# Clients\r\n")
+		fmt.Fprintf(&b, "connected_clients:%d\r\n", rs.connectedClients())
+		fmt.Fprintf(&b, "\r\n")
+	}
+
+	if all || sections["stats"] {
+		cache := rs.s.db.cacheStats()
+		compression := &rs.s.db.compression
+
+		fmt.Fprintf(&b, "# Stats\r\n")
+		fmt.Fprintf(&b, "total_commands_processed:%d\r\n", rs.commands.Load())
+		fmt.Fprintf(&b, "cache_hits:%d\r\n", cache.hits)
+		fmt.Fprintf(&b, "cache_misses:%d\r\n", cache.misses)
+		fmt.Fprintf(&b, "cache_entries:%d\r\n", cache.entries)
+		fmt.Fprintf(&b, "cache_bytes:%d\r\n", cache.bytes)
+		fmt.Fprintf(&b, "compressed_values:%d\r\n", compression.values.Load())
+		fmt.Fprintf(&b, "compression_bytes_in:%d\r\n", compression.bytesIn.Load())
+		fmt.Fprintf(&b, "compression_bytes_out:%d\r\n", compression.bytesOut.Load())
+		fmt.Fprintf(&b, "\r\n")
+	}
+
+	c.w.bulk(strings.TrimSuffix(b.String(), "\r\n"))
+}
+
+// authenticate authenticates the connection with a token given as the
+// password. The username, if it is not "default", must be the name of the
+// principal that the token belongs to. Without an authorization policy
+// there are no users, and any credentials are accepted.
+func (rs *respServer) authenticate(c *respConn, username, password string) bool {
+	if rs.s.policy == nil {
+		return true
+	}
+
+	pr, ok := rs.s.policy.authenticate(password)
+	if !ok || (username != "default" && username != pr.name) {
+		c.w.error("WRONGPASS invalid username-password pair or user is disabled.")
+		return false
+	}
+
+	c.principal = pr
+	return true
+}
+
+// auth implements AUTH [username] password.
+func (rs *respServer) auth(c *respConn, args []string) {
+	if len(args) > 3 {
+		c.w.error("ERR syntax error")
+		return
+	}
+
+	if rs.s.policy == nil {
+		c.w.error("ERR AUTH <password> called without any password configured for the default user. " +
+			"Are you sure your configuration is correct?")
+		return
+	}
+
+	username := "default"
+	if len(args) == 3 {
+		username = args[1]
+	}
+
+	if rs.authenticate(c, username, args[len(args)-1]) {
+		c.w.simple("OK")
+	}
+}
+
+// hello implements HELLO [protover [AUTH username password] [SETNAME
+// name]], which switches the connection to another version of the
+// protocol.
+func (rs *respServer) hello(c *respConn, args []string) {
+	version := c.w.version
+
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || (v != 2 && v != 3) {
+			c.w.error("NOPROTO unsupported protocol version")
+			return
+		}
+		version = v
+	}
+
+	name := c.name
+	var username, password string
+	withAuth := false
+
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "AUTH" && i+2 < len(args):
+			username, password, withAuth = args[i+1], args[i+2], true
+			i += 2
+		case option == "SETNAME" && i+1 < len(args):
+			i++
+			name = args[i]
+		default:
+			c.w.error(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i]))
+			return
+		}
+	}
+
+	if withAuth && !rs.authenticate(c, username, password) {
+		return
+	}
+
+	if rs.s.policy != nil && c.principal == nil {
+		c.w.error("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+		return
+	}
+
+	c.w.version = version
+	c.name = name
+
+	c.w.mapOf(7)
+	c.w.bulk("server")
+	c.w.bulk("redis")
+	c.w.bulk("version")
+	c.w.bulk(respVersion)
+	c.w.bulk("proto")
+	c.w.integer(int64(version))
+	c.w.bulk("id")
+	c.w.integer(c.id)
+	c.w.bulk("mode")
+	c.w.bulk("standalone")
+	c.w.bulk("role")
+	c.w.bulk("master")
+	c.w.bulk("modules")
+	c.w.array(0)
+}
+
+// client implements the CLIENT subcommands that client libraries send
+// when they connect.
+func (rs *respServer) client(c *respConn, args []string) {
+	switch subcommand := strings.ToUpper(args[1]); {
+	case subcommand == "SETNAME" && len(args) == 3:
+		c.name = args[2]
+		c.w.simple("OK")
+	case subcommand == "SETINFO" && len(args) == 4:
+		c.w.simple("OK")
+	case subcommand == "GETNAME" && len(args) == 2:
+		if c.name == "" {
+			c.w.null()
+		} else {
+			c.w.bulk(c.name)
+		}
+	case subcommand == "ID" && len(args) == 2:
+		c.w.integer(c.id)
+	default:
+		c.w.error(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'", args[1]))
+	}
+}
+
+// selectDB implements SELECT. There is a single database, number 0.
+func (rs *respServer) selectDB(c *respConn, args []string) {
+	if args[1] != "0" {
+		c.w.error("ERR DB index is out of range")
+		return
+	}
+
+	c.w.simple("OK")
+}
+
+// command replies to COMMAND, which redis-cli sends to learn about the
+// commands, with no information.
+func (rs *respServer) command(c *respConn, args []string) {
+	c.w.array(0)
+}
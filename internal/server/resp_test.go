@@ -0,0 +1,413 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// respClient is a minimal RESP client. Replies are read as strings, int64,
+// nil, []any, map[string]any or respError.
+type respClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+type respError string
+
+func dialRESP(t *testing.T, addr string) *respClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &respClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *respClient) send(args ...string) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	_, err := io.WriteString(c.conn, b.String())
+	return err
+}
+
+func (c *respClient) do(t *testing.T, args ...string) any {
+	t.Helper()
+
+	if err := c.send(args...); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := c.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return reply
+}
+
+func (c *respClient) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '_':
+		return nil, nil
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:size]), nil
+	case '*', '%':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		if line[0] == '%' {
+			m := make(map[string]any)
+			for i := 0; i < n; i++ {
+				key, err := c.read()
+				if err != nil {
+					return nil, err
+				}
+				value, err := c.read()
+				if err != nil {
+					return nil, err
+				}
+				m[fmt.Sprint(key)] = value
+			}
+			return m, nil
+		}
+
+		elements := []any{}
+		for i := 0; i < n; i++ {
+			element, err := c.read()
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, element)
+		}
+		return elements, nil
+	default:
+		return nil, fmt.Errorf("unknown reply %q", line)
+	}
+}
+
+// startRESPServer serves the Redis protocol for a new test database, and
+// returns its address.
+func startRESPServer(t *testing.T) (*server, string) {
+	t.Helper()
+	t.Cleanup(deleteDatabase)
+
+	s := getServer()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs := newRESPServer(s, lis)
+	go rs.serve()
+	t.Cleanup(rs.gracefulStop)
+
+	return s, lis.Addr().String()
+}
+
+func Test_respServer_commands(t *testing.T) {
+	_, addr := startRESPServer(t)
+	c := dialRESP(t, addr)
+
+	tests := []struct {
+		command []string
+		want    any
+	}{
+		{[]string{"PING"}, "PONG"},
+		{[]string{"ping", "hello"}, "hello"},
+		{[]string{"SET", "key", "value"}, "OK"},
+		{[]string{"GET", "key"}, "value"},
+		{[]string{"GET", "missing"}, nil},
+		{[]string{"SET", "key", "value2", "NX"}, nil},
+		{[]string{"SET", "new", "value", "XX"}, nil},
+		{[]string{"SET", "new", "value", "NX"}, "OK"},
+		{[]string{"SET", "key", "value2", "XX"}, "OK"},
+		{[]string{"GET", "key"}, "value2"},
+		{[]string{"EXISTS", "key", "new", "missing", "key"}, int64(3)},
+		{[]string{"MSET", "a", "1", "b", "2"}, "OK"},
+		{[]string{"MGET", "a", "missing", "b"}, []any{"1", nil, "2"}},
+		{[]string{"INCR", "a"}, int64(2)},
+		{[]string{"INCR", "counter"}, int64(1)},
+		{[]string{"INCR", "key"}, respError("ERR value is not an integer or out of range")},
+		{[]string{"DEL", "key", "a", "missing", "a"}, int64(2)},
+		{[]string{"GET", "key"}, nil},
+		{[]string{"EXISTS", "key"}, int64(0)},
+		{[]string{"SET", "key", "value", "EX", "0"}, respError("ERR invalid expire time in 'set' command")},
+		{[]string{"SET", "key", "value", "EX"}, respError("ERR syntax error")},
+		{[]string{"SET", "key", "value", "NX", "XX"}, respError("ERR syntax error")},
+		{[]string{"SET", "", "value"}, respError("ERR Key cannot be empty")},
+		{[]string{"GET"}, respError("ERR wrong number of arguments for 'get' command")},
+		{[]string{"MSET", "a", "1", "b"}, respError("ERR wrong number of arguments for 'mset' command")},
+		{[]string{"FLUSHALL"}, respError("ERR unknown command 'FLUSHALL'")},
+		{[]string{"SELECT", "0"}, "OK"},
+		{[]string{"SELECT", "1"}, respError("ERR DB index is out of range")},
+		{[]string{"CLIENT", "SETNAME", "test"}, "OK"},
+		{[]string{"CLIENT", "GETNAME"}, "test"},
+		{[]string{"QUIT"}, "OK"},
+	}
+	for _, tt := range tests {
+		got := c.do(t, tt.command...)
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got = %#v, want = %#v", tt.command, got, tt.want)
+		}
+	}
+
+	if _, err := c.read(); err != io.EOF {
+		t.Errorf("error = %v after QUIT, want = %v", err, io.EOF)
+	}
+}
+
+func Test_respServer_expiry(t *testing.T) {
+	s, addr := startRESPServer(t)
+	c := dialRESP(t, addr)
+
+	c.do(t, "SET", "short", "value", "PX", "50")
+	c.do(t, "SET", "long", "1", "EX", "100")
+	c.do(t, "INCR", "long")
+
+	if got := c.do(t, "GET", "short"); got != "value" {
+		t.Errorf("got = %v before expiry, want = value", got)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if got := c.do(t, "GET", "short"); got != nil {
+		t.Errorf("got = %v after expiry, want = nil", got)
+	}
+
+	if got := c.do(t, "EXISTS", "short", "long"); got != int64(1) {
+		t.Errorf("got = %v, want = 1", got)
+	}
+
+	// INCR keeps the expiry time
+	e, code := s.db.getEntry(context.Background(), "long")
+	if code != OK || e.value != "2" || e.expiresAt == 0 {
+		t.Errorf("got = %+v and code %v, want = value 2 with an expiry time", e, code)
+	}
+
+	// compaction drops expired and deleted keys
+	c.do(t, "SET", "deleted", "value")
+	c.do(t, "DEL", "deleted")
+
+	result, code := s.db.compact()
+	if code != OK {
+		t.Fatalf("compaction failed with code %d", code)
+	}
+
+	if result.recordsAfter != 1 {
+		t.Errorf("kept %d records, want = 1", result.recordsAfter)
+	}
+
+	if got := c.do(t, "GET", "long"); got != "2" {
+		t.Errorf("got = %v after compaction, want = 2", got)
+	}
+}
+
+func Test_respServer_scan(t *testing.T) {
+	_, addr := startRESPServer(t)
+	c := dialRESP(t, addr)
+
+	var want []string
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("user:%d", i)
+		c.do(t, "SET", key, "value")
+		want = append(want, key)
+	}
+
+	// overwritten and deleted keys are returned once and not at all
+	c.do(t, "SET", "user:3", "value2")
+	c.do(t, "SET", "other", "value")
+	c.do(t, "DEL", "other")
+
+	scan := func(args ...string) []string {
+		t.Helper()
+
+		var keys []string
+		cursor := "0"
+
+		for {
+			reply := c.do(t, append([]string{"SCAN", cursor}, args...)...)
+
+			elements, ok := reply.([]any)
+			if !ok || len(elements) != 2 {
+				t.Fatalf("reply = %#v, want = cursor and keys", reply)
+			}
+
+			cursor = elements[0].(string)
+			for _, key := range elements[1].([]any) {
+				keys = append(keys, key.(string))
+			}
+
+			if cursor == "0" {
+				sort.Strings(keys)
+				return keys
+			}
+		}
+	}
+
+	sort.Strings(want)
+
+	if got := scan("COUNT", "7"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got = %v, want = %v", got, want)
+	}
+
+	wantMatching := []string{"user:1", "user:10", "user:11", "user:12", "user:13", "user:14", "user:15", "user:16", "user:17", "user:18", "user:19"}
+	if got := scan("MATCH", "user:1*"); !reflect.DeepEqual(got, wantMatching) {
+		t.Errorf("got = %v, want = %v", got, wantMatching)
+	}
+
+	if got := c.do(t, "SCAN", "0", "COUNT", "0"); got != respError("ERR syntax error") {
+		t.Errorf("got = %v, want = syntax error", got)
+	}
+}
+
+func Test_respServer_resp3(t *testing.T) {
+	_, addr := startRESPServer(t)
+	c := dialRESP(t, addr)
+
+	hello, ok := c.do(t, "HELLO", "3").(map[string]any)
+	if !ok || hello["proto"] != int64(3) || hello["server"] != "redis" {
+		t.Errorf("got = %#v, want = map with proto 3", hello)
+	}
+
+	if err := c.send("GET", "missing"); err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if line != "_\r\n" {
+		t.Errorf("got = %q, want = RESP3 null", line)
+	}
+
+	if got := c.do(t, "HELLO", "4"); got != respError("NOPROTO unsupported protocol version") {
+		t.Errorf("got = %v, want = NOPROTO error", got)
+	}
+}
+
+func Test_respServer_inlineAndPipelined(t *testing.T) {
+	_, addr := startRESPServer(t)
+	c := dialRESP(t, addr)
+
+	if _, err := io.WriteString(c.conn, "SET key value\r\nGET key\nPING\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []any{"OK", "value", "PONG"} {
+		got, err := c.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Errorf("got = %v, want = %v", got, want)
+		}
+	}
+
+	if _, err := io.WriteString(c.conn, "*1\r\n$x\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != respError("ERR Protocol error: invalid bulk length") {
+		t.Errorf("got = %v, want = protocol error", got)
+	}
+}
+
+func Test_respServer_info(t *testing.T) {
+	_, addr := startRESPServer(t)
+	c := dialRESP(t, addr)
+
+	info, _ := c.do(t, "INFO").(string)
+
+	for _, want := range []string{"# Server\r\n", "redis_version:", "connected_clients:1", "total_commands_processed:1"} {
+		if !strings.Contains(info, want) {
+			t.Errorf("INFO = %q, want it to contain %q", info, want)
+		}
+	}
+
+	if info, _ := c.do(t, "INFO", "clients").(string); strings.Contains(info, "# Server") {
+		t.Errorf("INFO clients = %q, want only the clients section", info)
+	}
+}
+
+func Test_globMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "users", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"*:*:end", "a:b:end", true},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want = %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,692 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+	pbv2 "github.com/arpitchauhan/simple-database/database/v2"
+	"github.com/arpitchauhan/simple-database/internal/tracing"
+)
+
+type server struct {
+	pb.UnimplementedDatabaseServer
+	db *database
+
+	// Largest key and value accepted, in bytes. 0 means no limit.
+	maxKeySize   int
+	maxValueSize int
+
+	// policy authenticates and authorizes requests. Every request is
+	// allowed if it is nil.
+	policy *policy
+
+	// metrics are collected if it is not nil.
+	metrics *metrics
+
+	// replica follows the primary of the server, if it is a replica.
+	replica *replica
+	// multiPrimary merges the writes of the peers of the server, in
+	// multi-primary mode.
+	multiPrimary *multiPrimary
+}
+
+// defaultScanLimit and maxScanLimit bound the number of keys in a page of
+// a scan.
+const (
+	defaultScanLimit = 100
+	maxScanLimit     = 1000
+)
+
+var (
+	internalErr = status.Error(codes.Internal, "Internal error")
+	closedErr   = status.Error(codes.Unavailable, "Server is shutting down")
+	// not Aborted, which sharded clients take for a stale shard map
+	readOnlyErr = status.Error(codes.FailedPrecondition, "Server is a read-only replica, write to its primary")
+)
+
+func (s *server) initialize() ErrorCode {
+	return s.db.initialize()
+}
+
+// RunUntilSignalled runs the server until it receives SIGINT or SIGTERM.
+func RunUntilSignalled(cfg *Config, ready func(net.Addr)) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return Run(ctx, cfg, ready)
+}
+
+// Run serves requests until ctx is done, and then shuts down gracefully:
+// it stops accepting requests, waits up to the shutdown timeout for the
+// requests in flight, and closes the database. If ready is not nil, it is
+// called with the address of the server once it accepts requests.
+func Run(ctx context.Context, cfg *Config, ready func(net.Addr)) error {
+	logLevel = cfg.logLevel
+
+	keys, err := loadKeyring(cfg.encryptionKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.traceExporter, "simple-database", os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}()
+
+	var p *policy
+	if cfg.authPolicyFile != "" {
+		p, err = loadPolicy(cfg.authPolicyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load the authorization policy: %w", err)
+		}
+	}
+
+	var certs *certReloader
+	if cfg.tlsCert != "" {
+		certs, err = newCertReloader(cfg.tlsCert, cfg.tlsKey, cfg.tlsClientCA)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificates: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(cfg.dataDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create the data directory: %w", err)
+	}
+
+	d := &database{
+		filepath:         cfg.databasePath(),
+		initialized:      false,
+		syncWrites:       cfg.durability == durabilityFsync,
+		indexMode:        cfg.indexMode,
+		indexMemoryLimit: cfg.indexMemoryLimit,
+		cacheSize:        cfg.cacheSize,
+		watchers:         newKeyWatchers(),
+
+		compressionThreshold: cfg.compressionThreshold,
+		maxValueSize:         cfg.maxValueSize,
+		keys:                 keys,
+
+		readOnly: cfg.replicaOf != "",
+
+		nodeID:             cfg.nodeID,
+		tombstoneRetention: cfg.tombstoneRetention,
+	}
+	if cfg.nodeID != "" {
+		d.clock = &hlc{}
+	}
+	s := &server{db: d, maxKeySize: cfg.maxKeySize, maxValueSize: cfg.maxValueSize, policy: p}
+
+	if cfg.metricsAddr != "" {
+		s.metrics = newMetrics(d)
+	}
+
+	if code := s.initialize(); code != OK {
+		return fmt.Errorf("failed to initialize the database: error code %d", code)
+	}
+
+	if cfg.replicaOf != "" {
+		s.replica, err = newReplicaOf(d, cfg, certs)
+		if err != nil {
+			d.close()
+			return fmt.Errorf("failed to set up replication: %w", err)
+		}
+
+		if s.metrics != nil {
+			s.metrics.registerReplica(s.replica)
+		}
+	}
+
+	if cfg.nodeID != "" {
+		s.multiPrimary, err = newMultiPrimary(d, cfg, certs)
+		if err != nil {
+			d.close()
+			return fmt.Errorf("failed to set up multi-primary replication: %w", err)
+		}
+	}
+
+	if cfg.raftID != "" {
+		d.cluster, err = joinCluster(d, cfg, certs)
+		if err != nil {
+			d.close()
+			return err
+		}
+	}
+
+	if cfg.shardID != "" {
+		d.shard, err = openShard(d, cfg, certs)
+		if err != nil {
+			d.close()
+			return err
+		}
+	}
+
+	// closeDatabase leaves the cluster before closing the database, so that
+	// Raft stops applying writes to it, and stops moving keys to other
+	// shards.
+	closeDatabase := func() ErrorCode {
+		if d.cluster != nil {
+			if err := d.cluster.shutdown(); err != nil {
+				log.Printf("Failed to leave the cluster cleanly: %v", err)
+			}
+		}
+
+		if d.shard != nil {
+			d.shard.shutdown()
+		}
+
+		return d.close()
+	}
+
+	lis, err := net.Listen("tcp", cfg.addr)
+	if err != nil {
+		closeDatabase()
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	log.Printf("server listening at %v", lis.Addr())
+
+	frontEnds := []frontEnd{newGRPCFrontEnd(s, lis, cfg, certs)}
+
+	if s.replica != nil {
+		log.Printf("replicating from %s", cfg.replicaOf)
+		frontEnds = append(frontEnds, s.replica)
+	}
+
+	if s.multiPrimary != nil {
+		log.Printf("merging the writes of peers %s", strings.Join(cfg.multiPrimaryPeers, ", "))
+		frontEnds = append(frontEnds, s.multiPrimary)
+	}
+
+	stopAll := func() {
+		for _, fe := range frontEnds {
+			fe.stop()
+		}
+	}
+
+	optional := []struct {
+		name  string
+		addr  string
+		serve func(lis net.Listener) frontEnd
+	}{
+		{"RESP", cfg.respAddr, func(lis net.Listener) frontEnd { return newRESPServer(s, lis) }},
+		{"HTTP", cfg.httpAddr, func(lis net.Listener) frontEnd { return newHTTPFrontEnd(s, lis) }},
+		{"memcached", cfg.memcachedAddr, func(lis net.Listener) frontEnd { return newMemcachedServer(s, lis) }},
+		{"metrics", cfg.metricsAddr, func(lis net.Listener) frontEnd { return newMetricsFrontEnd(s.metrics, lis) }},
+	}
+
+	for _, o := range optional {
+		if o.addr == "" {
+			continue
+		}
+
+		lis, err := net.Listen("tcp", o.addr)
+		if err != nil {
+			stopAll()
+			closeDatabase()
+			return fmt.Errorf("failed to listen for %s: %w", o.name, err)
+		}
+
+		log.Printf("%s server listening at %v", o.name, lis.Addr())
+
+		if certs != nil {
+			lis = tls.NewListener(lis, certs.config())
+		}
+
+		frontEnds = append(frontEnds, o.serve(lis))
+	}
+
+	if ready != nil {
+		ready(lis.Addr())
+	}
+
+	serveErr := make(chan error, len(frontEnds))
+	for _, fe := range frontEnds {
+		go func() {
+			serveErr <- fe.serve()
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		stopAll()
+		closeDatabase()
+		return fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down, waiting up to %v for requests in flight", cfg.shutdownTimeout)
+
+	var wg sync.WaitGroup
+	for _, fe := range frontEnds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fe.gracefulStop()
+		}()
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(cfg.shutdownTimeout):
+		log.Printf("requests still in flight after %v, cancelling them", cfg.shutdownTimeout)
+		stopAll()
+		<-stopped
+	}
+
+	if code := closeDatabase(); code != OK {
+		return fmt.Errorf("failed to close the database: error code %d", code)
+	}
+
+	log.Printf("server stopped, database closed cleanly")
+
+	return nil
+}
+
+// frontEnd serves the database over a protocol.
+type frontEnd interface {
+	// serve serves requests until gracefulStop or stop is called, after
+	// which it returns nil.
+	serve() error
+	// gracefulStop stops accepting requests and waits for the ones in
+	// progress.
+	gracefulStop()
+	// stop cancels the requests in progress.
+	stop()
+}
+
+type grpcFrontEnd struct {
+	gs          *grpc.Server
+	lis         net.Listener
+	replication *replicationServer
+	// invalidation streams never end on their own either
+	invalidation *invalidationServer
+}
+
+func newGRPCFrontEnd(s *server, lis net.Listener, cfg *Config, certs *certReloader) *grpcFrontEnd {
+	opts := []grpc.ServerOption{
+		// leave room for the rest of the request besides the key and value
+		grpc.MaxRecvMsgSize(cfg.maxKeySize + cfg.maxValueSize + 1024),
+		// clients may keep idle connections alive with pings
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: 10 * time.Second, PermitWithoutStream: true}),
+	}
+
+	if certs != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(certs.config("h2"))))
+	}
+
+	if cfg.traceExporter != tracing.ExporterNone {
+		opts = append(opts, grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	}
+
+	// requests that fail authentication are counted too
+	var interceptors []grpc.UnaryServerInterceptor
+	if s.metrics != nil {
+		interceptors = append(interceptors, s.metrics.unaryInterceptor)
+	}
+	if s.policy != nil {
+		interceptors = append(interceptors, s.policy.unaryInterceptor)
+	}
+	if s.db.cluster != nil {
+		interceptors = append(interceptors, s.db.cluster.unaryInterceptor)
+	}
+	opts = append(opts, grpc.ChainUnaryInterceptor(interceptors...))
+
+	if s.policy != nil {
+		opts = append(opts, grpc.StreamInterceptor(s.policy.streamInterceptor))
+	}
+
+	gs := grpc.NewServer(opts...)
+	replication := newReplicationServer(s.db)
+
+	pb.RegisterDatabaseServer(gs, s)
+	pbv2.RegisterDatabaseServer(gs, &serverV2{s: s})
+	pb.RegisterReplicationServer(gs, replication)
+	pb.RegisterShardingServer(gs, &shardingServer{db: s.db})
+	pb.RegisterAntiEntropyServer(gs, &antiEntropyServer{s: s})
+	invalidation := newInvalidationServer(s.db)
+	pb.RegisterInvalidationServer(gs, invalidation)
+
+	return &grpcFrontEnd{gs: gs, lis: lis, replication: replication, invalidation: invalidation}
+}
+
+func (fe *grpcFrontEnd) serve() error {
+	return fe.gs.Serve(fe.lis)
+}
+
+func (fe *grpcFrontEnd) gracefulStop() {
+	// replication and invalidation streams never end on their own
+	fe.replication.shutdown()
+	fe.invalidation.shutdown()
+	fe.gs.GracefulStop()
+}
+
+func (fe *grpcFrontEnd) stop() {
+	fe.replication.shutdown()
+	fe.invalidation.shutdown()
+	fe.gs.Stop()
+	// in case it was never served
+	fe.lis.Close()
+}
+
+func (s *server) Get(ctx context.Context, in *pb.GetRequest) (*pb.GetReply, error) {
+	value, err := s.get(ctx, in.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !utf8.ValidString(value) {
+		return nil, status.Error(
+			codes.FailedPrecondition,
+			"Value is not valid UTF-8, use version 2 of the API to get it",
+		)
+	}
+
+	return &pb.GetReply{Value: value}, nil
+}
+
+func (s *server) get(ctx context.Context, key string) (string, error) {
+	infof("Get: received key: %v", key)
+
+	keyValid, errmsg := s.isKeyValid(key)
+
+	if !keyValid {
+		return "", status.Error(codes.InvalidArgument, errmsg)
+	}
+
+	e, errCode := s.db.getEntry(ctx, key)
+
+	if errCode == KeyNotFound {
+		return "", status.Error(codes.NotFound, "Key was not found")
+	}
+
+	if errCode == DatabaseClosed {
+		return "", closedErr
+	}
+
+	if errCode == WrongShard {
+		return "", wrongShardErr
+	}
+
+	if errCode != OK {
+		return "", internalErr
+	}
+
+	return e.value, nil
+}
+
+func (s *server) Set(ctx context.Context, in *pb.SetRequest) (*pb.SetReply, error) {
+	if err := s.set(ctx, in.Key, in.Value); err != nil {
+		return nil, err
+	}
+
+	return &pb.SetReply{}, nil
+}
+
+func (s *server) set(ctx context.Context, key string, value string) error {
+	return s.setIf(ctx, key, value, nil)
+}
+
+// setIf is set, but if condition is not nil, only if it holds for the
+// current entry of the key. Otherwise it fails with FailedPrecondition.
+func (s *server) setIf(ctx context.Context, key, value string, condition func(current entry, found bool) bool) error {
+	if logLevel == logLevelDebug {
+		debugf("Set: received key: %v, value: %v", key, value)
+	} else {
+		infof("Set: received key: %v", key)
+	}
+
+	keyValid, errmsg := s.isKeyValid(key)
+
+	if !keyValid {
+		return status.Error(codes.InvalidArgument, errmsg)
+	}
+
+	if s.maxValueSize > 0 && len(value) > s.maxValueSize {
+		return status.Errorf(codes.InvalidArgument, "Value cannot be larger than %d bytes", s.maxValueSize)
+	}
+
+	var code ErrorCode
+
+	if condition == nil {
+		code = s.db.setEntries(ctx, change{key: key, entry: entry{value: value}})
+	} else {
+		code = s.db.updateKey(ctx, key, func(current entry, found bool) (entry, ErrorCode) {
+			if !condition(current, found) {
+				return entry{}, ConditionFailed
+			}
+			return entry{value: value}, OK
+		})
+	}
+
+	if code == ConditionFailed {
+		return status.Error(codes.FailedPrecondition, "Condition on the current value does not hold")
+	}
+
+	if code == DatabaseClosed {
+		return closedErr
+	}
+
+	if code == ReadOnly {
+		return readOnlyErr
+	}
+
+	if code == NotLeader {
+		return notLeaderErr
+	}
+
+	if code == WrongShard {
+		return wrongShardErr
+	}
+
+	if code != OK {
+		return internalErr
+	}
+
+	return nil
+}
+
+// scan returns up to limit keys that start with prefix and that allowed
+// accepts, from cursor, and the cursor of the next page, which is 0 once
+// every key was scanned. Only the last page has fewer keys than the limit.
+func (s *server) scan(prefix string, cursor uint64, limit int, allowed func(key string) bool) ([]string, uint64, error) {
+	var page []string
+
+	// every record scanned holds at most one key, so scanning no more
+	// records than there is room left for keys keeps the page within limit
+	for {
+		keys, next, code := s.db.scanKeys(cursor, limit-len(page))
+
+		if code == DatabaseClosed {
+			return nil, 0, closedErr
+		}
+
+		if code != OK {
+			return nil, 0, internalErr
+		}
+
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) && allowed(key) {
+				page = append(page, key)
+			}
+		}
+
+		cursor = next
+
+		if cursor == 0 || len(page) == limit {
+			return page, cursor, nil
+		}
+	}
+}
+
+// deleteIf deletes a key if condition is nil or holds for its current
+// entry. Otherwise it fails with FailedPrecondition.
+func (s *server) deleteIf(ctx context.Context, key string, condition func(current entry) bool) error {
+	infof("Delete: received key: %v", key)
+
+	keyValid, errmsg := s.isKeyValid(key)
+
+	if !keyValid {
+		return status.Error(codes.InvalidArgument, errmsg)
+	}
+
+	code := s.db.deleteKeyIf(ctx, key, func(current entry) ErrorCode {
+		if condition != nil && !condition(current) {
+			return ConditionFailed
+		}
+		return OK
+	})
+
+	if code == KeyNotFound {
+		return status.Error(codes.NotFound, "Key was not found")
+	}
+
+	if code == ConditionFailed {
+		return status.Error(codes.FailedPrecondition, "Condition on the current value does not hold")
+	}
+
+	if code == DatabaseClosed {
+		return closedErr
+	}
+
+	if code == ReadOnly {
+		return readOnlyErr
+	}
+
+	if code == NotLeader {
+		return notLeaderErr
+	}
+
+	if code == WrongShard {
+		return wrongShardErr
+	}
+
+	if code != OK {
+		return internalErr
+	}
+
+	return nil
+}
+
+func (s *server) Stats(ctx context.Context, in *pb.StatsRequest) (*pb.StatsReply, error) {
+	cache := s.db.cacheStats()
+	compression := &s.db.compression
+
+	reply := &pb.StatsReply{
+		CacheHits:           cache.hits,
+		CacheMisses:         cache.misses,
+		CacheEntries:        cache.entries,
+		CacheBytes:          cache.bytes,
+		CompressedValues:    compression.values.Load(),
+		CompressionBytesIn:  compression.bytesIn.Load(),
+		CompressionBytesOut: compression.bytesOut.Load(),
+	}
+
+	if reply.CompressionBytesOut > 0 {
+		reply.CompressionRatio = float64(reply.CompressionBytesIn) / float64(reply.CompressionBytesOut)
+	}
+
+	reply.Role = "primary"
+	if c := s.db.cluster; c != nil {
+		reply.Role = c.role()
+		if leader, ok := c.leader(); ok {
+			reply.ClusterLeader = leader.grpcAddr
+		}
+	}
+
+	if s.replica != nil {
+		connected, lagBytes, lag := s.replica.lag()
+
+		reply.Role = "replica"
+		reply.ReplicaConnected = connected
+		reply.ReplicationLagBytes = lagBytes
+		reply.ReplicationLagSeconds = lag.Seconds()
+	}
+
+	if mp := s.multiPrimary; mp != nil {
+		reply.Role = "multi-primary"
+		for _, p := range mp.peers {
+			connected, lagBytes := p.lag()
+			reply.Peers = append(reply.Peers, &pb.PeerStats{Addr: p.addr, Connected: connected, LagBytes: lagBytes})
+		}
+	}
+
+	return reply, nil
+}
+
+func (s *server) Compact(ctx context.Context, in *pb.CompactRequest) (*pb.CompactReply, error) {
+	infof("Compact: started")
+
+	result, code := s.db.compact()
+
+	if code == DatabaseClosed {
+		return nil, closedErr
+	}
+
+	if code == ReadOnly {
+		return nil, readOnlyErr
+	}
+
+	if code == Rebalancing {
+		return nil, rebalancingErr
+	}
+
+	if code != OK {
+		return nil, internalErr
+	}
+
+	infof(
+		"Compact: kept %d of %d records, %d bytes down to %d",
+		result.recordsAfter,
+		result.recordsBefore,
+		result.bytesBefore,
+		result.bytesAfter,
+	)
+
+	return &pb.CompactReply{
+		RecordsBefore: result.recordsBefore,
+		RecordsAfter:  result.recordsAfter,
+		BytesBefore:   result.bytesBefore,
+		BytesAfter:    result.bytesAfter,
+	}, nil
+}
+
+func (s *server) isKeyValid(key string) (bool, string) {
+	if len(strings.TrimSpace(key)) == 0 {
+		return false, "Key cannot be empty"
+	}
+
+	if s.maxKeySize > 0 && len(key) > s.maxKeySize {
+		return false, fmt.Sprintf("Key cannot be larger than %d bytes", s.maxKeySize)
+	}
+
+	return true, ""
+}
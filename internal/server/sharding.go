@@ -0,0 +1,644 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+	"github.com/arpitchauhan/simple-database/sharding"
+)
+
+// In a sharded deployment, every server is a shard that holds the keys that
+// the shard map places on it. Requests for other keys fail with WrongShard,
+// which tells clients to fetch the map again.
+//
+// A rebalancing moves keys to the shards of a new map. While it is in
+// progress, the map lists the previous shards too, and every shard moves
+// the keys that it no longer owns to their new owners, which import the
+// keys that they have no record of. A new owner also pulls a key from its
+// previous owner whenever the key is used before it was moved, so that no
+// request misses it. Keys written or deleted on the new owner keep a record
+// there, which keeps the moved entry from overwriting them.
+
+const (
+	shardMapFileName = "shardmap.json"
+
+	// migrationBatchSize is the number of records scanned for keys to move
+	// at a time.
+	migrationBatchSize = 100
+	// migrationRetryDelay is how long moving keys waits after failing
+	// before it tries again.
+	migrationRetryDelay = time.Second
+	// shardRequestTimeout bounds the requests to other shards.
+	shardRequestTimeout = 10 * time.Second
+)
+
+var (
+	wrongShardErr = status.Error(codes.Aborted, "Key belongs to another shard, fetch the shard map again")
+	// the server is up but not in the state that the request needs, which
+	// clients must not take for an endpoint that is down
+	notShardedErr  = status.Error(codes.FailedPrecondition, "Server is not a shard")
+	rebalancingErr = status.Error(codes.FailedPrecondition, "Shard is being rebalanced, compact it once that is done")
+)
+
+// shard is the part of a sharded deployment that a server holds.
+type shard struct {
+	d  *database
+	id string
+	// path is where the latest shard map is kept, so that the shard
+	// restarts with it.
+	path string
+
+	mu sync.RWMutex
+	// m is nil until the shard is given a map, and it owns no key until
+	// then.
+	m *sharding.Map
+
+	// token authenticates the shard to the others.
+	token    string
+	dialOpts []grpc.DialOption
+	connsMu  sync.Mutex
+	conns    map[string]*grpc.ClientConn
+
+	// kick wakes up the migration of keys after the map changed.
+	kick   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// migratedVersion is the version of the last map whose keys the shard
+	// moved, and migrated the number of keys moved since it started.
+	migratedVersion atomic.Uint64
+	migrated        atomic.Uint64
+}
+
+// openShard makes d the shard in cfg. Its map is the one kept in the data
+// directory or the one of cfg, whichever is the latest. It connects to the
+// other shards with TLS if certs is not nil.
+func openShard(d *database, cfg *Config, certs *certReloader) (*shard, error) {
+	creds, err := peerCredentials(certs != nil, cfg.tlsClientCA, certs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up connections to the other shards: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sh := &shard{
+		d:     d,
+		id:    cfg.shardID,
+		path:  filepath.Join(cfg.dataDir, shardMapFileName),
+		token: cfg.shardToken,
+		dialOpts: []grpc.DialOption{
+			grpc.WithTransportCredentials(creds),
+			grpc.WithDefaultCallOptions(
+				grpc.MaxCallSendMsgSize(migrationBatchSize*(cfg.maxKeySize+cfg.maxValueSize+1024)),
+				grpc.MaxCallRecvMsgSize(cfg.maxKeySize+cfg.maxValueSize+1024),
+			),
+		},
+		conns:  make(map[string]*grpc.ClientConn),
+		kick:   make(chan struct{}, 1),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	kept, err := sharding.Load(sh.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		cancel()
+		return nil, fmt.Errorf("failed to load the shard map: %w", err)
+	}
+	sh.m = kept
+
+	if cfg.shardMap != "" {
+		m, err := sharding.Load(cfg.shardMap)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to load the shard map: %w", err)
+		}
+
+		if sh.m == nil || m.Version > sh.m.Version {
+			if err := sh.setMap(m); err != nil {
+				cancel()
+				return nil, err
+			}
+		}
+	}
+
+	if sh.m == nil {
+		log.Printf("shard %s has no shard map yet, and owns no key until it is given one", sh.id)
+	} else {
+		log.Printf("shard %s of a map of %d shards, version %d", sh.id, len(sh.m.Shards), sh.m.Version)
+	}
+
+	go sh.migrateWhenKicked()
+	sh.kickMigration()
+
+	return sh, nil
+}
+
+func (sh *shard) shardMap() *sharding.Map {
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	return sh.m
+}
+
+// setMap replaces the map of the shard with m, unless m is older, and
+// keeps it in the data directory.
+func (sh *shard) setMap(m *sharding.Map) error {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.m != nil && m.Version < sh.m.Version {
+		return status.Errorf(codes.FailedPrecondition, "Shard map version %d is older than version %d", m.Version, sh.m.Version)
+	}
+
+	if sh.m != nil && m.Version == sh.m.Version {
+		return nil
+	}
+
+	contents, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := sh.path + ".tmp"
+	if err := os.WriteFile(tmpPath, contents, 0o644); err != nil {
+		return fmt.Errorf("failed to keep the shard map: %w", err)
+	}
+	if err := os.Rename(tmpPath, sh.path); err != nil {
+		return fmt.Errorf("failed to keep the shard map: %w", err)
+	}
+
+	sh.m = m
+	sh.kickMigration()
+
+	return nil
+}
+
+func (sh *shard) owns(key string) bool {
+	m := sh.shardMap()
+	return m != nil && m.Owner(key).ID == sh.id
+}
+
+// rebalancing reports whether keys are being moved between shards.
+func (sh *shard) rebalancing() bool {
+	m := sh.shardMap()
+	return m != nil && m.Rebalancing()
+}
+
+// migrating reports whether the shard is still moving keys to their new
+// owners.
+func (sh *shard) migrating() bool {
+	m := sh.shardMap()
+	return m != nil && m.Rebalancing() && sh.migratedVersion.Load() != m.Version
+}
+
+// pull checks that the shard owns keys, and imports those that it has no
+// record of from their previous owners during a rebalancing, so that they
+// can be used before they were moved.
+func (sh *shard) pull(ctx context.Context, keys ...string) ErrorCode {
+	m := sh.shardMap()
+
+	for _, key := range keys {
+		if m == nil || m.Owner(key).ID != sh.id {
+			return WrongShard
+		}
+
+		previous, ok := m.PreviousOwner(key)
+		if !ok || previous.ID == sh.id {
+			continue
+		}
+
+		found, code := sh.d.hasRecord(key)
+		if code != OK {
+			return code
+		} else if found {
+			continue
+		}
+
+		e, found, err := sh.fetch(ctx, previous, key)
+		if err != nil {
+			log.Printf("Failed to fetch a key from shard %s: %v", previous.ID, err)
+			return InternalError
+		}
+
+		if !found {
+			continue
+		}
+
+		if _, code := sh.d.importEntries(ctx, change{key: key, entry: e}); code != OK {
+			return code
+		}
+	}
+
+	return OK
+}
+
+func (sh *shard) fetch(ctx context.Context, from sharding.Shard, key string) (entry, bool, error) {
+	conn, err := sh.conn(from.Addr)
+	if err != nil {
+		return entry{}, false, err
+	}
+
+	ctx, cancel := context.WithTimeout(sh.outgoing(ctx), shardRequestTimeout)
+	defer cancel()
+
+	reply, err := pb.NewShardingClient(conn).Fetch(ctx, &pb.FetchRequest{Key: []byte(key)})
+	if err != nil {
+		return entry{}, false, err
+	}
+
+	if !reply.Found {
+		return entry{}, false, nil
+	}
+
+	return entryFromProto(reply.Entry), true, nil
+}
+
+// outgoing returns ctx with the token of the shard, if it has one.
+func (sh *shard) outgoing(ctx context.Context) context.Context {
+	if sh.token == "" {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+sh.token)
+}
+
+func (sh *shard) conn(addr string) (*grpc.ClientConn, error) {
+	sh.connsMu.Lock()
+	defer sh.connsMu.Unlock()
+
+	if conn, ok := sh.conns[addr]; ok {
+		return conn, nil
+	}
+
+	conn, err := grpc.NewClient(addr, sh.dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	sh.conns[addr] = conn
+	return conn, nil
+}
+
+func (sh *shard) kickMigration() {
+	select {
+	case sh.kick <- struct{}{}:
+	default:
+	}
+}
+
+// migrateWhenKicked moves the keys that the shard no longer owns whenever
+// a rebalancing starts, until the shard shuts down.
+func (sh *shard) migrateWhenKicked() {
+	defer close(sh.done)
+
+	for {
+		select {
+		case <-sh.kick:
+		case <-sh.ctx.Done():
+			return
+		}
+
+		m := sh.shardMap()
+		if m == nil || !m.Rebalancing() || sh.migratedVersion.Load() == m.Version {
+			continue
+		}
+
+		if sh.migrate(m) {
+			sh.migratedVersion.Store(m.Version)
+			log.Printf("shard %s moved its keys for shard map version %d", sh.id, m.Version)
+		}
+	}
+}
+
+// migrate moves every key that m places on another shard to it, and
+// returns whether it did. It gives up if the map changes or the shard
+// shuts down.
+func (sh *shard) migrate(m *sharding.Map) bool {
+	var cursor uint64
+
+	for {
+		keys, next, code := sh.d.scanKeys(cursor, migrationBatchSize)
+		if code != OK {
+			return false
+		}
+
+		var moving []string
+		for _, key := range keys {
+			if m.Owner(key).ID != sh.id {
+				moving = append(moving, key)
+			}
+		}
+
+		changes, code := sh.d.readEntries(sh.ctx, moving...)
+		if code != OK {
+			return false
+		}
+
+		byOwner := make(map[sharding.Shard][]change)
+		for _, c := range changes {
+			owner := m.Owner(c.key)
+			byOwner[owner] = append(byOwner[owner], c)
+		}
+
+		for owner, changes := range byOwner {
+			if !sh.moveKeys(m, owner, changes) {
+				return false
+			}
+		}
+
+		if next == 0 {
+			return true
+		}
+		cursor = next
+	}
+}
+
+// moveKeys imports changes into their new owner until it succeeds, and then
+// deletes them from the shard.
+func (sh *shard) moveKeys(m *sharding.Map, owner sharding.Shard, changes []change) bool {
+	entries := make([]*pb.ShardEntry, len(changes))
+	for i, c := range changes {
+		entries[i] = entryProto(c.key, c.entry)
+	}
+
+	for {
+		err := sh.importInto(owner, entries)
+		if err == nil {
+			break
+		}
+
+		log.Printf("Failed to move %d keys to shard %s, retrying: %v", len(entries), owner.ID, err)
+
+		select {
+		case <-sh.ctx.Done():
+			return false
+		case <-time.After(migrationRetryDelay):
+		}
+
+		if sh.shardMap() != m {
+			return false
+		}
+	}
+
+	if code := sh.d.forgetMoved(sh.ctx, changes); code != OK {
+		return false
+	}
+
+	sh.migrated.Add(uint64(len(changes)))
+
+	return true
+}
+
+func (sh *shard) importInto(owner sharding.Shard, entries []*pb.ShardEntry) error {
+	conn, err := sh.conn(owner.Addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(sh.outgoing(sh.ctx), shardRequestTimeout)
+	defer cancel()
+
+	_, err = pb.NewShardingClient(conn).Import(ctx, &pb.ImportRequest{Entries: entries})
+	return err
+}
+
+// shutdown stops moving keys, and closes the connections to the other
+// shards.
+func (sh *shard) shutdown() {
+	sh.cancel()
+	<-sh.done
+
+	sh.connsMu.Lock()
+	defer sh.connsMu.Unlock()
+
+	for _, conn := range sh.conns {
+		conn.Close()
+	}
+}
+
+func entryProto(key string, e entry) *pb.ShardEntry {
+	return &pb.ShardEntry{Key: []byte(key), Value: []byte(e.value), ExpiresAt: e.expiresAt, Flags: e.flags}
+}
+
+func entryFromProto(e *pb.ShardEntry) entry {
+	return entry{value: string(e.GetValue()), expiresAt: e.GetExpiresAt(), flags: e.GetFlags()}
+}
+
+// hasRecord reports whether the database file has a record of key, even
+// one that deleted it.
+func (d *database) hasRecord(key string) (bool, ErrorCode) {
+	d.ensureInitialized()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return false, DatabaseClosed
+	}
+
+	found, _, code := d.getKeyPosition(key)
+	return found, code
+}
+
+// readEntries returns the entries of the keys that exist, as changes that
+// set them. They are read past the cache, as they are usually read once.
+func (d *database) readEntries(ctx context.Context, keys ...string) ([]change, ErrorCode) {
+	d.ensureInitialized()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return nil, DatabaseClosed
+	}
+
+	var changes []change
+	for _, key := range keys {
+		e, code := d.readStoredEntry(ctx, key)
+		if code == KeyNotFound {
+			continue
+		} else if code != OK {
+			return nil, code
+		}
+
+		changes = append(changes, change{key: key, entry: e})
+	}
+
+	return changes, OK
+}
+
+// importEntries writes the changes to keys that the database has no record
+// of, and returns how many it wrote.
+func (d *database) importEntries(ctx context.Context, changes ...change) (int, ErrorCode) {
+	var imported []change
+
+	code := d.write(ctx, func() ([]change, ErrorCode) {
+		imported = nil
+
+		for _, c := range changes {
+			found, _, code := d.getKeyPosition(c.key)
+			if code != OK {
+				return nil, code
+			}
+
+			if !found {
+				imported = append(imported, c)
+			}
+		}
+
+		return imported, OK
+	})
+	if code != OK {
+		return 0, code
+	}
+
+	return len(imported), OK
+}
+
+// forgetMoved deletes the keys that were moved to another shard, unless
+// their entry changed since. The shard does not own them anymore, so it
+// deletes them without checking.
+func (d *database) forgetMoved(ctx context.Context, moved []change) ErrorCode {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return DatabaseClosed
+	}
+
+	var changes []change
+	for _, c := range moved {
+		current, code := d.readEntry(ctx, c.key)
+		if code == KeyNotFound {
+			continue
+		} else if code != OK {
+			return code
+		}
+
+		if current == c.entry {
+			changes = append(changes, change{key: c.key, deleted: true})
+		}
+	}
+
+	if len(changes) == 0 {
+		return OK
+	}
+
+	return d.apply(ctx, changes)
+}
+
+// shardingServer serves the shard map, and the requests that shards make
+// to each other to move keys.
+type shardingServer struct {
+	pb.UnimplementedShardingServer
+	db *database
+}
+
+func (ss *shardingServer) GetShardMap(ctx context.Context, in *pb.GetShardMapRequest) (*pb.GetShardMapReply, error) {
+	sh := ss.db.shard
+	if sh == nil {
+		return nil, notShardedErr
+	}
+
+	m := sh.shardMap()
+	if m == nil {
+		return nil, status.Error(codes.FailedPrecondition, "Shard has no shard map yet")
+	}
+
+	return &pb.GetShardMapReply{
+		Map:          m.Proto(),
+		ShardId:      sh.id,
+		Migrating:    sh.migrating(),
+		MigratedKeys: sh.migrated.Load(),
+	}, nil
+}
+
+func (ss *shardingServer) SetShardMap(ctx context.Context, in *pb.SetShardMapRequest) (*pb.SetShardMapReply, error) {
+	sh := ss.db.shard
+	if sh == nil {
+		return nil, notShardedErr
+	}
+
+	m, err := sharding.FromProto(in.Map)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid shard map: %v", err)
+	}
+
+	if err := sh.setMap(m); err != nil {
+		if _, ok := status.FromError(err); !ok {
+			log.Print(err)
+			return nil, internalErr
+		}
+		return nil, err
+	}
+
+	infof("SetShardMap: version %d", m.Version)
+
+	return &pb.SetShardMapReply{}, nil
+}
+
+func (ss *shardingServer) Import(ctx context.Context, in *pb.ImportRequest) (*pb.ImportReply, error) {
+	if ss.db.shard == nil {
+		return nil, notShardedErr
+	}
+
+	changes := make([]change, len(in.Entries))
+	for i, e := range in.Entries {
+		changes[i] = change{key: string(e.Key), entry: entryFromProto(e)}
+	}
+
+	imported, code := ss.db.importEntries(ctx, changes...)
+
+	if code == WrongShard {
+		return nil, wrongShardErr
+	}
+
+	if code == DatabaseClosed {
+		return nil, closedErr
+	}
+
+	if code != OK {
+		return nil, internalErr
+	}
+
+	return &pb.ImportReply{Imported: uint32(imported)}, nil
+}
+
+func (ss *shardingServer) Fetch(ctx context.Context, in *pb.FetchRequest) (*pb.FetchReply, error) {
+	if ss.db.shard == nil {
+		return nil, notShardedErr
+	}
+
+	changes, code := ss.db.readEntries(ctx, string(in.Key))
+
+	if code == DatabaseClosed {
+		return nil, closedErr
+	}
+
+	if code != OK {
+		return nil, internalErr
+	}
+
+	if len(changes) == 0 {
+		return &pb.FetchReply{}, nil
+	}
+
+	return &pb.FetchReply{Found: true, Entry: entryProto(changes[0].key, changes[0].entry)}, nil
+}
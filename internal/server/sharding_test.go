@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/arpitchauhan/simple-database/client"
+	pb "github.com/arpitchauhan/simple-database/database"
+	"github.com/arpitchauhan/simple-database/sharding"
+)
+
+func dialSharding(t *testing.T, addr string) pb.ShardingClient {
+	t.Helper()
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewShardingClient(conn)
+}
+
+func writeShardMap(t *testing.T, m *sharding.Map) string {
+	t.Helper()
+
+	contents, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "shardmap.json")
+	if err := os.WriteFile(path, contents, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func newShardMap(t *testing.T, version uint64, shards, previous []sharding.Shard) *sharding.Map {
+	t.Helper()
+
+	m, err := sharding.New(version, sharding.DefaultVirtualNodes, shards, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+// useShardedClient routes the requests of the client package through the
+// shard at addr until the test ends.
+func useShardedClient(t *testing.T, addr string) {
+	interval := client.RebalancePollInterval
+
+	client.SetAddr(addr)
+	client.SetSharding(true)
+	client.RebalancePollInterval = 10 * time.Millisecond
+
+	t.Cleanup(func() {
+		client.SetAddr(client.DefaultAddr)
+		client.SetSharding(false)
+		client.RebalancePollInterval = interval
+	})
+}
+
+// assertPlacement checks that every key is on the shard that m places it
+// on, and on no other.
+func assertPlacement(t *testing.T, m *sharding.Map, shards []sharding.Shard, keys map[string]string) {
+	t.Helper()
+
+	for _, s := range shards {
+		sc := dialSharding(t, s.Addr)
+
+		for key, want := range keys {
+			reply, err := sc.Fetch(context.Background(), &pb.FetchRequest{Key: []byte(key)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			owner := m.Owner(key).ID == s.ID
+			if reply.Found != owner || owner && string(reply.Entry.Value) != want {
+				t.Errorf("%s on shard %s = %v, found = %t, want found = %t", key, s.ID, reply.Entry, reply.Found, owner)
+			}
+		}
+	}
+}
+
+func Test_sharding(t *testing.T) {
+	shards := []sharding.Shard{
+		{ID: "s1", Addr: freeAddr(t)},
+		{ID: "s2", Addr: freeAddr(t)},
+		{ID: "s3", Addr: freeAddr(t)},
+	}
+
+	mapFile := writeShardMap(t, newShardMap(t, 1, shards[:2], nil))
+
+	for _, s := range shards[:2] {
+		runServer(t, "-addr", s.Addr, "-data-dir", t.TempDir(), "-shard-id", s.ID, "-shard-map", mapFile)
+	}
+	// the new shard has no map until the rebalancing gives it one
+	runServer(t, "-addr", shards[2].Addr, "-data-dir", t.TempDir(), "-shard-id", shards[2].ID)
+
+	useShardedClient(t, shards[0].Addr)
+
+	keys := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key, value := fmt.Sprintf("key%d", i), fmt.Sprint(i)
+		keys[key] = value
+
+		if err := client.SetValueForKey(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertPlacement(t, newShardMap(t, 1, shards[:2], nil), shards, keys)
+
+	for key := range keys {
+		if newShardMap(t, 1, shards[:2], nil).Owner(key).ID == "s1" {
+			continue
+		}
+
+		_, err := dialDatabase(t, shards[0].Addr).Get(context.Background(), &pb.GetRequest{Key: key})
+		if status.Code(err) != codes.Aborted {
+			t.Errorf("get of a key of another shard error = %v, want = Aborted", err)
+		}
+		break
+	}
+
+	assertReadable := func() {
+		t.Helper()
+
+		for key, want := range keys {
+			if got, err := client.GetValueForKey(key); err != nil || got != want {
+				t.Errorf("%s = %q, %v, want = %q", key, got, err, want)
+			}
+		}
+	}
+
+	progress := func(format string, args ...any) { t.Logf(format, args...) }
+
+	// adding a shard moves keys to it
+	if err := client.Rebalance(shards, progress); err != nil {
+		t.Fatal(err)
+	}
+
+	assertPlacement(t, newShardMap(t, 3, shards, nil), shards, keys)
+	assertReadable()
+
+	// removing a shard moves its keys to the others
+	if err := client.Rebalance(shards[1:], progress); err != nil {
+		t.Fatal(err)
+	}
+
+	assertPlacement(t, newShardMap(t, 5, shards[1:], nil), shards, keys)
+	assertReadable()
+
+	reply, err := client.GetShardMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Map.Version != 5 || len(reply.Map.Shards) != 2 || len(reply.Map.Previous) != 0 {
+		t.Errorf("shard map = %v, want version 5 with s2 and s3", reply.Map)
+	}
+}
+
+func Test_sharding_notSharded(t *testing.T) {
+	addr := runServer(t, "-data-dir", t.TempDir())
+
+	_, err := dialSharding(t, addr).GetShardMap(context.Background(), &pb.GetShardMapRequest{})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("error = %v, want = FailedPrecondition", err)
+	}
+}
+
+func Test_sharding_pull(t *testing.T) {
+	shards := []sharding.Shard{
+		{ID: "s1", Addr: freeAddr(t)},
+		{ID: "s2", Addr: freeAddr(t)},
+	}
+
+	runServer(t, "-addr", shards[0].Addr, "-data-dir", t.TempDir(), "-shard-id", "s1",
+		"-shard-map", writeShardMap(t, newShardMap(t, 1, shards[:1], nil)))
+	runServer(t, "-addr", shards[1].Addr, "-data-dir", t.TempDir(), "-shard-id", "s2")
+
+	ctx := context.Background()
+	s1 := dialDatabase(t, shards[0].Addr)
+	s2 := dialDatabase(t, shards[1].Addr)
+
+	moving := newShardMap(t, 2, shards, shards[:1])
+
+	var moved []string
+	for i := 0; len(moved) < 2; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if moving.Owner(key).ID == "s2" {
+			moved = append(moved, key)
+		}
+
+		if _, err := s1.Set(ctx, &pb.SetRequest{Key: key, Value: "old"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only the new owner knows about the rebalancing, so s1 keeps its keys
+	// until it is told too
+	if _, err := dialSharding(t, shards[1].Addr).SetShardMap(ctx, &pb.SetShardMapRequest{Map: moving.Proto()}); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := s2.Get(ctx, &pb.GetRequest{Key: moved[0]})
+	if err != nil || reply.Value != "old" {
+		t.Errorf("get of a key that was not moved yet = %v, %v, want = old", reply, err)
+	}
+
+	if _, err := s2.Set(ctx, &pb.SetRequest{Key: moved[1], Value: "new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s1.Compact(ctx, &pb.CompactRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s2.Compact(ctx, &pb.CompactRequest{}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("compaction while rebalancing error = %v, want = FailedPrecondition", err)
+	}
+
+	if _, err := dialSharding(t, shards[0].Addr).SetShardMap(ctx, &pb.SetShardMapRequest{Map: moving.Proto()}); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the keys to move", func() bool {
+		reply, err := dialSharding(t, shards[0].Addr).GetShardMap(ctx, &pb.GetShardMapRequest{})
+		return err == nil && !reply.Migrating
+	})
+
+	// the key written on the new owner is not overwritten by the moved one
+	for key, want := range map[string]string{moved[0]: "old", moved[1]: "new"} {
+		reply, err := s2.Get(ctx, &pb.GetRequest{Key: key})
+		if err != nil || reply.Value != want {
+			t.Errorf("%s = %v, %v, want = %s", key, reply, err, want)
+		}
+	}
+
+	older := newShardMap(t, 1, shards, nil)
+	if _, err := dialSharding(t, shards[0].Addr).SetShardMap(ctx, &pb.SetShardMapRequest{Map: older.Proto()}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("older shard map error = %v, want = FailedPrecondition", err)
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+func Test_runUntilSignalled(t *testing.T) {
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGINT} {
+		t.Run(sig.String(), func(t *testing.T) {
+			dataDir := t.TempDir()
+
+			cfg, err := ParseConfig(
+				[]string{"-addr", "localhost:0", "-data-dir", dataDir, "-index", "disk", "-log-level", "error"},
+				io.Discard,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			addrs := make(chan net.Addr, 1)
+			done := make(chan error, 1)
+			go func() {
+				done <- RunUntilSignalled(cfg, func(addr net.Addr) { addrs <- addr })
+			}()
+
+			addr := <-addrs
+
+			conn, err := grpc.Dial(addr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			client := pb.NewDatabaseClient(conn)
+			if _, err := client.Set(context.Background(), &pb.SetRequest{Key: "key", Value: "value"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := syscall.Kill(os.Getpid(), sig); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("error = %v, did not want error", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("server did not shut down")
+			}
+
+			if _, err := net.DialTimeout("tcp", addr.String(), time.Second); err == nil {
+				t.Errorf("server still accepts connections")
+			}
+
+			dbContents, err := os.ReadFile(filepath.Join(dataDir, databaseFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(dbContents) != "key,value\n" {
+				t.Errorf("database file = %q, want = %q", dbContents, "key,value\n")
+			}
+
+			// the index records that it covers the whole database file
+			index, err := os.ReadFile(filepath.Join(dataDir, databaseFileName+diskIndexSuffix))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			indexedUpTo := int64(binary.LittleEndian.Uint64(index[24:]))
+			if indexedUpTo != int64(len(dbContents)) {
+				t.Errorf("index covers %d bytes, want %d", indexedUpTo, len(dbContents))
+			}
+		})
+	}
+}
+
+func Test_database_closed(t *testing.T) {
+	t.Cleanup(deleteDatabase)
+
+	s := getServer()
+	if code := s.db.close(); code != OK {
+		t.Fatalf("closing failed with code %d", code)
+	}
+
+	if code := s.db.setKey("key", "value"); code != DatabaseClosed {
+		t.Errorf("code = %v, want = %v", code, DatabaseClosed)
+	}
+
+	if _, code := s.db.getKey("key"); code != DatabaseClosed {
+		t.Errorf("code = %v, want = %v", code, DatabaseClosed)
+	}
+}
+
+// freeAddr returns an address to listen on that is free for now.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	return lis.Addr().String()
+}
+
+func Test_run_listeners(t *testing.T) {
+	respAddr, httpAddr, memcachedAddr := freeAddr(t), freeAddr(t), freeAddr(t)
+
+	cfg, err := ParseConfig(
+		[]string{
+			"-addr", "localhost:0",
+			"-resp-addr", respAddr,
+			"-http-addr", httpAddr,
+			"-memcached-addr", memcachedAddr,
+			"-data-dir", t.TempDir(),
+			"-log-level", "error",
+		},
+		io.Discard,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ready := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, cfg, func(net.Addr) { close(ready) })
+	}()
+
+	<-ready
+
+	c := dialRESP(t, respAddr)
+	if got := c.do(t, "SET", "key", "value"); got != "OK" {
+		t.Errorf("got = %v, want = OK", got)
+	}
+
+	resp, body := httpTestClient{t: t, baseURL: "http://" + httpAddr}.do("GET", "/v1/keys/key", "")
+	if resp.StatusCode != http.StatusOK || body != `{"key":"key","value":"value"}` {
+		t.Errorf("got = %v %v over HTTP, want = the value set over RESP", resp.StatusCode, body)
+	}
+
+	conn, err := net.Dial("tcp", memcachedAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	mc := &memcachedClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	if got := mc.do("get key\r\n", 3); got != "VALUE key 0 5\r\nvalue\r\nEND\r\n" {
+		t.Errorf("got = %q over memcached, want = the value set over RESP", got)
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("error = %v, did not want error", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+
+	if _, err := c.read(); err == nil {
+		t.Errorf("connection is still open after shutdown")
+	}
+}
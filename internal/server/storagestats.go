@@ -0,0 +1,142 @@
+package server
+
+import (
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// storageStats tracks the keys in the database and the bytes of records
+// that compaction would reclaim: overwritten records and tombstones. They
+// are counted once when the database is initialized, and then kept up to
+// date on every write, which costs a read of the record that the write
+// replaces. Records of expired keys are only counted as stale once the key
+// is written again.
+type storageStats struct {
+	keys       atomic.Int64
+	staleBytes atomic.Int64
+}
+
+// countStorage counts the keys and stale bytes of the whole database file,
+// as compaction would find them.
+func (d *database) countStorage() ErrorCode {
+	f, code := d.openForReading()
+	if code != OK {
+		return code
+	}
+	defer f.Close()
+
+	csvReader := newCSVReader(f)
+
+	var keys, staleBytes int64
+
+	for {
+		pos := csvReader.InputOffset()
+		record, err := csvReader.Read()
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Printf("Error while reading file: %v", err)
+			return InternalError
+		}
+
+		key, header, code := decodeRecordKey(record, d.keys)
+		if code != OK {
+			return code
+		}
+
+		_, latestPosition, code := d.getKeyPosition(key)
+		if code != OK {
+			return code
+		}
+
+		if latestPosition == pos && !header.deleted {
+			keys++
+		} else {
+			staleBytes += csvReader.InputOffset() - pos
+		}
+	}
+
+	d.storage.keys.Store(keys)
+	d.storage.staleBytes.Store(staleBytes)
+
+	return OK
+}
+
+// trackChange updates the storage stats for a record of size bytes that
+// is about to replace the latest record of its key in the index.
+func (d *database) trackChange(c change, size int64) ErrorCode {
+	found, pos, code := d.getKeyPosition(c.key)
+	if code != OK {
+		return code
+	}
+
+	if found {
+		previousSize, previousDeleted, code := d.recordInfoAt(pos)
+		if code != OK {
+			return code
+		}
+
+		// a tombstone was counted as stale when it was written
+		if !previousDeleted {
+			d.storage.keys.Add(-1)
+			d.storage.staleBytes.Add(previousSize)
+		}
+	}
+
+	if c.deleted {
+		d.storage.staleBytes.Add(size)
+	} else {
+		d.storage.keys.Add(1)
+	}
+
+	return OK
+}
+
+// recordInfoAt returns the size of the record that starts at the given
+// position, and whether it is a tombstone.
+func (d *database) recordInfoAt(pos int64) (int64, bool, ErrorCode) {
+	f, code := d.openForReading()
+	if code != OK {
+		return 0, false, code
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(pos, io.SeekStart); err != nil {
+		log.Printf("Failed to seek position of record: %v", err)
+		return 0, false, InternalError
+	}
+
+	csvReader := newCSVReader(f)
+	record, err := csvReader.Read()
+	if err != nil {
+		log.Printf("Error while reading: %v", err)
+		return 0, false, InternalError
+	}
+
+	var header recordHeader
+	if len(record) == 3 {
+		header, err = parseRecordHeader(record[2])
+		if err != nil {
+			log.Printf("Failed to parse header of record: %v", err)
+			return 0, false, InternalError
+		}
+	}
+
+	return csvReader.InputOffset(), header.deleted, OK
+}
+
+// sync flushes a file to disk, and reports how long it took.
+func (d *database) sync(f *os.File) error {
+	start := time.Now()
+	err := f.Sync()
+
+	if d.observeFsync != nil {
+		d.observeFsync(time.Since(start))
+	}
+
+	return err
+}
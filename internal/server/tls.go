@@ -0,0 +1,202 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// certReloader serves TLS with the certificate and key from files, and
+// verifies client certificates against the CA bundle from a file if there
+// is one. Files are reloaded when they change, so that certificates can be
+// renewed without restarting the server. If a changed file fails to load,
+// the previous certificates stay in use.
+type certReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	// versions identifies the contents of the files when they were last
+	// loaded.
+	versions map[string]fileVersion
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func newCertReloader(certFile, keyFile, clientCAFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+func (r *certReloader) load() error {
+	versions := make(map[string]fileVersion)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		versions[file] = fileVersion{modTime: info.ModTime(), size: info.Size()}
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		clientCAs, err = loadCertPool(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.cert, r.clientCAs, r.versions = &cert, clientCAs, versions
+
+	return nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", file)
+	}
+
+	return pool, nil
+}
+
+// peerCredentials returns the credentials to connect to another server
+// with: TLS if useTLS is true, verifying its certificate against the CAs in
+// caFile, or the system ones if it is empty, and presenting the certificate
+// of certs if it is not nil.
+func peerCredentials(useTLS bool, caFile string, certs *certReloader) (credentials.TransportCredentials, error) {
+	if !useTLS {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig, err := peerTLSConfig(caFile, certs)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// peerTLSConfig returns the TLS configuration of peerCredentials, for
+// connections that are not gRPC.
+func peerTLSConfig(caFile string, certs *certReloader) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		rootCAs, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if certs != nil {
+		tlsConfig.GetClientCertificate = certs.clientCertificate
+	}
+
+	return tlsConfig, nil
+}
+
+// clientCertificate returns the certificate to present to servers, such as
+// the primary of a replica.
+func (r *certReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reloadIfChanged()
+
+	return r.cert, nil
+}
+
+// reloadIfChanged reloads the files if they changed. The caller must hold
+// the lock.
+func (r *certReloader) reloadIfChanged() {
+	if !r.changed() {
+		return
+	}
+
+	if err := r.load(); err != nil {
+		log.Printf("Failed to reload TLS certificates, keeping the previous ones: %v", err)
+	} else {
+		infof("Reloaded TLS certificates")
+	}
+}
+
+func (r *certReloader) changed() bool {
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil || r.versions[file] != (fileVersion{modTime: info.ModTime(), size: info.Size()}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// config returns the TLS configuration of a listener that negotiates the
+// given application protocols.
+func (r *certReloader) config(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.configForHandshake(nextProtos), nil
+		},
+	}
+}
+
+func (r *certReloader) configForHandshake(nextProtos []string) *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reloadIfChanged()
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   nextProtos,
+		Certificates: []tls.Certificate{*r.cert},
+	}
+
+	if r.clientCAs != nil {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.ClientCAs = r.clientCAs
+	}
+
+	return cfg
+}
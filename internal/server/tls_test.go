@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+// testCA issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key for localhost.
+func (ca *testCA) issue(t *testing.T, commonName string, serial int64) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeFiles writes files in dir, with a modification time in the future
+// so that rewriting a file is noticed even within the same clock tick.
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, contents, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		modTime := info.ModTime().Add(time.Second)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_run_mutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "server", 2)
+	clientCert, clientKey := ca.issue(t, "client", 3)
+
+	writeFiles(t, dir, map[string][]byte{"ca.pem": ca.pem, "server.pem": serverCert, "server.key": serverKey})
+
+	cfg, err := ParseConfig(
+		[]string{
+			"-addr", "localhost:0",
+			"-data-dir", dir,
+			"-log-level", "error",
+			"-tls-cert", filepath.Join(dir, "server.pem"),
+			"-tls-key", filepath.Join(dir, "server.key"),
+			"-tls-client-ca", filepath.Join(dir, "ca.pem"),
+		},
+		io.Discard,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	addrs := make(chan net.Addr, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, cfg, func(addr net.Addr) { addrs <- addr })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	addr := (<-addrs).String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	cert, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := func(tlsConfig *tls.Config) error {
+		conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err = pb.NewDatabaseClient(conn).Set(ctx, &pb.SetRequest{Key: "key", Value: "value"})
+		return err
+	}
+
+	if err := set(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{cert}}); err != nil {
+		t.Errorf("error = %v with a client certificate, did not want error", err)
+	}
+
+	if err := set(&tls.Config{RootCAs: roots}); err == nil {
+		t.Errorf("request without a client certificate succeeded")
+	}
+
+	if err := set(&tls.Config{Certificates: []tls.Certificate{cert}}); err == nil {
+		t.Errorf("request that does not trust the CA of the server succeeded")
+	}
+}
+
+func Test_certReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	cert, key := ca.issue(t, "server", 10)
+
+	writeFiles(t, dir, map[string][]byte{"server.pem": cert, "server.key": key})
+
+	certs, err := newCertReloader(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tlsLis := tls.NewListener(lis, certs.config())
+	defer tlsLis.Close()
+
+	go func() {
+		for {
+			conn, err := tlsLis.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	servedSerial := func() int64 {
+		t.Helper()
+
+		conn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	if got := servedSerial(); got != 10 {
+		t.Errorf("serial = %d, want = 10", got)
+	}
+
+	cert, key = ca.issue(t, "server", 11)
+	writeFiles(t, dir, map[string][]byte{"server.pem": cert, "server.key": key})
+
+	if got := servedSerial(); got != 11 {
+		t.Errorf("serial = %d after renewal, want = 11", got)
+	}
+
+	// a broken certificate leaves the previous one in use
+	writeFiles(t, dir, map[string][]byte{"server.pem": []byte("not a certificate")})
+
+	if got := servedSerial(); got != 11 {
+		t.Errorf("serial = %d after a failed reload, want = 11", got)
+	}
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer traces requests down to the storage operations they make. Spans
+// go nowhere unless tracing is set up, with the trace-exporter setting. It
+// is looked up on every use, since the tracer provider changes when the
+// server is restarted in the same process.
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/arpitchauhan/simple-database/internal/server")
+}
+
+// endSpan ends a span, marking it as failed if code is an error. Keys that
+// are not found are not errors.
+func endSpan(span trace.Span, code ErrorCode) {
+	if code != OK && code != KeyNotFound {
+		span.SetStatus(codes.Error, fmt.Sprintf("error code %d", code))
+	}
+
+	span.End()
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+func Test_tracing(t *testing.T) {
+	t.Cleanup(deleteDatabase)
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	cfg, err := ParseConfig([]string{"-trace-exporter", "stdout"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := getServer()
+	s.db.syncWrites = true
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fe := newGRPCFrontEnd(s, lis, cfg, nil)
+	go fe.serve()
+	t.Cleanup(fe.stop)
+
+	conn, err := grpc.Dial(
+		lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := pb.NewDatabaseClient(conn).Set(ctx, &pb.SetRequest{Key: "key", Value: "value"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server span may end after the client received the reply
+	ended := recorder.Ended()
+	for deadline := time.Now().Add(5 * time.Second); len(ended) < 9 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		ended = recorder.Ended()
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	var client, server sdktrace.ReadOnlySpan
+	for _, span := range ended {
+		switch span.SpanKind() {
+		case trace.SpanKindClient:
+			client = span
+		case trace.SpanKindServer:
+			server = span
+		default:
+			spans[span.Name()] = span
+		}
+	}
+
+	if client == nil || server == nil {
+		t.Fatalf("got client span %v and server span %v, want both", client, server)
+	}
+
+	if server.Parent().SpanID() != client.SpanContext().SpanID() {
+		t.Errorf("server span is not a child of the client span")
+	}
+
+	apply, ok := spans["database.apply"]
+	if !ok {
+		t.Fatalf("no database.apply span")
+	}
+
+	if apply.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("database.apply span is not a child of the server span")
+	}
+
+	for _, name := range []string{"open", "seek", "encode", "write", "fsync", "index"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+
+		if span.Parent().SpanID() != apply.SpanContext().SpanID() {
+			t.Errorf("%s span is not a child of database.apply", name)
+		}
+
+		if span.SpanContext().TraceID() != client.SpanContext().TraceID() {
+			t.Errorf("%s span is not in the trace of the client", name)
+		}
+	}
+}
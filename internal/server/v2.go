@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pbv2 "github.com/arpitchauhan/simple-database/database/v2"
+)
+
+// serverV2 implements version 2 of the Database service, which carries keys
+// and values as bytes, on top of the same database as server.
+type serverV2 struct {
+	pbv2.UnimplementedDatabaseServer
+	s *server
+}
+
+func (s *serverV2) Get(ctx context.Context, in *pbv2.GetRequest) (*pbv2.GetReply, error) {
+	value, err := s.s.get(ctx, string(in.Key))
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbv2.GetReply{Value: []byte(value)}, nil
+}
+
+func (s *serverV2) Set(ctx context.Context, in *pbv2.SetRequest) (*pbv2.SetReply, error) {
+	if err := s.s.set(ctx, string(in.Key), string(in.Value)); err != nil {
+		return nil, err
+	}
+
+	return &pbv2.SetReply{}, nil
+}
+
+func (s *serverV2) Delete(ctx context.Context, in *pbv2.DeleteRequest) (*pbv2.DeleteReply, error) {
+	if err := s.s.deleteIf(ctx, string(in.Key), nil); err != nil {
+		return nil, err
+	}
+
+	return &pbv2.DeleteReply{}, nil
+}
+
+func (s *serverV2) Scan(ctx context.Context, in *pbv2.ScanRequest) (*pbv2.ScanReply, error) {
+	limit := int(in.Limit)
+	if limit == 0 {
+		limit = defaultScanLimit
+	} else if limit > maxScanLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "Limit must be at most %d", maxScanLimit)
+	}
+
+	infof("Scan: received prefix: %v", string(in.Prefix))
+
+	keys, next, err := s.s.scan(string(in.Prefix), in.Cursor, limit, func(string) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &pbv2.ScanReply{NextCursor: next}
+	for _, key := range keys {
+		reply.Keys = append(reply.Keys, []byte(key))
+	}
+
+	return reply, nil
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+	pbv2 "github.com/arpitchauhan/simple-database/database/v2"
+)
+
+func Test_serverV2_binary(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   []byte
+		value []byte
+	}{
+		{
+			name:  "Plain text",
+			key:   []byte("key"),
+			value: []byte("value, with a comma and \"quotes\"\nand a newline"),
+		},
+		{
+			name:  "Carriage return",
+			key:   []byte("key"),
+			value: []byte("line 1\r\nline 2\r"),
+		},
+		{
+			name:  "Invalid UTF-8",
+			key:   []byte("key"),
+			value: []byte{0xff, 0xfe, 0x00, 0x01},
+		},
+		{
+			name:  "Binary key",
+			key:   []byte{0x00, 0xc3, 0x28, '\r', '\n'},
+			value: []byte("value"),
+		},
+		{
+			name:  "Empty value",
+			key:   []byte("key"),
+			value: []byte{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(deleteDatabase)
+
+			s := &serverV2{s: getServer()}
+			setRequest := &pbv2.SetRequest{Key: tt.key, Value: tt.value}
+			if _, err := s.Set(context.Background(), setRequest); err != nil {
+				t.Fatal(err)
+			}
+
+			// read it back from the file with a fresh server
+			s = &serverV2{s: getServer()}
+			reply, err := s.Get(context.Background(), &pbv2.GetRequest{Key: tt.key})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(reply.Value, tt.value) {
+				t.Errorf("got = %q, want = %q", reply.Value, tt.value)
+			}
+		})
+	}
+}
+
+func Test_server_binaryValueOverV1(t *testing.T) {
+	t.Cleanup(deleteDatabase)
+
+	s := getServer()
+	setRequest := &pbv2.SetRequest{Key: []byte("key"), Value: []byte{0xff}}
+	if _, err := (&serverV2{s: s}).Set(context.Background(), setRequest); err != nil {
+		t.Fatal(err)
+	}
+
+	dbContents, err := os.ReadFile(testDatabasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "a2V5,/w==,b=1\n"; string(dbContents) != want {
+		t.Errorf("got = %q, want = %q", dbContents, want)
+	}
+
+	_, err = s.Get(context.Background(), &pb.GetRequest{Key: "key"})
+
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("error = %v, want code %v", err, codes.FailedPrecondition)
+	}
+
+	if !strings.Contains(err.Error(), "version 2") {
+		t.Errorf("error = %v, want it to point to version 2 of the API", err)
+	}
+}
+
+func Test_serverV2_deleteAndScan(t *testing.T) {
+	t.Cleanup(deleteDatabase)
+
+	ctx := context.Background()
+	s := &serverV2{s: getServer()}
+
+	for _, key := range []string{"user:1", "user:2", "user:3", "order:1"} {
+		if _, err := s.Set(ctx, &pbv2.SetRequest{Key: []byte(key), Value: []byte("value")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.Delete(ctx, &pbv2.DeleteRequest{Key: []byte("user:2")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Delete(ctx, &pbv2.DeleteRequest{Key: []byte("user:2")}); status.Code(err) != codes.NotFound {
+		t.Errorf("error = %v deleting a missing key, want NotFound", err)
+	}
+
+	var keys []string
+	var cursor uint64
+	for {
+		reply, err := s.Scan(ctx, &pbv2.ScanRequest{Prefix: []byte("user:"), Cursor: cursor, Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(reply.Keys) > 1 {
+			t.Fatalf("page of %d keys, want at most 1", len(reply.Keys))
+		}
+
+		for _, key := range reply.Keys {
+			keys = append(keys, string(key))
+		}
+
+		cursor = reply.NextCursor
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if got := strings.Join(keys, ","); got != "user:1,user:3" {
+		t.Errorf("keys = %s, want = user:1,user:3", got)
+	}
+
+	if _, err := s.Scan(ctx, &pbv2.ScanRequest{Limit: maxScanLimit + 1}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("error = %v for a limit too large, want InvalidArgument", err)
+	}
+}
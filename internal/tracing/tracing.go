@@ -0,0 +1,70 @@
+// Package tracing sets up OpenTelemetry tracing for the server and the CLI.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Exporters that spans can be sent to.
+const (
+	// ExporterNone disables tracing.
+	ExporterNone = ""
+	// ExporterStdout writes spans as JSON.
+	ExporterStdout = "stdout"
+	// ExporterOTLP sends spans to an OTLP collector over gRPC, configured
+	// with the standard OTEL_EXPORTER_OTLP_* environment variables. The
+	// default collector is localhost:4317.
+	ExporterOTLP = "otlp"
+)
+
+// Setup makes the global tracer provider export the spans of service with
+// exporter, and propagates trace context across gRPC calls with the W3C
+// headers. Spans written to stdout go to w. The returned function flushes
+// the spans left, and must be called before the program exits.
+func Setup(ctx context.Context, exporter, service string, w io.Writer) (func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		spanExporter, err = otlptracegrpc.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, want %q or %q", exporter, ExporterStdout, ExporterOTLP)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the trace exporter: %w", err)
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(attribute.String("service.name", service)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
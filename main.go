@@ -8,9 +8,9 @@ import (
 )
 
 func main() {
-	run(os.Stdout, os.Stderr)
+	os.Exit(run(os.Stdout, os.Stderr))
 }
 
-func run(wout io.Writer, werr io.Writer) {
-	cmd.Execute(wout, werr)
+func run(wout io.Writer, werr io.Writer) int {
+	return cmd.Execute(wout, werr)
 }
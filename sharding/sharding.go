@@ -0,0 +1,266 @@
+// Package sharding places keys on the shards of a sharded deployment.
+//
+// Keys are placed with consistent hashing: each shard has a number of
+// virtual nodes on a ring of 64-bit hashes, and a key belongs to the shard
+// of the first virtual node at or after the hash of the key. Adding or
+// removing a shard only moves the keys of the ranges next to its virtual
+// nodes.
+package sharding
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	pb "github.com/arpitchauhan/simple-database/database"
+)
+
+// DefaultVirtualNodes is the number of virtual nodes of each shard unless a
+// map sets it.
+const DefaultVirtualNodes = 128
+
+// Shard is a server that holds a part of the keys.
+type Shard struct {
+	ID string `json:"id"`
+	// Addr is the gRPC address of the shard.
+	Addr string `json:"addr"`
+}
+
+// Map places keys on shards. Maps are versioned, and a map with a later
+// version replaces the earlier ones.
+//
+// While the keys are moved to the shards of a new map, Previous holds the
+// shards of the map before it, so that keys that were not moved yet can be
+// found.
+type Map struct {
+	Version      uint64
+	VirtualNodes int
+	Shards       []Shard
+	Previous     []Shard
+
+	ring, previousRing ring
+}
+
+// New returns a map of shards, with previous as the shards that are being
+// rebalanced from, if any.
+func New(version uint64, virtualNodes int, shards, previous []Shard) (*Map, error) {
+	if virtualNodes <= 0 {
+		return nil, fmt.Errorf("virtual nodes must be positive")
+	}
+
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("a shard map needs at least one shard")
+	}
+
+	for _, list := range [][]Shard{shards, previous} {
+		seen := make(map[string]bool)
+
+		for _, s := range list {
+			if s.ID == "" || s.Addr == "" {
+				return nil, fmt.Errorf("shard %q needs an ID and an address", s.ID)
+			}
+
+			if seen[s.ID] {
+				return nil, fmt.Errorf("shard %q is listed more than once", s.ID)
+			}
+			seen[s.ID] = true
+		}
+	}
+
+	return &Map{
+		Version:      version,
+		VirtualNodes: virtualNodes,
+		Shards:       shards,
+		Previous:     previous,
+		ring:         newRing(shards, virtualNodes),
+		previousRing: newRing(previous, virtualNodes),
+	}, nil
+}
+
+// Rebalancing reports whether keys are being moved to the shards of the
+// map.
+func (m *Map) Rebalancing() bool {
+	return len(m.Previous) > 0
+}
+
+// Owner returns the shard that key belongs to.
+func (m *Map) Owner(key string) Shard {
+	return m.ring.owner(key)
+}
+
+// PreviousOwner returns the shard that key belonged to before the
+// rebalancing in progress, if there is one.
+func (m *Map) PreviousOwner(key string) (Shard, bool) {
+	if !m.Rebalancing() {
+		return Shard{}, false
+	}
+
+	return m.previousRing.owner(key), true
+}
+
+// Shard returns the shard with an ID, among the shards of the map and the
+// previous ones.
+func (m *Map) Shard(id string) (Shard, bool) {
+	for _, list := range [][]Shard{m.Shards, m.Previous} {
+		for _, s := range list {
+			if s.ID == id {
+				return s, true
+			}
+		}
+	}
+
+	return Shard{}, false
+}
+
+// All returns the shards of the map followed by the previous shards that
+// are not in it.
+func (m *Map) All() []Shard {
+	all := slices.Clone(m.Shards)
+
+	for _, s := range m.Previous {
+		if !slices.ContainsFunc(m.Shards, func(t Shard) bool { return t.ID == s.ID }) {
+			all = append(all, s)
+		}
+	}
+
+	return all
+}
+
+// ring is the sorted virtual nodes of a set of shards.
+type ring struct {
+	hashes []uint64
+	shards []Shard
+}
+
+func newRing(shards []Shard, virtualNodes int) ring {
+	type node struct {
+		hash  uint64
+		shard Shard
+	}
+
+	var nodes []node
+	for _, s := range shards {
+		for i := 0; i < virtualNodes; i++ {
+			nodes = append(nodes, node{hash: hash(s.ID + "#" + strconv.Itoa(i)), shard: s})
+		}
+	}
+
+	// ties, which are very unlikely, go to the shard with the lowest ID
+	slices.SortFunc(nodes, func(a, b node) int {
+		if a.hash != b.hash {
+			return cmp.Compare(a.hash, b.hash)
+		}
+		return cmp.Compare(a.shard.ID, b.shard.ID)
+	})
+
+	var r ring
+	for _, n := range nodes {
+		r.hashes = append(r.hashes, n.hash)
+		r.shards = append(r.shards, n.shard)
+	}
+
+	return r
+}
+
+func (r ring) owner(key string) Shard {
+	if len(r.hashes) == 0 {
+		return Shard{}
+	}
+
+	i, _ := slices.BinarySearch(r.hashes, hash(key))
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	return r.shards[i]
+}
+
+func hash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// mapFile is a map as it is stored in a file, for example:
+//
+//	{"version": 1, "virtual_nodes": 128, "shards": [{"id": "s1", "addr": "db1:50051"}, {"id": "s2", "addr": "db2:50051"}]}
+type mapFile struct {
+	Version      uint64  `json:"version"`
+	VirtualNodes int     `json:"virtual_nodes,omitempty"`
+	Shards       []Shard `json:"shards"`
+	Previous     []Shard `json:"previous,omitempty"`
+}
+
+// Load reads a map from a JSON file. Its virtual nodes default to
+// DefaultVirtualNodes.
+func Load(path string) (*Map, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f mapFile
+	if err := json.Unmarshal(contents, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if f.VirtualNodes == 0 {
+		f.VirtualNodes = DefaultVirtualNodes
+	}
+
+	m, err := New(f.Version, f.VirtualNodes, f.Shards, f.Previous)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return m, nil
+}
+
+// MarshalJSON encodes a map in the format that Load reads.
+func (m *Map) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mapFile{
+		Version:      m.Version,
+		VirtualNodes: m.VirtualNodes,
+		Shards:       m.Shards,
+		Previous:     m.Previous,
+	})
+}
+
+// FromProto returns the map of a gRPC message.
+func FromProto(pm *pb.ShardMap) (*Map, error) {
+	if pm == nil {
+		return nil, fmt.Errorf("no shard map")
+	}
+
+	fromProto := func(shards []*pb.Shard) []Shard {
+		var list []Shard
+		for _, s := range shards {
+			list = append(list, Shard{ID: s.Id, Addr: s.Addr})
+		}
+		return list
+	}
+
+	return New(pm.Version, int(pm.VirtualNodes), fromProto(pm.Shards), fromProto(pm.Previous))
+}
+
+// Proto returns the map as a gRPC message.
+func (m *Map) Proto() *pb.ShardMap {
+	toProto := func(shards []Shard) []*pb.Shard {
+		var list []*pb.Shard
+		for _, s := range shards {
+			list = append(list, &pb.Shard{Id: s.ID, Addr: s.Addr})
+		}
+		return list
+	}
+
+	return &pb.ShardMap{
+		Version:      m.Version,
+		VirtualNodes: uint32(m.VirtualNodes),
+		Shards:       toProto(m.Shards),
+		Previous:     toProto(m.Previous),
+	}
+}
@@ -0,0 +1,140 @@
+package sharding
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newMap(t *testing.T, shards ...string) *Map {
+	t.Helper()
+
+	var list []Shard
+	for _, id := range shards {
+		list = append(list, Shard{ID: id, Addr: id + ":50051"})
+	}
+
+	m, err := New(1, DefaultVirtualNodes, list, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func Test_Map_Owner(t *testing.T) {
+	before := newMap(t, "s1", "s2", "s3")
+	after := newMap(t, "s1", "s2", "s3", "s4")
+
+	const keys = 10000
+	counts := make(map[string]int)
+	moved := 0
+
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key%d", i)
+
+		owner := before.Owner(key)
+		counts[owner.ID]++
+
+		if newOwner := after.Owner(key); newOwner != owner {
+			moved++
+			if newOwner.ID != "s4" {
+				t.Fatalf("%s moved from %s to %s, want keys to only move to the new shard", key, owner.ID, newOwner.ID)
+			}
+		}
+	}
+
+	for id, count := range counts {
+		if count < keys/3*3/4 || count > keys/3*5/4 {
+			t.Errorf("shard %s owns %d of %d keys, want about a third", id, count, keys)
+		}
+	}
+
+	if moved < keys/4*3/4 || moved > keys/4*5/4 {
+		t.Errorf("%d of %d keys moved to the new shard, want about a quarter", moved, keys)
+	}
+}
+
+func Test_Map_PreviousOwner(t *testing.T) {
+	before := newMap(t, "s1", "s2")
+
+	m, err := New(2, DefaultVirtualNodes, newMap(t, "s1", "s2", "s3").Shards, before.Shards)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Rebalancing() {
+		t.Errorf("map with previous shards is not rebalancing")
+	}
+
+	if got := len(m.All()); got != 3 {
+		t.Errorf("All() has %d shards, want 3", got)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i)
+		if previous, ok := m.PreviousOwner(key); !ok || previous != before.Owner(key) {
+			t.Errorf("previous owner of %s = %v, %t, want = %v", key, previous, ok, before.Owner(key))
+		}
+	}
+
+	if _, ok := before.PreviousOwner("key"); ok {
+		t.Errorf("map that is not rebalancing has a previous owner")
+	}
+}
+
+func Test_New(t *testing.T) {
+	tests := []struct {
+		name     string
+		shards   []Shard
+		previous []Shard
+	}{
+		{name: "No shards"},
+		{name: "Shard without an address", shards: []Shard{{ID: "s1"}}},
+		{name: "Duplicate shard", shards: []Shard{{ID: "s1", Addr: "a"}, {ID: "s1", Addr: "b"}}},
+		{
+			name:     "Duplicate previous shard",
+			shards:   []Shard{{ID: "s1", Addr: "a"}},
+			previous: []Shard{{ID: "s1", Addr: "a"}, {ID: "s1", Addr: "a"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(1, DefaultVirtualNodes, tt.shards, tt.previous); err == nil {
+				t.Errorf("wanted error")
+			}
+		})
+	}
+}
+
+func Test_Load(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shardmap.json")
+	contents := `{"version": 3, "shards": [{"id": "s1", "addr": "db1:50051"}, {"id": "s2", "addr": "db2:50051"}]}`
+
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Version != 3 || m.VirtualNodes != DefaultVirtualNodes || len(m.Shards) != 2 {
+		t.Errorf("got = %+v, want version 3 with 2 shards", m)
+	}
+
+	// maps survive a round trip through gRPC
+	fromProto, err := FromProto(m.Proto())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i)
+		if fromProto.Owner(key) != m.Owner(key) {
+			t.Errorf("owner of %s = %v after a round trip, want = %v", key, fromProto.Owner(key), m.Owner(key))
+		}
+	}
+}